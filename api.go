@@ -0,0 +1,629 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+	"github.com/matcha-devs/matcha/internal/forecast"
+	"github.com/matcha-devs/matcha/internal/openapi"
+	"github.com/matcha-devs/matcha/internal/validation"
+)
+
+const (
+	apiPrefix  = "/api/v1"
+	maxAPIBody = 1 << 20
+
+	defaultPageSize = 50
+	maxPageSize     = 200
+)
+
+// errNotLoggedIn is returned for requests with neither a session nor a personal API token.
+var errNotLoggedIn = errors.New("not logged in")
+
+// errBadRequest is wrapped by errors about requests that can't be understood, its message is shown as is.
+var errBadRequest = errors.New("bad request")
+
+// apiError is the body of every error response of the API, Fields names what is wrong with which request fields.
+type apiError struct {
+	Error apiErrorDetail `json:"error"`
+}
+
+type apiErrorDetail struct {
+	// Code is one of bad_request, unauthorized, insufficient_scope, not_found, invalid_fields or internal.
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// page is a list response, more items come after NextCursor when it is set.
+type page struct {
+	Data       any    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type userResponse struct {
+	ID               uint64    `json:"id"`
+	FirstName        string    `json:"first_name"`
+	MiddleName       string    `json:"middle_name"`
+	LastName         string    `json:"last_name"`
+	Email            string    `json:"email"`
+	EmailVerified    bool      `json:"email_verified"`
+	DateOfBirth      string    `json:"date_of_birth"`
+	TwoFactorEnabled bool      `json:"two_factor_enabled"`
+	CreatedOn        time.Time `json:"created_on"`
+}
+
+type institutionInput struct {
+	Name string `json:"name"`
+}
+
+type accountInput struct {
+	InstitutionID uint64 `json:"institution_id"`
+	AssetClass    string `json:"asset_class"`
+	Name          string `json:"name"`
+	NetValue      int64  `json:"net_value"`
+}
+
+type transactionInput struct {
+	FinancialAccountID uint64 `json:"financial_account_id"`
+	Amount             int64  `json:"amount"`
+	Type               string `json:"type"`
+	// Date is YYYY-MM-DD, today when left out.
+	Date string `json:"date,omitempty"`
+	// Splits divide the amount between categories, adding up to it.
+	Splits []internal.Split `json:"splits,omitempty"`
+}
+
+type splitsInput struct {
+	// Splits replace those of the transaction, none makes it whole again.
+	Splits []internal.Split `json:"splits"`
+}
+
+type transferInput struct {
+	FromAccountID uint64 `json:"from_account_id"`
+	ToAccountID   uint64 `json:"to_account_id"`
+	// Amount is how many cents move, above 0.
+	Amount int64 `json:"amount"`
+	// Date is YYYY-MM-DD, today when left out.
+	Date string `json:"date,omitempty"`
+}
+
+// transfer is the two sides of a transfer, From spending the amount To receives.
+type transfer struct {
+	From internal.Transaction `json:"from"`
+	To   internal.Transaction `json:"to"`
+}
+
+type transferLinkInput struct {
+	// TransactionID is the other side of the transfer.
+	TransactionID uint64 `json:"transaction_id"`
+}
+
+type recurringInput struct {
+	FinancialAccountID uint64 `json:"financial_account_id"`
+	Name               string `json:"name"`
+	Amount             int64  `json:"amount"`
+	Type               string `json:"type"`
+	Rule               string `json:"rule"`
+	StartsOn           string `json:"starts_on"`
+	EndsOn             string `json:"ends_on,omitempty"`
+}
+
+// apiRoute is an endpoint of the API, serve handles its requests on behalf of user, who is nil on public routes.
+// Personal API tokens need scope to call it, sessions may call every route.
+type apiRoute struct {
+	openapi.Operation
+	scope internalDatabase.Scope
+	serve func(r *http.Request, user *internal.User) (response any, err error)
+}
+
+var (
+	pageParams = []openapi.Param{
+		{Name: "cursor", Description: "next_cursor of the previous page"},
+		{Name: "limit", Description: "Items per page, 50 by default and 200 at most", Integer: true},
+	}
+	apiRoutes []apiRoute
+)
+
+func init() {
+	// Assigned here since the OpenAPI route describes the others.
+	apiRoutes = []apiRoute{
+		{openapi.Operation{
+			Method: "GET", Path: "/user", Summary: "The logged in user", Response: userResponse{},
+		}, internalDatabase.ReadScope, getAPIUser},
+		{openapi.Operation{
+			Method: "GET", Path: "/institutions", Summary: "Institutions accounts can be held at",
+			Query: pageParams, Response: internal.Institution{}, Paginated: true,
+		}, internalDatabase.ReadScope, listInstitutions},
+		{openapi.Operation{
+			Method: "POST", Path: "/institutions", Summary: "Find or add an institution by name",
+			Request: institutionInput{}, Response: internal.Institution{}, Status: http.StatusCreated,
+		}, internalDatabase.AccountsWriteScope, postInstitution},
+		{openapi.Operation{
+			Method: "GET", Path: "/accounts", Summary: "Financial accounts", Query: pageParams,
+			Response: internal.FinancialAccount{}, Paginated: true,
+		}, internalDatabase.ReadScope, listAccounts},
+		{openapi.Operation{
+			Method: "POST", Path: "/accounts", Summary: "Add a financial account", Request: accountInput{},
+			Response: internal.FinancialAccount{}, Status: http.StatusCreated,
+		}, internalDatabase.AccountsWriteScope, postAccount},
+		{openapi.Operation{
+			Method: "GET", Path: "/accounts/{id}", Summary: "A financial account",
+			Response: internal.FinancialAccount{},
+		}, internalDatabase.ReadScope, getAccount},
+		{openapi.Operation{
+			Method: "GET", Path: "/transactions", Summary: "Transactions, newest last",
+			Query: append(
+				[]openapi.Param{
+					{Name: "account_id", Description: "Only the transactions of this account", Integer: true},
+				}, pageParams...,
+			),
+			Response: internal.Transaction{}, Paginated: true,
+		}, internalDatabase.ReadScope, listTransactions},
+		{openapi.Operation{
+			Method: "POST", Path: "/transactions", Summary: "Record a transaction, moving its account's net value",
+			Request: transactionInput{}, Response: internal.Transaction{}, Status: http.StatusCreated,
+		}, internalDatabase.TransactionsWriteScope, postTransaction},
+		{openapi.Operation{
+			Method: "GET", Path: "/transactions/{id}", Summary: "A transaction", Response: internal.Transaction{},
+		}, internalDatabase.ReadScope, getTransaction},
+		{openapi.Operation{
+			Method: "PUT", Path: "/transactions/{id}/splits",
+			Summary: "Split a transaction between categories, the parts adding up to its amount",
+			Request: splitsInput{}, Response: internal.Transaction{},
+		}, internalDatabase.TransactionsWriteScope, putSplits},
+		{openapi.Operation{
+			Method: "PUT", Path: "/transactions/{id}/transfer",
+			Summary: "Link a transaction with one of the opposite amount on another account as a transfer",
+			Request: transferLinkInput{}, Response: internal.Transaction{},
+		}, internalDatabase.TransactionsWriteScope, putTransfer},
+		{openapi.Operation{
+			Method: "DELETE", Path: "/transactions/{id}/transfer",
+			Summary:  "Unlink a transfer, both its transactions count as spending and income again",
+			Response: internal.Transaction{},
+		}, internalDatabase.TransactionsWriteScope, deleteTransfer},
+		{openapi.Operation{
+			Method: "POST", Path: "/transfers",
+			Summary: "Move money between accounts, recording a transaction on each that is neither spending nor income",
+			Request: transferInput{}, Response: transfer{}, Status: http.StatusCreated,
+		}, internalDatabase.TransactionsWriteScope, postTransfer},
+		{openapi.Operation{
+			Method: "GET", Path: "/recurring", Summary: "Recurring transactions, all on one page",
+			Response: internal.RecurringTransaction{}, Paginated: true,
+		}, internalDatabase.ReadScope, listRecurring},
+		{openapi.Operation{
+			Method: "POST", Path: "/recurring",
+			Summary: "Add a recurring transaction, its rule is an RFC 5545 RRULE like FREQ=MONTHLY;BYMONTHDAY=1",
+			Request: recurringInput{}, Response: internal.RecurringTransaction{}, Status: http.StatusCreated,
+		}, internalDatabase.TransactionsWriteScope, postRecurringTransaction},
+		{openapi.Operation{
+			Method: "GET", Path: "/recurring/upcoming",
+			Summary: "Occurrences of recurring transactions not recorded yet, overdue ones first",
+			Query: []openapi.Param{
+				{Name: "days", Description: "Days ahead to look, 30 by default and 366 at most", Integer: true},
+			},
+			Response: internal.Occurrence{}, Paginated: true,
+		}, internalDatabase.ReadScope, listUpcoming},
+		{openapi.Operation{
+			Method: "GET", Path: "/forecast",
+			Summary: "Daily balances of every account projected from recurring transactions, warning of negative ones",
+			Query: []openapi.Param{
+				{Name: "days", Description: "Days ahead to forecast, 30 by default and 365 at most", Integer: true},
+			},
+			Response: forecast.Forecast{},
+		}, internalDatabase.ReadScope, getForecast},
+		{openapi.Operation{
+			Method: "POST", Path: "/forecast", Summary: "The forecast with what-if recurring transactions, none stored",
+			Request: forecastInput{}, Response: forecast.Forecast{},
+		}, internalDatabase.ReadScope, postForecast},
+		{openapi.Operation{
+			Method: "GET", Path: "/reports/summary", Summary: "Net worth and spending totals",
+			Response: internal.SummaryReport{},
+		}, internalDatabase.ReadScope, getSummaryReport},
+		{openapi.Operation{
+			Method: "GET", Path: "/openapi.json", Summary: "This document", Response: map[string]any{}, Public: true,
+		}, "", getOpenAPI},
+	}
+}
+
+// openAPIDocument describes apiRoutes, generated once from the Go types they read and write.
+var openAPIDocument = sync.OnceValue(
+	func() map[string]any {
+		spec := openapi.Spec{
+			Title: "Matcha", Version: "1", ServerURL: apiPrefix, Error: apiError{},
+			Security: map[string]map[string]any{
+				"token":   {"type": "http", "scheme": "bearer", "description": "A personal API token"},
+				"session": {"type": "apiKey", "in": "cookie", "name": sessionCookieName},
+			},
+		}
+		for _, route := range apiRoutes {
+			operation := route.Operation
+			if route.scope != "" {
+				operation.Description = "Personal API tokens need the " + string(route.scope) + " scope."
+			}
+			spec.Operations = append(spec.Operations, operation)
+		}
+		return spec.Document()
+	},
+)
+
+// serveAPI authenticates the requests of route and writes what it serves as JSON.
+func serveAPI(route apiRoute) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var user *internal.User
+		if !route.Public {
+			var (
+				scopes []internalDatabase.Scope
+				err    error
+			)
+			user, scopes, err = apiUser(r)
+			if errors.Is(err, internalDatabase.ErrInvalidToken) || errors.Is(err, errNotLoggedIn) {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="matcha"`)
+				writeAPIError(
+					w, r, http.StatusUnauthorized, "unauthorized", "please log in or send a valid personal API token",
+					nil,
+				)
+				return
+			} else if err != nil {
+				writeAPIFailure(w, r, err)
+				return
+			}
+			if !slices.Contains(scopes, route.scope) {
+				writeAPIError(
+					w, r, http.StatusForbidden, "insufficient_scope",
+					"this token lacks the "+string(route.scope)+" scope", nil,
+				)
+				return
+			}
+			if !user.EmailVerified() {
+				writeAPIError(w, r, http.StatusForbidden, "email_unverified", "please verify your email first", nil)
+				return
+			}
+		}
+		response, err := route.serve(r, user)
+		if err != nil {
+			writeAPIFailure(w, r, err)
+			return
+		}
+		status := route.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		writeAPIResponse(w, r, status, response)
+	}
+}
+
+// apiUser authenticates a request by the personal API token it carries as a bearer token, or else by its session.
+// Requests carrying a token are never authenticated by their cookies, which withCSRF relies on. Sessions have every
+// scope.
+func apiUser(r *http.Request) (user *internal.User, scopes []internalDatabase.Scope, err error) {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		token, ok := strings.CutPrefix(authorization, "Bearer ")
+		if !ok {
+			return nil, nil, internalDatabase.ErrInvalidToken
+		}
+		user, scopes, err = matcha.database.AuthenticateAPIToken(r.Context(), token)
+		apiTokenAuths.Inc(strconv.FormatBool(err == nil))
+		return
+	}
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil, nil, errNotLoggedIn
+	}
+	if user = matcha.database.GetSessionUser(r.Context(), cookie.Value); user == nil {
+		return nil, nil, errNotLoggedIn
+	}
+	return user, internalDatabase.Scopes, nil
+}
+
+func writeAPIResponse(w http.ResponseWriter, r *http.Request, status int, response any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing API response", "err", err)
+	}
+}
+
+func writeAPIError(
+	w http.ResponseWriter, r *http.Request, status int, code, message string, fields map[string]string,
+) {
+	writeAPIResponse(w, r, status, apiError{apiErrorDetail{code, message, fields}})
+}
+
+// writeAPIFailure maps the error of a route to its status and code.
+func writeAPIFailure(w http.ResponseWriter, r *http.Request, err error) {
+	var errs validation.Errors
+	switch {
+	case errors.Is(err, errBadRequest):
+		writeAPIError(w, r, http.StatusBadRequest, "bad_request", err.Error(), nil)
+	case errors.As(err, &errs):
+		writeAPIError(w, r, http.StatusUnprocessableEntity, "invalid_fields", errs.Error(), errs)
+	case errors.Is(err, internalDatabase.ErrNotFound):
+		writeAPIError(w, r, http.StatusNotFound, "not_found", "not found", nil)
+	default:
+		writeAPIError(w, r, http.StatusInternalServerError, "internal", userMessage(err), nil)
+	}
+}
+
+// apiNotFound answers requests to paths of the API that don't exist.
+func apiNotFound(w http.ResponseWriter, r *http.Request) {
+	writeAPIError(w, r, http.StatusNotFound, "not_found", "no such endpoint", nil)
+}
+
+// readAPIRequest decodes the JSON body of a request into request, refusing fields it doesn't have.
+func readAPIRequest(r *http.Request, request any) (err error) {
+	decoder := json.NewDecoder(http.MaxBytesReader(nil, r.Body, maxAPIBody))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(request); err != nil {
+		return fmt.Errorf("%w: malformed JSON body, %v", errBadRequest, err)
+	}
+	return
+}
+
+// idParam parses the {name} path parameter of a request.
+func idParam(r *http.Request, name string) (id uint64, err error) {
+	if id, err = strconv.ParseUint(r.PathValue(name), 10, 64); err != nil || id == 0 {
+		return 0, fmt.Errorf("%w: %s must be a positive integer", errBadRequest, name)
+	}
+	return
+}
+
+// pagination parses the cursor and limit query parameters of a list request.
+func pagination(r *http.Request) (after uint64, limit int, err error) {
+	query := r.URL.Query()
+	if cursor := query.Get("cursor"); cursor != "" {
+		if after, err = strconv.ParseUint(cursor, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("%w: invalid cursor", errBadRequest)
+		}
+	}
+	limit = defaultPageSize
+	if param := query.Get("limit"); param != "" {
+		if limit, err = strconv.Atoi(param); err != nil || limit < 1 || limit > maxPageSize {
+			return 0, 0, fmt.Errorf("%w: limit must be between 1 and %d", errBadRequest, maxPageSize)
+		}
+	}
+	return
+}
+
+// wholePage is a list response holding every item, for lists short enough not to need a cursor.
+func wholePage[T any](items []T) page {
+	return newPage(items, len(items), nil)
+}
+
+// newPage makes a page out of items queried with one more than limit, the extra one only tells that more follow.
+func newPage[T any](items []T, limit int, id func(T) uint64) page {
+	if len(items) <= limit {
+		if items == nil {
+			items = []T{}
+		}
+		return page{Data: items}
+	}
+	items = items[:limit]
+	return page{Data: items, NextCursor: strconv.FormatUint(id(items[limit-1]), 10)}
+}
+
+func getAPIUser(_ *http.Request, user *internal.User) (response any, err error) {
+	return userResponse{
+		user.ID, user.FirstName, user.MiddleName, user.LastName, user.Email, user.EmailVerified(), user.DateOfBirth,
+		user.TOTPEnabled, user.CreatedOn,
+	}, nil
+}
+
+func listInstitutions(r *http.Request, user *internal.User) (response any, err error) {
+	after, limit, err := pagination(r)
+	if err != nil {
+		return
+	}
+	institutions, err := matcha.database.GetInstitutions(r.Context(), user.ID, after, limit+1)
+	if err != nil {
+		return
+	}
+	return newPage(institutions, limit, func(institution internal.Institution) uint64 { return institution.ID }), nil
+}
+
+func postInstitution(r *http.Request, user *internal.User) (response any, err error) {
+	var input institutionInput
+	if err = readAPIRequest(r, &input); err != nil {
+		return
+	}
+	return matcha.database.AddInstitution(r.Context(), user.ID, input.Name)
+}
+
+func listAccounts(r *http.Request, user *internal.User) (response any, err error) {
+	after, limit, err := pagination(r)
+	if err != nil {
+		return
+	}
+	accounts, err := matcha.database.GetAccounts(r.Context(), user.ID, after, limit+1)
+	if err != nil {
+		return
+	}
+	return newPage(accounts, limit, func(account internal.FinancialAccount) uint64 { return account.ID }), nil
+}
+
+func postAccount(r *http.Request, user *internal.User) (response any, err error) {
+	var input accountInput
+	if err = readAPIRequest(r, &input); err != nil {
+		return
+	}
+	return matcha.database.AddAccount(
+		r.Context(), user.ID, internal.FinancialAccount{
+			InstitutionID: input.InstitutionID, AssetClass: input.AssetClass, Name: input.Name,
+			NetValue: input.NetValue,
+		},
+	)
+}
+
+func getAccount(r *http.Request, user *internal.User) (response any, err error) {
+	id, err := idParam(r, "id")
+	if err != nil {
+		return
+	}
+	return matcha.database.GetAccount(r.Context(), user.ID, id)
+}
+
+func listTransactions(r *http.Request, user *internal.User) (response any, err error) {
+	after, limit, err := pagination(r)
+	if err != nil {
+		return
+	}
+	var accountID uint64
+	if param := r.URL.Query().Get("account_id"); param != "" {
+		if accountID, err = strconv.ParseUint(param, 10, 64); err != nil || accountID == 0 {
+			return nil, fmt.Errorf("%w: account_id must be a positive integer", errBadRequest)
+		}
+	}
+	transactions, err := matcha.database.GetTransactions(r.Context(), user.ID, accountID, after, limit+1)
+	if err != nil {
+		return
+	}
+	return newPage(transactions, limit, func(transaction internal.Transaction) uint64 { return transaction.ID }), nil
+}
+
+func postTransaction(r *http.Request, user *internal.User) (response any, err error) {
+	var input transactionInput
+	if err = readAPIRequest(r, &input); err != nil {
+		return
+	}
+	return matcha.database.AddTransaction(
+		r.Context(), user.ID, internal.Transaction{
+			FinancialAccountID: input.FinancialAccountID, Amount: input.Amount, Type: input.Type, Date: input.Date,
+			Splits: input.Splits,
+		},
+	)
+}
+
+func getTransaction(r *http.Request, user *internal.User) (response any, err error) {
+	id, err := idParam(r, "id")
+	if err != nil {
+		return
+	}
+	return matcha.database.GetTransaction(r.Context(), user.ID, id)
+}
+
+func putSplits(r *http.Request, user *internal.User) (response any, err error) {
+	id, err := idParam(r, "id")
+	if err != nil {
+		return
+	}
+	var input splitsInput
+	if err = readAPIRequest(r, &input); err != nil {
+		return
+	}
+	return matcha.database.SetSplits(r.Context(), user.ID, id, input.Splits)
+}
+
+func putTransfer(r *http.Request, user *internal.User) (response any, err error) {
+	id, err := idParam(r, "id")
+	if err != nil {
+		return
+	}
+	var input transferLinkInput
+	if err = readAPIRequest(r, &input); err != nil {
+		return
+	}
+	if err = matcha.database.LinkTransfer(r.Context(), user.ID, id, input.TransactionID); err != nil {
+		return
+	}
+	return matcha.database.GetTransaction(r.Context(), user.ID, id)
+}
+
+func deleteTransfer(r *http.Request, user *internal.User) (response any, err error) {
+	id, err := idParam(r, "id")
+	if err != nil {
+		return
+	}
+	if err = matcha.database.UnlinkTransfer(r.Context(), user.ID, id); err != nil {
+		return
+	}
+	return matcha.database.GetTransaction(r.Context(), user.ID, id)
+}
+
+func postTransfer(r *http.Request, user *internal.User) (response any, err error) {
+	var input transferInput
+	if err = readAPIRequest(r, &input); err != nil {
+		return
+	}
+	from, to, err := matcha.database.AddTransfer(
+		r.Context(), user.ID, input.FromAccountID, input.ToAccountID, input.Amount, input.Date,
+	)
+	if err != nil {
+		return
+	}
+	return transfer{from, to}, nil
+}
+
+func listRecurring(r *http.Request, user *internal.User) (response any, err error) {
+	recurring, err := matcha.database.GetRecurring(r.Context(), user.ID)
+	if err != nil {
+		return
+	}
+	return wholePage(recurring), nil
+}
+
+func postRecurringTransaction(r *http.Request, user *internal.User) (response any, err error) {
+	var input recurringInput
+	if err = readAPIRequest(r, &input); err != nil {
+		return
+	}
+	return matcha.database.AddRecurring(
+		r.Context(), user.ID, internal.RecurringTransaction{
+			FinancialAccountID: input.FinancialAccountID, Name: input.Name, Amount: input.Amount, Type: input.Type,
+			Rule: input.Rule, StartsOn: input.StartsOn, EndsOn: input.EndsOn,
+		},
+	)
+}
+
+func listUpcoming(r *http.Request, user *internal.User) (response any, err error) {
+	days := upcomingDays
+	if param := r.URL.Query().Get("days"); param != "" {
+		if days, err = strconv.Atoi(param); err != nil || days < 0 || days > 366 {
+			return nil, fmt.Errorf("%w: days must be between 0 and 366", errBadRequest)
+		}
+	}
+	upcoming, err := matcha.database.GetUpcoming(r.Context(), user.ID, time.Now().AddDate(0, 0, days))
+	if err != nil {
+		return
+	}
+	return wholePage(upcoming), nil
+}
+
+func getForecast(r *http.Request, user *internal.User) (response any, err error) {
+	days, err := forecastDays(r.URL.Query().Get("days"))
+	if err != nil {
+		return
+	}
+	return projectForecast(r.Context(), user.ID, days, nil, "")
+}
+
+func postForecast(r *http.Request, user *internal.User) (response any, err error) {
+	var input forecastInput
+	if err = readAPIRequest(r, &input); err != nil {
+		return
+	}
+	if input.Days == 0 {
+		input.Days = defaultForecastDays
+	} else if input.Days < 0 || input.Days > maxForecastDays {
+		return nil, fmt.Errorf("%w: days must be between 1 and %d", errBadRequest, maxForecastDays)
+	}
+	return projectForecast(r.Context(), user.ID, input.Days, input.WhatIf, "what_if.")
+}
+
+func getSummaryReport(r *http.Request, user *internal.User) (response any, err error) {
+	return matcha.database.GetSummaryReport(r.Context(), user.ID)
+}
+
+func getOpenAPI(_ *http.Request, _ *internal.User) (response any, err error) {
+	return openAPIDocument(), nil
+}
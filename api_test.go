@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+)
+
+// apiTestDatabase has a verified user with a session, a read only token and one allowed to add accounts.
+func apiTestDatabase(t *testing.T) (db *fakeDatabase) {
+	db = &fakeDatabase{
+		user: internal.User{ID: 1, Email: "api@example.com", EmailVerifiedOn: time.Now()},
+		apiTokens: map[string][]internalDatabase.Scope{
+			"read":  {internalDatabase.ReadScope},
+			"write": {internalDatabase.ReadScope, internalDatabase.AccountsWriteScope},
+		},
+		institutions: []internal.Institution{{ID: 1, Name: "First"}, {ID: 2, Name: "Second"}, {ID: 3, Name: "Third"}},
+	}
+	useFakeDatabase(t, db)
+	return
+}
+
+// serveAPIRequest sends a request through the router, authenticated by a bearer token unless it is empty, or by the
+// session when it is "session".
+func serveAPIRequest(t *testing.T, method, path, token, body string) (w *httptest.ResponseRecorder) {
+	r := httptest.NewRequest(method, "http://example.com"+apiPrefix+path, strings.NewReader(body))
+	if token == "session" {
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: token})
+	} else if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	w = httptest.NewRecorder()
+	loggedRouter("").ServeHTTP(w, r)
+	return
+}
+
+func TestServeAPI(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		token  string
+		body   string
+		status int
+		// code is the code of the error, empty for successes.
+		code string
+	}{
+		{"no credentials", http.MethodGet, "/user", "", "", http.StatusUnauthorized, "unauthorized"},
+		{"unknown token", http.MethodGet, "/user", "unknown", "", http.StatusUnauthorized, "unauthorized"},
+		{"session", http.MethodGet, "/user", "session", "", http.StatusOK, ""},
+		{"token", http.MethodGet, "/user", "read", "", http.StatusOK, ""},
+		{
+			"token without scope", http.MethodPost, "/institutions", "read", `{"name":"Bank"}`,
+			http.StatusForbidden, "insufficient_scope",
+		},
+		{"token with scope", http.MethodPost, "/institutions", "write", `{"name":"Bank"}`, http.StatusCreated, ""},
+		{
+			"invalid fields", http.MethodPost, "/institutions", "write", `{"name":""}`,
+			http.StatusUnprocessableEntity, "invalid_fields",
+		},
+		{
+			"unknown field", http.MethodPost, "/institutions", "write", `{"title":"Bank"}`, http.StatusBadRequest,
+			"bad_request",
+		},
+		{"malformed id", http.MethodGet, "/accounts/first", "read", "", http.StatusBadRequest, "bad_request"},
+		{"account of nobody", http.MethodGet, "/accounts/7", "read", "", http.StatusNotFound, "not_found"},
+		{"no such endpoint", http.MethodGet, "/nothing", "read", "", http.StatusNotFound, "not_found"},
+		{"public", http.MethodGet, "/openapi.json", "", "", http.StatusOK, ""},
+	}
+	for _, tc := range tests {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				apiTestDatabase(t)
+				w := serveAPIRequest(t, tc.method, tc.path, tc.token, tc.body)
+				if w.Code != tc.status {
+					t.Error("Expected status", tc.status, "got", w.Code, w.Body.String())
+				}
+				if content := w.Header().Get("Content-Type"); content != "application/json" {
+					t.Error("Expected JSON, got", content)
+				}
+				var response apiError
+				if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+					t.Fatal("Failed to decode response -", err)
+				}
+				if response.Error.Code != tc.code {
+					t.Error("Expected error code", tc.code, "got", response.Error)
+				}
+				if tc.status == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+					t.Error("Expected a bearer challenge")
+				}
+			},
+		)
+	}
+}
+
+func TestServeAPIErrorFields(t *testing.T) {
+	apiTestDatabase(t)
+	w := serveAPIRequest(t, http.MethodPost, "/institutions", "write", `{"name":""}`)
+	var response apiError
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal("Failed to decode response -", err)
+	}
+	if response.Error.Message == "" || response.Error.Fields["name"] == "" {
+		t.Error("Expected the error to name the invalid field, got", response.Error)
+	}
+}
+
+func TestServeAPIUnverifiedEmail(t *testing.T) {
+	db := apiTestDatabase(t)
+	db.user.EmailVerifiedOn = time.Time{}
+	for _, token := range []string{"session", "read"} {
+		w := serveAPIRequest(t, http.MethodGet, "/user", token, "")
+		var response apiError
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal("Failed to decode response -", err)
+		}
+		if w.Code != http.StatusForbidden || response.Error.Code != "email_unverified" {
+			t.Error("Expected an unverified user to be refused by", token, "got", w.Code, response.Error)
+		}
+	}
+}
+
+func TestServeAPIDeletedUser(t *testing.T) {
+	db := apiTestDatabase(t)
+	db.deleted = true
+	for _, token := range []string{"session", "read"} {
+		if w := serveAPIRequest(t, http.MethodGet, "/user", token, ""); w.Code != http.StatusUnauthorized {
+			t.Error("Expected a deleted user to be logged out of", token, "got", w.Code)
+		}
+	}
+}
+
+func TestAPIPagination(t *testing.T) {
+	apiTestDatabase(t)
+	var names []string
+	cursor := ""
+	for pages := 0; pages < 3; pages++ {
+		path := "/institutions?limit=2"
+		if cursor != "" {
+			path += "&cursor=" + cursor
+		}
+		w := serveAPIRequest(t, http.MethodGet, path, "read", "")
+		var response struct {
+			Data       []internal.Institution `json:"data"`
+			NextCursor string                 `json:"next_cursor"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal("Failed to decode response -", err)
+		}
+		if len(response.Data) > 2 {
+			t.Error("Expected at most 2 institutions a page, got", response.Data)
+		}
+		for _, institution := range response.Data {
+			names = append(names, institution.Name)
+		}
+		if cursor = response.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if strings.Join(names, ",") != "First,Second,Third" {
+		t.Error("Expected every institution once over two pages, got", names)
+	}
+
+	for _, query := range []string{"?limit=0", "?limit=201", "?limit=two", "?cursor=-1"} {
+		w := serveAPIRequest(t, http.MethodGet, "/institutions"+query, "read", "")
+		var response apiError
+		if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+			t.Fatal("Failed to decode response -", err)
+		}
+		if w.Code != http.StatusBadRequest || response.Error.Code != "bad_request" {
+			t.Error("Expected", query, "to be refused, got", w.Code, response.Error)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+	"github.com/matcha-devs/matcha/internal/validation"
+)
+
+// apiTokenLifetimes are the days a new personal API token may last, 0 for tokens that never expire.
+var apiTokenLifetimes = []int{30, 90, 365, 0}
+
+// apiTokenFields are the API token form fields that may be marked with an error.
+var apiTokenFields = []string{"name", "scopes", "expires_in"}
+
+// scopeLabels describe what each scope allows on the settings page.
+var scopeLabels = map[internalDatabase.Scope]string{
+	internalDatabase.ReadScope:              "Read your profile, accounts, transactions, upcoming bills and reports",
+	internalDatabase.AccountsWriteScope:     "Add accounts and institutions",
+	internalDatabase.TransactionsWriteScope: "Record transactions and schedule recurring ones",
+}
+
+type scopeSetting struct {
+	Scope internalDatabase.Scope
+	Label string
+}
+
+type apiTokenSetting struct {
+	ID         uint64
+	Name       string
+	Scopes     []internalDatabase.Scope
+	CreatedOn  time.Time
+	ExpiresOn  time.Time
+	LastUsedOn time.Time
+	Expired    bool
+}
+
+// apiTokenSettings lists the personal API tokens of a user for the settings page, along with the scopes to choose
+// from for a new one.
+func apiTokenSettings(r *http.Request, data *pageData) (err error) {
+	tokens, err := matcha.database.GetAPITokens(r.Context(), data.User.ID)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, token := range tokens {
+		data.APITokens = append(
+			data.APITokens, apiTokenSetting{
+				token.ID, token.Name, token.Scopes, token.CreatedOn, token.ExpiresOn, token.LastUsedOn,
+				!token.ExpiresOn.IsZero() && !token.ExpiresOn.After(now),
+			},
+		)
+	}
+	for _, scope := range internalDatabase.Scopes {
+		data.Scopes = append(data.Scopes, scopeSetting{scope, scopeLabels[scope]})
+	}
+	data.APITokenLifetimes = apiTokenLifetimes
+	return
+}
+
+// postAPIToken creates a personal API token and shows it, the only time it can be seen.
+func postAPIToken(w http.ResponseWriter, r *http.Request) {
+	user := checkLoginStatus(w, r)
+	if user == nil || !requireVerifiedEmail(w, r, user) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeMessage(w, r, "please reload the page and try again")
+		return
+	}
+	var scopes []internalDatabase.Scope
+	for _, scope := range r.PostForm["scopes"] {
+		scopes = append(scopes, internalDatabase.Scope(scope))
+	}
+	days, err := strconv.Atoi(r.FormValue("expires_in"))
+	if err != nil || !slices.Contains(apiTokenLifetimes, days) {
+		writeFieldErrors(w, r, apiTokenFields, validation.Errors{"expires_in": "please choose when the token expires"})
+		return
+	}
+	token, err := matcha.database.CreateAPIToken(
+		r.Context(), user.ID, r.FormValue("name"), scopes, time.Duration(days)*24*time.Hour,
+	)
+	var errs validation.Errors
+	if errors.As(err, &errs) {
+		writeFieldErrors(w, r, apiTokenFields, errs)
+		return
+	} else if err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	writeFieldErrors(w, r, apiTokenFields, nil)
+	writeMessage(
+		w, r, "Copy your new token now, it won't be shown again. Send it as \"Authorization: Bearer "+token+"\".",
+	)
+}
+
+func postDeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	user := checkLoginStatus(w, r)
+	if user == nil {
+		return
+	}
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		writeMessage(w, r, "please reload the page and try again")
+		return
+	}
+	if err = matcha.database.DeleteAPIToken(r.Context(), user.ID, id); err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+)
+
+// postSettingsForm posts form to a settings handler as the user logged in with the session "session", or as nobody
+// when loggedIn is false.
+func postSettingsForm(
+	handler http.HandlerFunc, path string, form url.Values, loggedIn bool,
+) (w *httptest.ResponseRecorder) {
+	r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if loggedIn {
+		r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "session"})
+	}
+	w = httptest.NewRecorder()
+	handler(w, r)
+	return
+}
+
+func TestPostAPIToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		form     url.Values
+		loggedIn bool
+		verified bool
+		status   int
+		message  string
+	}{
+		{
+			"logged out", url.Values{"name": {"ci"}, "scopes": {"read"}, "expires_in": {"30"}}, false, true,
+			http.StatusUnauthorized, "Unauthorized login session.",
+		},
+		{
+			"unverified email", url.Values{"name": {"ci"}, "scopes": {"read"}, "expires_in": {"30"}}, true, false,
+			http.StatusForbidden, "Please verify your email first.",
+		},
+		{
+			"unknown lifetime", url.Values{"name": {"ci"}, "scopes": {"read"}, "expires_in": {"7"}}, true, true,
+			http.StatusOK, "please choose when the token expires",
+		},
+		{
+			"no scopes", url.Values{"name": {"ci"}, "expires_in": {"30"}}, true, true, http.StatusOK,
+			"please choose at least one scope",
+		},
+		{
+			"created", url.Values{"name": {"ci"}, "scopes": {"read", "accounts:write"}, "expires_in": {"0"}}, true,
+			true, http.StatusOK, "Authorization: Bearer " + internalDatabase.APITokenPrefix + "ci",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				db := &fakeDatabase{user: internal.User{ID: 1, Email: "tokens@example.com"}}
+				if tc.verified {
+					db.user.EmailVerifiedOn = time.Now()
+				}
+				useFakeDatabase(t, db)
+				w := postSettingsForm(postAPIToken, "/api-tokens", tc.form, tc.loggedIn)
+				if w.Code != tc.status || !strings.Contains(w.Body.String(), tc.message) {
+					t.Error("Expected", tc.status, tc.message, "got", w.Code, w.Body.String())
+				}
+				scopes, created := db.apiTokens[internalDatabase.APITokenPrefix+"ci"]
+				if created != (tc.name == "created") {
+					t.Error("Expected a token to be created only when the form is valid, got", db.apiTokens)
+				} else if created && !slices.Equal(
+					scopes, []internalDatabase.Scope{internalDatabase.ReadScope, internalDatabase.AccountsWriteScope},
+				) {
+					t.Error("Expected the token to have the chosen scopes, got", scopes)
+				}
+			},
+		)
+	}
+}
+
+func TestPostDeleteAPIToken(t *testing.T) {
+	db := &fakeDatabase{user: internal.User{ID: 1, Email: "tokens@example.com", EmailVerifiedOn: time.Now()}}
+	useFakeDatabase(t, db)
+
+	if w := postSettingsForm(
+		postDeleteAPIToken, "/api-tokens/delete", url.Values{"id": {"4"}}, false,
+	); w.Code != http.StatusUnauthorized || len(db.revoked) != 0 {
+		t.Error("Expected a logged out request to be refused, got", w.Code, db.revoked)
+	}
+	if w := postSettingsForm(
+		postDeleteAPIToken, "/api-tokens/delete", url.Values{"id": {"four"}}, true,
+	); w.Body.String() != "please reload the page and try again" || len(db.revoked) != 0 {
+		t.Error("Expected a malformed id to be refused, got", w.Body.String(), db.revoked)
+	}
+	w := postSettingsForm(postDeleteAPIToken, "/api-tokens/delete", url.Values{"id": {"4"}}, true)
+	if w.Header().Get("HX-Refresh") != "true" || !slices.Equal(db.revoked, []uint64{4}) {
+		t.Error("Expected token 4 of the user to be revoked, got", db.revoked)
+	}
+}
@@ -1,39 +1,137 @@
 package main
 
 import (
-	"log"
+	"context"
+	"log/slog"
 	"time"
+
+	"github.com/matcha-devs/matcha/internal/lifecycle"
+	"github.com/matcha-devs/matcha/internal/mailer"
+	"github.com/matcha-devs/matcha/internal/secrets"
+	"github.com/matcha-devs/matcha/internal/tracing"
+	"github.com/matcha-devs/matcha/internal/webauthn"
+	"github.com/matcha-devs/matcha/internal/webhooks"
+)
+
+const (
+	purgeInterval = time.Hour
+	// recurringInterval is how often occurrences of recurring transactions nobody recorded are looked for.
+	recurringInterval = time.Hour
 )
 
 type app struct {
-	server   server
-	database database
+	server       server
+	database     database
+	baseURL      string
+	relyingParty webauthn.RelyingParty
+	secrets      *secrets.Box
+	lifecycle    *lifecycle.Manager
+	// tasks runs the work handlers leave for after answering.
+	tasks *lifecycle.Tasks
 }
 
-func newApp(server server, db database) *app {
-	return &app{server, db}
+// newApp wires the components, baseURL is where users reach the app and prefixes the links sent to them by email, rp
+// is the same site as passkeys know it.
+// Mail is queued in the database and handed to mail by a background job, box encrypts secrets stored in the database
+// and may be nil, which turns off features that need it. Webhooks are queued in the database too and sent by hooks.
+func newApp(
+	server server, db database, mail mailer.Mailer, hooks *webhooks.Dispatcher, baseURL string,
+	rp webauthn.RelyingParty, box *secrets.Box,
+) (matcha *app) {
+	matcha = &app{server, db, baseURL, rp, box, lifecycle.New(5 * time.Second), &lifecycle.Tasks{}}
+	matcha.lifecycle.Register(
+		lifecycle.Component{
+			Name: "tracing",
+			Stop: tracing.Shutdown,
+		},
+	)
+	matcha.lifecycle.Register(
+		lifecycle.Component{
+			Name:      "database",
+			DependsOn: []string{"tracing"},
+			Start:     func(context.Context) error { return db.Open() },
+			Stop:      func(context.Context) error { return db.Close() },
+		},
+	)
+	matcha.lifecycle.Register(matcha.tasks.Component("tasks", []string{"database"}))
+	matcha.lifecycle.Register(lifecycle.Background("mailer", []string{"database"}, mailer.NewOutbox(db, mail).Run))
+	matcha.lifecycle.Register(lifecycle.Background("webhooks", []string{"database"}, hooks.Run))
+	matcha.lifecycle.Register(lifecycle.Background("purger", []string{"database"}, purgeDeletedUsers(db)))
+	matcha.lifecycle.Register(lifecycle.Background("recurring", []string{"database"}, materializeRecurring(db)))
+	matcha.lifecycle.Register(
+		lifecycle.Component{
+			Name:      "server",
+			DependsOn: []string{"database", "tasks", "tracing"},
+			Start: func(context.Context) (err error) {
+				go func() {
+					if err := server.Run(); err != nil {
+						slog.Error("Server run error", "err", err)
+					}
+				}()
+				return
+			},
+			Stop:            server.Shutdown,
+			ShutdownTimeout: 10 * time.Second,
+		},
+	)
+	return
 }
 
-func (app *app) run() {
-	if err := app.database.Open(); err != nil {
-		log.Println("database open error -", err)
+// purgeDeletedUsers returns a job erasing users whose deletion grace period is over, checking every purgeInterval.
+func purgeDeletedUsers(db database) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(purgeInterval)
+		defer ticker.Stop()
+		for {
+			if purged, err := db.PurgeDeletedUsers(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error purging deleted users", "err", err)
+			} else if purged > 0 {
+				slog.InfoContext(ctx, "Purged deleted users", "count", purged)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
 	}
-	if err := app.server.Run(); err != nil {
-		log.Println("server run error -", err)
+}
+
+// materializeRecurring returns a job recording the occurrences of recurring transactions that went without a
+// matching transaction, checking every recurringInterval.
+func materializeRecurring(db database) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		ticker := time.NewTicker(recurringInterval)
+		defer ticker.Stop()
+		for {
+			if recorded, err := db.MaterializeRecurring(ctx); err != nil {
+				slog.ErrorContext(ctx, "Error recording recurring transactions", "err", err)
+			} else if recorded > 0 {
+				slog.InfoContext(ctx, "Recorded recurring transactions", "count", recorded)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
 	}
 }
 
+// run starts every component, background jobs keep running until ctx is cancelled or the app is closed.
+func (app *app) run(ctx context.Context) (err error) {
+	return app.lifecycle.Start(ctx)
+}
+
 func (app *app) close() {
-	var success = true
-	if err := app.server.Shutdown(10 * time.Second); err != nil {
-		log.Println("Failed to shutdown server -", err)
-		success = false
-	}
-	if err := app.database.Close(); err != nil {
-		log.Println("Failed to close database -", err)
-		success = false
+	report := app.lifecycle.Stop()
+	if len(report.Failures) == 0 {
+		slog.Info("All dependencies closed", "stopped", report.Stopped)
+		return
 	}
-	if success {
-		log.Println("All dependencies closed 👌🏽")
+	failed := make([]string, 0, len(report.Failures))
+	for _, failure := range report.Failures {
+		failed = append(failed, failure.Component)
 	}
+	slog.Error("Some dependencies failed to close", "failed", failed, "stopped", report.Stopped, "err", report.Err())
 }
@@ -1,22 +1,128 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"time"
 
 	"github.com/matcha-devs/matcha/internal"
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+	"github.com/matcha-devs/matcha/internal/mailer"
+	"github.com/matcha-devs/matcha/internal/validation"
+	"github.com/matcha-devs/matcha/internal/webauthn"
+	"github.com/matcha-devs/matcha/internal/webhooks"
 )
 
 type server interface {
 	Run() (err error)
-	Shutdown(maxClientDisconnectTime time.Duration) (err error)
+	Shutdown(ctx context.Context) (err error)
 }
 
 type database interface {
 	Open() (err error)
 	Close() (err error)
-	AuthenticateLogin(email, password string) (id uint64, err error)
-	GetUser(id uint64) (user *internal.User)
-	AddUser(firstName, middleName, lastName, email, password, dateOfBirth string) (id uint64, err error)
-	GetUserID(email string) (id uint64)
-	DeleteUser(id uint64) (err error)
+	Stats() (stats sql.DBStats)
+	AuthenticateLogin(ctx context.Context, email, password string) (id uint64, err error)
+	GetUser(ctx context.Context, id uint64) (user *internal.User)
+	AddUser(
+		ctx context.Context, firstName, middleName, lastName, email, password, dateOfBirth string,
+	) (id uint64, err error)
+	GetUserID(ctx context.Context, email string) (id uint64)
+	DeleteUser(ctx context.Context, id uint64) (err error)
+	RestoreUser(ctx context.Context, token string) (id uint64, err error)
+	PurgeDeletedUsers(ctx context.Context) (purged int, err error)
+	UnlockUser(ctx context.Context, email string) (err error)
+	ResetPassword(ctx context.Context, token, password string) (id uint64, err error)
+	UpdateUserProfile(ctx context.Context, id uint64, firstName, middleName, lastName, dateOfBirth string) (err error)
+	ChangePassword(ctx context.Context, id uint64, currentPassword, newPassword, keepSession string) (err error)
+	VerifyEmail(ctx context.Context, token string) (id uint64, err error)
+	ChangeEmail(ctx context.Context, id uint64, email string) (err error)
+	CreateSession(ctx context.Context, userID uint64, ttl time.Duration) (token string, err error)
+	GetSessionUser(ctx context.Context, token string) (user *internal.User)
+	DeleteSession(ctx context.Context, token string) (err error)
+	DeleteUserSessions(ctx context.Context, userID uint64) (err error)
+	CreateUserToken(
+		ctx context.Context, userID uint64, purpose internalDatabase.TokenPurpose, ttl time.Duration,
+	) (token string, err error)
+	ConsumeUserToken(
+		ctx context.Context, purpose internalDatabase.TokenPurpose, token string,
+	) (userID uint64, err error)
+	CheckUserToken(ctx context.Context, purpose internalDatabase.TokenPurpose, token string) (userID uint64, err error)
+	EnableTOTP(ctx context.Context, id uint64, encryptedSecret []byte) (recoveryCodes []string, err error)
+	GetTOTPSecret(ctx context.Context, id uint64) (encryptedSecret []byte, err error)
+	UseTOTPStep(ctx context.Context, id uint64, step int64) (err error)
+	UseRecoveryCode(ctx context.Context, id uint64, code string) (err error)
+	DisableTOTP(ctx context.Context, id uint64) (err error)
+	CreateWebAuthnChallenge(
+		ctx context.Context, userID uint64, ceremony internalDatabase.Ceremony, ttl time.Duration,
+	) (challenge string, err error)
+	ConsumeWebAuthnChallenge(
+		ctx context.Context, ceremony internalDatabase.Ceremony, challenge string,
+	) (userID uint64, err error)
+	AddPasskey(ctx context.Context, userID uint64, credential webauthn.Credential, name string) (err error)
+	GetPasskeys(ctx context.Context, userID uint64) (passkeys []internalDatabase.Passkey, err error)
+	GetPasskey(ctx context.Context, credentialID []byte) (userID uint64, credential webauthn.Credential, err error)
+	UsePasskey(ctx context.Context, credentialID []byte, signCount uint32) (err error)
+	DeletePasskey(ctx context.Context, userID uint64, credentialID []byte) (err error)
+	CreateAPIToken(
+		ctx context.Context, userID uint64, name string, scopes []internalDatabase.Scope, ttl time.Duration,
+	) (token string, err error)
+	GetAPITokens(ctx context.Context, userID uint64) (tokens []internalDatabase.APIToken, err error)
+	DeleteAPIToken(ctx context.Context, userID, id uint64) (err error)
+	AuthenticateAPIToken(
+		ctx context.Context, token string,
+	) (user *internal.User, scopes []internalDatabase.Scope, err error)
+	mailer.Store
+	webhooks.Store
+	AddWebhook(
+		ctx context.Context, userID uint64, url string, events []webhooks.Event,
+	) (secret string, err error)
+	GetWebhooks(ctx context.Context, userID uint64) (hooks []internalDatabase.Webhook, err error)
+	DeleteWebhook(ctx context.Context, userID, id uint64) (err error)
+	SendTestWebhook(ctx context.Context, userID, id uint64) (err error)
+	EnqueueMail(ctx context.Context, message mailer.Message) (err error)
+	GetInstitutions(
+		ctx context.Context, userID, after uint64, limit int,
+	) (institutions []internal.Institution, err error)
+	AddInstitution(ctx context.Context, userID uint64, name string) (institution internal.Institution, err error)
+	GetAccounts(
+		ctx context.Context, userID, after uint64, limit int,
+	) (accounts []internal.FinancialAccount, err error)
+	GetAccount(ctx context.Context, userID, id uint64) (account internal.FinancialAccount, err error)
+	AddAccount(
+		ctx context.Context, userID uint64, account internal.FinancialAccount,
+	) (added internal.FinancialAccount, err error)
+	GetTransactions(
+		ctx context.Context, userID, accountID, after uint64, limit int,
+	) (transactions []internal.Transaction, err error)
+	GetTransaction(ctx context.Context, userID, id uint64) (transaction internal.Transaction, err error)
+	AddTransaction(
+		ctx context.Context, userID uint64, transaction internal.Transaction,
+	) (added internal.Transaction, err error)
+	SetSplits(
+		ctx context.Context, userID, id uint64, splits []internal.Split,
+	) (transaction internal.Transaction, err error)
+	AddTransfer(
+		ctx context.Context, userID, fromAccountID, toAccountID uint64, amount int64, date string,
+	) (from, to internal.Transaction, err error)
+	LinkTransfer(ctx context.Context, userID, id, otherID uint64) (err error)
+	UnlinkTransfer(ctx context.Context, userID, id uint64) (err error)
+	GetRecurring(ctx context.Context, userID uint64) (recurring []internal.RecurringTransaction, err error)
+	AddRecurring(
+		ctx context.Context, userID uint64, recurring internal.RecurringTransaction,
+	) (added internal.RecurringTransaction, err error)
+	DeleteRecurring(ctx context.Context, userID, id uint64) (err error)
+	GetUpcoming(ctx context.Context, userID uint64, until time.Time) (upcoming []internal.Occurrence, err error)
+	MaterializeRecurring(ctx context.Context) (recorded int, err error)
+	GetUserData(ctx context.Context, userID uint64) (data internal.UserData, err error)
+	GetSummaryReport(ctx context.Context, userID uint64) (report internal.SummaryReport, err error)
+	ImportUserData(
+		ctx context.Context, userID uint64, profile validation.Profile, data internal.UserData,
+	) (err error)
+	GetNotificationPreferences(
+		ctx context.Context, userID uint64,
+	) (preferences map[internalDatabase.NotificationKind]bool, err error)
+	SetNotificationPreference(
+		ctx context.Context, userID uint64, kind internalDatabase.NotificationKind, enabled bool,
+	) (err error)
 }
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+	"github.com/matcha-devs/matcha/internal/forecast"
+	"github.com/matcha-devs/matcha/internal/recurrence"
+	"github.com/matcha-devs/matcha/internal/validation"
+)
+
+const (
+	defaultForecastDays = 30
+	maxForecastDays     = 365
+)
+
+// forecastHorizons are the days ahead the dashboard offers to forecast.
+var forecastHorizons = []int{30, 90, 365}
+
+// whatIfFields are the what-if form fields that may be marked with an error.
+var whatIfFields = []string{"what_if_account", "what_if_amount", "what_if_rule", "what_if_starts_on"}
+
+// scenario is a what-if recurring transaction, forecast along with the real ones but never stored.
+type scenario struct {
+	FinancialAccountID uint64 `json:"financial_account_id"`
+	Name               string `json:"name,omitempty"`
+	Amount             int64  `json:"amount"`
+	Rule               string `json:"rule"`
+	// StartsOn is YYYY-MM-DD, today when left out.
+	StartsOn string `json:"starts_on,omitempty"`
+	EndsOn   string `json:"ends_on,omitempty"`
+}
+
+type forecastInput struct {
+	Days   int        `json:"days"`
+	WhatIf []scenario `json:"what_if"`
+}
+
+// forecastRow sums up the forecast of an account for the dashboard.
+type forecastRow struct {
+	Name     string
+	Balance  string
+	End      string
+	Lowest   string
+	LowestOn string
+	Negative bool
+}
+
+// forecastView is the forecast the dashboard shows, what-if ones included.
+type forecastView struct {
+	Days     int
+	Horizons []int
+	To       string
+	Accounts []forecastRow
+	Warnings []forecast.Warning
+	WhatIf   string
+}
+
+// projectForecast forecasts the accounts of a user days ahead from today, moved by their recurring transactions and
+// the what-if scenarios, which are checked like recurring transactions are but never stored. Invalid scenarios are
+// refused with validation.Errors under fieldPrefix and their index, like what_if.0.rule.
+func projectForecast(
+	ctx context.Context, userID uint64, days int, whatIf []scenario, fieldPrefix string,
+) (projected forecast.Forecast, err error) {
+	accounts, err := allAccounts(ctx, userID)
+	if err != nil {
+		return
+	}
+	recurring, err := matcha.database.GetRecurring(ctx, userID)
+	if err != nil {
+		return
+	}
+	today := recurrence.Day(time.Now())
+	balances := make([]forecast.Account, 0, len(accounts))
+	for _, account := range accounts {
+		balances = append(balances, forecast.Account{ID: account.ID, Name: account.Name, Balance: account.NetValue})
+	}
+	var flows []forecast.Flow
+	for _, scheduled := range recurring {
+		if scheduled.NextOn == "" {
+			continue
+		}
+		flow := forecast.Flow{
+			AccountID: scheduled.FinancialAccountID, Name: scheduled.Name, Amount: scheduled.Amount,
+		}
+		if flow.Rule, err = recurrence.Parse(scheduled.Rule); err != nil {
+			return projected, fmt.Errorf("recurring transaction %d: %w", scheduled.ID, err)
+		}
+		flow.Start, _ = time.Parse(validation.DateLayout, scheduled.StartsOn)
+		flow.First, _ = time.Parse(validation.DateLayout, scheduled.NextOn)
+		if scheduled.EndsOn != "" {
+			flow.End, _ = time.Parse(validation.DateLayout, scheduled.EndsOn)
+		}
+		flows = append(flows, flow)
+	}
+	errs := validation.Errors{}
+	for i, what := range whatIf {
+		field := fieldPrefix + strconv.Itoa(i) + "."
+		flow := forecast.Flow{AccountID: what.FinancialAccountID, Name: "What if", Amount: what.Amount}
+		if name := strings.TrimSpace(what.Name); name != "" {
+			flow.Name = name
+		}
+		if !slices.ContainsFunc(
+			accounts, func(account internal.FinancialAccount) bool { return account.ID == what.FinancialAccountID },
+		) {
+			errs.Add(field+"financial_account_id", "you have no account with this id")
+		}
+		if what.Amount == 0 {
+			errs.Add(field+"amount", "please enter an amount other than 0")
+		}
+		var ruleErr error
+		if flow.Rule, ruleErr = recurrence.Parse(what.Rule); ruleErr != nil {
+			errs.Add(field+"rule", "please choose how often it repeats, like FREQ=MONTHLY;BYMONTHDAY=1")
+		}
+		flow.Start = today
+		if what.StartsOn != "" {
+			flow.Start = validation.Date(errs, field+"starts_on", what.StartsOn)
+		}
+		if what.EndsOn != "" {
+			flow.End = validation.Date(errs, field+"ends_on", what.EndsOn)
+		}
+		// Like recurring transactions, only what is still to come counts.
+		if flow.First = flow.Start; flow.First.Before(today) {
+			flow.First = today
+		}
+		flows = append(flows, flow)
+	}
+	if len(errs) > 0 {
+		return projected, errs
+	}
+	return forecast.Project(balances, flows, today, days), nil
+}
+
+// allAccounts lists every financial account of a user, however many pages they take.
+func allAccounts(ctx context.Context, userID uint64) (accounts []internal.FinancialAccount, err error) {
+	var after uint64
+	for {
+		page, err := matcha.database.GetAccounts(ctx, userID, after, maxPageSize)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, page...)
+		if len(page) < maxPageSize {
+			return accounts, nil
+		}
+		after = page[len(page)-1].ID
+	}
+}
+
+// newForecastView sums up a forecast for the dashboard, whatIf describes the scenario it was made with if any.
+func newForecastView(projected forecast.Forecast, days int, whatIf string) (view forecastView) {
+	view = forecastView{
+		Days: days, Horizons: forecastHorizons, To: projected.To, Warnings: projected.Warnings, WhatIf: whatIf,
+	}
+	for _, account := range projected.Accounts {
+		start := account.Balances[0].Balance
+		end := account.Balances[len(account.Balances)-1].Balance
+		view.Accounts = append(
+			view.Accounts, forecastRow{
+				account.Name, formatCents(start), formatCents(end), formatCents(account.Lowest), account.LowestOn,
+				account.Lowest < 0,
+			},
+		)
+	}
+	return
+}
+
+// forecastDays parses how many days ahead to forecast, defaultForecastDays when text is empty.
+func forecastDays(text string) (days int, err error) {
+	if text == "" {
+		return defaultForecastDays, nil
+	}
+	if days, err = strconv.Atoi(text); err != nil || days < 1 || days > maxForecastDays {
+		return 0, fmt.Errorf("%w: days must be between 1 and %d", errBadRequest, maxForecastDays)
+	}
+	return
+}
+
+// postWhatIf forecasts the dashboard's accounts as if the scenario of the what-if form were a recurring transaction,
+// swapping the forecast in place of the real one without storing anything.
+func postWhatIf(w http.ResponseWriter, r *http.Request) {
+	user := checkLoginStatus(w, r)
+	if user == nil || !requireVerifiedEmail(w, r, user) {
+		return
+	}
+	days, err := forecastDays(r.FormValue("days"))
+	if err != nil {
+		writeMessage(w, r, "please reload the page and try again")
+		return
+	}
+	errs := validation.Errors{}
+	accountID, err := strconv.ParseUint(r.FormValue("what_if_account"), 10, 64)
+	if err != nil {
+		errs.Add("what_if_account", "please choose an account")
+	}
+	amount, ok := parseCents(r.FormValue("what_if_amount"))
+	if !ok || amount <= 0 {
+		errs.Add("what_if_amount", "please enter an amount like 500.00")
+	}
+	if r.FormValue("what_if_direction") != "in" {
+		amount = -amount
+	}
+	what := scenario{
+		FinancialAccountID: accountID, Name: r.FormValue("what_if_name"), Amount: amount,
+		Rule: r.FormValue("what_if_rule"), StartsOn: r.FormValue("what_if_starts_on"),
+	}
+	projected, err := projectForecast(r.Context(), user.ID, days, []scenario{what}, "")
+	var scenarioErrs validation.Errors
+	if errors.As(err, &scenarioErrs) {
+		// The form names its fields apart from the API, map them back.
+		for field, message := range scenarioErrs {
+			switch strings.TrimPrefix(field, "0.") {
+			case "financial_account_id":
+				errs.Add("what_if_account", message)
+			case "amount":
+				errs.Add("what_if_amount", message)
+			case "rule":
+				errs.Add("what_if_rule", message)
+			case "starts_on":
+				errs.Add("what_if_starts_on", message)
+			}
+		}
+	} else if err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, r, whatIfFields, errs)
+		return
+	}
+	name := strings.TrimSpace(what.Name)
+	if name == "" {
+		name = "this"
+	}
+	writeFieldErrors(w, r, whatIfFields, nil)
+	executeTemplate(
+		w, r, "forecast-update", newForecastView(projected, days, "With "+name+" at "+formatCents(amount)+" "+
+			describeRule(what.Rule)+", nothing saved."),
+	)
+}
+
+// describeRule puts a rule in words, or leaves it as is when it can't be parsed.
+func describeRule(text string) string {
+	if rule, err := recurrence.Parse(text); err == nil {
+		return rule.Describe()
+	}
+	return text
+}
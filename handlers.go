@@ -1,18 +1,21 @@
 package main
 
 import (
+	"context"
 	"embed"
 	"errors"
 	"html/template"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/mail"
-	"strconv"
+	"net/url"
 	"strings"
 	"time"
 
 	"github.com/matcha-devs/matcha/internal"
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+	"github.com/matcha-devs/matcha/internal/tracing"
+	"github.com/matcha-devs/matcha/internal/validation"
 )
 
 var (
@@ -20,153 +23,293 @@ var (
 	content        embed.FS
 	publicServer   = http.FileServer(http.FS(content))
 	templateServer = template.Must(template.ParseFS(content, "internal/templates/*.go.html"))
-	surfacePages   = map[string]struct{}{"signup": {}, "login": {}}
+	surfacePages   = map[string]struct{}{
+		"signup": {}, "login": {}, "forgot-password": {}, "reset-password": {}, "verify-email": {},
+		"two-factor-login": {}, "restore-account": {},
+	}
 )
 
+// pageData is what every page template is rendered with.
+type pageData struct {
+	PageName      string
+	User          *internal.User
+	CSRFToken     string
+	Token         string
+	Notifications []notificationSetting
+	TwoFactor     twoFactorSetup
+	Passkeys      []passkeySetting
+	APITokens     []apiTokenSetting
+	Scopes        []scopeSetting
+	// APITokenLifetimes are the days new API tokens can be chosen to last.
+	APITokenLifetimes []int
+	Webhooks          []internalDatabase.Webhook
+	WebhookEvents     []eventSetting
+	Dashboard         dashboardData
+}
+
+func newPageData(r *http.Request, pageName string) pageData {
+	return pageData{PageName: pageName, CSRFToken: csrfToken(r), Token: r.URL.Query().Get("token")}
+}
+
+// userMessage maps a database error to what the browser is told, never revealing whether an email is registered.
+func userMessage(err error) string {
+	switch {
+	case errors.Is(err, internalDatabase.ErrInvalidCredentials):
+		return "invalid email or password"
+	case errors.Is(err, internalDatabase.ErrAccountDeleted):
+		return "this account is being deleted, use the link we emailed you to restore it"
+	case errors.Is(err, internalDatabase.ErrEmptyFields):
+		return "please fill in all required fields"
+	case errors.As(err, new(validation.Errors)):
+		return "please correct the highlighted fields"
+	case errors.Is(err, internalDatabase.ErrInvalidToken):
+		return "this link is invalid or has expired"
+	case errors.Is(err, internalDatabase.ErrInvalidCode):
+		return "invalid authentication code"
+	case errors.Is(err, errTwoFactorUnavailable):
+		return "two-factor authentication isn't available right now"
+	case errors.Is(err, internalDatabase.ErrEmailTaken):
+		// Kept vague for any caller, signups never get here since they answer the same whether the email is free.
+		return "we couldn't use that email address"
+	default:
+		return "internal server error"
+	}
+}
+
+// fieldError is an error message shown under the form field called Name, empty messages clear the field.
+type fieldError struct {
+	Name    string
+	Message string
+}
+
+// writeFieldErrors writes the message of every field in fields, swapping each into the element with id
+// "<field>-error" out of band, so errors fixed since the last submission disappear. No errors clears them all.
+func writeFieldErrors(w http.ResponseWriter, r *http.Request, fields []string, errs validation.Errors) {
+	data := struct {
+		Fields  []fieldError
+		Message string
+	}{}
+	if len(errs) > 0 {
+		data.Message = userMessage(errs)
+	}
+	for _, field := range fields {
+		data.Fields = append(data.Fields, fieldError{field, errs[field]})
+	}
+	executeTemplate(w, r, "field-errors", data)
+}
+
+// writeMessage writes a short message into the HTMX target of the request.
+func writeMessage(w http.ResponseWriter, r *http.Request, message string) {
+	if _, err := io.WriteString(w, message); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing message", "message", message, "err", err)
+	}
+}
+
 func getPublic(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "max-age=3600")
 	publicServer.ServeHTTP(w, r)
 }
 
-func getIndex(w http.ResponseWriter, _ *http.Request) {
-	err := templateServer.ExecuteTemplate(w, "index.go.html", nil)
-	if err != nil {
-		log.Println("Error executing template index.go.html -", err)
-	}
-}
-
-func setSessionCookie(w http.ResponseWriter, id uint64) {
-	log.Println("Issued cookie for id:", id)
-	http.SetCookie(
-		w, &http.Cookie{
-			Name:     "c_user_id",
-			Value:    strconv.FormatUint(id, 10),
-			Path:     "/",
-			Expires:  time.Now().Add(20 * time.Minute),
-			MaxAge:   20 * 60,
-			HttpOnly: true,
-			SameSite: http.SameSiteLaxMode,
-		},
-	)
+// executeTemplate renders a template under its own span, so slow renders can be told apart from slow queries.
+func executeTemplate(w http.ResponseWriter, r *http.Request, name string, data any) {
+	ctx, span := tracing.Start(r.Context(), "template "+name)
+	defer span.End()
+	// Links in pages may carry tokens, like password reset links do, which must not leak to other sites.
+	w.Header().Set("Referrer-Policy", "same-origin")
+	if err := templateServer.ExecuteTemplate(w, name, data); err != nil {
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Error executing template", "template", name, "err", err)
+	}
 }
 
+func getIndex(w http.ResponseWriter, r *http.Request) {
+	executeTemplate(w, r, "index.go.html", newPageData(r, ""))
+}
+
+// signupMessage answers every valid signup, whether the email was free or not.
+const signupMessage = "Check your email to finish signing up."
+
+// signupFields are the signup form fields that may be marked with an error.
+var signupFields = []string{"first_name", "middle_name", "last_name", "email", "date_of_birth", "psw", "psw-repeat"}
+
 func postSignup(w http.ResponseWriter, r *http.Request) {
-	firstName := r.FormValue("first_name")
-	// TODO(@seoyoungcho213): Validate user data way better here.
-	middleName := r.FormValue("middle_name")
-	lastName := r.FormValue("last_name")
-	email := r.FormValue("email")
-	// TODO(@seoyoungcho213): read about mail.ParseAddress' return type and use it to add first+last name formatting too
-	if _, err := mail.ParseAddress("<" + email + ">"); err != nil {
-		log.Println("Error adding user {"+email+"} to database -", err)
-		if _, err := io.WriteString(w, err.Error()); err != nil {
-			log.Println("Error writing signup email format error -", err)
-		}
+	ctx := r.Context()
+	signup := validation.Signup{
+		Profile: profileForm(r), Email: r.FormValue("email"), Password: r.FormValue("psw"),
+	}
+	errs := validation.Errors{}
+	if _, err := signup.Validate(time.Now()); !errors.As(err, &errs) && err != nil {
+		writeMessage(w, r, userMessage(err))
 		return
 	}
-	password := r.FormValue("psw")
-	if password != r.FormValue("psw-repeat") {
-		log.Println("Passwords didnt match.")
-		if _, err := io.WriteString(w, "passwords do not match"); err != nil {
-			log.Println("Error writing signup failure -", err)
-		}
+	if signup.Password != r.FormValue("psw-repeat") {
+		errs.Add("psw-repeat", "passwords do not match")
+	}
+	if len(errs) > 0 {
+		slog.InfoContext(ctx, "Signup refused", "err", errs)
+		writeFieldErrors(w, r, signupFields, errs)
 		return
 	}
-	dateOfBirth := r.FormValue("date_of_birth")
-	// TODO(@FaaizMemonPurdue): Add API call timeouts.
-	id, err := matcha.database.AddUser(firstName, middleName, lastName, email, password, dateOfBirth)
-	if err != nil {
-		log.Println("Error adding user {"+email+"} to database -", err)
-		if _, err := io.WriteString(w, err.Error()); err != nil {
-			log.Println("Error writing server error -", err)
+	if ok, retryAfter := mailLimits.allow(r, signup.Email); !ok {
+		tooManyAttempts(w, r, retryAfter)
+		return
+	}
+	id, err := matcha.database.AddUser(
+		ctx, signup.FirstName, signup.MiddleName, signup.LastName, signup.Email, signup.Password, signup.DateOfBirth,
+	)
+	if errors.As(err, &errs) {
+		writeFieldErrors(w, r, signupFields, errs)
+		return
+	} else if errors.Is(err, internalDatabase.ErrEmailTaken) {
+		// The owner of the email hears about it instead, so the answer doesn't tell whether it's registered.
+		data := map[string]any{"LoginURL": matcha.baseURL + "/login", "ResetURL": matcha.baseURL + "/forgot-password"}
+		if err := queueMail(ctx, signup.Email, "signup-attempt", data); err != nil {
+			slog.ErrorContext(ctx, "Error warning of signup attempt", "email", signup.Email, "err", err)
 		}
+	} else if err != nil {
+		slog.InfoContext(ctx, "Error adding user to database", "email", signup.Email, "err", err)
+		writeMessage(w, r, userMessage(err))
 		return
+	} else if err := sendVerification(ctx, id, signup.Email); err != nil {
+		slog.ErrorContext(ctx, "Error sending verification", "user_id", id, "err", err)
 	}
-	setSessionCookie(w, id)
-	w.Header().Set("HX-Redirect", "/dashboard")
+	// Sessions only start at login once the link proved the email is theirs. Errors of earlier attempts are cleared.
+	writeFieldErrors(w, r, signupFields, nil)
+	writeMessage(w, r, signupMessage)
 }
 
-func postLogout(w http.ResponseWriter, _ *http.Request) {
-	setSessionCookie(w, 0)
+func postLogout(w http.ResponseWriter, r *http.Request) {
+	endSession(w, r)
 	w.Header().Set("HX-Redirect", "/")
 }
 
 func postLogin(w http.ResponseWriter, r *http.Request) {
-	// TODO(@FaaizMemonPurdue): Add API call timeouts.
-	id, err := matcha.database.AuthenticateLogin(r.FormValue("email"), r.FormValue("password"))
-	if err != nil {
-		log.Println("Login failed -", err)
-		if _, err := io.WriteString(w, err.Error()); err != nil {
-			log.Println("Error writing login failure -", err)
+	ctx := r.Context()
+	email := validation.NormalizeEmail(r.FormValue("email"))
+	if ok, retryAfter := loginLimits.allow(r, email); !ok {
+		logins.Inc("throttled")
+		tooManyAttempts(w, r, retryAfter)
+		return
+	}
+	id, err := matcha.database.AuthenticateLogin(ctx, email, r.FormValue("password"))
+	if errors.Is(err, internalDatabase.ErrAccountDeleted) {
+		logins.Inc("deleted")
+		loginLimits.succeeded(email)
+		writeMessage(w, r, userMessage(err))
+		return
+	} else if err != nil {
+		logins.Inc("failure")
+		loginLimits.failed(email)
+		slog.InfoContext(ctx, "Login failed", "err", err)
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	loginLimits.succeeded(email)
+	user := matcha.database.GetUser(ctx, id)
+	if user == nil {
+		writeMessage(w, r, userMessage(internalDatabase.ErrInternal))
+		return
+	}
+	if !user.EmailVerified() {
+		// Signing up doesn't log in, sessions wait for the email to be proven, so the link is sent again.
+		logins.Inc("unverified")
+		if ok, _ := mailLimits.allow(r, user.Email); ok {
+			if err := sendVerification(ctx, id, user.Email); err != nil {
+				slog.ErrorContext(ctx, "Error sending verification", "user_id", id, "err", err)
+			}
 		}
+		writeMessage(w, r, "Please verify your email first with the link we've emailed you.")
+		return
+	}
+	if user.TOTPEnabled {
+		logins.Inc("second_factor")
+		if err := beginTwoFactorLogin(w, r, id); err != nil {
+			writeMessage(w, r, userMessage(err))
+		}
+		return
+	}
+	logins.Inc("success")
+	if err := startSession(w, r, id); err != nil {
+		writeMessage(w, r, userMessage(err))
 		return
 	}
-	setSessionCookie(w, id)
 	w.Header().Set("HX-Redirect", "/dashboard")
 }
 
 func postDeleteUser(w http.ResponseWriter, r *http.Request) {
-	// TODO(@FaaizMemonPurdue): Add API call timeouts.
-	email := r.FormValue("email")
-	id, err := matcha.database.AuthenticateLogin(email, r.FormValue("password"))
-	if err != nil {
-		if _, err := io.WriteString(w, err.Error()); err != nil {
-			log.Println("User failed to validate delete request -", err)
+	ctx := r.Context()
+	email := validation.NormalizeEmail(r.FormValue("email"))
+	if ok, retryAfter := loginLimits.allow(r, email); !ok {
+		tooManyAttempts(w, r, retryAfter)
+		return
+	}
+	var user *internal.User
+	id, err := matcha.database.AuthenticateLogin(ctx, email, r.FormValue("password"))
+	if err == nil {
+		// Deleting an account is final, so whoever asks must pass two-factor authentication again too.
+		if user = matcha.database.GetUser(ctx, id); user == nil {
+			err = internalDatabase.ErrInternal
+		} else {
+			err = checkSecondFactor(ctx, user, r.FormValue("code"))
 		}
+	}
+	if err != nil {
+		loginLimits.failed(email)
+		slog.InfoContext(ctx, "User failed to validate delete request", "err", err)
+		writeMessage(w, r, userMessage(err))
 		return
 	}
+	loginLimits.succeeded(email)
 
-	// TODO(@FaaizMemonPurdue): Add API call timeouts.
-	err = matcha.database.DeleteUser(id)
+	err = matcha.database.DeleteUser(ctx, id)
 	if err != nil {
-		log.Println("Delete User failed -", err)
-		if _, err = io.WriteString(w, "internal server error"); err != nil {
-			log.Println("Error writing deleted user internal server error -", err)
-			return
-		}
+		slog.ErrorContext(ctx, "Delete user failed", "user_id", id, "err", err)
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	if err := sendRestoreLink(ctx, id, user.Email); err != nil {
+		slog.ErrorContext(ctx, "Error sending account restore link", "user_id", id, "err", err)
 	}
 	postLogout(w, r)
 }
 
-func checkLoginStatus(w http.ResponseWriter, r *http.Request) (user *internal.User) {
-	cookie, err := r.Cookie("c_user_id")
-	if errors.Is(err, http.ErrNoCookie) {
-		log.Println("Client has no session cookie -", err)
-		http.Error(w, "Unauthorized login session.", http.StatusUnauthorized)
-		return nil
-	} else if err != nil {
-		http.Error(w, "Unauthorized login session.", http.StatusUnauthorized)
-		log.Println("Error getting session cookie -", err)
-		return nil
+// sendRestoreLink emails a deleted user the link undoing their deletion, the only way back into the account.
+func sendRestoreLink(ctx context.Context, id uint64, email string) (err error) {
+	token, err := matcha.database.CreateUserToken(
+		ctx, id, internalDatabase.RestoreAccountToken, internalDatabase.DeletionGracePeriod,
+	)
+	if err != nil {
+		return
 	}
-	id, err := strconv.ParseUint(cookie.Value, 10, 64)
+	link := matcha.baseURL + "/restore-account?token=" + url.QueryEscape(token)
+	return queueMail(
+		ctx, email, "account-deleted",
+		map[string]any{"Link": link, "Days": int(internalDatabase.DeletionGracePeriod.Hours() / 24)},
+	)
+}
+
+func postRestoreAccount(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id, err := matcha.database.RestoreUser(ctx, r.FormValue("token"))
 	if err != nil {
-		log.Println("Failed to convert user id -", err)
-		http.Error(w, "Invalid login session.", http.StatusBadRequest)
-		return nil
-	} else if id < 1 {
-		log.Println("Invalid user id:", id)
-		http.Error(w, "Invalid login session.", http.StatusBadRequest)
-		return nil
+		slog.InfoContext(ctx, "Account restore with unusable token", "err", err)
+		writeMessage(w, r, userMessage(err))
+		return
 	}
-	// TODO(@FaaizMemonPurdue): Add API call timeouts.
-	return matcha.database.GetUser(id)
+	slog.InfoContext(ctx, "Account restored", "user_id", id)
+	w.Header().Set("HX-Redirect", "/login")
 }
 
 func getPage(w http.ResponseWriter, r *http.Request) {
-	pageData := struct {
-		PageName string
-		User     *internal.User
-	}{
-		PageName: strings.TrimLeft(r.URL.Path, "/"),
-	}
-	if _, exists := surfacePages[pageData.PageName]; !exists {
-		pageData.User = checkLoginStatus(w, r)
-		if pageData.User == nil {
+	data := newPageData(r, strings.TrimLeft(r.URL.Path, "/"))
+	if _, exists := surfacePages[data.PageName]; !exists {
+		data.User = checkLoginStatus(w, r)
+		if data.User == nil {
+			return
+		}
+		if _, allowed := unverifiedPages[data.PageName]; !allowed && !requireVerifiedEmail(w, r, data.User) {
 			return
 		}
 	}
-	if err := templateServer.ExecuteTemplate(w, pageData.PageName+".go.html", pageData); err != nil {
-		log.Println("Error executing template", pageData.PageName, "-", err)
-	}
+	executeTemplate(w, r, data.PageName+".go.html", data)
 }
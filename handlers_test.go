@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+	"github.com/matcha-devs/matcha/internal/validation"
+	"github.com/matcha-devs/matcha/internal/webauthn"
+)
+
+// fakeDatabase stands in for MySQL in handler tests with a single user, any method it doesn't implement panics
+// through the nil database it embeds. The user's session is "session", apiTokens maps their personal API tokens to
+// the scopes of each.
+type fakeDatabase struct {
+	database
+	user         internal.User
+	password     string
+	deleted      bool
+	passkey      webauthn.Credential
+	apiTokens    map[string][]internalDatabase.Scope
+	revoked      []uint64
+	institutions []internal.Institution
+}
+
+func (db *fakeDatabase) AuthenticateLogin(_ context.Context, email, password string) (id uint64, err error) {
+	if email != db.user.Email || password != db.password {
+		return 0, internalDatabase.ErrInvalidCredentials
+	} else if db.deleted {
+		return 0, internalDatabase.ErrAccountDeleted
+	}
+	return db.user.ID, nil
+}
+
+func (db *fakeDatabase) GetUser(_ context.Context, id uint64) (user *internal.User) {
+	if id != db.user.ID || db.deleted {
+		return nil
+	}
+	user = new(internal.User)
+	*user = db.user
+	return
+}
+
+func (db *fakeDatabase) GetSessionUser(ctx context.Context, token string) (user *internal.User) {
+	if token != "session" {
+		return nil
+	}
+	return db.GetUser(ctx, db.user.ID)
+}
+
+func (db *fakeDatabase) CreateSession(context.Context, uint64, time.Duration) (token string, err error) {
+	return "session", nil
+}
+
+func (db *fakeDatabase) CheckUserToken(
+	_ context.Context, _ internalDatabase.TokenPurpose, token string,
+) (userID uint64, err error) {
+	// Deleting an account revokes its tokens.
+	if token != "token" || db.deleted {
+		return 0, internalDatabase.ErrInvalidToken
+	}
+	return db.user.ID, nil
+}
+
+func (db *fakeDatabase) ConsumeWebAuthnChallenge(
+	context.Context, internalDatabase.Ceremony, string,
+) (userID uint64, err error) {
+	return 0, nil
+}
+
+func (db *fakeDatabase) GetPasskey(
+	_ context.Context, credentialID []byte,
+) (userID uint64, credential webauthn.Credential, err error) {
+	if !bytes.Equal(credentialID, db.passkey.ID) {
+		return 0, credential, internalDatabase.ErrInvalidCredentials
+	}
+	return db.user.ID, db.passkey, nil
+}
+
+func (db *fakeDatabase) UsePasskey(_ context.Context, _ []byte, signCount uint32) (err error) {
+	if db.deleted {
+		return internalDatabase.ErrAccountDeleted
+	}
+	db.passkey.SignCount = signCount
+	return
+}
+
+func (db *fakeDatabase) CreateAPIToken(
+	_ context.Context, _ uint64, name string, scopes []internalDatabase.Scope, _ time.Duration,
+) (token string, err error) {
+	if len(scopes) == 0 {
+		return "", validation.Errors{"scopes": "please choose at least one scope"}
+	}
+	token = internalDatabase.APITokenPrefix + name
+	if db.apiTokens == nil {
+		db.apiTokens = map[string][]internalDatabase.Scope{}
+	}
+	db.apiTokens[token] = scopes
+	return
+}
+
+func (db *fakeDatabase) DeleteAPIToken(_ context.Context, userID, id uint64) (err error) {
+	if userID == db.user.ID {
+		db.revoked = append(db.revoked, id)
+	}
+	return
+}
+
+func (db *fakeDatabase) AuthenticateAPIToken(
+	ctx context.Context, token string,
+) (user *internal.User, scopes []internalDatabase.Scope, err error) {
+	scopes, ok := db.apiTokens[token]
+	if user = db.GetUser(ctx, db.user.ID); !ok || user == nil {
+		return nil, nil, internalDatabase.ErrInvalidToken
+	}
+	return
+}
+
+func (db *fakeDatabase) GetInstitutions(
+	_ context.Context, _, after uint64, limit int,
+) (institutions []internal.Institution, err error) {
+	for _, institution := range db.institutions {
+		if institution.ID > after && len(institutions) < limit {
+			institutions = append(institutions, institution)
+		}
+	}
+	return
+}
+
+func (db *fakeDatabase) AddInstitution(
+	_ context.Context, _ uint64, name string,
+) (institution internal.Institution, err error) {
+	if name == "" {
+		return institution, validation.Errors{"name": "please fill in a name"}
+	}
+	institution = internal.Institution{ID: uint64(len(db.institutions) + 1), Name: name}
+	db.institutions = append(db.institutions, institution)
+	return
+}
+
+// GetAccount finds no accounts, the user has none.
+func (db *fakeDatabase) GetAccount(context.Context, uint64, uint64) (account internal.FinancialAccount, err error) {
+	return account, internalDatabase.ErrNotFound
+}
+
+// useFakeDatabase points the app at db for the rest of the test.
+func useFakeDatabase(t *testing.T, db database) {
+	previous := matcha
+	matcha = &app{
+		database:     db,
+		baseURL:      "http://example.com",
+		relyingParty: webauthn.RelyingParty{ID: "example.com", Name: "Matcha", Origins: []string{"http://example.com"}},
+	}
+	t.Cleanup(func() { matcha = previous })
+}
+
+// signedPasskeyLogin is a passkey login request signed by private for the credential with the given id.
+func signedPasskeyLogin(t *testing.T, id []byte, private ed25519.PrivateKey) *http.Request {
+	clientData, err := json.Marshal(
+		map[string]string{"type": "webauthn.get", "challenge": "challenge", "origin": "http://example.com"},
+	)
+	if err != nil {
+		t.Fatal("Failed to encode client data -", err)
+	}
+	rpIDHash := sha256.Sum256([]byte("example.com"))
+	// The user is present and the signature counter at 1.
+	authData := binary.BigEndian.AppendUint32(append(rpIDHash[:], 0x01), 1)
+	clientDataHash := sha256.Sum256(clientData)
+	signature := ed25519.Sign(private, append(bytes.Clone(authData), clientDataHash[:]...))
+	encode := base64.RawURLEncoding.EncodeToString
+	body, err := json.Marshal(
+		map[string]any{
+			"id": encode(id),
+			"response": map[string]string{
+				"clientDataJSON":    encode(clientData),
+				"authenticatorData": encode(authData),
+				"signature":         encode(signature),
+			},
+		},
+	)
+	if err != nil {
+		t.Fatal("Failed to encode passkey response -", err)
+	}
+	return httptest.NewRequest(http.MethodPost, "/passkeys/login", bytes.NewReader(body))
+}
+
+func TestDeletedUserLogin(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Failed to generate key -", err)
+	}
+	db := &fakeDatabase{
+		user:     internal.User{ID: 1, Email: "deleted@example.com", TOTPEnabled: true},
+		password: "deletedPass",
+		deleted:  true,
+		// A COSE_Key map of an Ed25519 public key: kty OKP, alg EdDSA, crv Ed25519 and x.
+		passkey: webauthn.Credential{
+			ID:        []byte("credential"),
+			PublicKey: append([]byte{0xa4, 1, 1, 3, 0x27, 0x20, 6, 0x21, 0x58, 32}, public...),
+		},
+	}
+	useFakeDatabase(t, db)
+	restore := userMessage(internalDatabase.ErrAccountDeleted)
+
+	form := url.Values{"email": {db.user.Email}, "password": {db.password}}
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	postLogin(w, r)
+	if w.Body.String() != restore || len(w.Result().Cookies()) != 0 {
+		t.Error("Expected password login to be told how to restore the account, got", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	postPasskeyLogin(w, signedPasskeyLogin(t, db.passkey.ID, private))
+	if w.Code != http.StatusForbidden || strings.TrimSpace(w.Body.String()) != restore ||
+		len(w.Result().Cookies()) != 0 {
+		t.Error("Expected passkey login to be told how to restore the account, got", w.Code, w.Body.String())
+	}
+	if db.passkey.SignCount != 0 {
+		t.Error("Expected the passkey of a deleted user to be left unused, sign count is", db.passkey.SignCount)
+	}
+
+	// A second factor pending from before the account was deleted.
+	r = httptest.NewRequest(http.MethodPost, "/two-factor-login", strings.NewReader("code=123456"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.AddCookie(&http.Cookie{Name: twoFactorCookieName, Value: "token"})
+	w = httptest.NewRecorder()
+	postTwoFactorLogin(w, r)
+	if w.Body.String() != "your login expired, please log in again" || len(w.Result().Cookies()) != 0 {
+		t.Error("Expected the two-factor login to have expired, got", w.Body.String())
+	}
+}
@@ -15,9 +15,102 @@ type User struct {
 	Password    string
 	DateOfBirth string
 	CreatedOn   time.Time
+	// EmailVerifiedOn is zero until the user proves they own Email.
+	EmailVerifiedOn time.Time
+	// TOTPEnabled users log in with a code from their authenticator app on top of their password.
+	TOTPEnabled bool
+}
+
+func (user User) EmailVerified() (verified bool) {
+	return !user.EmailVerifiedOn.IsZero()
 }
 
 func (user User) IsValid() (valid bool) {
 	return user.ID != 0 && "" != user.FirstName && "" != user.LastName && "" != user.Email && "" != user.Password &&
 		"" != user.DateOfBirth && user.CreatedOn.Before(time.Now())
 }
+
+// AssetClasses are the kinds of financial account, as stored in financial_accounts.asset_class.
+var AssetClasses = []string{
+	"CASH", "STOCKS", "CREDIT_CARD", "OTHER_LOAN", "RETIREMENT_CASH", "RETIREMENT_STOCKS", "REAL_ESTATE",
+	"OTHER_PROPERTY",
+}
+
+// TransactionTypes are the categories of transaction, as stored in transactions.type.
+var TransactionTypes = []string{"RESTAURANTS", "BILLS", "HOUSING", "GROCERY", "TRAVEL", "ETC"}
+
+type Institution struct {
+	ID   uint64 `json:"id"`
+	Name string `json:"name"`
+}
+
+// FinancialAccount is an account a user holds at an institution, amounts are in cents.
+type FinancialAccount struct {
+	ID            uint64 `json:"id"`
+	InstitutionID uint64 `json:"institution_id"`
+	AssetClass    string `json:"asset_class"`
+	Name          string `json:"name"`
+	NetValue      int64  `json:"net_value"`
+}
+
+// Transaction is money moved in or out of a financial account, amounts are in cents.
+type Transaction struct {
+	ID                 uint64 `json:"id"`
+	FinancialAccountID uint64 `json:"financial_account_id"`
+	Amount             int64  `json:"amount"`
+	Type               string `json:"type"`
+	// Date is the day the money moved, as YYYY-MM-DD.
+	Date string `json:"date"`
+	// RecurringID is the recurring transaction this one was expected by, 0 for one-offs.
+	RecurringID uint64 `json:"recurring_id,omitempty"`
+	// TransferID is the other side of a transfer between the user's accounts, which is neither spending nor income.
+	TransferID uint64 `json:"transfer_id,omitempty"`
+	// Splits divide the amount between categories, like a receipt of groceries and household goods, in place of Type.
+	Splits []Split `json:"splits,omitempty"`
+}
+
+// Split is the part of a transaction spent on one category, amounts are in cents with the sign of the transaction.
+type Split struct {
+	Type   string `json:"type"`
+	Amount int64  `json:"amount"`
+}
+
+// RecurringTransaction is a transaction expected on a schedule, like rent or a paycheck, amounts are in cents.
+type RecurringTransaction struct {
+	ID                 uint64 `json:"id"`
+	FinancialAccountID uint64 `json:"financial_account_id"`
+	Name               string `json:"name"`
+	Amount             int64  `json:"amount"`
+	Type               string `json:"type"`
+	// Rule is the schedule as an RFC 5545 recurrence rule, like "FREQ=MONTHLY;BYMONTHDAY=1".
+	Rule string `json:"rule"`
+	// StartsOn and EndsOn are YYYY-MM-DD, EndsOn is empty for schedules that go on for good.
+	StartsOn string `json:"starts_on"`
+	EndsOn   string `json:"ends_on,omitempty"`
+	// NextOn is the first occurrence not recorded yet, empty once the schedule is over.
+	NextOn string `json:"next_on,omitempty"`
+}
+
+// Occurrence is a day a recurring transaction is expected on, as YYYY-MM-DD.
+type Occurrence struct {
+	RecurringID        uint64 `json:"recurring_id"`
+	FinancialAccountID uint64 `json:"financial_account_id"`
+	Name               string `json:"name"`
+	Amount             int64  `json:"amount"`
+	Date               string `json:"date"`
+}
+
+// SummaryReport sums up the accounts and transactions of a user, in cents. Transfers aren't spending, and split
+// transactions are spent on the type of each split.
+type SummaryReport struct {
+	NetWorth       int64            `json:"net_worth"`
+	ByAssetClass   map[string]int64 `json:"by_asset_class"`
+	SpendingByType map[string]int64 `json:"spending_by_type"`
+}
+
+// UserData is the financial data of a user, along with the institutions their accounts are held at.
+type UserData struct {
+	Institutions []Institution
+	Accounts     []FinancialAccount
+	Transactions []Transaction
+}
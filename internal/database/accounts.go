@@ -0,0 +1,311 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+	"github.com/matcha-devs/matcha/internal/tracing"
+	"github.com/matcha-devs/matcha/internal/validation"
+	"github.com/matcha-devs/matcha/internal/webhooks"
+)
+
+// maxLabelLength is the longest name of an institution or account.
+const maxLabelLength = 255
+
+// ErrNotFound is returned for records that don't exist or belong to another user, which callers can't tell apart.
+var ErrNotFound = errors.New("not found")
+
+// GetInstitutions lists the institutions a user can hold accounts at, the shared ones and those they added, in order
+// of id after the id after, at most limit.
+func (db *MySQLDatabase) GetInstitutions(
+	ctx context.Context, userID, after uint64, limit int,
+) (institutions []internal.Institution, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetInstitutions")
+	defer func() { span.RecordError(err); span.End() }()
+	err = db.queryRows(
+		ctx, func(rows *sql.Rows) (err error) {
+			var institution internal.Institution
+			if err = rows.Scan(&institution.ID, &institution.Name); err == nil {
+				institutions = append(institutions, institution)
+			}
+			return
+		}, `SELECT id, name FROM institutions
+		WHERE (user_id IS NULL OR user_id = ?) AND id > ? ORDER BY id LIMIT ?`, userID, after, limit,
+	)
+	return
+}
+
+// AddInstitution returns the institution called name a user can hold accounts at, adding it for them alone if there
+// is none.
+func (db *MySQLDatabase) AddInstitution(
+	ctx context.Context, userID uint64, name string,
+) (institution internal.Institution, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.AddInstitution")
+	defer func() { span.RecordError(err); span.End() }()
+	errs := validation.Errors{}
+	if institution.Name = validation.Text(errs, "name", name, maxLabelLength); len(errs) > 0 {
+		return internal.Institution{}, errs
+	}
+	err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			institution.ID, err = importInstitution(ctx, tx, userID, institution.Name)
+			return
+		},
+	)
+	return
+}
+
+// GetAccounts lists the financial accounts of a user in order of id after the id after, at most limit.
+func (db *MySQLDatabase) GetAccounts(
+	ctx context.Context, userID, after uint64, limit int,
+) (accounts []internal.FinancialAccount, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetAccounts")
+	defer func() { span.RecordError(err); span.End() }()
+	err = db.queryRows(
+		ctx, func(rows *sql.Rows) (err error) {
+			var account internal.FinancialAccount
+			if err = rows.Scan(
+				&account.ID, &account.InstitutionID, &account.AssetClass, &account.Name, &account.NetValue,
+			); err == nil {
+				accounts = append(accounts, account)
+			}
+			return
+		}, `SELECT id, institution_id, asset_class, name, net_value FROM financial_accounts
+		WHERE user_id = ? AND id > ? ORDER BY id LIMIT ?`, userID, after, limit,
+	)
+	return
+}
+
+// GetAccount returns a financial account of a user, ErrNotFound if they have none with that id.
+func (db *MySQLDatabase) GetAccount(
+	ctx context.Context, userID, id uint64,
+) (account internal.FinancialAccount, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetAccount")
+	defer func() { span.RecordError(err); span.End() }()
+	err = db.underlyingDB.QueryRowContext(
+		ctx, `SELECT id, institution_id, asset_class, name, net_value FROM financial_accounts
+		WHERE id = ? AND user_id = ?`, id, userID,
+	).Scan(&account.ID, &account.InstitutionID, &account.AssetClass, &account.Name, &account.NetValue)
+	if errors.Is(err, sql.ErrNoRows) {
+		return account, ErrNotFound
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error selecting account", "user_id", userID, "account_id", id, "err", err)
+		return account, ErrInternal
+	}
+	return
+}
+
+// AddAccount adds a financial account for a user, returning it with its id. Invalid fields are refused with
+// validation.Errors under their JSON names.
+func (db *MySQLDatabase) AddAccount(
+	ctx context.Context, userID uint64, account internal.FinancialAccount,
+) (added internal.FinancialAccount, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.AddAccount")
+	defer func() { span.RecordError(err); span.End() }()
+	errs := validation.Errors{}
+	account.Name = validation.Text(errs, "name", account.Name, maxLabelLength)
+	validation.OneOf(errs, "asset_class", account.AssetClass, internal.AssetClasses)
+	if len(errs) > 0 {
+		return added, errs
+	}
+	err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			var exists bool
+			if err = tx.QueryRowContext(
+				ctx, "SELECT EXISTS (SELECT 1 FROM institutions WHERE id = ? AND (user_id IS NULL OR user_id = ?))",
+				account.InstitutionID, userID,
+			).Scan(&exists); err != nil {
+				slog.ErrorContext(ctx, "Error selecting institution", "err", err)
+				return internalError(err)
+			} else if !exists {
+				return validation.Errors{"institution_id": "there is no institution with this id"}
+			}
+			result, err := tx.ExecContext(
+				ctx, `INSERT INTO financial_accounts (user_id, institution_id, asset_class, name, net_value)
+				VALUES (?, ?, ?, ?, ?)`,
+				userID, account.InstitutionID, account.AssetClass, account.Name, account.NetValue,
+			)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error adding account", "user_id", userID, "err", err)
+				return internalError(err)
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				slog.ErrorContext(ctx, "Error adding account", "user_id", userID, "err", err)
+				return internalError(err)
+			}
+			account.ID = uint64(id)
+			return
+		},
+	)
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "Account added", "user_id", userID, "account_id", account.ID)
+	return account, nil
+}
+
+// GetTransactions lists the transactions of a user, only those of accountID unless it is 0, in order of id after
+// the id after, at most limit.
+func (db *MySQLDatabase) GetTransactions(
+	ctx context.Context, userID, accountID, after uint64, limit int,
+) (transactions []internal.Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetTransactions")
+	defer func() { span.RecordError(err); span.End() }()
+	err = db.queryRows(
+		ctx, func(rows *sql.Rows) (err error) {
+			transaction, err := scanTransaction(rows)
+			if err == nil {
+				transactions = append(transactions, transaction)
+			}
+			return
+		}, "SELECT "+transactionColumns+` FROM transactions
+		WHERE user_id = ? AND (? = 0 OR financial_account_id = ?) AND id > ? ORDER BY id LIMIT ?`,
+		userID, accountID, accountID, after, limit,
+	)
+	if err != nil {
+		return
+	}
+	return transactions, db.loadSplits(ctx, userID, transactions)
+}
+
+// GetTransaction returns a transaction of a user, ErrNotFound if they have none with that id.
+func (db *MySQLDatabase) GetTransaction(
+	ctx context.Context, userID, id uint64,
+) (transaction internal.Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetTransaction")
+	defer func() { span.RecordError(err); span.End() }()
+	transaction, err = scanTransaction(
+		db.underlyingDB.QueryRowContext(
+			ctx, "SELECT "+transactionColumns+" FROM transactions WHERE id = ? AND user_id = ?", id, userID,
+		),
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return transaction, ErrNotFound
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error selecting transaction", "user_id", userID, "transaction_id", id, "err", err)
+		return transaction, ErrInternal
+	}
+	transactions := []internal.Transaction{transaction}
+	if err = db.loadSplits(ctx, userID, transactions); err != nil {
+		return internal.Transaction{}, err
+	}
+	return transactions[0], nil
+}
+
+// AddTransaction records a transaction on one of the user's accounts, moving the net value of the account by its
+// amount, and returns it with its id. Transactions without a date happened today, those a recurring transaction of the
+// user expects are recorded as its occurrence. Splits must add up to the amount. Webhooks are sent the transaction,
+// large purchases also as such.
+func (db *MySQLDatabase) AddTransaction(
+	ctx context.Context, userID uint64, transaction internal.Transaction,
+) (added internal.Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.AddTransaction")
+	defer func() { span.RecordError(err); span.End() }()
+	errs := validation.Errors{}
+	if validation.OneOf(errs, "type", transaction.Type, internal.TransactionTypes); transaction.Amount == 0 {
+		errs.Add("amount", "please enter an amount other than 0")
+	}
+	if transaction.Date == "" {
+		transaction.Date = db.today().Format(validation.DateLayout)
+	} else {
+		transaction.Date = validation.Date(errs, "date", transaction.Date).Format(validation.DateLayout)
+	}
+	if checkSplits(errs, transaction.Amount, transaction.Splits); len(errs) > 0 {
+		return added, errs
+	}
+	transaction.RecurringID, transaction.TransferID = 0, 0
+	err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			if transaction.RecurringID, err = matchRecurring(ctx, tx, userID, transaction); err != nil {
+				return
+			}
+			return db.recordTransaction(ctx, tx, userID, &transaction)
+		},
+	)
+	if err != nil {
+		return
+	}
+	slog.InfoContext(
+		ctx, "Transaction added", "user_id", userID, "transaction_id", transaction.ID,
+		"recurring_id", transaction.RecurringID,
+	)
+	return transaction, nil
+}
+
+// recordTransaction adds a transaction within tx and queues the webhook events it makes, setting its id.
+func (db *MySQLDatabase) recordTransaction(
+	ctx context.Context, tx *sql.Tx, userID uint64, transaction *internal.Transaction,
+) (err error) {
+	if transaction.ID, err = addTransaction(ctx, tx, userID, *transaction); err != nil {
+		return
+	}
+	if err = addSplits(ctx, tx, userID, *transaction); err != nil {
+		return
+	}
+	if err = db.queueWebhookEvent(ctx, tx, userID, webhooks.TransactionCreated, transaction); err != nil {
+		return
+	}
+	if -transaction.Amount >= webhooks.LargePurchaseCents {
+		err = db.queueWebhookEvent(ctx, tx, userID, webhooks.LargePurchase, transaction)
+	}
+	return
+}
+
+// transactionColumns are the columns scanTransaction reads, in order.
+const transactionColumns = "id, financial_account_id, amount, type, occurred_on, recurring_id, transfer_id"
+
+func scanTransaction(row interface{ Scan(dest ...any) error }) (transaction internal.Transaction, err error) {
+	var (
+		date                    time.Time
+		recurringID, transferID sql.NullInt64
+	)
+	if err = row.Scan(
+		&transaction.ID, &transaction.FinancialAccountID, &transaction.Amount, &transaction.Type, &date, &recurringID,
+		&transferID,
+	); err == nil {
+		transaction.Date, transaction.RecurringID = date.Format(validation.DateLayout), uint64(recurringID.Int64)
+		transaction.TransferID = uint64(transferID.Int64)
+	}
+	return
+}
+
+// addTransaction inserts a transaction within tx and moves the net value of its account, which must be the user's.
+func addTransaction(
+	ctx context.Context, tx *sql.Tx, userID uint64, transaction internal.Transaction,
+) (id uint64, err error) {
+	result, err := tx.ExecContext(
+		ctx, "UPDATE financial_accounts SET net_value = net_value + ? WHERE id = ? AND user_id = ?",
+		transaction.Amount, transaction.FinancialAccountID, userID,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error updating account value", "user_id", userID, "err", err)
+		return 0, internalError(err)
+	}
+	// Amounts are never 0, so the account row is changed whenever it matched.
+	if updated, err := result.RowsAffected(); err != nil {
+		slog.ErrorContext(ctx, "Error updating account value", "user_id", userID, "err", err)
+		return 0, internalError(err)
+	} else if updated != 1 {
+		return 0, validation.Errors{"financial_account_id": "you have no account with this id"}
+	}
+	if result, err = tx.ExecContext(
+		ctx, `INSERT INTO transactions (user_id, financial_account_id, amount, type, occurred_on, recurring_id)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		userID, transaction.FinancialAccountID, transaction.Amount, transaction.Type, transaction.Date,
+		sql.NullInt64{Int64: int64(transaction.RecurringID), Valid: transaction.RecurringID != 0},
+	); err != nil {
+		slog.ErrorContext(ctx, "Error adding transaction", "user_id", userID, "err", err)
+		return 0, internalError(err)
+	}
+	inserted, err := result.LastInsertId()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error adding transaction", "user_id", userID, "err", err)
+		return 0, internalError(err)
+	}
+	return uint64(inserted), nil
+}
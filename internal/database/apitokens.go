@@ -0,0 +1,175 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+	"github.com/matcha-devs/matcha/internal/tracing"
+	"github.com/matcha-devs/matcha/internal/validation"
+)
+
+// Scope is something a personal API token is allowed to do.
+type Scope string
+
+const (
+	ReadScope              Scope = "read"
+	AccountsWriteScope     Scope = "accounts:write"
+	TransactionsWriteScope Scope = "transactions:write"
+)
+
+// Scopes lists every scope in the order they are shown to users.
+var Scopes = []Scope{ReadScope, AccountsWriteScope, TransactionsWriteScope}
+
+const (
+	// APITokenPrefix starts every personal API token, so leaked ones are easy to recognize.
+	APITokenPrefix = "matcha_pat_"
+	// apiTokenUseGranularity is how stale last_used_on may get, sparing a write on every request.
+	apiTokenUseGranularity = time.Minute
+)
+
+// APIToken is a personal API token, the token itself is only ever known to its user.
+type APIToken struct {
+	ID     uint64
+	Name   string
+	Scopes []Scope
+	// ExpiresOn is zero for tokens that never expire.
+	ExpiresOn  time.Time
+	CreatedOn  time.Time
+	LastUsedOn time.Time
+}
+
+// CreateAPIToken issues a personal API token allowed the scopes, valid for ttl or forever when it is 0. Invalid names
+// and scopes are refused with validation.Errors.
+func (db *MySQLDatabase) CreateAPIToken(
+	ctx context.Context, userID uint64, name string, scopes []Scope, ttl time.Duration,
+) (token string, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.CreateAPIToken")
+	defer func() { span.RecordError(err); span.End() }()
+	errs := validation.Errors{}
+	name = validation.Text(errs, "name", name, maxLabelLength)
+	if len(scopes) == 0 {
+		errs.Add("scopes", "please choose at least one scope")
+	}
+	for _, scope := range scopes {
+		if !slices.Contains(Scopes, scope) {
+			errs.Add("scopes", "unknown scope "+string(scope))
+		}
+	}
+	if len(errs) > 0 {
+		return "", errs
+	}
+	secret, _, err := newToken()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error generating API token", "err", err)
+		return "", ErrInternal
+	}
+	token = APITokenPrefix + secret
+	joined := make([]string, len(scopes))
+	for i, scope := range scopes {
+		joined[i] = string(scope)
+	}
+	var expiresOn sql.NullTime
+	if ttl > 0 {
+		expiresOn = sql.NullTime{Time: db.now().Add(ttl), Valid: true}
+	}
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, "INSERT INTO api_tokens (token_hash, user_id, name, scopes, expires_on) VALUES (?, ?, ?, ?, ?)",
+		hashToken(token), userID, name, strings.Join(joined, ","), expiresOn,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error adding API token", "user_id", userID, "err", err)
+		return "", ErrInternal
+	}
+	slog.InfoContext(ctx, "API token created", "user_id", userID, "scopes", joined)
+	return
+}
+
+// GetAPITokens lists the personal API tokens of a user, expired ones included, newest first.
+func (db *MySQLDatabase) GetAPITokens(ctx context.Context, userID uint64) (tokens []APIToken, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetAPITokens")
+	defer func() { span.RecordError(err); span.End() }()
+	err = db.queryRows(
+		ctx, func(rows *sql.Rows) (err error) {
+			var (
+				token              APIToken
+				scopes             string
+				expiresOn, lastUse sql.NullTime
+			)
+			if err = rows.Scan(
+				&token.ID, &token.Name, &scopes, &expiresOn, &token.CreatedOn, &lastUse,
+			); err == nil {
+				token.Scopes = splitScopes(scopes)
+				token.ExpiresOn, token.LastUsedOn = expiresOn.Time, lastUse.Time
+				tokens = append(tokens, token)
+			}
+			return
+		}, `SELECT id, name, scopes, expires_on, created_on, last_used_on FROM api_tokens
+		WHERE user_id = ? ORDER BY id DESC`, userID,
+	)
+	return
+}
+
+// DeleteAPIToken revokes a personal API token of a user.
+func (db *MySQLDatabase) DeleteAPIToken(ctx context.Context, userID, id uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.DeleteAPIToken")
+	defer func() { span.RecordError(err); span.End() }()
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, "DELETE FROM api_tokens WHERE id = ? AND user_id = ?", id, userID,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error deleting API token", "user_id", userID, "err", err)
+		return ErrInternal
+	}
+	slog.InfoContext(ctx, "API token revoked", "user_id", userID, "token_id", id)
+	return
+}
+
+// AuthenticateAPIToken returns the user a personal API token belongs to and its scopes, recording its use. Unknown,
+// expired and revoked tokens fail with ErrInvalidToken.
+func (db *MySQLDatabase) AuthenticateAPIToken(
+	ctx context.Context, token string,
+) (user *internal.User, scopes []Scope, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.AuthenticateAPIToken")
+	defer func() { span.RecordError(err); span.End() }()
+	var (
+		id, userID uint64
+		joined     string
+	)
+	now := db.now()
+	err = db.underlyingDB.QueryRowContext(
+		ctx, `SELECT id, user_id, scopes FROM api_tokens
+		WHERE token_hash = ? AND (expires_on IS NULL OR expires_on > ?)`, hashToken(token), now,
+	).Scan(&id, &userID, &joined)
+	if errors.Is(err, sql.ErrNoRows) {
+		slog.InfoContext(ctx, "Unknown or expired API token")
+		return nil, nil, ErrInvalidToken
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error selecting API token", "err", err)
+		return nil, nil, ErrInternal
+	}
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, "UPDATE api_tokens SET last_used_on = ? WHERE id = ? AND (last_used_on IS NULL OR last_used_on < ?)",
+		now, id, now.Add(-apiTokenUseGranularity),
+	); err != nil {
+		slog.ErrorContext(ctx, "Error recording API token use", "token_id", id, "err", err)
+		return nil, nil, ErrInternal
+	}
+	if user = db.GetUser(ctx, userID); user == nil {
+		return nil, nil, ErrInvalidToken
+	}
+	return user, splitScopes(joined), nil
+}
+
+// splitScopes parses the scopes column, which MySQL returns as a comma separated SET.
+func splitScopes(joined string) (scopes []Scope) {
+	for _, scope := range strings.Split(joined, ",") {
+		if scope != "" {
+			scopes = append(scopes, Scope(scope))
+		}
+	}
+	return
+}
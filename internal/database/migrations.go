@@ -0,0 +1,242 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal/validation"
+)
+
+// addedColumns are the columns added to tables after they were first created, in the order they were added.
+var addedColumns = []struct{ table, column, definition string }{
+	{"users", "failed_logins", "INT UNSIGNED DEFAULT 0 NOT NULL"},
+	{"users", "locked_until", "timestamp NULL"},
+	{"users", "email_verified_on", "timestamp NULL"},
+	{"users", "totp_secret", "VARBINARY(255) NULL"},
+	{"users", "totp_last_step", "BIGINT DEFAULT 0 NOT NULL"},
+	{"users", "deleted_on", "timestamp NULL"},
+	{"transactions", "recurring_id", "BIGINT(20) UNSIGNED NULL"},
+	{"transactions", "transfer_id", "BIGINT(20) UNSIGNED NULL"},
+	// Institutions there before are shared by every user.
+	{"institutions", "user_id", "BIGINT(20) UNSIGNED NULL"},
+}
+
+// requiredColumns are the NOT NULL columns without a default added after their tables were first created. Rows
+// already there have no value for them and none is made up, they're added as NULL and the upgrade stops until an
+// admin fills them in.
+var requiredColumns = []struct{ table, column, definition string }{
+	{"transactions", "occurred_on", "DATE NOT NULL"},
+}
+
+// addedIndexes are the indexes added to tables after they were first created, under the names MySQL gives them.
+var addedIndexes = []struct{ table, name, columns string }{
+	{"users", "deleted_on", "deleted_on"},
+	{"transactions", "recurring_id", "recurring_id"},
+	{"transactions", "user_id", "user_id, amount, occurred_on"},
+	{"institutions", "user_id", "user_id"},
+}
+
+// droppedTables are the tables init_tables.sql no longer creates.
+var droppedTables = []string{
+	// Deleted accounts are kept with deleted_on set instead of having their ids recycled through it.
+	"openid",
+}
+
+// addedEnumValues are the ENUM columns that gained values after their tables were first created, with all the values
+// they have now. Values are only ever added at the end, so rows already there keep theirs.
+var addedEnumValues = []struct{ table, column, values string }{
+	{"user_tokens", "purpose", "'PASSWORD_RESET','VERIFY_EMAIL','TWO_FACTOR_LOGIN','RESTORE_ACCOUNT'"},
+}
+
+// upgradeSchema brings tables created by an older init_tables.sql, which only creates tables that don't exist yet, up
+// to date with it. Every step checks whether it's still needed first, so it runs on each start and picks up where a
+// failed run left off.
+func upgradeSchema(ctx context.Context, initDB *sql.DB, dbName string) (err error) {
+	// USE only holds for one connection of the pool.
+	conn, err := initDB.Conn(ctx)
+	if err != nil {
+		return
+	}
+	defer func() { err = errors.Join(err, conn.Close()) }()
+	if _, err = conn.ExecContext(ctx, "USE "+dbName); err != nil {
+		return
+	}
+	for _, added := range addedColumns {
+		columnType, err := columnType(ctx, conn, added.table, added.column)
+		if err != nil {
+			return err
+		} else if columnType != "" {
+			continue
+		}
+		slog.InfoContext(ctx, "Adding column", "table", added.table, "column", added.column)
+		if _, err = conn.ExecContext(
+			ctx, "ALTER TABLE "+added.table+" ADD COLUMN "+added.column+" "+added.definition,
+		); err != nil {
+			return err
+		}
+	}
+	for _, added := range requiredColumns {
+		columnType, err := columnType(ctx, conn, added.table, added.column)
+		if err != nil {
+			return err
+		} else if columnType == "" {
+			slog.InfoContext(ctx, "Adding column", "table", added.table, "column", added.column)
+			nullable, _ := strings.CutSuffix(added.definition, " NOT NULL")
+			if _, err = conn.ExecContext(
+				ctx, "ALTER TABLE "+added.table+" ADD COLUMN "+added.column+" "+nullable+" NULL",
+			); err != nil {
+				return err
+			}
+		}
+		var missing int
+		if err = conn.QueryRowContext(
+			ctx, "SELECT COUNT(*) FROM "+added.table+" WHERE "+added.column+" IS NULL",
+		).Scan(&missing); err != nil {
+			return err
+		} else if missing > 0 {
+			return fmt.Errorf(
+				"%d rows of %s have no %s yet, fill them in and restart", missing, added.table, added.column,
+			)
+		}
+		var isNullable string
+		if err = conn.QueryRowContext(
+			ctx, `SELECT IS_NULLABLE FROM information_schema.COLUMNS
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`, added.table, added.column,
+		).Scan(&isNullable); err != nil {
+			return err
+		} else if isNullable == "NO" {
+			continue
+		}
+		if _, err = conn.ExecContext(
+			ctx, "ALTER TABLE "+added.table+" MODIFY COLUMN "+added.column+" "+added.definition,
+		); err != nil {
+			return err
+		}
+	}
+	for _, added := range addedIndexes {
+		var count int
+		if err = conn.QueryRowContext(
+			ctx, `SELECT COUNT(*) FROM information_schema.STATISTICS
+			WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = ?`, added.table, added.name,
+		).Scan(&count); err != nil {
+			return
+		} else if count > 0 {
+			continue
+		}
+		slog.InfoContext(ctx, "Adding index", "table", added.table, "columns", added.columns)
+		if _, err = conn.ExecContext(ctx, "ALTER TABLE "+added.table+" ADD INDEX ("+added.columns+")"); err != nil {
+			return
+		}
+	}
+	for _, added := range addedEnumValues {
+		columnType, err := columnType(ctx, conn, added.table, added.column)
+		if err != nil {
+			return err
+		} else if columnType == "enum("+added.values+")" {
+			continue
+		}
+		slog.InfoContext(ctx, "Adding enum values", "table", added.table, "column", added.column)
+		if _, err = conn.ExecContext(
+			ctx, "ALTER TABLE "+added.table+" MODIFY COLUMN "+added.column+" ENUM ("+added.values+") NOT NULL",
+		); err != nil {
+			return err
+		}
+	}
+	if err = convertBirthDates(ctx, conn); err != nil {
+		return
+	}
+	for _, table := range droppedTables {
+		if _, err = conn.ExecContext(ctx, "DROP TABLE IF EXISTS "+table); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// columnType returns the type of a column as MySQL spells it, like varchar(255), empty if the table has no such column.
+func columnType(ctx context.Context, conn *sql.Conn, table, column string) (columnType string, err error) {
+	err = conn.QueryRowContext(
+		ctx, `SELECT COLUMN_TYPE FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?`, table, column,
+	).Scan(&columnType)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return
+}
+
+// convertBirthDates turns the free text dates of birth stored before signups were validated into DATE values, through
+// a date_of_birth_new column swapped in for the old one. Nothing is guessed for dates that don't pass validation, the
+// upgrade stops until an admin fills in date_of_birth_new of those users.
+func convertBirthDates(ctx context.Context, conn *sql.Conn) (err error) {
+	oldType, err := columnType(ctx, conn, "users", "date_of_birth")
+	if err != nil || oldType == "date" {
+		return
+	}
+	if oldType != "" {
+		slog.InfoContext(ctx, "Converting dates of birth to dates")
+		newType, err := columnType(ctx, conn, "users", "date_of_birth_new")
+		if err != nil {
+			return err
+		} else if newType == "" {
+			if _, err = conn.ExecContext(
+				ctx, "ALTER TABLE users ADD COLUMN date_of_birth_new DATE NULL AFTER date_of_birth",
+			); err != nil {
+				return err
+			}
+		}
+		type birthDate struct {
+			userID uint64
+			date   string
+		}
+		var dates []birthDate
+		rows, err := conn.QueryContext(ctx, "SELECT id, date_of_birth FROM users WHERE date_of_birth_new IS NULL")
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var date birthDate
+			if err = rows.Scan(&date.userID, &date.date); err != nil {
+				return errors.Join(err, rows.Close())
+			}
+			dates = append(dates, date)
+		}
+		if err = errors.Join(rows.Err(), rows.Close()); err != nil {
+			return err
+		}
+		var unreadable []uint64
+		now := time.Now()
+		for _, date := range dates {
+			errs := validation.Errors{}
+			dateOfBirth := validation.DateOfBirth(errs, "date_of_birth", date.date, now)
+			if len(errs) > 0 {
+				unreadable = append(unreadable, date.userID)
+				continue
+			}
+			if _, err = conn.ExecContext(
+				ctx, "UPDATE users SET date_of_birth_new = ? WHERE id = ?",
+				dateOfBirth.Format(validation.DateLayout), date.userID,
+			); err != nil {
+				return err
+			}
+		}
+		if len(unreadable) > 0 {
+			return fmt.Errorf(
+				"dates of birth of users %v aren't valid dates, set date_of_birth_new of those users and restart",
+				unreadable,
+			)
+		}
+		if _, err = conn.ExecContext(ctx, "ALTER TABLE users DROP COLUMN date_of_birth"); err != nil {
+			return err
+		}
+	}
+	_, err = conn.ExecContext(
+		ctx, "ALTER TABLE users CHANGE COLUMN date_of_birth_new date_of_birth DATE NOT NULL AFTER password",
+	)
+	return
+}
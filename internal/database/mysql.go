@@ -3,22 +3,67 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
-	"log"
+	"log/slog"
 	"os"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	mysqlDriver "github.com/go-sql-driver/mysql"
 	"github.com/matcha-devs/matcha/internal"
+	"github.com/matcha-devs/matcha/internal/logging"
+	"github.com/matcha-devs/matcha/internal/metrics"
+	"github.com/matcha-devs/matcha/internal/tracing"
+	"github.com/matcha-devs/matcha/internal/validation"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var bcryptSeconds = metrics.NewHistogram(
+	"matcha_bcrypt_duration_seconds", "Time spent hashing or comparing passwords with bcrypt.",
+	[]float64{.01, .025, .05, .1, .25, .5, 1}, "op",
+)
+
+const (
+	// errDuplicateEntry is the MySQL error number for unique key violations.
+	errDuplicateEntry = 1062
+
+	// maxFailedLogins consecutive wrong passwords lock an account for lockoutDuration.
+	maxFailedLogins = 10
+	lockoutDuration = 15 * time.Minute
+
+	// DeletionGracePeriod is how long deleted users can be restored before their data is purged.
+	DeletionGracePeriod = 30 * 24 * time.Hour
+)
+
+// Errors callers can tell apart with errors.Is, any other failure is reported as ErrInternal.
+var (
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrAccountDeleted     = errors.New("account deleted")
+	ErrEmailTaken         = errors.New("email already registered")
+	ErrEmptyFields        = errors.New("empty fields")
+	ErrInternal           = errors.New("internal server error")
+)
+
+// dummyHash is compared against when no account matches an email, so that unknown emails take as long to reject as
+// wrong passwords.
+var dummyHash = sync.OnceValue(
+	func() []byte {
+		hash, err := bcrypt.GenerateFromPassword([]byte("matcha dummy password"), bcrypt.DefaultCost)
+		if err != nil {
+			logging.Fatal("Error generating dummy password hash", "err", err)
+		}
+		return hash
+	},
+)
+
 type MySQLDatabase struct {
 	rootDSN      string
 	dbName       string
 	underlyingDB *sql.DB
+	now          func() time.Time
 }
 
 func New(dbName string, username string, password string) (mysql *MySQLDatabase) {
@@ -27,168 +72,566 @@ func New(dbName string, username string, password string) (mysql *MySQLDatabase)
 		rootDSN:      username + ":" + password + "@tcp(localhost:3306)/",
 		dbName:       dbName,
 		underlyingDB: nil,
+		now:          time.Now,
 	}
 
 	// Open a separate connection to the root DSN and create the database if it does not exist
 	initDB, err := sql.Open("mysql", mysql.rootDSN+"?multiStatements=true")
 	if err != nil {
-		log.Fatalln("Error opening MySQL root DSN -", err)
+		logging.Fatal("Error opening MySQL root DSN", "err", err)
 	}
 	if err = initDB.Ping(); err != nil {
 		if err = initDB.Close(); err != nil {
-			log.Fatalln("Error closing broken MySQL root DSN -", err)
+			logging.Fatal("Error closing broken MySQL root DSN", "err", err)
 		}
-		log.Fatalln("Error connecting to MySQL root DSN -", err)
+		logging.Fatal("Error connecting to MySQL root DSN", "err", err)
 	}
 	_, err = initDB.Exec("CREATE DATABASE IF NOT EXISTS " + dbName)
 	if err != nil {
-		log.Fatalln("Error creating database -", err)
+		logging.Fatal("Error creating database", "db", dbName, "err", err)
 	}
 
 	// Move to the database and run 'init_tables.sql' script.
 	_, err = initDB.Exec("USE " + dbName)
 	if err != nil {
-		log.Fatalln("Error using", dbName, "-", err)
+		logging.Fatal("Error using database", "db", dbName, "err", err)
 	}
 	initScript, err := os.ReadFile("internal/database/queries/init_tables.sql")
 	if err != nil {
-		log.Fatalln("Error reading init_tables.sql file -", err)
+		logging.Fatal("Error reading init_tables.sql file", "err", err)
 	}
 	if _, err = initDB.Exec(string(initScript)); err != nil {
-		log.Fatalln("Error executing init_tables.sql -", err)
+		logging.Fatal("Error executing init_tables.sql", "err", err)
+	}
+	if err = upgradeSchema(context.Background(), initDB, dbName); err != nil {
+		logging.Fatal("Error upgrading database schema", "err", err)
 	}
 	if err := initDB.Close(); err != nil {
-		log.Fatalln("Error closing init DB -", err)
+		logging.Fatal("Error closing init DB", "err", err)
 	}
 	return
 }
 
 func (db *MySQLDatabase) Open() (err error) {
 	if db.underlyingDB, err = sql.Open("mysql", db.rootDSN+db.dbName+"?parseTime=true"); err != nil {
-		log.Println("Error opening database -", err)
+		slog.Error("Error opening database", "err", err)
 		return
 	}
-	log.Println("MySQL Database connecting to", db.rootDSN[strings.Index(db.rootDSN, "@"):]+db.dbName, "🫡")
+	slog.Info("MySQL database connecting", "addr", db.rootDSN[strings.Index(db.rootDSN, "@")+1:]+db.dbName)
 	if err = db.underlyingDB.Ping(); err != nil {
 		if err = db.underlyingDB.Close(); err != nil {
-			log.Println("Error closing broken database -", err)
+			slog.Error("Error closing broken database", "err", err)
 		}
-		log.Println("Error connecting to database -", err)
+		slog.Error("Error connecting to database", "err", err)
 	}
 	return
 }
 
 func (db *MySQLDatabase) Close() (err error) {
 	if err = db.underlyingDB.Close(); err != nil {
-		log.Println("underlying database close failure -", err)
+		slog.Error("Underlying database close failure", "err", err)
 	} else {
-		log.Println("MySQL database has closed 👋🏽")
+		slog.Info("MySQL database has closed")
 	}
 	return
 }
 
-func (db *MySQLDatabase) AuthenticateLogin(email, password string) (id uint64, err error) {
-	var hash []byte
-	err = db.underlyingDB.QueryRow("SELECT id, password FROM users WHERE BINARY email = ?", email).Scan(
-		&id, &hash,
+// Stats reports the connection pool statistics, zeroed while the database is not open.
+func (db *MySQLDatabase) Stats() (stats sql.DBStats) {
+	if db.underlyingDB == nil {
+		return
+	}
+	return db.underlyingDB.Stats()
+}
+
+func (db *MySQLDatabase) AuthenticateLogin(ctx context.Context, email, password string) (id uint64, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.AuthenticateLogin")
+	defer func() { span.RecordError(err); span.End() }()
+	var (
+		hash         []byte
+		failedLogins uint
+		lockedUntil  sql.NullTime
+		deleted      bool
 	)
+	err = db.underlyingDB.QueryRowContext(
+		ctx, `SELECT id, password, failed_logins, locked_until, deleted_on IS NOT NULL FROM users
+		WHERE BINARY email = ?`, email,
+	).Scan(&id, &hash, &failedLogins, &lockedUntil, &deleted)
 	if errors.Is(err, sql.ErrNoRows) {
-		return 0, errors.New("invalid email")
+		slog.InfoContext(ctx, "Login for unknown email", "email", email)
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return 0, ErrInvalidCredentials
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error querying users for login", "err", err)
+		return 0, ErrInternal
+	}
+	_, bcryptSpan := tracing.Start(ctx, "bcrypt.compare")
+	start := time.Now()
+	err = bcrypt.CompareHashAndPassword(hash, []byte(password))
+	bcryptSeconds.Observe(time.Since(start).Seconds(), "compare")
+	bcryptSpan.End()
+	// Locked accounts are refused like unknown emails, after comparing the password all the same, so neither the
+	// answer nor how long it takes tells that the email is registered.
+	if lockedUntil.Valid && db.now().Before(lockedUntil.Time) {
+		slog.InfoContext(ctx, "Login to locked account", "user_id", id, "locked_until", lockedUntil.Time)
+		return 0, ErrInvalidCredentials
+	}
+	if err != nil {
+		slog.InfoContext(ctx, "Login with wrong password", "user_id", id)
+		db.recordLoginFailure(ctx, id)
+		return 0, ErrInvalidCredentials
 	}
-	if err = bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
-		return 0, errors.New("invalid password")
+	// Only the owner learns the account is awaiting deletion, anyone else is told the password is wrong above.
+	if deleted {
+		slog.InfoContext(ctx, "Login to deleted account", "user_id", id)
+		return 0, ErrAccountDeleted
+	}
+	if failedLogins > 0 || lockedUntil.Valid {
+		if _, err = db.underlyingDB.ExecContext(
+			ctx, "UPDATE users SET failed_logins = 0, locked_until = NULL WHERE id = ?", id,
+		); err != nil {
+			slog.ErrorContext(ctx, "Error resetting failed logins", "user_id", id, "err", err)
+			return 0, ErrInternal
+		}
 	}
 	return
 }
 
-func (db *MySQLDatabase) GetUser(id uint64) (user *internal.User) {
+// recordLoginFailure counts a wrong password against the account, locking it once there are too many in a row.
+func (db *MySQLDatabase) recordLoginFailure(ctx context.Context, id uint64) {
+	// MySQL assigns left to right, so both conditions see the count from before this failure.
+	if _, err := db.underlyingDB.ExecContext(
+		ctx, `UPDATE users
+		SET locked_until  = IF(failed_logins + 1 >= ?, ?, locked_until),
+		    failed_logins = IF(failed_logins + 1 >= ?, 0, failed_logins + 1)
+		WHERE id = ?`,
+		maxFailedLogins, db.now().Add(lockoutDuration), maxFailedLogins, id,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error recording failed login", "user_id", id, "err", err)
+	}
+}
+
+// UnlockUser lifts a lockout early, it is an administrator's escape hatch.
+func (db *MySQLDatabase) UnlockUser(ctx context.Context, email string) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.UnlockUser")
+	defer func() { span.RecordError(err); span.End() }()
+	result, err := db.underlyingDB.ExecContext(
+		ctx, "UPDATE users SET failed_logins = 0, locked_until = NULL WHERE BINARY email = ?", email,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error unlocking user", "email", email, "err", err)
+		return ErrInternal
+	}
+	if unlocked, err := result.RowsAffected(); err == nil && unlocked > 0 {
+		slog.InfoContext(ctx, "Unlocked user", "email", email)
+	}
+	return
+}
+
+func (db *MySQLDatabase) GetUser(ctx context.Context, id uint64) (user *internal.User) {
+	ctx, span := tracing.Start(ctx, "mysql.GetUser")
+	defer span.End()
 	user = &internal.User{}
-	err := db.underlyingDB.QueryRow("SELECT * FROM users WHERE id = ?", id).Scan(
-		&user.ID, &user.FirstName, &user.MiddleName, &user.LastName, &user.Email, &user.Password, &user.DateOfBirth,
-		&user.CreatedOn)
+	var dateOfBirth, emailVerifiedOn sql.NullTime
+	err := db.underlyingDB.QueryRowContext(
+		ctx, `SELECT id, first_name, middle_name, last_name, email, password, date_of_birth, created_on,
+		email_verified_on, totp_secret IS NOT NULL FROM users WHERE id = ? AND deleted_on IS NULL`, id,
+	).Scan(
+		&user.ID, &user.FirstName, &user.MiddleName, &user.LastName, &user.Email, &user.Password, &dateOfBirth,
+		&user.CreatedOn, &emailVerifiedOn, &user.TOTPEnabled)
+	if dateOfBirth.Valid {
+		user.DateOfBirth = dateOfBirth.Time.Format(validation.DateLayout)
+	}
+	user.EmailVerifiedOn = emailVerifiedOn.Time
 	if errors.Is(err, sql.ErrNoRows) {
-		log.Println("No user with ID:", id, "-", err)
+		slog.InfoContext(ctx, "No user with id", "user_id", id)
 		return nil
 	} else if err != nil {
-		log.Println("Failed to query users for ID:", id, "-", err)
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Failed to query users", "user_id", id, "err", err)
 		return nil
 	} else if !user.IsValid() {
-		log.Println("Malformed user with ID:", id, "-", user)
+		slog.ErrorContext(ctx, "Malformed user", "user_id", id)
 		return nil
 	}
 	return
 }
 
-func (db *MySQLDatabase) getOpenID() (id uint64, err error) {
-	err = db.underlyingDB.QueryRow("SELECT id FROM openid LIMIT 1").Scan(&id)
-	if err != nil && !errors.Is(err, sql.ErrNoRows) {
-		log.Println("Failed to query for an openid -", err)
-		return 0, errors.New("invalid openid")
+func (db *MySQLDatabase) AddUser(
+	ctx context.Context, firstName, middleName, lastName, email, password, dateOfBirth string,
+) (id uint64, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.AddUser")
+	defer func() { span.RecordError(err); span.End() }()
+	signup := validation.Signup{
+		Profile: validation.Profile{
+			FirstName: firstName, MiddleName: middleName, LastName: lastName, DateOfBirth: dateOfBirth,
+		},
+		Email: email, Password: password,
+	}
+	birthDate, err := signup.Validate(db.now())
+	if err != nil {
+		slog.InfoContext(ctx, "Refused invalid signup", "err", err)
+		return 0, err
+	}
+	email = signup.Email
+	hashedPassword, err := hashPassword(ctx, password)
+	if err != nil {
+		return id, err
+	}
+	result, err := db.underlyingDB.ExecContext(
+		ctx, `INSERT INTO users (first_name, middle_name, last_name, email, password, date_of_birth)
+		VALUES (?, ?, ?, ?, ?, ?)`, signup.FirstName, signup.MiddleName, signup.LastName, email, hashedPassword,
+		birthDate.Format(validation.DateLayout),
+	)
+	var driverErr *mysqlDriver.MySQLError
+	if errors.As(err, &driverErr) && driverErr.Number == errDuplicateEntry {
+		slog.InfoContext(ctx, "Signup with registered email", "email", email)
+		return 0, ErrEmailTaken
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error adding user", "email", email, "err", err)
+		return 0, ErrInternal
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error getting user id", "err", err)
+		return 0, ErrInternal
+	}
+	return uint64(userID), nil
+}
+
+func hashPassword(ctx context.Context, password string) (hash []byte, err error) {
+	_, span := tracing.Start(ctx, "bcrypt.hash")
+	defer span.End()
+	start := time.Now()
+	hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	bcryptSeconds.Observe(time.Since(start).Seconds(), "hash")
+	if err != nil {
+		slog.ErrorContext(ctx, "Error hashing password", "err", err)
+		return nil, ErrInternal
 	}
 	return
 }
 
-func (db *MySQLDatabase) AddUser(firstName, middleName, lastName, email, password, dateOfBirth string) (
-	id uint64, err error) {
-	if len(firstName) == 0 || len(lastName) == 0 || len(email) == 0 || len(password) == 0 || len(dateOfBirth) == 0 {
-		return 0, errors.New("empty fields")
+// ResetPassword redeems a password reset token, replacing the password of its user and logging them out of every
+// session, all or nothing.
+func (db *MySQLDatabase) ResetPassword(ctx context.Context, token, password string) (id uint64, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.ResetPassword")
+	defer func() { span.RecordError(err); span.End() }()
+	if len(password) == 0 {
+		return 0, ErrEmptyFields
 	}
-	query := "INSERT INTO users (first_name, middle_name, last_name, email, password, date_of_birth"
-	if openID, err := db.getOpenID(); err != nil && !errors.Is(err, sql.ErrNoRows) {
-		log.Println("Error getting open id -", err)
-		return id, errors.New("internal server error")
-	} else if openID == 0 {
-		query += `) VALUES (?, ?, ?, ?, ?, ?)`
-	} else {
-		log.Println("Re-using open id:", openID, "for {"+email+"}")
-		id = openID
-		query += `, id) VALUES (?, ?, ?, ?, ?, ?, ` + strconv.FormatUint(id, 10) + `)`
-		if _, err = db.underlyingDB.Exec("DELETE FROM openid WHERE id = ?", id); err != nil {
-			log.Println("Error deleting open id -", err)
-			return id, errors.New("internal server error")
-		}
+	hash, err := hashPassword(ctx, password)
+	if err != nil {
+		return
+	}
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			if id, err = db.consumeUserToken(ctx, tx, PasswordResetToken, token); err != nil {
+				return
+			}
+			return setPassword(ctx, tx, id, hash)
+		},
+	); err != nil {
+		return 0, err
 	}
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	slog.InfoContext(ctx, "Password reset, revoked sessions", "user_id", id)
+	return
+}
+
+// setPassword stores the hash of a user's new password within tx, lifting any lockout and deleting their sessions.
+func setPassword(ctx context.Context, tx *sql.Tx, id uint64, hash []byte) (err error) {
+	if _, err = tx.ExecContext(
+		ctx, "UPDATE users SET password = ?, failed_logins = 0, locked_until = NULL WHERE id = ?", hash, id,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error setting password", "user_id", id, "err", err)
+		return internalError(err)
+	}
+	if _, err = tx.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ?", id); err != nil {
+		slog.ErrorContext(ctx, "Error revoking sessions", "user_id", id, "err", err)
+		return internalError(err)
+	}
+	return
+}
+
+// UpdateUserProfile replaces the names and date of birth of a user, checked by the same rules as at signup.
+func (db *MySQLDatabase) UpdateUserProfile(
+	ctx context.Context, id uint64, firstName, middleName, lastName, dateOfBirth string,
+) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.UpdateUserProfile")
+	defer func() { span.RecordError(err); span.End() }()
+	profile := validation.Profile{
+		FirstName: firstName, MiddleName: middleName, LastName: lastName, DateOfBirth: dateOfBirth,
+	}
+	birthDate, err := profile.Validate(db.now())
 	if err != nil {
-		log.Println("Error hashing password -", err)
-		return id, errors.New("internal server error")
+		slog.InfoContext(ctx, "Refused invalid profile", "user_id", id, "err", err)
+		return
+	}
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, "UPDATE users SET first_name = ?, middle_name = ?, last_name = ?, date_of_birth = ? WHERE id = ?",
+		profile.FirstName, profile.MiddleName, profile.LastName, birthDate.Format(validation.DateLayout), id,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error updating profile", "user_id", id, "err", err)
+		return ErrInternal
+	}
+	slog.InfoContext(ctx, "Profile updated", "user_id", id)
+	return
+}
+
+// ChangePassword replaces the password of a user who knows the current one, logging out every session except
+// keepSession and voiding password reset links. New passwords are refused with validation.Errors under "new_password".
+func (db *MySQLDatabase) ChangePassword(
+	ctx context.Context, id uint64, currentPassword, newPassword, keepSession string,
+) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.ChangePassword")
+	defer func() { span.RecordError(err); span.End() }()
+	var (
+		email string
+		hash  []byte
+	)
+	if err = db.underlyingDB.QueryRowContext(
+		ctx, "SELECT email, password FROM users WHERE id = ?", id,
+	).Scan(&email, &hash); err != nil {
+		slog.ErrorContext(ctx, "Error selecting password", "user_id", id, "err", err)
+		return ErrInternal
 	}
-	result, err := db.underlyingDB.Exec(query, firstName, middleName, lastName, email, hashedPassword, dateOfBirth)
+	_, bcryptSpan := tracing.Start(ctx, "bcrypt.compare")
+	start := time.Now()
+	err = bcrypt.CompareHashAndPassword(hash, []byte(currentPassword))
+	bcryptSeconds.Observe(time.Since(start).Seconds(), "compare")
+	bcryptSpan.End()
 	if err != nil {
-		log.Println("Error adding user -", err)
-		return 0, errors.New("internal server error")
+		slog.InfoContext(ctx, "Password change with wrong password", "user_id", id)
+		return ErrInvalidCredentials
 	}
-	if id == 0 {
-		userid, err := result.LastInsertId()
-		if err != nil {
-			log.Println("Error getting user ID -", err)
-			return 0, errors.New("internal server error")
-		}
-		id = uint64(userid)
-		log.Println("All existing IDs in use, assigning new ID:", id, "to {"+email+"}")
+	errs := validation.Errors{}
+	if validation.Password(errs, "new_password", newPassword, email); len(errs) > 0 {
+		return errs
 	}
+	if hash, err = hashPassword(ctx, newPassword); err != nil {
+		return
+	}
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			if _, err = tx.ExecContext(ctx, "UPDATE users SET password = ? WHERE id = ?", hash, id); err != nil {
+				slog.ErrorContext(ctx, "Error changing password", "user_id", id, "err", err)
+				return internalError(err)
+			}
+			if _, err = tx.ExecContext(
+				ctx, "DELETE FROM sessions WHERE user_id = ? AND token_hash <> ?", id, hashToken(keepSession),
+			); err != nil {
+				slog.ErrorContext(ctx, "Error revoking other sessions", "user_id", id, "err", err)
+				return internalError(err)
+			}
+			if _, err = tx.ExecContext(
+				ctx, "DELETE FROM user_tokens WHERE user_id = ? AND purpose = ?", id, PasswordResetToken,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error revoking password reset links", "user_id", id, "err", err)
+				return internalError(err)
+			}
+			return
+		},
+	); err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "Password changed, revoked other sessions", "user_id", id)
+	return
+}
+
+// VerifyEmail redeems an email verification token, marking the current email of its user as verified.
+func (db *MySQLDatabase) VerifyEmail(ctx context.Context, token string) (id uint64, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.VerifyEmail")
+	defer func() { span.RecordError(err); span.End() }()
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			if id, err = db.consumeUserToken(ctx, tx, VerifyEmailToken, token); err != nil {
+				return
+			}
+			if _, err = tx.ExecContext(
+				ctx, "UPDATE users SET email_verified_on = ? WHERE id = ? AND email_verified_on IS NULL", db.now(), id,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error verifying email", "user_id", id, "err", err)
+				return internalError(err)
+			}
+			return
+		},
+	); err != nil {
+		return 0, err
+	}
+	slog.InfoContext(ctx, "Email verified", "user_id", id)
 	return
 }
 
-func (db *MySQLDatabase) GetUserID(email string) (id uint64) {
+// ChangeEmail sets a new email that must be verified again, links already sent to the previous one stop working.
+func (db *MySQLDatabase) ChangeEmail(ctx context.Context, id uint64, email string) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.ChangeEmail")
+	defer func() { span.RecordError(err); span.End() }()
+	errs := validation.Errors{}
+	if email = validation.Email(errs, "email", email); len(errs) > 0 {
+		return errs
+	}
+	return db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			_, err = tx.ExecContext(ctx, "UPDATE users SET email = ?, email_verified_on = NULL WHERE id = ?", email, id)
+			var driverErr *mysqlDriver.MySQLError
+			if errors.As(err, &driverErr) && driverErr.Number == errDuplicateEntry {
+				slog.InfoContext(ctx, "Email change to registered email", "user_id", id, "email", email)
+				return ErrEmailTaken
+			} else if err != nil {
+				slog.ErrorContext(ctx, "Error changing email", "user_id", id, "err", err)
+				return internalError(err)
+			}
+			if _, err = tx.ExecContext(
+				ctx, "UPDATE user_tokens SET used_on = ? WHERE user_id = ? AND purpose = ? AND used_on IS NULL",
+				db.now(), id, VerifyEmailToken,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error revoking verification tokens", "user_id", id, "err", err)
+				return internalError(err)
+			}
+			return
+		},
+	)
+}
+
+func (db *MySQLDatabase) GetUserID(ctx context.Context, email string) (id uint64) {
+	ctx, span := tracing.Start(ctx, "mysql.GetUserID")
+	defer span.End()
 	// TODO(@seoyoungcho213): might not use this anymore cuz of cookie
-	if err := db.underlyingDB.QueryRow(
-		"SELECT id FROM users WHERE BINARY email = ?", email,
+	if err := db.underlyingDB.QueryRowContext(
+		ctx, "SELECT id FROM users WHERE BINARY email = ? AND deleted_on IS NULL", email,
 	).Scan(&id); err != nil && !errors.Is(err, sql.ErrNoRows) {
-		log.Println("Error querying users for email:"+email, "-", err)
+		span.RecordError(err)
+		slog.ErrorContext(ctx, "Error querying users by email", "email", email, "err", err)
 		return 0
 	}
 	return id
 }
 
-func (db *MySQLDatabase) DeleteUser(id uint64) (err error) {
-	if _, err = db.underlyingDB.Exec("INSERT INTO openid (id) VALUES(?)", id); err != nil {
-		log.Println("Error inserting openID", id, " to the table -", err)
-		return errors.New("internal server error")
+// DeleteUser schedules a user for deletion, logging them out everywhere. For DeletionGracePeriod the user can't log in
+// but can still be brought back by RestoreUser, then PurgeDeletedUsers erases them.
+func (db *MySQLDatabase) DeleteUser(ctx context.Context, id uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.DeleteUser")
+	defer func() { span.RecordError(err); span.End() }()
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			if _, err = tx.ExecContext(
+				ctx, "UPDATE users SET deleted_on = ? WHERE id = ? AND deleted_on IS NULL", db.now(), id,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error deleting user", "user_id", id, "err", err)
+				return internalError(err)
+			}
+			for _, table := range []string{"sessions", "user_tokens", "api_tokens"} {
+				if _, err = tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE user_id = ?", id); err != nil {
+					slog.ErrorContext(
+						ctx, "Error revoking access of deleted user", "user_id", id, "table", table, "err", err,
+					)
+					return internalError(err)
+				}
+			}
+			return
+		},
+	); err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "User deleted", "user_id", id)
+	return
+}
+
+// RestoreUser redeems a RestoreAccountToken, undoing the deletion of its user if the grace period isn't over.
+func (db *MySQLDatabase) RestoreUser(ctx context.Context, token string) (id uint64, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.RestoreUser")
+	defer func() { span.RecordError(err); span.End() }()
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			if id, err = db.consumeUserToken(ctx, tx, RestoreAccountToken, token); err != nil {
+				return
+			}
+			result, err := tx.ExecContext(
+				ctx, "UPDATE users SET deleted_on = NULL WHERE id = ? AND deleted_on > ?",
+				id, db.now().Add(-DeletionGracePeriod),
+			)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error restoring user", "user_id", id, "err", err)
+				return internalError(err)
+			}
+			if restored, err := result.RowsAffected(); err != nil {
+				slog.ErrorContext(ctx, "Error restoring user", "user_id", id, "err", err)
+				return internalError(err)
+			} else if restored != 1 {
+				slog.InfoContext(ctx, "Restore of user no longer deleted or already purged", "user_id", id)
+				return ErrInvalidToken
+			}
+			return
+		},
+	); err != nil {
+		return 0, err
+	}
+	slog.InfoContext(ctx, "User restored", "user_id", id)
+	return
+}
+
+// PurgeDeletedUsers erases every user deleted over DeletionGracePeriod ago along with all of their data, each in a
+// transaction of its own. Their ids are never handed out again.
+func (db *MySQLDatabase) PurgeDeletedUsers(ctx context.Context) (purged int, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.PurgeDeletedUsers")
+	defer func() { span.RecordError(err); span.End() }()
+	rows, err := db.underlyingDB.QueryContext(
+		ctx, "SELECT id FROM users WHERE deleted_on <= ? LIMIT 100", db.now().Add(-DeletionGracePeriod),
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error selecting deleted users", "err", err)
+		return 0, ErrInternal
+	}
+	var ids []uint64
+	for rows.Next() {
+		var id uint64
+		if err = rows.Scan(&id); err != nil {
+			_ = rows.Close()
+			slog.ErrorContext(ctx, "Error scanning deleted user", "err", err)
+			return 0, ErrInternal
+		}
+		ids = append(ids, id)
 	}
-	if _, err = db.underlyingDB.Exec("DELETE FROM users WHERE BINARY id = ?", id); err != nil {
-		log.Println("Error deleting the user id", id, " -", err)
-		return errors.New("internal server error")
+	if err = errors.Join(rows.Err(), rows.Close()); err != nil {
+		slog.ErrorContext(ctx, "Error selecting deleted users", "err", err)
+		return 0, ErrInternal
 	}
-	return err
+	for _, id := range ids {
+		if err = db.purgeUser(ctx, id); err != nil {
+			return
+		}
+		purged++
+	}
+	return
+}
+
+// userTables are the tables holding rows of a user by user_id, all of which a purge deletes.
+var userTables = []string{
+	"transactions", "financial_accounts", "institutions", "sessions", "user_tokens", "notification_preferences",
+	"recovery_codes", "webauthn_credentials", "webauthn_challenges", "api_tokens", "webhook_deliveries", "webhooks",
+	"recurring_transactions", "transaction_splits",
+}
+
+func (db *MySQLDatabase) purgeUser(ctx context.Context, id uint64) (err error) {
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			for _, table := range userTables {
+				if _, err = tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE user_id = ?", id); err != nil {
+					slog.ErrorContext(ctx, "Error purging user data", "user_id", id, "table", table, "err", err)
+					return internalError(err)
+				}
+			}
+			if _, err = tx.ExecContext(
+				ctx, "DELETE FROM users WHERE id = ? AND deleted_on IS NOT NULL", id,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error purging user", "user_id", id, "err", err)
+				return internalError(err)
+			}
+			return
+		},
+	); err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "User purged", "user_id", id)
+	return
 }
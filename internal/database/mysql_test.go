@@ -1,6 +1,7 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
@@ -8,8 +9,14 @@ import (
 	"os"
 	"strings"
 	"testing"
+	"time"
 
-	_ "github.com/go-sql-driver/mysql"
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"github.com/matcha-devs/matcha/internal"
+	"github.com/matcha-devs/matcha/internal/mailer"
+	"github.com/matcha-devs/matcha/internal/validation"
+	"github.com/matcha-devs/matcha/internal/webauthn"
+	"github.com/matcha-devs/matcha/internal/webhooks"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -88,14 +95,34 @@ func TestNew(t *testing.T) {
 
 	expectedTables := map[string]map[string]struct{}{
 		"users": {"id": {}, "first_name": {}, "middle_name": {}, "last_name": {}, "email": {}, "password": {},
-			"date_of_birth": {}, "created_on": {}},
-		"openid": {"id": {}, "created_on": {}},
+			"date_of_birth": {}, "created_on": {}, "failed_logins": {}, "locked_until": {},
+			"email_verified_on": {}, "totp_secret": {}, "totp_last_step": {}, "deleted_on": {}},
 		"asset_class_aggregations": {"id": {}, "cash": {}, "stocks": {}, "credit_card": {}, "other_loan": {},
 			"retirement_cash": {}, "retirement_stocks": {}, "real_estate": {}, "other_property": {}},
-		"transactions": {"id": {}, "user_id": {}, "financial_account_id": {}, "amount": {}, "type": {}},
+		"transactions": {"id": {}, "user_id": {}, "financial_account_id": {}, "amount": {}, "type": {},
+			"occurred_on": {}, "recurring_id": {}, "transfer_id": {}},
+		"transaction_splits": {"id": {}, "transaction_id": {}, "user_id": {}, "type": {}, "amount": {}},
+		"recurring_transactions": {"id": {}, "user_id": {}, "financial_account_id": {}, "name": {}, "amount": {},
+			"type": {}, "rule": {}, "starts_on": {}, "ends_on": {}, "next_on": {}, "created_on": {}},
 		"financial_accounts": {"id": {}, "user_id": {}, "institution_id": {}, "asset_class": {}, "name": {},
 			"net_value": {}},
-		"institutions": {"id": {}, "name": {}},
+		"institutions": {"id": {}, "user_id": {}, "name": {}},
+		"sessions":     {"token_hash": {}, "user_id": {}, "expires_on": {}, "created_on": {}},
+		"user_tokens": {"token_hash": {}, "user_id": {}, "purpose": {}, "expires_on": {}, "used_on": {},
+			"created_on": {}},
+		"mail_outbox": {"id": {}, "recipient": {}, "subject": {}, "text_body": {}, "html_body": {}, "attempts": {},
+			"next_attempt_on": {}, "last_error": {}, "sent_on": {}, "failed_on": {}, "created_on": {}},
+		"notification_preferences": {"user_id": {}, "kind": {}, "enabled": {}},
+		"recovery_codes":           {"user_id": {}, "code_hash": {}, "used_on": {}},
+		"webauthn_credentials": {"id": {}, "user_id": {}, "public_key": {}, "sign_count": {}, "name": {},
+			"created_on": {}, "last_used_on": {}},
+		"webauthn_challenges": {"challenge_hash": {}, "user_id": {}, "ceremony": {}, "expires_on": {}},
+		"api_tokens": {"id": {}, "token_hash": {}, "user_id": {}, "name": {}, "scopes": {}, "expires_on": {},
+			"created_on": {}, "last_used_on": {}},
+		"webhooks": {"id": {}, "user_id": {}, "url": {}, "secret": {}, "events": {}, "created_on": {}},
+		"webhook_deliveries": {"id": {}, "webhook_id": {}, "user_id": {}, "event": {}, "payload": {}, "attempts": {},
+			"next_attempt_on": {}, "response_status": {}, "last_error": {}, "delivered_on": {}, "failed_on": {},
+			"created_on": {}},
 	}
 
 	tables, err := probe.Query("SHOW TABLES FROM test_db")
@@ -165,35 +192,42 @@ func TestAddUser(t *testing.T) {
 	}{
 		{"AddFirstUser", "test", "", "user", "test_user@example.com",
 			"test_pass", "2004-12-22", 1, false},
-		{"AddSecondUser", "test", "", "user2", "test_user@example2.com",
-			"test_pass2", "2024-12-22", 2, false},
+		{"AddSecondUser", "test", "", "usertwo", "test_user@example2.com",
+			"test_pass2", "2004-12-22", 2, false},
 		{"AddDuplicateName", "test", "", "user", "dupl_name@example.com",
-			"test_pass3", "2024-10-22", 3, false},
+			"test_pass3", "2004-10-22", 3, false},
 		{"AddDuplicateEmail", "unique", "", "user", "test_user@example2.com",
-			"test_pass4", "2024-10-22", 0, true},
+			"test_pass4", "2004-10-22", 0, true},
 		{"AddEmptyFirstname", "", "", "user", "empty_first@example.com",
-			"test_pass5", "2024-08-22", 0, true},
+			"test_pass5", "2004-08-22", 0, true},
 		// TODO : This supposed to be ID:3, but duplicate email increment ID by one. Fix this error.
 		{"AddEmptyMiddlename", "empty", "", "middle", "empty_mid@example.com",
-			"test_pass6", "2024-07-22", 5, false},
+			"test_pass6", "2004-07-22", 5, false},
 		{"AddEmptyLastname", "empty", "email", "", "empty_last@example.com",
-			"test_pass7", "2024-07-22", 0, true},
+			"test_pass7", "2004-07-22", 0, true},
 		{"AddEmptyEmail", "empty", "", "email", "", "test_pass7",
-			"2024-07-22", 0, true},
+			"2004-07-22", 0, true},
 		{"AddEmptyPassword", "empty", "pass", "user",
-			"empty_pass_user@example.com", "", "2021-07-22", 0, true},
+			"empty_pass_user@example.com", "", "2001-07-22", 0, true},
 		{"AddEmptyDateOfBirth", "empty", "", "DOB", "empty_dob@example.com",
 			"test_pass8", "", 0, true},
-
-		// TODO: The functionality for this test need to be implemented
-		// {"AddInvalidEmail", "invalid_email_user", "invalid_email.com", "test_pass7", 0, true},
+		{"AddInvalidEmail", "invalid", "", "email", "invalid_email.com", "test_pass9",
+			"2004-07-22", 0, true},
+		{"AddWeakPassword", "weak", "", "password", "weak_pass@example.com", "password1",
+			"2004-07-22", 0, true},
+		{"AddUnderage", "under", "", "age", "under_age@example.com", "test_pass10",
+			"2024-07-22", 0, true},
+		{"AddMalformedDateOfBirth", "bad", "", "date", "bad_date@example.com", "test_pass11",
+			"22/07/2004", 0, true},
 	}
 
 	for _, tc := range testCases {
 		t.Run(
 			tc.name, func(t *testing.T) {
-				id, err := subject.AddUser(tc.firstName, tc.middleName, tc.lastName, tc.email, tc.password,
-					tc.dateOfBirth)
+				id, err := subject.AddUser(
+					context.Background(), tc.firstName, tc.middleName, tc.lastName, tc.email, tc.password,
+					tc.dateOfBirth,
+				)
 				if tc.expectedError {
 					if err == nil {
 						t.Fatalf("Expected error but got none for case: %s", tc.name)
@@ -211,13 +245,29 @@ func TestAddUser(t *testing.T) {
 	}
 }
 
+func TestAddUserDuplicateEmail(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	email := "taken@example.com"
+	if _, err := subject.AddUser(
+		context.Background(), "first", "", "user", email, "takenPass", "2000-01-01",
+	); err != nil {
+		t.Fatal("Failed to add", email, "-", err)
+	}
+	_, err := subject.AddUser(context.Background(), "second", "", "user", email, "takenPass", "2000-01-01")
+	if !errors.Is(err, ErrEmailTaken) {
+		t.Error("Expected ErrEmailTaken, got", err)
+	}
+}
+
 func TestAuthenticateLogin(t *testing.T) {
 	subject, probe := setup(t)
 	defer teardown(t, subject, probe)
 
 	happyEmail := "test_user@example.com"
 	happyPass := "testPass"
-	_, err := subject.AddUser("test", "", "user", "test_user@example.com",
+	_, err := subject.AddUser(context.Background(), "test", "", "user", "test_user@example.com",
 		happyPass, "2000-02-13")
 	if err != nil {
 		t.Fatal("Failed to add", happyEmail, "-", err)
@@ -239,7 +289,7 @@ func TestAuthenticateLogin(t *testing.T) {
 	for _, testCase := range testCases {
 		t.Run(
 			testCase.name, func(t *testing.T) {
-				id, err := subject.AuthenticateLogin(testCase.email, testCase.password)
+				id, err := subject.AuthenticateLogin(context.Background(), testCase.email, testCase.password)
 				if (err == nil) != testCase.happyPath {
 					mood := "sad"
 					if testCase.happyPath {
@@ -247,6 +297,9 @@ func TestAuthenticateLogin(t *testing.T) {
 					}
 					t.Error("Was not expecting a", err, "error in this", mood, "test")
 				}
+				if !testCase.happyPath && !errors.Is(err, ErrInvalidCredentials) {
+					t.Error("Expected the same invalid credentials error for every sad path, got", err)
+				}
 				if id != testCase.expectedID {
 					t.Error("got id", id, "expected", testCase.expectedID)
 				}
@@ -255,29 +308,1451 @@ func TestAuthenticateLogin(t *testing.T) {
 	}
 }
 
-func TestDeleteUser(t *testing.T) {
+func TestLockout(t *testing.T) {
 	subject, probe := setup(t)
 	defer teardown(t, subject, probe)
 
-	id, err := subject.AddUser("delete", "", "user", "delete_user@example.com",
-		"delete_pass", "2000-01-01")
-	// Verify the user was added
+	email, password := "locked_user@example.com", "lockedPass"
+	_, err := subject.AddUser(context.Background(), "locked", "", "user", email, password, "2000-02-13")
+	if err != nil {
+		t.Fatal("Failed to add", email, "-", err)
+	}
+	now := time.Now()
+	subject.now = func() time.Time { return now }
+
+	for i := 0; i < maxFailedLogins; i++ {
+		if _, err := subject.AuthenticateLogin(context.Background(), email, "wrong"); err == nil {
+			t.Fatal("Expected wrong password", i, "to fail")
+		}
+	}
+	if _, err := subject.AuthenticateLogin(
+		context.Background(), email, password,
+	); !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatal("Expected the locked account to be refused like a wrong password, got", err)
+	}
+
+	now = now.Add(lockoutDuration)
+	if id, err := subject.AuthenticateLogin(context.Background(), email, password); err != nil || id == 0 {
+		t.Fatal("Expected the lockout to expire, got", err)
+	}
+
+	for i := 0; i < maxFailedLogins; i++ {
+		if _, err := subject.AuthenticateLogin(context.Background(), email, "wrong"); err == nil {
+			t.Fatal("Expected wrong password", i, "to fail")
+		}
+	}
+	if err := subject.UnlockUser(context.Background(), email); err != nil {
+		t.Fatal("Failed to unlock user -", err)
+	}
+	if _, err := subject.AuthenticateLogin(context.Background(), email, password); err != nil {
+		t.Error("Expected the unlocked account to log in, got", err)
+	}
+}
+
+func TestUpgradeSchema(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	// Take the tables back to how an older init_tables.sql created them, indexes first since dropping a column only
+	// takes it out of the indexes spanning more columns.
+	for _, added := range addedIndexes {
+		if _, err := probe.Exec("ALTER TABLE test_db." + added.table + " DROP INDEX " + added.name); err != nil {
+			t.Fatal("Failed to drop index", added.name, "-", err)
+		}
+	}
+	for _, added := range addedColumns {
+		if _, err := probe.Exec("ALTER TABLE test_db." + added.table + " DROP COLUMN " + added.column); err != nil {
+			t.Fatal("Failed to drop", added.column, "-", err)
+		}
+	}
+	for _, added := range requiredColumns {
+		if _, err := probe.Exec("ALTER TABLE test_db." + added.table + " DROP COLUMN " + added.column); err != nil {
+			t.Fatal("Failed to drop", added.column, "-", err)
+		}
+	}
+	for _, table := range droppedTables {
+		if _, err := probe.Exec("CREATE TABLE test_db." + table + " (id BIGINT(20) UNSIGNED NOT NULL)"); err != nil {
+			t.Fatal("Failed to create", table, "-", err)
+		}
+	}
+	for _, added := range addedEnumValues {
+		first, _, _ := strings.Cut(added.values, ",")
+		if _, err := probe.Exec(
+			"ALTER TABLE test_db." + added.table + " MODIFY COLUMN " + added.column + " ENUM (" + first + ") NOT NULL",
+		); err != nil {
+			t.Fatal("Failed to shrink", added.column, "-", err)
+		}
+	}
+	ctx := context.Background()
+	// The second run finds nothing left to do.
+	for range 2 {
+		if err := upgradeSchema(ctx, probe, "test_db"); err != nil {
+			t.Fatal("Failed to upgrade schema -", err)
+		}
+	}
+	for _, added := range addedColumns {
+		if _, err := probe.Exec("SELECT " + added.column + " FROM test_db." + added.table); err != nil {
+			t.Error("Expected", added.column, "back -", err)
+		}
+	}
+	for _, added := range requiredColumns {
+		var isNullable string
+		if err := probe.QueryRow(
+			`SELECT IS_NULLABLE FROM information_schema.COLUMNS
+			WHERE TABLE_SCHEMA = 'test_db' AND TABLE_NAME = ? AND COLUMN_NAME = ?`, added.table, added.column,
+		).Scan(&isNullable); err != nil || isNullable != "NO" {
+			t.Error("Expected", added.column, "back as NOT NULL, got", isNullable, err)
+		}
+	}
+	for _, added := range addedIndexes {
+		var count int
+		if err := probe.QueryRow(
+			`SELECT COUNT(*) FROM information_schema.STATISTICS
+			WHERE TABLE_SCHEMA = 'test_db' AND TABLE_NAME = ? AND INDEX_NAME = ?`, added.table, added.name,
+		).Scan(&count); err != nil || count == 0 {
+			t.Error("Expected index", added.name, "back -", err)
+		}
+	}
+	for _, table := range droppedTables {
+		if _, err := probe.Exec("SELECT 1 FROM test_db." + table); err == nil {
+			t.Error("Expected", table, "to be dropped")
+		}
+	}
+	for _, added := range addedEnumValues {
+		var columnType string
+		if err := probe.QueryRow(
+			`SELECT COLUMN_TYPE FROM information_schema.COLUMNS
+			WHERE TABLE_SCHEMA = 'test_db' AND TABLE_NAME = ? AND COLUMN_NAME = ?`, added.table, added.column,
+		).Scan(&columnType); err != nil || columnType != "enum("+added.values+")" {
+			t.Error("Expected the added enum values back, got", columnType, err)
+		}
+	}
+}
+
+func TestRequiredColumns(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	if _, err := probe.Exec("ALTER TABLE test_db.transactions DROP COLUMN occurred_on"); err != nil {
+		t.Fatal("Failed to drop occurred_on -", err)
+	}
+	if _, err := probe.Exec(
+		"INSERT INTO test_db.transactions (user_id, financial_account_id, amount, type) VALUES (1, 1, -500, 'ETC')",
+	); err != nil {
+		t.Fatal("Failed to add transaction -", err)
+	}
+	ctx := context.Background()
+	if err := upgradeSchema(ctx, probe, "test_db"); err == nil {
+		t.Fatal("Expected the upgrade to stop at the transaction without a date")
+	}
+	if _, err := probe.Exec("UPDATE test_db.transactions SET occurred_on = '2024-03-01'"); err != nil {
+		t.Fatal("Failed to fill in occurred_on -", err)
+	}
+	if err := upgradeSchema(ctx, probe, "test_db"); err != nil {
+		t.Fatal("Failed to upgrade schema -", err)
+	}
+	if _, err := probe.Exec("UPDATE test_db.transactions SET occurred_on = NULL"); err == nil {
+		t.Error("Expected occurred_on to be NOT NULL")
+	}
+}
+
+func TestConvertBirthDates(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	// Dates of birth were free text before signups were validated.
+	if _, err := probe.Exec("ALTER TABLE test_db.users MODIFY COLUMN date_of_birth VARCHAR(255) NOT NULL"); err != nil {
+		t.Fatal("Failed to downgrade date_of_birth -", err)
+	}
+	if _, err := probe.Exec(
+		`INSERT INTO test_db.users (first_name, last_name, email, password, date_of_birth) VALUES
+		('readable', 'user', 'readable@example.com', '', ' 1990-05-17'),
+		('unreadable', 'user', 'unreadable@example.com', '', 'May 17th')`,
+	); err != nil {
+		t.Fatal("Failed to add users -", err)
+	}
+	ctx := context.Background()
+	if err := upgradeSchema(ctx, probe, "test_db"); err == nil {
+		t.Fatal("Expected the upgrade to stop at the unreadable date")
+	}
+	if _, err := probe.Exec(
+		"UPDATE test_db.users SET date_of_birth_new = '1990-05-17' WHERE email = 'unreadable@example.com'",
+	); err != nil {
+		t.Fatal("Failed to fill in the date -", err)
+	}
+	if err := upgradeSchema(ctx, probe, "test_db"); err != nil {
+		t.Fatal("Failed to upgrade schema -", err)
+	}
+	var converted int
+	if err := probe.QueryRow(
+		"SELECT COUNT(*) FROM test_db.users WHERE date_of_birth = '1990-05-17'",
+	).Scan(&converted); err != nil || converted != 2 {
+		t.Error("Expected both dates of birth converted, got", converted, err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "session", "", "user", "session_user@example.com", "sessionPass", "2000-01-01")
 	if err != nil {
 		t.Fatal("Failed to add user -", err)
 	}
+	now := time.Now()
+	subject.now = func() time.Time { return now }
 
-	// Delete the user
-	err = subject.DeleteUser(id)
+	token, err := subject.CreateSession(ctx, id, time.Minute)
 	if err != nil {
-		t.Fatal("Failed to delete user -", err)
+		t.Fatal("Failed to create session -", err)
+	}
+	if user := subject.GetSessionUser(ctx, token); user == nil || user.ID != id {
+		t.Fatal("Expected session to belong to user", id, "got", user)
+	}
+	if user := subject.GetSessionUser(ctx, token+"x"); user != nil {
+		t.Error("Expected an unknown token to have no user, got", user)
+	}
+	now = now.Add(time.Minute)
+	if user := subject.GetSessionUser(ctx, token); user != nil {
+		t.Error("Expected the session to expire, got", user)
 	}
 
-	// Verify the user was deleted
-	err = probe.QueryRow("SELECT id FROM users WHERE id = ?", id).Scan(&id)
-	if err == nil {
-		t.Error("Deleted user still exists -", err)
-	} else if !errors.Is(err, sql.ErrNoRows) {
-		log.Println("Probe failed to verify user -", err)
+	other, err := subject.CreateSession(ctx, id, time.Minute)
+	if err != nil {
+		t.Fatal("Failed to create session -", err)
+	}
+	if err := subject.DeleteSession(ctx, other); err != nil {
+		t.Fatal("Failed to delete session -", err)
+	}
+	if user := subject.GetSessionUser(ctx, other); user != nil {
+		t.Error("Expected the deleted session to have no user, got", user)
+	}
+
+	token, err = subject.CreateSession(ctx, id, time.Minute)
+	if err != nil {
+		t.Fatal("Failed to create session -", err)
+	}
+	if err := subject.ChangePassword(ctx, id, "sessionPass", "newSessionPass42", ""); err != nil {
+		t.Fatal("Failed to change password -", err)
+	}
+	if user := subject.GetSessionUser(ctx, token); user != nil {
+		t.Error("Expected changing the password to end every session, got", user)
+	}
+}
+
+func TestAPITokens(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "token", "", "user", "token_user@example.com", "tokenPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	now := time.Now().Truncate(time.Second)
+	subject.now = func() time.Time { return now }
+
+	var errs validation.Errors
+	if _, err = subject.CreateAPIToken(ctx, id, "script", nil, 0); !errors.As(err, &errs) || errs["scopes"] == "" {
+		t.Error("got", err, "expected a token without scopes to be refused")
+	}
+	if _, err = subject.CreateAPIToken(
+		ctx, id, "script", []Scope{"admin"}, 0,
+	); !errors.As(err, &errs) || errs["scopes"] == "" {
+		t.Error("got", err, "expected an unknown scope to be refused")
+	}
+
+	token, err := subject.CreateAPIToken(ctx, id, "script", []Scope{ReadScope, TransactionsWriteScope}, time.Hour)
+	if err != nil {
+		t.Fatal("Failed to create API token -", err)
+	}
+	if !strings.HasPrefix(token, APITokenPrefix) {
+		t.Error("got token", token, "expected it to start with", APITokenPrefix)
+	}
+	user, scopes, err := subject.AuthenticateAPIToken(ctx, token)
+	if err != nil || user == nil || user.ID != id {
+		t.Fatal("Expected the token to belong to user", id, "got", user, err)
+	}
+	if len(scopes) != 2 || scopes[0] != ReadScope || scopes[1] != TransactionsWriteScope {
+		t.Error("got scopes", scopes, "expected read and transactions:write")
+	}
+	if _, _, err = subject.AuthenticateAPIToken(ctx, token+"x"); !errors.Is(err, ErrInvalidToken) {
+		t.Error("got", err, "expected an unknown token to be refused")
+	}
+
+	tokens, err := subject.GetAPITokens(ctx, id)
+	if err != nil || len(tokens) != 1 {
+		t.Fatal("Expected one API token, got", tokens, err)
+	}
+	if !tokens[0].LastUsedOn.Equal(now) || !tokens[0].ExpiresOn.Equal(now.Add(time.Hour)) {
+		t.Error("got", tokens[0], "expected the token to be used now and to expire in an hour")
+	}
+	now = now.Add(time.Hour)
+	if _, _, err = subject.AuthenticateAPIToken(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Error("got", err, "expected the token to expire")
+	}
+
+	forever, err := subject.CreateAPIToken(ctx, id, "integration", []Scope{ReadScope}, 0)
+	if err != nil {
+		t.Fatal("Failed to create API token -", err)
+	}
+	now = now.Add(10 * 365 * 24 * time.Hour)
+	if _, _, err = subject.AuthenticateAPIToken(ctx, forever); err != nil {
+		t.Error("got", err, "expected a token without expiry to last")
+	}
+	if tokens, err = subject.GetAPITokens(ctx, id); err != nil || len(tokens) != 2 {
+		t.Fatal("Expected two API tokens, got", tokens, err)
+	}
+	if err = subject.DeleteAPIToken(ctx, id, tokens[0].ID); err != nil {
+		t.Fatal("Failed to delete API token -", err)
+	}
+	if _, _, err = subject.AuthenticateAPIToken(ctx, forever); !errors.Is(err, ErrInvalidToken) {
+		t.Error("got", err, "expected the revoked token to be refused")
+	}
+}
+
+func TestUpdateUserProfile(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "profile", "", "user", "profile_user@example.com", "profilePass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	if err := subject.UpdateUserProfile(ctx, id, " Renamed ", "Middle", "Person", "1990-06-15"); err != nil {
+		t.Fatal("Failed to update profile -", err)
+	}
+	user := subject.GetUser(ctx, id)
+	if user == nil || user.FirstName != "Renamed" || user.MiddleName != "Middle" || user.LastName != "Person" ||
+		user.DateOfBirth != "1990-06-15" {
+		t.Error("got", user, "expected the new profile")
+	}
+	var errs validation.Errors
+	if err := subject.UpdateUserProfile(ctx, id, "", "", "Person", "next week"); !errors.As(err, &errs) ||
+		errs["first_name"] == "" || errs["date_of_birth"] == "" {
+		t.Error("Expected the invalid fields to be refused, got", err)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	email := "changing_user@example.com"
+	id, err := subject.AddUser(ctx, "changing", "", "user", email, "changingPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	current, err := subject.CreateSession(ctx, id, time.Minute)
+	if err != nil {
+		t.Fatal("Failed to create session -", err)
+	}
+	other, err := subject.CreateSession(ctx, id, time.Minute)
+	if err != nil {
+		t.Fatal("Failed to create session -", err)
+	}
+	reset, err := subject.CreateUserToken(ctx, id, PasswordResetToken, time.Hour)
+	if err != nil {
+		t.Fatal("Failed to create token -", err)
+	}
+
+	err = subject.ChangePassword(ctx, id, "wrongPass1", "brandNew42", current)
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Error("Expected the wrong current password to be refused, got", err)
+	}
+	var errs validation.Errors
+	if err := subject.ChangePassword(ctx, id, "changingPass", "short", current); !errors.As(err, &errs) {
+		t.Error("Expected a weak new password to be refused, got", err)
+	}
+	if err := subject.ChangePassword(ctx, id, "changingPass", "brandNew42", current); err != nil {
+		t.Fatal("Failed to change password -", err)
+	}
+
+	if _, err := subject.AuthenticateLogin(ctx, email, "brandNew42"); err != nil {
+		t.Error("Expected the new password to log in, got", err)
+	}
+	if user := subject.GetSessionUser(ctx, current); user == nil {
+		t.Error("Expected the session changing the password to stay logged in")
+	}
+	if user := subject.GetSessionUser(ctx, other); user != nil {
+		t.Error("Expected other sessions to be revoked, got", user)
+	}
+	if _, err := subject.ConsumeUserToken(ctx, PasswordResetToken, reset); !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expected password reset links to be voided, got", err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	email := "reset_user@example.com"
+	id, err := subject.AddUser(ctx, "reset", "", "user", email, "forgottenPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	session, err := subject.CreateSession(ctx, id, time.Minute)
+	if err != nil {
+		t.Fatal("Failed to create session -", err)
+	}
+	token, err := subject.CreateUserToken(ctx, id, PasswordResetToken, time.Hour)
+	if err != nil {
+		t.Fatal("Failed to create token -", err)
+	}
+
+	if _, err := subject.ResetPassword(ctx, token, ""); !errors.Is(err, ErrEmptyFields) {
+		t.Error("Expected an empty password to be refused, got", err)
+	}
+	if userID, err := subject.ResetPassword(ctx, token, "rememberedPass"); err != nil || userID != id {
+		t.Fatal("Expected the token to reset the password of user", id, "got", userID, err)
+	}
+	if _, err := subject.AuthenticateLogin(ctx, email, "rememberedPass"); err != nil {
+		t.Error("Expected the new password to log in, got", err)
+	}
+	if user := subject.GetSessionUser(ctx, session); user != nil {
+		t.Error("Expected resetting the password to end every session, got", user)
+	}
+	if _, err := subject.ResetPassword(ctx, token, "anotherPass"); !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expected a used token to be refused, got", err)
+	}
+	if _, err := subject.AuthenticateLogin(ctx, email, "rememberedPass"); err != nil {
+		t.Error("Expected a refused reset to leave the password alone, got", err)
+	}
+}
+
+func TestUserTokens(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "token", "", "user", "token_user@example.com", "tokenPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	now := time.Now()
+	subject.now = func() time.Time { return now }
+
+	token, err := subject.CreateUserToken(ctx, id, PasswordResetToken, time.Hour)
+	if err != nil {
+		t.Fatal("Failed to create token -", err)
+	}
+	if userID, err := subject.ConsumeUserToken(ctx, PasswordResetToken, token); err != nil || userID != id {
+		t.Fatal("Expected token to redeem for user", id, "got", userID, err)
+	}
+	if _, err := subject.ConsumeUserToken(ctx, PasswordResetToken, token); !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expected a used token to be refused, got", err)
+	}
+
+	first, err := subject.CreateUserToken(ctx, id, PasswordResetToken, time.Hour)
+	if err != nil {
+		t.Fatal("Failed to create token -", err)
+	}
+	second, err := subject.CreateUserToken(ctx, id, PasswordResetToken, time.Hour)
+	if err != nil {
+		t.Fatal("Failed to create token -", err)
+	}
+	if _, err := subject.ConsumeUserToken(ctx, PasswordResetToken, first); !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expected a newer token to revoke the previous one, got", err)
+	}
+	now = now.Add(time.Hour)
+	if _, err := subject.ConsumeUserToken(ctx, PasswordResetToken, second); !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expected an expired token to be refused, got", err)
+	}
+}
+
+func TestEmailVerification(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "verified", "", "user", "verified_user@example.com", "verifiedPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	_, err = subject.AddUser(ctx, "other", "", "user", "other_user@example.com", "otherPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	if user := subject.GetUser(ctx, id); user == nil || user.EmailVerified() {
+		t.Fatal("Expected a new user to be unverified, got", user)
+	}
+
+	token, err := subject.CreateUserToken(ctx, id, VerifyEmailToken, time.Hour)
+	if err != nil {
+		t.Fatal("Failed to create token -", err)
+	}
+	if verifiedID, err := subject.VerifyEmail(ctx, token); err != nil || verifiedID != id {
+		t.Fatal("Expected token to verify user", id, "got", verifiedID, err)
+	}
+	if user := subject.GetUser(ctx, id); user == nil || !user.EmailVerified() {
+		t.Fatal("Expected the user to be verified, got", user)
+	}
+
+	stale, err := subject.CreateUserToken(ctx, id, VerifyEmailToken, time.Hour)
+	if err != nil {
+		t.Fatal("Failed to create token -", err)
+	}
+	if err := subject.ChangeEmail(ctx, id, "other_user@example.com"); !errors.Is(err, ErrEmailTaken) {
+		t.Error("Expected a registered email to be refused, got", err)
+	}
+	if err := subject.ChangeEmail(ctx, id, "changed_user@example.com"); err != nil {
+		t.Fatal("Failed to change email -", err)
+	}
+	user := subject.GetUser(ctx, id)
+	if user == nil || user.EmailVerified() || user.Email != "changed_user@example.com" {
+		t.Error("Expected the changed email to be unverified, got", user)
+	}
+	if _, err := subject.VerifyEmail(ctx, stale); !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expected links sent to the previous email to stop working, got", err)
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "totp", "", "user", "totp_user@example.com", "totpPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	codes, err := subject.EnableTOTP(ctx, id, []byte("sealed secret"))
+	if err != nil || len(codes) != recoveryCodeCount {
+		t.Fatal("Expected", recoveryCodeCount, "recovery codes, got", len(codes), err)
+	}
+	if user := subject.GetUser(ctx, id); user == nil || !user.TOTPEnabled {
+		t.Fatal("Expected two-factor authentication to be on, got", user)
+	}
+	if secret, err := subject.GetTOTPSecret(ctx, id); err != nil || string(secret) != "sealed secret" {
+		t.Error("got secret", secret, err)
+	}
+
+	if err := subject.UseTOTPStep(ctx, id, 5); err != nil {
+		t.Fatal("Failed to use step -", err)
+	}
+	for _, step := range []int64{5, 4} {
+		if err := subject.UseTOTPStep(ctx, id, step); !errors.Is(err, ErrInvalidCode) {
+			t.Error("Expected step", step, "to be refused after step 5, got", err)
+		}
+	}
+
+	if err := subject.UseRecoveryCode(ctx, id, strings.ToUpper(strings.ReplaceAll(codes[0], "-", ""))); err != nil {
+		t.Error("Expected a recovery code typed differently to work, got", err)
+	}
+	if err := subject.UseRecoveryCode(ctx, id, codes[0]); !errors.Is(err, ErrInvalidCode) {
+		t.Error("Expected a used recovery code to be refused, got", err)
+	}
+
+	token, err := subject.CreateUserToken(ctx, id, TwoFactorLoginToken, time.Minute)
+	if err != nil {
+		t.Fatal("Failed to create token -", err)
+	}
+	for range 2 {
+		if userID, err := subject.CheckUserToken(ctx, TwoFactorLoginToken, token); err != nil || userID != id {
+			t.Error("Expected checking a token to leave it usable, got", userID, err)
+		}
+	}
+
+	if err := subject.DisableTOTP(ctx, id); err != nil {
+		t.Fatal("Failed to disable two-factor authentication -", err)
+	}
+	if user := subject.GetUser(ctx, id); user == nil || user.TOTPEnabled {
+		t.Error("Expected two-factor authentication to be off, got", user)
+	}
+	if err := subject.UseRecoveryCode(ctx, id, codes[1]); !errors.Is(err, ErrInvalidCode) {
+		t.Error("Expected recovery codes to be dropped, got", err)
+	}
+}
+
+func TestPasskeys(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "passkey", "", "user", "passkey_user@example.com", "passkeyPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	challenge, err := subject.CreateWebAuthnChallenge(ctx, id, RegisterCeremony, time.Minute)
+	if err != nil {
+		t.Fatal("Failed to create challenge -", err)
+	}
+	if _, err := subject.ConsumeWebAuthnChallenge(ctx, LoginCeremony, challenge); !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expected a registration challenge to be refused for a login, got", err)
+	}
+	if userID, err := subject.ConsumeWebAuthnChallenge(ctx, RegisterCeremony, challenge); err != nil || userID != id {
+		t.Error("got", userID, err, "expected the challenge's user")
+	}
+	if _, err := subject.ConsumeWebAuthnChallenge(ctx, RegisterCeremony, challenge); !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expected a used challenge to be refused, got", err)
+	}
+	challenge, err = subject.CreateWebAuthnChallenge(ctx, 0, LoginCeremony, time.Minute)
+	if err != nil {
+		t.Fatal("Failed to create challenge -", err)
+	}
+	if userID, err := subject.ConsumeWebAuthnChallenge(ctx, LoginCeremony, challenge); err != nil || userID != 0 {
+		t.Error("got", userID, err, "expected a login challenge without a user")
+	}
+
+	credential := webauthn.Credential{ID: []byte("credential"), PublicKey: []byte("cose key"), SignCount: 1}
+	if err := subject.AddPasskey(ctx, id, credential, "Laptop"); err != nil {
+		t.Fatal("Failed to add passkey -", err)
+	}
+	if err := subject.AddPasskey(ctx, id, credential, "Laptop"); !errors.Is(err, ErrPasskeyTaken) {
+		t.Error("Expected a passkey to be registered once, got", err)
+	}
+	if err := subject.UsePasskey(ctx, credential.ID, 7); err != nil {
+		t.Fatal("Failed to use passkey -", err)
+	}
+	userID, stored, err := subject.GetPasskey(ctx, credential.ID)
+	if err != nil || userID != id || stored.SignCount != 7 || string(stored.PublicKey) != "cose key" {
+		t.Error("got", userID, stored, err, "expected the passkey with its new sign count")
+	}
+	passkeys, err := subject.GetPasskeys(ctx, id)
+	if err != nil || len(passkeys) != 1 || passkeys[0].Name != "Laptop" || passkeys[0].LastUsedOn.IsZero() {
+		t.Error("got", passkeys, err, "expected the used passkey")
+	}
+
+	if err := subject.DeletePasskey(ctx, id+1, credential.ID); err != nil {
+		t.Fatal("Failed to delete passkey -", err)
+	}
+	if _, _, err := subject.GetPasskey(ctx, credential.ID); err != nil {
+		t.Error("Expected only the owner to delete a passkey, got", err)
+	}
+	if err := subject.DeletePasskey(ctx, id, credential.ID); err != nil {
+		t.Fatal("Failed to delete passkey -", err)
+	}
+	if _, _, err := subject.GetPasskey(ctx, credential.ID); !errors.Is(err, ErrInvalidCredentials) {
+		t.Error("Expected a deleted passkey to be unknown, got", err)
+	}
+}
+
+func TestMailOutbox(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Second)
+	subject.now = func() time.Time { return now }
+	for _, to := range []string{"first@example.com", "second@example.com"} {
+		if err := subject.EnqueueMail(ctx, mailer.Message{To: to, Subject: "Hi", Text: "Hello"}); err != nil {
+			t.Fatal("Failed to queue mail -", err)
+		}
+	}
+
+	mail, err := subject.ClaimMail(ctx, 10, time.Minute)
+	if err != nil || len(mail) != 2 {
+		t.Fatal("Expected to claim 2 messages, got", len(mail), err)
+	}
+	if mail[0].Attempts != 1 || mail[0].Message.To != "first@example.com" {
+		t.Error("got", mail[0], "expected the first message on its first attempt")
+	}
+	if again, err := subject.ClaimMail(ctx, 10, time.Minute); err != nil || len(again) != 0 {
+		t.Error("Expected claimed messages to be leased, got", len(again), err)
+	}
+
+	if err := subject.MarkMailSent(ctx, mail[0].ID); err != nil {
+		t.Fatal("Failed to mark mail sent -", err)
+	}
+	if err := subject.MarkMailFailed(ctx, mail[1].ID, "relay refused", now.Add(time.Hour)); err != nil {
+		t.Fatal("Failed to mark mail failed -", err)
+	}
+	now = now.Add(time.Hour)
+	retried, err := subject.ClaimMail(ctx, 10, time.Minute)
+	if err != nil || len(retried) != 1 || retried[0].ID != mail[1].ID || retried[0].Attempts != 2 {
+		t.Fatal("Expected only the failed message to be retried, got", retried, err)
+	}
+	if err := subject.MarkMailFailed(ctx, retried[0].ID, "relay refused", time.Time{}); err != nil {
+		t.Fatal("Failed to mark mail failed -", err)
+	}
+	now = now.Add(24 * time.Hour)
+	if given, err := subject.ClaimMail(ctx, 10, time.Minute); err != nil || len(given) != 0 {
+		t.Error("Expected no retries after giving up, got", len(given), err)
+	}
+}
+
+func TestInstitutions(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "bank", "", "user", "bank_user@example.com", "bankPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	other, err := subject.AddUser(ctx, "other", "", "bank", "other_bank@example.com", "otherPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add other user -", err)
+	}
+	if _, err = probe.Exec("INSERT INTO institutions (name) VALUES ('Shared Bank')"); err != nil {
+		t.Fatal("Probe failed to add shared institution -", err)
+	}
+	private, err := subject.AddInstitution(ctx, id, "My Credit Union")
+	if err != nil {
+		t.Fatal("Failed to add institution -", err)
+	}
+	if again, err := subject.AddInstitution(ctx, id, "My Credit Union"); err != nil || again != private {
+		t.Error("got", again, err, "expected the institution already added")
+	}
+	if shared, err := subject.AddInstitution(ctx, id, "Shared Bank"); err != nil || shared.ID == private.ID {
+		t.Error("got", shared, err, "expected the shared institution")
+	}
+	if got, err := subject.GetInstitutions(ctx, id, 0, 10); err != nil || len(got) != 2 {
+		t.Error("got", got, err, "expected the shared institution and the one added")
+	}
+
+	// Institutions added by a user are theirs alone
+	got, err := subject.GetInstitutions(ctx, other, 0, 10)
+	if err != nil || len(got) != 1 || got[0].Name != "Shared Bank" {
+		t.Error("got", got, err, "expected only the shared institution")
+	}
+	var errs validation.Errors
+	if _, err = subject.AddAccount(
+		ctx, other, internal.FinancialAccount{InstitutionID: private.ID, AssetClass: "CASH", Name: "Checking"},
+	); !errors.As(err, &errs) || errs["institution_id"] == "" {
+		t.Error("got", err, "expected the institution of another user to be refused")
+	}
+	if theirs, err := subject.AddInstitution(ctx, other, "My Credit Union"); err != nil || theirs.ID == private.ID {
+		t.Error("got", theirs, err, "expected an institution of their own")
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "hook", "", "user", "hook_user@example.com", "hookPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	now := time.Now().Truncate(time.Second)
+	subject.now = func() time.Time { return now }
+
+	var errs validation.Errors
+	if _, err = subject.AddWebhook(
+		ctx, id, "ftp://example.com", []webhooks.Event{"budget.exceeded"},
+	); !errors.As(err, &errs) || errs["url"] == "" || errs["events"] == "" {
+		t.Error("got", err, "expected the URL and event to be refused")
+	}
+	secret, err := subject.AddWebhook(
+		ctx, id, "https://example.com/hook", []webhooks.Event{webhooks.TransactionCreated, webhooks.LargePurchase},
+	)
+	if err != nil || secret == "" {
+		t.Fatal("Failed to add webhook -", err)
+	}
+	hooks, err := subject.GetWebhooks(ctx, id)
+	if err != nil || len(hooks) != 1 || len(hooks[0].Events) != 2 {
+		t.Fatal("Expected the webhook with its two events, got", hooks, err)
+	}
+
+	institution, err := subject.AddInstitution(ctx, id, "Bank")
+	if err != nil {
+		t.Fatal("Failed to add institution -", err)
+	}
+	account, err := subject.AddAccount(
+		ctx, id, internal.FinancialAccount{InstitutionID: institution.ID, AssetClass: "CASH", Name: "Checking"},
+	)
+	if err != nil {
+		t.Fatal("Failed to add account -", err)
+	}
+	for _, amount := range []int64{-1000, -webhooks.LargePurchaseCents} {
+		if _, err = subject.AddTransaction(
+			ctx, id, internal.Transaction{FinancialAccountID: account.ID, Amount: amount, Type: "GROCERY"},
+		); err != nil {
+			t.Fatal("Failed to add transaction -", err)
+		}
+	}
+	if err = subject.SendTestWebhook(ctx, id, hooks[0].ID); err != nil {
+		t.Fatal("Failed to send test webhook -", err)
+	}
+	if err = subject.SendTestWebhook(ctx, id+1, hooks[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Error("got", err, "expected webhooks of other users to be out of reach")
+	}
+
+	due, err := subject.ClaimWebhookDeliveries(ctx, 10, time.Minute)
+	if err != nil || len(due) != 4 {
+		t.Fatal("Expected two created, one large purchase and one test delivery, got", due, err)
+	}
+	if due[0].URL != "https://example.com/hook" || due[0].Secret != secret || due[0].Attempts != 1 {
+		t.Error("got", due[0], "expected the webhook's URL and secret on the first attempt")
+	}
+	if again, err := subject.ClaimWebhookDeliveries(ctx, 10, time.Minute); err != nil || len(again) != 0 {
+		t.Error("Expected claimed deliveries to be leased, got", len(again), err)
+	}
+	if err = subject.MarkWebhookDelivered(ctx, due[0].ID, 204); err != nil {
+		t.Fatal("Failed to mark webhook delivered -", err)
+	}
+	if err = subject.MarkWebhookFailed(ctx, due[1].ID, 500, "receiver answered 500", time.Time{}); err != nil {
+		t.Fatal("Failed to mark webhook failed -", err)
+	}
+	if hooks, err = subject.GetWebhooks(ctx, id); err != nil || len(hooks[0].Deliveries) != 4 {
+		t.Fatal("Expected the log of four deliveries, got", hooks, err)
+	}
+	sent := hooks[0].Deliveries
+	if sent[3].Status != 204 || sent[3].DeliveredOn.IsZero() || sent[2].Status != 500 || sent[2].FailedOn.IsZero() {
+		t.Error("got", sent, "expected the first delivery to succeed and the second to fail")
+	}
+
+	// Deliveries of deleted users wait for them to be restored, and they get no new events meanwhile
+	if err = subject.SendTestWebhook(ctx, id, hooks[0].ID); err != nil {
+		t.Fatal("Failed to send test webhook -", err)
+	}
+	if err = subject.DeleteUser(ctx, id); err != nil {
+		t.Fatal("Failed to delete user -", err)
+	}
+	if _, err = subject.AddTransaction(
+		ctx, id, internal.Transaction{FinancialAccountID: account.ID, Amount: -1000, Type: "GROCERY"},
+	); err != nil {
+		t.Fatal("Failed to add transaction -", err)
+	}
+	if due, err = subject.ClaimWebhookDeliveries(ctx, 10, time.Minute); err != nil || len(due) != 0 {
+		t.Error("Expected nothing sent for a deleted user, got", due, err)
+	}
+	if _, err = probe.Exec("UPDATE users SET deleted_on = NULL WHERE id = ?", id); err != nil {
+		t.Fatal("Probe failed to restore user -", err)
+	}
+	if due, err = subject.ClaimWebhookDeliveries(ctx, 10, time.Minute); err != nil || len(due) != 1 {
+		t.Error("Expected only the delivery queued before the deletion, got", due, err)
+	}
+
+	if err = subject.DeleteWebhook(ctx, id, hooks[0].ID); err != nil {
+		t.Fatal("Failed to delete webhook -", err)
+	}
+	now = now.Add(time.Hour)
+	if due, err = subject.ClaimWebhookDeliveries(ctx, 10, time.Minute); err != nil || len(due) != 0 {
+		t.Error("Expected deleting the webhook to drop its deliveries, got", due, err)
+	}
+}
+
+func TestRecurring(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "recurring", "", "user", "recurring_user@example.com", "recurPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	now := time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC)
+	subject.now = func() time.Time { return now }
+	institution, err := subject.AddInstitution(ctx, id, "Bank")
+	if err != nil {
+		t.Fatal("Failed to add institution -", err)
+	}
+	account, err := subject.AddAccount(
+		ctx, id, internal.FinancialAccount{InstitutionID: institution.ID, AssetClass: "CASH", Name: "Checking"},
+	)
+	if err != nil {
+		t.Fatal("Failed to add account -", err)
+	}
+
+	var errs validation.Errors
+	if _, err = subject.AddRecurring(
+		ctx, id, internal.RecurringTransaction{
+			FinancialAccountID: account.ID, Name: "Rent", Amount: -150000, Type: "HOUSING", Rule: "FREQ=HOURLY",
+			StartsOn: "2026-01-01", EndsOn: "2025-01-01",
+		},
+	); !errors.As(err, &errs) || errs["rule"] == "" || errs["ends_on"] == "" {
+		t.Error("got", err, "expected the rule and end to be refused")
+	}
+	rent, err := subject.AddRecurring(
+		ctx, id, internal.RecurringTransaction{
+			FinancialAccountID: account.ID, Name: "Rent", Amount: -150000, Type: "HOUSING",
+			Rule: "freq=monthly;bymonthday=12", StartsOn: "2026-01-01",
+		},
+	)
+	if err != nil || rent.NextOn != "2026-03-12" || rent.Rule != "FREQ=MONTHLY;BYMONTHDAY=12" {
+		t.Fatal("Expected rent due on the 12th, got", rent, err)
+	}
+	power, err := subject.AddRecurring(
+		ctx, id, internal.RecurringTransaction{
+			FinancialAccountID: account.ID, Name: "Power", Amount: -8000, Type: "BILLS",
+			Rule: "FREQ=MONTHLY;BYMONTHDAY=8", StartsOn: "2026-01-08", EndsOn: "2026-04-30",
+		},
+	)
+	if err != nil || power.NextOn != "2026-03-08" {
+		t.Fatal("Expected power due two days ago, got", power, err)
+	}
+
+	upcoming, err := subject.GetUpcoming(ctx, id, now.AddDate(0, 2, 0))
+	if err != nil || len(upcoming) != 4 || upcoming[0].Date != "2026-03-08" || upcoming[3].Name != "Rent" {
+		t.Fatal("Expected power overdue, then rent and both bills once more, got", upcoming, err)
+	}
+
+	// Within the tolerance of the amount and the window around the due date, so taken for this month's rent.
+	paid, err := subject.AddTransaction(
+		ctx, id, internal.Transaction{
+			FinancialAccountID: account.ID, Amount: -151000, Type: "HOUSING", Date: "2026-03-14",
+		},
+	)
+	if err != nil || paid.RecurringID != rent.ID {
+		t.Fatal("Expected the payment to match rent, got", paid, err)
+	}
+	if other, err := subject.AddTransaction(
+		ctx, id, internal.Transaction{FinancialAccountID: account.ID, Amount: 150000, Type: "HOUSING"},
+	); err != nil || other.RecurringID != 0 || other.Date != "2026-03-10" {
+		t.Error("got", other, err, "expected a deposit today not to match")
+	}
+
+	if recorded, err := subject.MaterializeRecurring(ctx); err != nil || recorded != 0 {
+		t.Error("got", recorded, err, "expected power to still wait for a match")
+	}
+	now = time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC)
+	if err = subject.DeleteUser(ctx, id); err != nil {
+		t.Fatal("Failed to delete user -", err)
+	}
+	if recorded, err := subject.MaterializeRecurring(ctx); err != nil || recorded != 0 {
+		t.Error("got", recorded, err, "expected nothing recorded for a deleted user")
+	}
+	if _, err = probe.Exec("UPDATE users SET deleted_on = NULL WHERE id = ?", id); err != nil {
+		t.Fatal("Probe failed to restore user -", err)
+	}
+	if recorded, err := subject.MaterializeRecurring(ctx); err != nil || recorded != 4 {
+		t.Fatal("Expected power and rent twice each, got", recorded, err)
+	}
+	recurring, err := subject.GetRecurring(ctx, id)
+	if err != nil || len(recurring) != 2 || recurring[0].NextOn != "2026-06-12" || recurring[1].NextOn != "" {
+		t.Fatal("Expected rent due next on the 12th and power over, got", recurring, err)
+	}
+	if account, err = subject.GetAccount(ctx, id, account.ID); err != nil ||
+		account.NetValue != -151000+150000-2*8000-2*150000 {
+		t.Error("got", account, err, "expected every occurrence to move the account")
+	}
+	transactions, err := subject.GetTransactions(ctx, id, 0, 0, 10)
+	if err != nil || len(transactions) != 6 || transactions[2].Date != "2026-03-08" ||
+		transactions[2].RecurringID != power.ID {
+		t.Fatal("Expected the occurrences recorded on their due dates, got", transactions, err)
+	}
+
+	if err = subject.DeleteRecurring(ctx, id, rent.ID); err != nil {
+		t.Fatal("Failed to delete recurring transaction -", err)
+	}
+	if paid, err = subject.GetTransaction(ctx, id, paid.ID); err != nil || paid.RecurringID != 0 {
+		t.Error("got", paid, err, "expected the payment to be a one-off again")
+	}
+}
+
+func TestTransfers(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "transfer", "", "user", "transfer_user@example.com", "transferPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	subject.now = func() time.Time { return time.Date(2026, time.March, 10, 12, 0, 0, 0, time.UTC) }
+	institution, err := subject.AddInstitution(ctx, id, "Bank")
+	if err != nil {
+		t.Fatal("Failed to add institution -", err)
+	}
+	checking, err := subject.AddAccount(
+		ctx, id, internal.FinancialAccount{InstitutionID: institution.ID, AssetClass: "CASH", Name: "Checking"},
+	)
+	if err != nil {
+		t.Fatal("Failed to add account -", err)
+	}
+	card, err := subject.AddAccount(
+		ctx, id, internal.FinancialAccount{InstitutionID: institution.ID, AssetClass: "CREDIT_CARD", Name: "Card"},
+	)
+	if err != nil {
+		t.Fatal("Failed to add account -", err)
+	}
+
+	var errs validation.Errors
+	if _, _, err = subject.AddTransfer(ctx, id, checking.ID, checking.ID, 0, ""); !errors.As(err, &errs) ||
+		errs["amount"] == "" || errs["to_account_id"] == "" {
+		t.Error("got", err, "expected the amount and the same account to be refused")
+	}
+	if _, _, err = subject.AddTransfer(ctx, id, checking.ID, card.ID+100, 5000, ""); !errors.As(err, &errs) ||
+		errs["to_account_id"] == "" {
+		t.Error("got", err, "expected an account of nobody to be refused")
+	}
+	from, to, err := subject.AddTransfer(ctx, id, checking.ID, card.ID, 20000, "2026-03-09")
+	if err != nil || from.Amount != -20000 || to.Amount != 20000 || from.TransferID != to.ID ||
+		to.TransferID != from.ID || to.Date != "2026-03-09" {
+		t.Fatal("Expected a card payment linked both ways, got", from, to, err)
+	}
+	if checking, err = subject.GetAccount(ctx, id, checking.ID); err != nil || checking.NetValue != -20000 {
+		t.Error("got", checking, err, "expected the payment to leave checking")
+	}
+
+	bill, err := subject.AddTransaction(
+		ctx, id, internal.Transaction{FinancialAccountID: checking.ID, Amount: -3000, Type: "BILLS"},
+	)
+	if err != nil {
+		t.Fatal("Failed to add transaction -", err)
+	}
+	payment, err := subject.AddTransaction(
+		ctx, id, internal.Transaction{FinancialAccountID: card.ID, Amount: 3000, Type: "ETC"},
+	)
+	if err != nil {
+		t.Fatal("Failed to add transaction -", err)
+	}
+	if err = subject.LinkTransfer(ctx, id, bill.ID, to.ID); !errors.As(err, &errs) || errs["transaction_id"] == "" {
+		t.Error("got", err, "expected a transaction already part of a transfer to be refused")
+	}
+	if err = subject.LinkTransfer(ctx, id, bill.ID, payment.ID); err != nil {
+		t.Fatal("Failed to link transfer -", err)
+	}
+	if bill, err = subject.GetTransaction(ctx, id, bill.ID); err != nil || bill.TransferID != payment.ID {
+		t.Error("got", bill, err, "expected the bill linked to the payment")
+	}
+	if err = subject.UnlinkTransfer(ctx, id, payment.ID); err != nil {
+		t.Fatal("Failed to unlink transfer -", err)
+	}
+	if bill, err = subject.GetTransaction(ctx, id, bill.ID); err != nil || bill.TransferID != 0 {
+		t.Error("got", bill, err, "expected the bill to be spending again")
+	}
+
+	// Exported transfers stay linked, and the payment cleared two days later is detected unlike the late refund.
+	profile := validation.Profile{FirstName: "Mary", LastName: "Jones", DateOfBirth: "1990-05-17"}
+	if err = subject.ImportUserData(
+		ctx, id, profile, internal.UserData{
+			Institutions: []internal.Institution{{ID: 40, Name: "Bank"}},
+			Accounts: []internal.FinancialAccount{
+				{ID: 50, InstitutionID: 40, AssetClass: "CASH", Name: "Old checking"},
+				{ID: 51, InstitutionID: 40, AssetClass: "CREDIT_CARD", Name: "Old card"},
+			},
+			Transactions: []internal.Transaction{
+				{ID: 60, FinancialAccountID: 50, Amount: -12000, Type: "ETC", Date: "2026-03-01"},
+				{ID: 61, FinancialAccountID: 51, Amount: 12000, Type: "ETC", Date: "2026-03-03"},
+				{ID: 62, FinancialAccountID: 50, Amount: -500, Type: "GROCERY", Date: "2026-03-01"},
+				{ID: 63, FinancialAccountID: 51, Amount: 500, Type: "ETC", Date: "2026-03-09"},
+				{ID: 64, FinancialAccountID: 50, Amount: -700, Type: "ETC", Date: "2026-02-01", TransferID: 65},
+				{ID: 65, FinancialAccountID: 51, Amount: 700, Type: "ETC", Date: "2026-02-20", TransferID: 64},
+			},
+		},
+	); err != nil {
+		t.Fatal("Failed to import -", err)
+	}
+	transactions, err := subject.GetTransactions(ctx, id, 0, payment.ID, 10)
+	if err != nil || len(transactions) != 6 {
+		t.Fatal("Expected the imported transactions, got", transactions, err)
+	}
+	for i, expected := range []uint64{
+		transactions[1].ID, transactions[0].ID, 0, 0, transactions[5].ID, transactions[4].ID,
+	} {
+		if transactions[i].TransferID != expected {
+			t.Error("got", transactions[i], "expected it linked to", expected)
+		}
+	}
+}
+
+func TestSplits(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "split", "", "user", "split_user@example.com", "splitPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	institution, err := subject.AddInstitution(ctx, id, "Bank")
+	if err != nil {
+		t.Fatal("Failed to add institution -", err)
+	}
+	account, err := subject.AddAccount(
+		ctx, id, internal.FinancialAccount{InstitutionID: institution.ID, AssetClass: "CASH", Name: "Checking"},
+	)
+	if err != nil {
+		t.Fatal("Failed to add account -", err)
+	}
+
+	var errs validation.Errors
+	if _, err = subject.AddTransaction(
+		ctx, id, internal.Transaction{
+			FinancialAccountID: account.ID, Amount: -10000, Type: "GROCERY",
+			Splits: []internal.Split{{Type: "GROCERY", Amount: -7000}, {Type: "GOLD", Amount: 2000}},
+		},
+	); !errors.As(err, &errs) || errs["splits"] == "" || errs["splits.1.type"] == "" || errs["splits.1.amount"] == "" {
+		t.Error("got", err, "expected the splits to be refused")
+	}
+	receipt, err := subject.AddTransaction(
+		ctx, id, internal.Transaction{
+			FinancialAccountID: account.ID, Amount: -10000, Type: "GROCERY",
+			Splits: []internal.Split{{Type: "GROCERY", Amount: -7000}, {Type: "ETC", Amount: -3000}},
+		},
+	)
+	if err != nil {
+		t.Fatal("Failed to add transaction -", err)
+	}
+	if got, err := subject.GetTransaction(ctx, id, receipt.ID); err != nil || len(got.Splits) != 2 ||
+		got.Splits[1] != (internal.Split{Type: "ETC", Amount: -3000}) {
+		t.Error("got", got, err, "expected the receipt split in two")
+	}
+
+	if _, err = subject.SetSplits(ctx, id, receipt.ID+100, nil); !errors.Is(err, ErrNotFound) {
+		t.Error("Expected ErrNotFound, got", err)
+	}
+	if _, err = subject.SetSplits(
+		ctx, id, receipt.ID, []internal.Split{{Type: "ETC", Amount: -10000}},
+	); !errors.As(err, &errs) || errs["splits"] == "" {
+		t.Error("got", err, "expected a single split to be refused")
+	}
+	split, err := subject.SetSplits(
+		ctx, id, receipt.ID, []internal.Split{
+			{Type: "GROCERY", Amount: -5000}, {Type: "ETC", Amount: -4000}, {Type: "RESTAURANTS", Amount: -1000},
+		},
+	)
+	if err != nil || len(split.Splits) != 3 {
+		t.Fatal("Expected the receipt split in three, got", split, err)
+	}
+	transactions, err := subject.GetTransactions(ctx, id, account.ID, 0, 10)
+	if err != nil || len(transactions) != 1 || len(transactions[0].Splits) != 3 ||
+		transactions[0].Splits[0].Amount != -5000 {
+		t.Error("got", transactions, err, "expected the new splits in place of the old ones")
+	}
+	if _, err = subject.SetSplits(ctx, id, receipt.ID, nil); err != nil {
+		t.Fatal("Failed to remove splits -", err)
+	}
+	if data, err := subject.GetUserData(ctx, id); err != nil || len(data.Transactions) != 1 ||
+		data.Transactions[0].Splits != nil {
+		t.Error("got", data, err, "expected the receipt whole again")
+	}
+}
+
+func TestGetSummaryReport(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "summed", "", "user", "summed_user@example.com", "summedPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	institution, err := subject.AddInstitution(ctx, id, "Bank")
+	if err != nil {
+		t.Fatal("Failed to add institution -", err)
+	}
+	checking, err := subject.AddAccount(
+		ctx, id, internal.FinancialAccount{
+			InstitutionID: institution.ID, AssetClass: "CASH", Name: "Checking", NetValue: 100000,
+		},
+	)
+	if err != nil {
+		t.Fatal("Failed to add account -", err)
+	}
+	card, err := subject.AddAccount(
+		ctx, id, internal.FinancialAccount{
+			InstitutionID: institution.ID, AssetClass: "CREDIT_CARD", Name: "Card", NetValue: -30000,
+		},
+	)
+	if err != nil {
+		t.Fatal("Failed to add account -", err)
+	}
+	for _, transaction := range []internal.Transaction{
+		{FinancialAccountID: card.ID, Amount: -2000, Type: "RESTAURANTS"},
+		{FinancialAccountID: checking.ID, Amount: 50000, Type: "ETC"},
+		{
+			FinancialAccountID: card.ID, Amount: -10000, Type: "GROCERY",
+			Splits: []internal.Split{{Type: "GROCERY", Amount: -7000}, {Type: "RESTAURANTS", Amount: -3000}},
+		},
+	} {
+		if _, err = subject.AddTransaction(ctx, id, transaction); err != nil {
+			t.Fatal("Failed to add transaction -", err)
+		}
+	}
+	if _, _, err = subject.AddTransfer(ctx, id, checking.ID, card.ID, 20000, ""); err != nil {
+		t.Fatal("Failed to add transfer -", err)
+	}
+
+	report, err := subject.GetSummaryReport(ctx, id)
+	if err != nil {
+		t.Fatal("Failed to get report -", err)
+	}
+	if report.NetWorth != 108000 || report.ByAssetClass["CASH"] != 130000 ||
+		report.ByAssetClass["CREDIT_CARD"] != -22000 {
+		t.Error("got", report, "expected the net values after the transactions")
+	}
+	if len(report.SpendingByType) != 2 || report.SpendingByType["RESTAURANTS"] != 5000 ||
+		report.SpendingByType["GROCERY"] != 7000 {
+		t.Error("got", report.SpendingByType, "expected the splits spent on their own types and the transfer left out")
+	}
+}
+
+func TestNotificationPreferences(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+
+	ctx := context.Background()
+	id, err := subject.AddUser(ctx, "notified", "", "user", "notified_user@example.com", "notifiedPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	preferences, err := subject.GetNotificationPreferences(ctx, id)
+	if err != nil {
+		t.Fatal("Failed to get preferences -", err)
+	}
+	for _, kind := range NotificationKinds {
+		if !preferences[kind] {
+			t.Error("Expected", kind, "to be on by default")
+		}
+	}
+
+	if err := subject.SetNotificationPreference(ctx, id, BudgetAlerts, false); err != nil {
+		t.Fatal("Failed to set preference -", err)
+	}
+	if preferences, err = subject.GetNotificationPreferences(ctx, id); err != nil {
+		t.Fatal("Failed to get preferences -", err)
+	}
+	if preferences[BudgetAlerts] || !preferences[SubscriptionWarnings] {
+		t.Error("got", preferences, "expected only budget alerts off")
+	}
+	if err := subject.SetNotificationPreference(ctx, id, "SPAM", true); !errors.Is(err, ErrUnknownNotification) {
+		t.Error("Expected an unknown kind to be refused, got", err)
+	}
+}
+
+func TestUserData(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+	ctx := context.Background()
+
+	id, err := subject.AddUser(ctx, "imported", "", "user", "imported_user@example.com", "importedPass", "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	profile := validation.Profile{FirstName: "Mary", LastName: "Jones", DateOfBirth: "1990-05-17"}
+	data := internal.UserData{
+		Institutions: []internal.Institution{{ID: 40, Name: "First Bank"}},
+		Accounts: []internal.FinancialAccount{
+			{ID: 50, InstitutionID: 40, AssetClass: "CASH", Name: "Checking", NetValue: 120050},
+		},
+		Transactions: []internal.Transaction{{ID: 60, FinancialAccountID: 50, Amount: -4599, Type: "GROCERY"}},
+	}
+	if err = subject.ImportUserData(ctx, id, profile, data); err != nil {
+		t.Fatal("Failed to import -", err)
+	}
+	if user := subject.GetUser(ctx, id); user.FirstName != "Mary" || user.DateOfBirth != "1990-05-17" {
+		t.Error("got", user, "expected the imported profile")
+	}
+	got, err := subject.GetUserData(ctx, id)
+	if err != nil {
+		t.Fatal("Failed to get data -", err)
+	}
+	if len(got.Institutions) != 1 || len(got.Accounts) != 1 || len(got.Transactions) != 1 ||
+		got.Accounts[0].InstitutionID != got.Institutions[0].ID ||
+		got.Transactions[0].FinancialAccountID != got.Accounts[0].ID || got.Transactions[0].Amount != -4599 {
+		t.Error("got", got, "expected the imported records linked together")
+	}
+
+	// Importing again reuses the institution, and nothing is imported when a record is dangling
+	if err = subject.ImportUserData(ctx, id, profile, data); err != nil {
+		t.Fatal("Failed to import again -", err)
+	}
+	data.Transactions[0].FinancialAccountID = 51
+	if err = subject.ImportUserData(ctx, id, profile, data); !errors.Is(err, ErrDanglingReference) {
+		t.Error("Expected ErrDanglingReference, got", err)
+	}
+	if got, err = subject.GetUserData(ctx, id); err != nil || len(got.Institutions) != 1 || len(got.Accounts) != 2 ||
+		len(got.Transactions) != 2 {
+		t.Error("got", got, err, "expected two imports sharing one institution")
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+	ctx := context.Background()
+
+	insert := func(tx *sql.Tx, name string) error {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO institutions (name) VALUES (?)", name); err != nil {
+			return internalError(err)
+		}
+		return nil
+	}
+	if err := subject.WithTx(ctx, func(tx *sql.Tx) error { return insert(tx, "committed") }); err != nil {
+		t.Fatal("Failed to commit -", err)
+	}
+	err := subject.WithTx(
+		ctx, func(tx *sql.Tx) error {
+			if err := insert(tx, "rolled back"); err != nil {
+				return err
+			}
+			return ErrInvalidToken
+		},
+	)
+	if !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expected the error of fn, got", err)
+	}
+	var names []string
+	rows, err := probe.Query("SELECT name FROM institutions")
+	if err != nil {
+		t.Fatal("Probe failed to select institutions -", err)
+	}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			t.Fatal("Probe failed to scan institution -", err)
+		}
+		names = append(names, name)
+	}
+	if len(names) != 1 || names[0] != "committed" {
+		t.Error("got", names, "expected only the committed transaction")
+	}
+
+	// Contention reruns the whole transaction, other failures are given up on
+	for _, tc := range []struct {
+		name     string
+		failures int
+		number   uint16
+		calls    int
+		ok       bool
+	}{
+		{"RetryDeadlock", 2, errDeadlock, 3, true},
+		{"RetryLockWaitTimeout", 1, errLockWaitTimeout, 2, true},
+		{"GiveUpOnDeadlocks", maxTxAttempts, errDeadlock, maxTxAttempts, false},
+		{"NoRetryOtherErrors", 1, errDuplicateEntry, 1, false},
+	} {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				calls := 0
+				err := subject.WithTx(
+					ctx, func(tx *sql.Tx) error {
+						if calls++; calls <= tc.failures {
+							return internalError(&mysqlDriver.MySQLError{Number: tc.number})
+						}
+						return nil
+					},
+				)
+				if calls != tc.calls || (err == nil) != tc.ok || err != nil && !errors.Is(err, ErrInternal) {
+					t.Error("got", calls, "calls", err, "expected", tc.calls, "calls ok", tc.ok)
+				}
+			},
+		)
+	}
+}
+
+func TestDeleteUser(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+	ctx := context.Background()
+
+	email, password := "delete_user@example.com", "delete_pass"
+	id, err := subject.AddUser(ctx, "delete", "", "user", email, password, "2000-01-01")
+	// Verify the user was added
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	if _, err = subject.CreateSession(ctx, id, time.Hour); err != nil {
+		t.Fatal("Failed to create session -", err)
+	}
+	credential := webauthn.Credential{ID: []byte("delete-credential"), PublicKey: []byte("key")}
+	if err = subject.AddPasskey(ctx, id, credential, "Phone"); err != nil {
+		t.Fatal("Failed to add passkey -", err)
+	}
+	twoFactor, err := subject.CreateUserToken(ctx, id, TwoFactorLoginToken, time.Hour)
+	if err != nil {
+		t.Fatal("Failed to create token -", err)
+	}
+	now := time.Now()
+	subject.now = func() time.Time { return now }
+
+	// Delete the user
+	err = subject.DeleteUser(ctx, id)
+	if err != nil {
+		t.Fatal("Failed to delete user -", err)
+	}
+
+	// Verify the user can't be used anymore, but can still be restored
+	if subject.GetUser(ctx, id) != nil || subject.GetUserID(ctx, email) != 0 {
+		t.Error("Deleted user can still be looked up")
+	}
+	if _, err := subject.AuthenticateLogin(ctx, email, password); !errors.Is(err, ErrAccountDeleted) {
+		t.Error("Expected deleted user to be refused login, got", err)
+	}
+	if _, err := subject.AuthenticateLogin(ctx, email, "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Error("Expected a wrong password to be refused as usual, got", err)
+	}
+	if err := subject.UsePasskey(ctx, credential.ID, 1); !errors.Is(err, ErrAccountDeleted) {
+		t.Error("Expected deleted user to be refused passkey login, got", err)
+	}
+	if _, err := subject.CheckUserToken(ctx, TwoFactorLoginToken, twoFactor); !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expected the pending two-factor login of the deleted user to be revoked, got", err)
+	}
+	var sessions int
+	if err := probe.QueryRow("SELECT COUNT(*) FROM sessions WHERE user_id = ?", id).Scan(&sessions); err != nil {
+		t.Fatal("Probe failed to count sessions -", err)
+	} else if sessions != 0 {
+		t.Error("Deleted user still has", sessions, "sessions")
+	}
+	if purged, err := subject.PurgeDeletedUsers(ctx); err != nil || purged != 0 {
+		t.Error("Expected nothing to purge within the grace period, purged", purged, err)
+	}
+
+	// Verify the user is erased once the grace period is over
+	now = now.Add(DeletionGracePeriod)
+	if purged, err := subject.PurgeDeletedUsers(ctx); err != nil || purged != 1 {
+		t.Fatal("Expected the deleted user to be purged, purged", purged, err)
+	}
+	err = probe.QueryRow("SELECT id FROM users WHERE id = ?", id).Scan(&id)
+	if err == nil {
+		t.Error("Deleted user still exists -", err)
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		log.Println("Probe failed to verify user -", err)
+	}
+
+	// Verify the id isn't handed out again
+	newID, err := subject.AddUser(ctx, "delete", "", "user", email, password, "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user again -", err)
+	} else if newID == id {
+		t.Error("Purged user id", id, "was reused")
+	}
+}
+
+func TestRestoreUser(t *testing.T) {
+	subject, probe := setup(t)
+	defer teardown(t, subject, probe)
+	ctx := context.Background()
+
+	email, password := "restore_user@example.com", "restore_pass"
+	id, err := subject.AddUser(ctx, "restore", "", "user", email, password, "2000-01-01")
+	if err != nil {
+		t.Fatal("Failed to add user -", err)
+	}
+	now := time.Now()
+	subject.now = func() time.Time { return now }
+	if err = subject.DeleteUser(ctx, id); err != nil {
+		t.Fatal("Failed to delete user -", err)
+	}
+	token, err := subject.CreateUserToken(ctx, id, RestoreAccountToken, DeletionGracePeriod)
+	if err != nil {
+		t.Fatal("Failed to create restore token -", err)
+	}
+
+	if _, err := subject.RestoreUser(ctx, "wrong"); !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expected an unknown token to be refused, got", err)
+	}
+	if restored, err := subject.RestoreUser(ctx, token); err != nil || restored != id {
+		t.Fatal("Expected user", id, "to be restored, got", restored, err)
+	}
+	if subject.GetUser(ctx, id) == nil {
+		t.Error("Restored user can't be looked up")
+	}
+	if got, err := subject.AuthenticateLogin(ctx, email, password); err != nil || got != id {
+		t.Error("Expected restored user to log in, got", got, err)
+	}
+	if _, err := subject.RestoreUser(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expected a used token to be refused, got", err)
+	}
+
+	// A token outliving the grace period, which purges the user, can't bring them back
+	if err = subject.DeleteUser(ctx, id); err != nil {
+		t.Fatal("Failed to delete user again -", err)
+	}
+	if token, err = subject.CreateUserToken(ctx, id, RestoreAccountToken, 2*DeletionGracePeriod); err != nil {
+		t.Fatal("Failed to create restore token -", err)
+	}
+	now = now.Add(DeletionGracePeriod)
+	if _, err := subject.RestoreUser(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Error("Expected a restore after the grace period to be refused, got", err)
 	}
 }
 
@@ -286,7 +1761,7 @@ func TestGetUser(t *testing.T) {
 	defer teardown(t, subject, probe)
 
 	// Add a test user to the database:
-	if _, err := subject.AddUser("test", "", "user", "test_user@example.com",
+	if _, err := subject.AddUser(context.Background(), "test", "", "user", "test_user@example.com",
 		"test_pass", "2000-01-02"); err != nil {
 		t.Fatal("Failed to add user -", err)
 	}
@@ -311,7 +1786,7 @@ func TestGetUser(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(
 			tc.name, func(t *testing.T) {
-				user := subject.GetUser(tc.userID)
+				user := subject.GetUser(context.Background(), tc.userID)
 				if tc.expectUser {
 					if user == nil {
 						t.Fatal("Expected to find user, but got nil")
@@ -355,15 +1830,15 @@ func TestGetUserID(t *testing.T) {
 	defer teardown(t, subject, probe)
 
 	t.Log("Adding user: user_id_user")
-	_, err := subject.AddUser("user", "id", "user", "user_id_user@example.com",
+	_, err := subject.AddUser(context.Background(), "user", "id", "user", "user_id_user@example.com",
 		"user_id_pass", "2000-01-04")
 	if err != nil {
 		t.Fatal("Failed to add user -", err)
 	}
 
 	t.Log("Adding user: user2_id_user2")
-	_, err = subject.AddUser("user2", "id", "user2", "user2_id_user2@example.com",
-		"user2_id2_pass", "2022-01-04")
+	_, err = subject.AddUser(context.Background(), "usertwo", "id", "usertwo", "user2_id_user2@example.com",
+		"user2_id2_pass", "2002-01-04")
 	if err != nil {
 		t.Fatal("Failed to add user -", err)
 	}
@@ -381,7 +1856,7 @@ func TestGetUserID(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(
 			tc.name, func(t *testing.T) {
-				id := subject.GetUserID(tc.email)
+				id := subject.GetUserID(context.Background(), tc.email)
 				if id != tc.expected {
 					t.Errorf("got id %d, expected %d", id, tc.expected)
 				}
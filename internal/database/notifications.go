@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+
+	"github.com/matcha-devs/matcha/internal/tracing"
+)
+
+// NotificationKind is a kind of optional email, security emails like password resets are always sent.
+type NotificationKind string
+
+const (
+	BudgetAlerts         NotificationKind = "BUDGET_ALERTS"
+	SubscriptionWarnings NotificationKind = "SUBSCRIPTION_WARNINGS"
+)
+
+// NotificationKinds lists every kind in the order they are shown to users.
+var NotificationKinds = []NotificationKind{BudgetAlerts, SubscriptionWarnings}
+
+var ErrUnknownNotification = errors.New("unknown notification kind")
+
+// GetNotificationPreferences reports which kinds of email the user wants, every kind is on until turned off.
+func (db *MySQLDatabase) GetNotificationPreferences(
+	ctx context.Context, userID uint64,
+) (preferences map[NotificationKind]bool, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetNotificationPreferences")
+	defer func() { span.RecordError(err); span.End() }()
+	preferences = make(map[NotificationKind]bool, len(NotificationKinds))
+	for _, kind := range NotificationKinds {
+		preferences[kind] = true
+	}
+	rows, err := db.underlyingDB.QueryContext(
+		ctx, "SELECT kind, enabled FROM notification_preferences WHERE user_id = ?", userID,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error selecting notification preferences", "user_id", userID, "err", err)
+		return nil, ErrInternal
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var (
+			kind    NotificationKind
+			enabled bool
+		)
+		if err = rows.Scan(&kind, &enabled); err != nil {
+			slog.ErrorContext(ctx, "Error scanning notification preference", "user_id", userID, "err", err)
+			return nil, ErrInternal
+		}
+		preferences[kind] = enabled
+	}
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "Error selecting notification preferences", "user_id", userID, "err", err)
+		return nil, ErrInternal
+	}
+	return
+}
+
+func (db *MySQLDatabase) SetNotificationPreference(
+	ctx context.Context, userID uint64, kind NotificationKind, enabled bool,
+) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.SetNotificationPreference")
+	defer func() { span.RecordError(err); span.End() }()
+	if !slices.Contains(NotificationKinds, kind) {
+		return ErrUnknownNotification
+	}
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, `INSERT INTO notification_preferences (user_id, kind, enabled) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE enabled = VALUES(enabled)`,
+		userID, kind, enabled,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error setting notification preference", "user_id", userID, "kind", kind, "err", err)
+		return ErrInternal
+	}
+	return
+}
@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal/mailer"
+	"github.com/matcha-devs/matcha/internal/tracing"
+)
+
+// EnqueueMail stores a message in the outbox, it is sent in the background and retried until it goes through.
+func (db *MySQLDatabase) EnqueueMail(ctx context.Context, message mailer.Message) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.EnqueueMail")
+	defer func() { span.RecordError(err); span.End() }()
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, `INSERT INTO mail_outbox (recipient, subject, text_body, html_body, next_attempt_on)
+		VALUES (?, ?, ?, ?, ?)`,
+		message.To, message.Subject, message.Text, message.HTML, db.now(),
+	); err != nil {
+		slog.ErrorContext(ctx, "Error queueing mail", "to", message.To, "err", err)
+		return ErrInternal
+	}
+	return
+}
+
+// ClaimMail implements mailer.Store, rows locked by another instance's claim are skipped rather than waited on.
+func (db *MySQLDatabase) ClaimMail(
+	ctx context.Context, limit int, lease time.Duration,
+) (mail []mailer.Queued, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.ClaimMail")
+	defer func() { span.RecordError(err); span.End() }()
+	now := db.now()
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			mail = nil
+			rows, err := tx.QueryContext(
+				ctx, `SELECT id, recipient, subject, text_body, html_body, attempts FROM mail_outbox
+				WHERE sent_on IS NULL AND failed_on IS NULL AND next_attempt_on <= ?
+				ORDER BY next_attempt_on LIMIT ? FOR UPDATE SKIP LOCKED`,
+				now, limit,
+			)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error selecting due mail", "err", err)
+				return internalError(err)
+			}
+			for rows.Next() {
+				var queued mailer.Queued
+				message := &queued.Message
+				if err = rows.Scan(
+					&queued.ID, &message.To, &message.Subject, &message.Text, &message.HTML, &queued.Attempts,
+				); err != nil {
+					_ = rows.Close()
+					slog.ErrorContext(ctx, "Error scanning due mail", "err", err)
+					return internalError(err)
+				}
+				queued.Attempts++
+				mail = append(mail, queued)
+			}
+			if err = rows.Err(); err != nil {
+				slog.ErrorContext(ctx, "Error selecting due mail", "err", err)
+				return internalError(err)
+			}
+			for _, queued := range mail {
+				if _, err = tx.ExecContext(
+					ctx, "UPDATE mail_outbox SET attempts = ?, next_attempt_on = ? WHERE id = ?",
+					queued.Attempts, now.Add(lease), queued.ID,
+				); err != nil {
+					slog.ErrorContext(ctx, "Error leasing mail", "mail_id", queued.ID, "err", err)
+					return internalError(err)
+				}
+			}
+			return
+		},
+	); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// MarkMailSent implements mailer.Store, the body is dropped since it may hold links with tokens in them.
+func (db *MySQLDatabase) MarkMailSent(ctx context.Context, id uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.MarkMailSent")
+	defer func() { span.RecordError(err); span.End() }()
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, "UPDATE mail_outbox SET sent_on = ?, last_error = NULL, text_body = '', html_body = '' WHERE id = ?",
+		db.now(), id,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error marking mail sent", "mail_id", id, "err", err)
+		return ErrInternal
+	}
+	return
+}
+
+// MarkMailFailed implements mailer.Store.
+func (db *MySQLDatabase) MarkMailFailed(ctx context.Context, id uint64, reason string, retryAt time.Time) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.MarkMailFailed")
+	defer func() { span.RecordError(err); span.End() }()
+	if len(reason) > 1024 {
+		reason = reason[:1024]
+	}
+	query, args := "UPDATE mail_outbox SET last_error = ?, next_attempt_on = ? WHERE id = ?", []any{reason, retryAt, id}
+	if retryAt.IsZero() {
+		query = "UPDATE mail_outbox SET last_error = ?, failed_on = ?, text_body = '', html_body = '' WHERE id = ?"
+		args = []any{reason, db.now(), id}
+	}
+	if _, err = db.underlyingDB.ExecContext(ctx, query, args...); err != nil {
+		slog.ErrorContext(ctx, "Error marking mail failed", "mail_id", id, "err", err)
+		return ErrInternal
+	}
+	return
+}
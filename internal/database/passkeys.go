@@ -0,0 +1,211 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"github.com/matcha-devs/matcha/internal/tracing"
+	"github.com/matcha-devs/matcha/internal/webauthn"
+)
+
+// Ceremony is the WebAuthn ceremony a challenge was issued for.
+type Ceremony string
+
+const (
+	RegisterCeremony Ceremony = "REGISTER"
+	LoginCeremony    Ceremony = "LOGIN"
+)
+
+var ErrPasskeyTaken = errors.New("passkey already registered")
+
+// Passkey is a WebAuthn credential registered by a user.
+type Passkey struct {
+	webauthn.Credential
+	Name       string
+	CreatedOn  time.Time
+	LastUsedOn time.Time
+}
+
+// CreateWebAuthnChallenge issues a single-use challenge for a ceremony, login challenges have no user (0) yet.
+func (db *MySQLDatabase) CreateWebAuthnChallenge(
+	ctx context.Context, userID uint64, ceremony Ceremony, ttl time.Duration,
+) (challenge string, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.CreateWebAuthnChallenge")
+	defer func() { span.RecordError(err); span.End() }()
+	challenge, hash, err := newToken()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error generating challenge", "err", err)
+		return "", ErrInternal
+	}
+	now := db.now()
+	// Abandoned ceremonies leave their challenges behind, clear them out as new ones come in.
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, "DELETE FROM webauthn_challenges WHERE expires_on <= ?", now,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error deleting expired challenges", "err", err)
+		return "", ErrInternal
+	}
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, "INSERT INTO webauthn_challenges (challenge_hash, user_id, ceremony, expires_on) VALUES (?, ?, ?, ?)",
+		hash, sql.NullInt64{Int64: int64(userID), Valid: userID != 0}, ceremony, now.Add(ttl),
+	); err != nil {
+		slog.ErrorContext(ctx, "Error adding challenge", "ceremony", ceremony, "err", err)
+		return "", ErrInternal
+	}
+	return
+}
+
+// ConsumeWebAuthnChallenge redeems a challenge issued for ceremony, returning the user it was issued to, if any.
+func (db *MySQLDatabase) ConsumeWebAuthnChallenge(
+	ctx context.Context, ceremony Ceremony, challenge string,
+) (userID uint64, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.ConsumeWebAuthnChallenge")
+	defer func() { span.RecordError(err); span.End() }()
+	hash := hashToken(challenge)
+	var user sql.NullInt64
+	err = db.underlyingDB.QueryRowContext(
+		ctx, "SELECT user_id FROM webauthn_challenges WHERE challenge_hash = ? AND ceremony = ? AND expires_on > ?",
+		hash, ceremony, db.now(),
+	).Scan(&user)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInvalidToken
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error selecting challenge", "ceremony", ceremony, "err", err)
+		return 0, ErrInternal
+	}
+	// Only one of two concurrent ceremonies can delete the challenge.
+	result, err := db.underlyingDB.ExecContext(ctx, "DELETE FROM webauthn_challenges WHERE challenge_hash = ?", hash)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error consuming challenge", "ceremony", ceremony, "err", err)
+		return 0, ErrInternal
+	}
+	if consumed, err := result.RowsAffected(); err != nil {
+		slog.ErrorContext(ctx, "Error consuming challenge", "ceremony", ceremony, "err", err)
+		return 0, ErrInternal
+	} else if consumed != 1 {
+		return 0, ErrInvalidToken
+	}
+	return uint64(user.Int64), nil
+}
+
+func (db *MySQLDatabase) AddPasskey(
+	ctx context.Context, userID uint64, credential webauthn.Credential, name string,
+) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.AddPasskey")
+	defer func() { span.RecordError(err); span.End() }()
+	_, err = db.underlyingDB.ExecContext(
+		ctx, "INSERT INTO webauthn_credentials (id, user_id, public_key, sign_count, name) VALUES (?, ?, ?, ?, ?)",
+		credential.ID, userID, credential.PublicKey, credential.SignCount, name,
+	)
+	var driverErr *mysqlDriver.MySQLError
+	if errors.As(err, &driverErr) && driverErr.Number == errDuplicateEntry {
+		slog.WarnContext(ctx, "Passkey registered twice", "user_id", userID)
+		return ErrPasskeyTaken
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error adding passkey", "user_id", userID, "err", err)
+		return ErrInternal
+	}
+	slog.InfoContext(ctx, "Passkey added", "user_id", userID)
+	return
+}
+
+func (db *MySQLDatabase) GetPasskeys(ctx context.Context, userID uint64) (passkeys []Passkey, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetPasskeys")
+	defer func() { span.RecordError(err); span.End() }()
+	rows, err := db.underlyingDB.QueryContext(
+		ctx, `SELECT id, public_key, sign_count, name, created_on, last_used_on FROM webauthn_credentials
+		WHERE user_id = ? ORDER BY created_on`, userID,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error selecting passkeys", "user_id", userID, "err", err)
+		return nil, ErrInternal
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var (
+			passkey  Passkey
+			lastUsed sql.NullTime
+		)
+		if err = rows.Scan(
+			&passkey.ID, &passkey.PublicKey, &passkey.SignCount, &passkey.Name, &passkey.CreatedOn, &lastUsed,
+		); err != nil {
+			slog.ErrorContext(ctx, "Error scanning passkey", "user_id", userID, "err", err)
+			return nil, ErrInternal
+		}
+		passkey.LastUsedOn = lastUsed.Time
+		passkeys = append(passkeys, passkey)
+	}
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "Error selecting passkeys", "user_id", userID, "err", err)
+		return nil, ErrInternal
+	}
+	return
+}
+
+// GetPasskey finds the passkey a login was made with, failing with ErrInvalidCredentials for unknown ones.
+func (db *MySQLDatabase) GetPasskey(
+	ctx context.Context, credentialID []byte,
+) (userID uint64, credential webauthn.Credential, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetPasskey")
+	defer func() { span.RecordError(err); span.End() }()
+	err = db.underlyingDB.QueryRowContext(
+		ctx, "SELECT user_id, id, public_key, sign_count FROM webauthn_credentials WHERE id = ?", credentialID,
+	).Scan(&userID, &credential.ID, &credential.PublicKey, &credential.SignCount)
+	if errors.Is(err, sql.ErrNoRows) {
+		slog.InfoContext(ctx, "Login with unknown passkey")
+		return 0, credential, ErrInvalidCredentials
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error selecting passkey", "err", err)
+		return 0, credential, ErrInternal
+	}
+	return
+}
+
+// UsePasskey records a login with a passkey and its new signature counter, refusing it with ErrAccountDeleted while
+// the account awaits deletion.
+func (db *MySQLDatabase) UsePasskey(ctx context.Context, credentialID []byte, signCount uint32) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.UsePasskey")
+	defer func() { span.RecordError(err); span.End() }()
+	var deleted bool
+	err = db.underlyingDB.QueryRowContext(
+		ctx, `SELECT u.deleted_on IS NOT NULL FROM webauthn_credentials c JOIN users u ON u.id = c.user_id
+		WHERE c.id = ?`, credentialID,
+	).Scan(&deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrInvalidCredentials
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error selecting passkey owner", "err", err)
+		return ErrInternal
+	}
+	// Passkeys are kept so restoring the account brings them back. Like at a password login, only the owner who just
+	// proved the passkey is theirs learns the account awaits deletion.
+	if deleted {
+		slog.InfoContext(ctx, "Passkey login to deleted account")
+		return ErrAccountDeleted
+	}
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, "UPDATE webauthn_credentials SET sign_count = ?, last_used_on = ? WHERE id = ?",
+		signCount, db.now(), credentialID,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error updating passkey", "err", err)
+		return ErrInternal
+	}
+	return
+}
+
+func (db *MySQLDatabase) DeletePasskey(ctx context.Context, userID uint64, credentialID []byte) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.DeletePasskey")
+	defer func() { span.RecordError(err); span.End() }()
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, "DELETE FROM webauthn_credentials WHERE user_id = ? AND id = ?", userID, credentialID,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error deleting passkey", "user_id", userID, "err", err)
+		return ErrInternal
+	}
+	slog.InfoContext(ctx, "Passkey deleted", "user_id", userID)
+	return
+}
@@ -2,20 +2,21 @@
 
 CREATE TABLE IF NOT EXISTS users
 (
-    id            BIGINT(20) UNSIGNED     NOT NULL AUTO_INCREMENT PRIMARY KEY,
-    first_name    VARCHAR(255)            NOT NULL,
-    middle_name   VARCHAR(255),
-    last_name     VARCHAR(255)            NOT NULL,
-    email         VARCHAR(255)            NOT NULL UNIQUE,
-    password      VARCHAR(255)            NOT NULL,
-    date_of_birth VARCHAR(255)            NOT NULL,
-    created_on    timestamp DEFAULT NOW() NOT NULL
-);
-
-CREATE TABLE IF NOT EXISTS openid
-(
-    id         BIGINT(20) UNSIGNED NOT NULL PRIMARY KEY,
-    created_on timestamp DEFAULT NOW()
+    id                BIGINT(20) UNSIGNED     NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    first_name        VARCHAR(255)            NOT NULL,
+    middle_name       VARCHAR(255),
+    last_name         VARCHAR(255)            NOT NULL,
+    email             VARCHAR(255)            NOT NULL UNIQUE,
+    password          VARCHAR(255)            NOT NULL,
+    date_of_birth     DATE                    NOT NULL,
+    created_on        timestamp DEFAULT NOW() NOT NULL,
+    failed_logins     INT UNSIGNED DEFAULT 0  NOT NULL,
+    locked_until      timestamp               NULL,
+    email_verified_on timestamp               NULL,
+    totp_secret       VARBINARY(255)          NULL,
+    totp_last_step    BIGINT DEFAULT 0        NOT NULL,
+    deleted_on        timestamp               NULL,
+    INDEX (deleted_on)
 );
 
 CREATE TABLE IF NOT EXISTS asset_class_aggregations
@@ -38,7 +39,41 @@ CREATE TABLE IF NOT EXISTS transactions
     financial_account_id INT UNSIGNED                                                         NOT NULL,
     amount               BIGINT(20)                                                           NOT NULL,
     type                 ENUM ('RESTAURANTS', 'BILLS', 'HOUSING', 'GROCERY', 'TRAVEL', 'ETC') NOT NULL,
-    PRIMARY KEY (id)
+    occurred_on          DATE                                                                 NOT NULL,
+    recurring_id         BIGINT(20) UNSIGNED                                                  NULL,
+    transfer_id          BIGINT(20) UNSIGNED                                                  NULL,
+    PRIMARY KEY (id),
+    INDEX (recurring_id),
+    INDEX (user_id, amount, occurred_on)
+);
+
+CREATE TABLE IF NOT EXISTS transaction_splits
+(
+    id             BIGINT(20) UNSIGNED                                                  NOT NULL AUTO_INCREMENT,
+    transaction_id BIGINT(20) UNSIGNED                                                  NOT NULL,
+    user_id        BIGINT(20) UNSIGNED                                                  NOT NULL,
+    type           ENUM ('RESTAURANTS', 'BILLS', 'HOUSING', 'GROCERY', 'TRAVEL', 'ETC') NOT NULL,
+    amount         BIGINT(20)                                                           NOT NULL,
+    PRIMARY KEY (id),
+    INDEX (user_id, transaction_id)
+);
+
+CREATE TABLE IF NOT EXISTS recurring_transactions
+(
+    id                   BIGINT(20) UNSIGNED                                                  NOT NULL AUTO_INCREMENT,
+    user_id              BIGINT(20) UNSIGNED                                                  NOT NULL,
+    financial_account_id INT UNSIGNED                                                         NOT NULL,
+    name                 VARCHAR(255)                                                         NOT NULL,
+    amount               BIGINT(20)                                                           NOT NULL,
+    type                 ENUM ('RESTAURANTS', 'BILLS', 'HOUSING', 'GROCERY', 'TRAVEL', 'ETC') NOT NULL,
+    rule                 VARCHAR(255)                                                         NOT NULL,
+    starts_on            DATE                                                                 NOT NULL,
+    ends_on              DATE                                                                 NULL,
+    next_on              DATE                                                                 NULL,
+    created_on           timestamp DEFAULT NOW()                                              NOT NULL,
+    PRIMARY KEY (id),
+    INDEX (next_on),
+    INDEX (user_id)
 );
 
 CREATE TABLE IF NOT EXISTS financial_accounts
@@ -54,6 +89,123 @@ CREATE TABLE IF NOT EXISTS financial_accounts
 
 CREATE TABLE IF NOT EXISTS institutions
 (
-    id   INT          NOT NULL AUTO_INCREMENT PRIMARY KEY,
-    name VARCHAR(255) NOT NULL
+    id      INT                 NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id BIGINT(20) UNSIGNED NULL,
+    name    VARCHAR(255)        NOT NULL,
+    INDEX (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS sessions
+(
+    token_hash BINARY(32)              NOT NULL PRIMARY KEY,
+    user_id    BIGINT(20) UNSIGNED     NOT NULL,
+    expires_on timestamp               NOT NULL,
+    created_on timestamp DEFAULT NOW() NOT NULL,
+    INDEX (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_tokens
+(
+    token_hash BINARY(32)                                                                     NOT NULL PRIMARY KEY,
+    user_id    BIGINT(20) UNSIGNED                                                            NOT NULL,
+    purpose    ENUM ('PASSWORD_RESET', 'VERIFY_EMAIL', 'TWO_FACTOR_LOGIN', 'RESTORE_ACCOUNT') NOT NULL,
+    expires_on timestamp                                                                      NOT NULL,
+    used_on    timestamp                                                                      NULL,
+    created_on timestamp DEFAULT NOW()                                                        NOT NULL,
+    INDEX (user_id, purpose)
+);
+
+CREATE TABLE IF NOT EXISTS mail_outbox
+(
+    id              BIGINT(20) UNSIGNED     NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    recipient       VARCHAR(255)            NOT NULL,
+    subject         VARCHAR(255)            NOT NULL,
+    text_body       TEXT                    NOT NULL,
+    html_body       MEDIUMTEXT              NOT NULL,
+    attempts        INT UNSIGNED DEFAULT 0  NOT NULL,
+    next_attempt_on timestamp DEFAULT NOW() NOT NULL,
+    last_error      VARCHAR(1024)           NULL,
+    sent_on         timestamp               NULL,
+    failed_on       timestamp               NULL,
+    created_on      timestamp DEFAULT NOW() NOT NULL,
+    INDEX (sent_on, failed_on, next_attempt_on)
+);
+
+CREATE TABLE IF NOT EXISTS notification_preferences
+(
+    user_id BIGINT(20) UNSIGNED                             NOT NULL,
+    kind    ENUM ('BUDGET_ALERTS', 'SUBSCRIPTION_WARNINGS') NOT NULL,
+    enabled BOOLEAN                                         NOT NULL,
+    PRIMARY KEY (user_id, kind)
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes
+(
+    user_id   BIGINT(20) UNSIGNED NOT NULL,
+    code_hash BINARY(32)          NOT NULL,
+    used_on   timestamp           NULL,
+    PRIMARY KEY (user_id, code_hash)
+);
+
+CREATE TABLE IF NOT EXISTS webauthn_credentials
+(
+    id           VARBINARY(1023)         NOT NULL PRIMARY KEY,
+    user_id      BIGINT(20) UNSIGNED     NOT NULL,
+    public_key   BLOB                    NOT NULL,
+    sign_count   INT UNSIGNED DEFAULT 0  NOT NULL,
+    name         VARCHAR(255)            NOT NULL,
+    created_on   timestamp DEFAULT NOW() NOT NULL,
+    last_used_on timestamp               NULL,
+    INDEX (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS webauthn_challenges
+(
+    challenge_hash BINARY(32)                 NOT NULL PRIMARY KEY,
+    user_id        BIGINT(20) UNSIGNED        NULL,
+    ceremony       ENUM ('REGISTER', 'LOGIN') NOT NULL,
+    expires_on     timestamp                  NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS api_tokens
+(
+    id           BIGINT(20) UNSIGNED                                     NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    token_hash   BINARY(32)                                              NOT NULL UNIQUE,
+    user_id      BIGINT(20) UNSIGNED                                     NOT NULL,
+    name         VARCHAR(255)                                            NOT NULL,
+    scopes       SET ('read', 'accounts:write', 'transactions:write')    NOT NULL,
+    expires_on   timestamp                                               NULL,
+    created_on   timestamp DEFAULT NOW()                                 NOT NULL,
+    last_used_on timestamp                                               NULL,
+    INDEX (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS webhooks
+(
+    id         BIGINT(20) UNSIGNED                                  NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id    BIGINT(20) UNSIGNED                                  NOT NULL,
+    url        VARCHAR(2048)                                        NOT NULL,
+    secret     VARCHAR(255)                                         NOT NULL,
+    events     SET ('transaction.created', 'transaction.large_purchase',
+        'transactions.imported')                                    NOT NULL,
+    created_on timestamp DEFAULT NOW()                              NOT NULL,
+    INDEX (user_id)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries
+(
+    id              BIGINT(20) UNSIGNED     NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    webhook_id      BIGINT(20) UNSIGNED     NOT NULL,
+    user_id         BIGINT(20) UNSIGNED     NOT NULL,
+    event           VARCHAR(64)             NOT NULL,
+    payload         MEDIUMTEXT              NOT NULL,
+    attempts        INT UNSIGNED DEFAULT 0  NOT NULL,
+    next_attempt_on timestamp DEFAULT NOW() NOT NULL,
+    response_status SMALLINT UNSIGNED       NULL,
+    last_error      VARCHAR(1024)           NULL,
+    delivered_on    timestamp               NULL,
+    failed_on       timestamp               NULL,
+    created_on      timestamp DEFAULT NOW() NOT NULL,
+    INDEX (delivered_on, failed_on, next_attempt_on),
+    INDEX (webhook_id, id)
 );
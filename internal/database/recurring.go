@@ -0,0 +1,396 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+	"github.com/matcha-devs/matcha/internal/recurrence"
+	"github.com/matcha-devs/matcha/internal/tracing"
+	"github.com/matcha-devs/matcha/internal/validation"
+)
+
+const (
+	// maxRecurring is how many recurring transactions a user may have.
+	maxRecurring = 100
+	// matchDays is how far from its due date a transaction may happen and still be taken for an occurrence, and how
+	// many days occurrences wait for one before they are recorded on their own.
+	matchDays = 3
+	// matchTolerance is how far off the expected amount, in percent, a transaction may be to be taken for an
+	// occurrence, since bills like utilities vary.
+	matchTolerance = 10
+	// materializeBatch is how many recurring transactions MaterializeRecurring catches up on in one transaction.
+	materializeBatch = 100
+)
+
+// recurringColumns are the columns scanRecurring reads, in order.
+const recurringColumns = "id, financial_account_id, name, amount, type, rule, starts_on, ends_on, next_on"
+
+// AddRecurring adds a recurring transaction for a user on one of their accounts, returning it with its id and first
+// occurrence. Occurrences more than matchDays before today are taken to be recorded already. Invalid fields are
+// refused with validation.Errors under their JSON names.
+func (db *MySQLDatabase) AddRecurring(
+	ctx context.Context, userID uint64, recurring internal.RecurringTransaction,
+) (added internal.RecurringTransaction, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.AddRecurring")
+	defer func() { span.RecordError(err); span.End() }()
+	errs := validation.Errors{}
+	recurring.Name = validation.Text(errs, "name", recurring.Name, maxLabelLength)
+	if validation.OneOf(errs, "type", recurring.Type, internal.TransactionTypes); recurring.Amount == 0 {
+		errs.Add("amount", "please enter an amount other than 0")
+	}
+	rule, ruleErr := recurrence.Parse(recurring.Rule)
+	if ruleErr != nil {
+		errs.Add("rule", "please choose how often it repeats, like FREQ=MONTHLY;BYMONTHDAY=1")
+	}
+	start := validation.Date(errs, "starts_on", recurring.StartsOn)
+	var end time.Time
+	if strings.TrimSpace(recurring.EndsOn) != "" {
+		if end = validation.Date(errs, "ends_on", recurring.EndsOn); end.Before(start) {
+			errs.Add("ends_on", "please choose a day after it starts")
+		}
+	}
+	if len(errs) > 0 {
+		return added, errs
+	}
+	next := rule.Next(start, db.today().AddDate(0, 0, -matchDays))
+	if next.IsZero() || !end.IsZero() && next.After(end) {
+		return added, validation.Errors{"rule": "this schedule has no occurrence left"}
+	}
+	recurring.Rule, recurring.StartsOn = rule.String(), start.Format(validation.DateLayout)
+	recurring.NextOn = next.Format(validation.DateLayout)
+	endsOn := sql.NullTime{Time: end, Valid: !end.IsZero()}
+	if endsOn.Valid {
+		recurring.EndsOn = end.Format(validation.DateLayout)
+	}
+	err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			var count int
+			var owned bool
+			if err = tx.QueryRowContext(
+				ctx, `SELECT (SELECT COUNT(*) FROM recurring_transactions WHERE user_id = ?),
+				EXISTS (SELECT 1 FROM financial_accounts WHERE id = ? AND user_id = ?)`,
+				userID, recurring.FinancialAccountID, userID,
+			).Scan(&count, &owned); err != nil {
+				slog.ErrorContext(ctx, "Error counting recurring transactions", "user_id", userID, "err", err)
+				return internalError(err)
+			} else if count >= maxRecurring {
+				return validation.Errors{"name": "you can have at most 100 recurring transactions"}
+			} else if !owned {
+				return validation.Errors{"financial_account_id": "you have no account with this id"}
+			}
+			result, err := tx.ExecContext(
+				ctx, `INSERT INTO recurring_transactions
+				(user_id, financial_account_id, name, amount, type, rule, starts_on, ends_on, next_on)
+				VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				userID, recurring.FinancialAccountID, recurring.Name, recurring.Amount, recurring.Type, recurring.Rule,
+				start, endsOn, next,
+			)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error adding recurring transaction", "user_id", userID, "err", err)
+				return internalError(err)
+			}
+			id, err := result.LastInsertId()
+			if err != nil {
+				slog.ErrorContext(ctx, "Error adding recurring transaction", "user_id", userID, "err", err)
+				return internalError(err)
+			}
+			recurring.ID = uint64(id)
+			return
+		},
+	)
+	if err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "Recurring transaction added", "user_id", userID, "recurring_id", recurring.ID)
+	return recurring, nil
+}
+
+// GetRecurring lists the recurring transactions of a user, oldest first.
+func (db *MySQLDatabase) GetRecurring(
+	ctx context.Context, userID uint64,
+) (recurring []internal.RecurringTransaction, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetRecurring")
+	defer func() { span.RecordError(err); span.End() }()
+	err = db.queryRows(
+		ctx, func(rows *sql.Rows) (err error) {
+			scanned, err := scanRecurring(rows)
+			if err == nil {
+				recurring = append(recurring, scanned)
+			}
+			return
+		}, "SELECT "+recurringColumns+" FROM recurring_transactions WHERE user_id = ? ORDER BY id", userID,
+	)
+	return
+}
+
+// DeleteRecurring removes a recurring transaction of a user, the transactions recorded for it stay as one-offs.
+func (db *MySQLDatabase) DeleteRecurring(ctx context.Context, userID, id uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.DeleteRecurring")
+	defer func() { span.RecordError(err); span.End() }()
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			if _, err = tx.ExecContext(
+				ctx, "DELETE FROM recurring_transactions WHERE id = ? AND user_id = ?", id, userID,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error deleting recurring transaction", "user_id", userID, "err", err)
+				return internalError(err)
+			}
+			if _, err = tx.ExecContext(
+				ctx, "UPDATE transactions SET recurring_id = NULL WHERE recurring_id = ? AND user_id = ?", id, userID,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error detaching recurring transactions", "user_id", userID, "err", err)
+				return internalError(err)
+			}
+			return
+		},
+	); err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "Recurring transaction deleted", "user_id", userID, "recurring_id", id)
+	return
+}
+
+// GetUpcoming lists the occurrences of the recurring transactions of a user not recorded yet up to until, in order
+// of date. Those still waiting for a matching transaction are included even though they are due already.
+func (db *MySQLDatabase) GetUpcoming(
+	ctx context.Context, userID uint64, until time.Time,
+) (upcoming []internal.Occurrence, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetUpcoming")
+	defer func() { span.RecordError(err); span.End() }()
+	recurring, err := db.GetRecurring(ctx, userID)
+	if err != nil {
+		return
+	}
+	for _, scheduled := range recurring {
+		if scheduled.NextOn == "" {
+			continue
+		}
+		rule, start, end, err := parseSchedule(scheduled)
+		if err != nil {
+			slog.ErrorContext(ctx, "Error parsing stored schedule", "recurring_id", scheduled.ID, "err", err)
+			return nil, ErrInternal
+		}
+		next, _ := time.Parse(validation.DateLayout, scheduled.NextOn)
+		last := recurrence.Day(until)
+		if !end.IsZero() && end.Before(last) {
+			last = end
+		}
+		for _, day := range rule.Between(start, next, last) {
+			upcoming = append(
+				upcoming, internal.Occurrence{
+					RecurringID: scheduled.ID, FinancialAccountID: scheduled.FinancialAccountID, Name: scheduled.Name,
+					Amount: scheduled.Amount, Date: day.Format(validation.DateLayout),
+				},
+			)
+		}
+	}
+	slices.SortStableFunc(
+		upcoming, func(a, b internal.Occurrence) int { return strings.Compare(a.Date, b.Date) },
+	)
+	return
+}
+
+// MaterializeRecurring records the occurrences of every user's recurring transactions that went matchDays without a
+// matching transaction, on the day they were due, and returns how many it recorded. Webhooks are sent them like any
+// other transaction. Those of deleted users are left for when they're restored.
+func (db *MySQLDatabase) MaterializeRecurring(ctx context.Context) (recorded int, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.MaterializeRecurring")
+	defer func() { span.RecordError(err); span.End() }()
+	cutoff := db.today().AddDate(0, 0, -matchDays)
+	for {
+		var batch, batchRecorded int
+		if err = db.WithTx(
+			ctx, func(tx *sql.Tx) (err error) {
+				batch, batchRecorded = 0, 0
+				type due struct {
+					userID uint64
+					internal.RecurringTransaction
+				}
+				var dues []due
+				rows, err := tx.QueryContext(
+					ctx, "SELECT "+recurringColumns+`, user_id FROM recurring_transactions
+					WHERE next_on <= ? AND user_id IN (SELECT id FROM users WHERE deleted_on IS NULL)
+					ORDER BY next_on LIMIT ? FOR UPDATE SKIP LOCKED`, cutoff, materializeBatch,
+				)
+				if err != nil {
+					slog.ErrorContext(ctx, "Error selecting due recurring transactions", "err", err)
+					return internalError(err)
+				}
+				for rows.Next() {
+					var scanned due
+					if scanned.RecurringTransaction, err = scanRecurring(rows, &scanned.userID); err != nil {
+						_ = rows.Close()
+						slog.ErrorContext(ctx, "Error scanning due recurring transaction", "err", err)
+						return internalError(err)
+					}
+					dues = append(dues, scanned)
+				}
+				if err = rows.Err(); err != nil {
+					slog.ErrorContext(ctx, "Error selecting due recurring transactions", "err", err)
+					return internalError(err)
+				}
+				batch = len(dues)
+				for _, scheduled := range dues {
+					n, err := db.materialize(ctx, tx, scheduled.userID, scheduled.RecurringTransaction, cutoff)
+					if err != nil {
+						return err
+					}
+					batchRecorded += n
+				}
+				return
+			},
+		); err != nil {
+			return
+		}
+		recorded += batchRecorded
+		if batch < materializeBatch {
+			return
+		}
+	}
+}
+
+// materialize records the occurrences of a recurring transaction due up to cutoff within tx and moves its schedule
+// past them. Schedules whose account is gone are ended.
+func (db *MySQLDatabase) materialize(
+	ctx context.Context, tx *sql.Tx, userID uint64, scheduled internal.RecurringTransaction, cutoff time.Time,
+) (recorded int, err error) {
+	next := sql.NullTime{Valid: true}
+	next.Time, _ = time.Parse(validation.DateLayout, scheduled.NextOn)
+	for next.Valid && !next.Time.After(cutoff) {
+		transaction := internal.Transaction{
+			FinancialAccountID: scheduled.FinancialAccountID, Amount: scheduled.Amount, Type: scheduled.Type,
+			Date: next.Time.Format(validation.DateLayout), RecurringID: scheduled.ID,
+		}
+		if err = db.recordTransaction(ctx, tx, userID, &transaction); errors.As(err, new(validation.Errors)) {
+			slog.WarnContext(ctx, "Ending recurring transaction of a missing account", "recurring_id", scheduled.ID)
+			next.Valid = false
+			break
+		} else if err != nil {
+			return
+		}
+		recorded++
+		if next, err = nextOccurrence(scheduled, next.Time); err != nil {
+			slog.ErrorContext(ctx, "Error parsing stored schedule", "recurring_id", scheduled.ID, "err", err)
+			return 0, internalError(err)
+		}
+	}
+	if _, err = tx.ExecContext(
+		ctx, "UPDATE recurring_transactions SET next_on = ? WHERE id = ?", next, scheduled.ID,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error advancing recurring transaction", "recurring_id", scheduled.ID, "err", err)
+		return 0, internalError(err)
+	}
+	if recorded > 0 {
+		slog.InfoContext(
+			ctx, "Recurring transaction recorded", "user_id", userID, "recurring_id", scheduled.ID, "count", recorded,
+		)
+	}
+	return
+}
+
+// matchRecurring returns the id of the recurring transaction of the user expecting transaction, 0 if none does, and
+// moves its schedule past the occurrence within tx. Expected means on the same account, due within matchDays of the
+// transaction and for an amount of the same sign at most matchTolerance percent off, the closest due date winning.
+func matchRecurring(
+	ctx context.Context, tx *sql.Tx, userID uint64, transaction internal.Transaction,
+) (id uint64, err error) {
+	day, err := time.Parse(validation.DateLayout, transaction.Date)
+	if err != nil {
+		return 0, internalError(err)
+	}
+	scheduled, err := scanRecurring(
+		tx.QueryRowContext(
+			ctx, "SELECT "+recurringColumns+` FROM recurring_transactions
+			WHERE user_id = ? AND financial_account_id = ? AND next_on BETWEEN ? AND ?
+			AND SIGN(amount) = SIGN(?) AND ABS(amount - ?) * 100 <= ABS(amount) * ?
+			ORDER BY ABS(DATEDIFF(next_on, ?)), id LIMIT 1 FOR UPDATE`,
+			userID, transaction.FinancialAccountID, day.AddDate(0, 0, -matchDays), day.AddDate(0, 0, matchDays),
+			transaction.Amount, transaction.Amount, matchTolerance, day,
+		),
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, nil
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error matching recurring transaction", "user_id", userID, "err", err)
+		return 0, internalError(err)
+	}
+	due, _ := time.Parse(validation.DateLayout, scheduled.NextOn)
+	next, err := nextOccurrence(scheduled, due)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error parsing stored schedule", "recurring_id", scheduled.ID, "err", err)
+		return 0, internalError(err)
+	}
+	if _, err = tx.ExecContext(
+		ctx, "UPDATE recurring_transactions SET next_on = ? WHERE id = ?", next, scheduled.ID,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error advancing recurring transaction", "recurring_id", scheduled.ID, "err", err)
+		return 0, internalError(err)
+	}
+	slog.InfoContext(
+		ctx, "Transaction matched a recurring one", "user_id", userID, "recurring_id", scheduled.ID,
+		"due_on", scheduled.NextOn,
+	)
+	return scheduled.ID, nil
+}
+
+// nextOccurrence is the occurrence of a recurring transaction after the day after, invalid once its schedule is over.
+func nextOccurrence(scheduled internal.RecurringTransaction, after time.Time) (next sql.NullTime, err error) {
+	rule, start, end, err := parseSchedule(scheduled)
+	if err != nil {
+		return
+	}
+	next.Time = rule.Next(start, after.AddDate(0, 0, 1))
+	next.Valid = !next.Time.IsZero() && (end.IsZero() || !next.Time.After(end))
+	return
+}
+
+// parseSchedule reads back the schedule of a stored recurring transaction, end is zero when it has none.
+func parseSchedule(scheduled internal.RecurringTransaction) (rule recurrence.Rule, start, end time.Time, err error) {
+	if rule, err = recurrence.Parse(scheduled.Rule); err != nil {
+		return
+	}
+	if start, err = time.Parse(validation.DateLayout, scheduled.StartsOn); err != nil || scheduled.EndsOn == "" {
+		return
+	}
+	end, err = time.Parse(validation.DateLayout, scheduled.EndsOn)
+	return
+}
+
+// scanRecurring reads the recurringColumns of a row, followed by any columns into extra.
+func scanRecurring(
+	row interface{ Scan(dest ...any) error }, extra ...any,
+) (recurring internal.RecurringTransaction, err error) {
+	var (
+		start     time.Time
+		end, next sql.NullTime
+	)
+	if err = row.Scan(
+		append(
+			[]any{
+				&recurring.ID, &recurring.FinancialAccountID, &recurring.Name, &recurring.Amount, &recurring.Type,
+				&recurring.Rule, &start, &end, &next,
+			}, extra...,
+		)...,
+	); err != nil {
+		return
+	}
+	recurring.StartsOn = start.Format(validation.DateLayout)
+	if end.Valid {
+		recurring.EndsOn = end.Time.Format(validation.DateLayout)
+	}
+	if next.Valid {
+		recurring.NextOn = next.Time.Format(validation.DateLayout)
+	}
+	return
+}
+
+// today is the date of db.now, the day dates without a time of day are compared to.
+func (db *MySQLDatabase) today() time.Time {
+	return recurrence.Day(db.now())
+}
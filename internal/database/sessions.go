@@ -0,0 +1,68 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+	"github.com/matcha-devs/matcha/internal/tracing"
+)
+
+// CreateSession logs a user in for ttl, the returned token is the only way to refer to the session.
+func (db *MySQLDatabase) CreateSession(
+	ctx context.Context, userID uint64, ttl time.Duration,
+) (token string, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.CreateSession")
+	defer func() { span.RecordError(err); span.End() }()
+	token, hash, err := newToken()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error generating session token", "err", err)
+		return "", ErrInternal
+	}
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, "INSERT INTO sessions (token_hash, user_id, expires_on) VALUES (?, ?, ?)",
+		hash, userID, db.now().Add(ttl),
+	); err != nil {
+		slog.ErrorContext(ctx, "Error adding session", "user_id", userID, "err", err)
+		return "", ErrInternal
+	}
+	return
+}
+
+// GetSessionUser returns the user logged in with the session token, or nil if it is unknown or expired.
+func (db *MySQLDatabase) GetSessionUser(ctx context.Context, token string) (user *internal.User) {
+	ctx, span := tracing.Start(ctx, "mysql.GetSessionUser")
+	defer span.End()
+	var userID uint64
+	if err := db.underlyingDB.QueryRowContext(
+		ctx, "SELECT user_id FROM sessions WHERE token_hash = ? AND expires_on > ?", hashToken(token), db.now(),
+	).Scan(&userID); err != nil {
+		slog.InfoContext(ctx, "No live session for token", "err", err)
+		return nil
+	}
+	return db.GetUser(ctx, userID)
+}
+
+func (db *MySQLDatabase) DeleteSession(ctx context.Context, token string) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.DeleteSession")
+	defer func() { span.RecordError(err); span.End() }()
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, "DELETE FROM sessions WHERE token_hash = ?", hashToken(token),
+	); err != nil {
+		slog.ErrorContext(ctx, "Error deleting session", "err", err)
+		return ErrInternal
+	}
+	return
+}
+
+// DeleteUserSessions logs a user out everywhere.
+func (db *MySQLDatabase) DeleteUserSessions(ctx context.Context, userID uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.DeleteUserSessions")
+	defer func() { span.RecordError(err); span.End() }()
+	if _, err = db.underlyingDB.ExecContext(ctx, "DELETE FROM sessions WHERE user_id = ?", userID); err != nil {
+		slog.ErrorContext(ctx, "Error deleting user sessions", "user_id", userID, "err", err)
+		return ErrInternal
+	}
+	return
+}
@@ -0,0 +1,117 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"strconv"
+
+	"github.com/matcha-devs/matcha/internal"
+	"github.com/matcha-devs/matcha/internal/tracing"
+	"github.com/matcha-devs/matcha/internal/validation"
+)
+
+// maxSplits is how many parts a transaction may be split into.
+const maxSplits = 20
+
+// SetSplits splits a transaction of a user between categories in place of the splits it had, or makes it whole again
+// when splits is empty, returning it. The splits must add up to its amount, and are refused with validation.Errors
+// under their JSON names, like splits.0.amount, otherwise. ErrNotFound is returned if the user has no transaction
+// with that id.
+func (db *MySQLDatabase) SetSplits(
+	ctx context.Context, userID, id uint64, splits []internal.Split,
+) (transaction internal.Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.SetSplits")
+	defer func() { span.RecordError(err); span.End() }()
+	err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			if transaction, err = lockTransaction(ctx, tx, userID, id); err != nil {
+				return
+			}
+			errs := validation.Errors{}
+			if checkSplits(errs, transaction.Amount, splits); len(errs) > 0 {
+				return errs
+			}
+			if _, err = tx.ExecContext(
+				ctx, "DELETE FROM transaction_splits WHERE transaction_id = ? AND user_id = ?", id, userID,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error deleting splits", "user_id", userID, "transaction_id", id, "err", err)
+				return internalError(err)
+			}
+			transaction.Splits = splits
+			return addSplits(ctx, tx, userID, transaction)
+		},
+	)
+	if err != nil {
+		return internal.Transaction{}, err
+	}
+	slog.InfoContext(ctx, "Transaction split", "user_id", userID, "transaction_id", id, "splits", len(splits))
+	return
+}
+
+// checkSplits adds to errs what is wrong with splitting amount into splits, nothing for transactions left whole.
+func checkSplits(errs validation.Errors, amount int64, splits []internal.Split) {
+	if len(splits) == 0 {
+		return
+	}
+	if len(splits) == 1 || len(splits) > maxSplits {
+		errs.Add("splits", "please split into 2 to "+strconv.Itoa(maxSplits)+" parts")
+		return
+	}
+	var sum int64
+	for i, split := range splits {
+		field := "splits." + strconv.Itoa(i) + "."
+		validation.OneOf(errs, field+"type", split.Type, internal.TransactionTypes)
+		if split.Amount == 0 || split.Amount < 0 != (amount < 0) {
+			errs.Add(field+"amount", "please enter an amount other than 0 with the sign of the transaction")
+		}
+		sum += split.Amount
+	}
+	if sum != amount {
+		errs.Add("splits", "the parts must add up to the amount of the transaction")
+	}
+}
+
+// addSplits inserts the splits of a transaction of a user within tx.
+func addSplits(ctx context.Context, tx *sql.Tx, userID uint64, transaction internal.Transaction) (err error) {
+	for _, split := range transaction.Splits {
+		if _, err = tx.ExecContext(
+			ctx, "INSERT INTO transaction_splits (transaction_id, user_id, type, amount) VALUES (?, ?, ?, ?)",
+			transaction.ID, userID, split.Type, split.Amount,
+		); err != nil {
+			slog.ErrorContext(ctx, "Error adding split", "user_id", userID, "err", err)
+			return internalError(err)
+		}
+	}
+	return
+}
+
+// loadSplits fills in the splits of transactions of a user, which must be in order of id.
+func (db *MySQLDatabase) loadSplits(
+	ctx context.Context, userID uint64, transactions []internal.Transaction,
+) (err error) {
+	if len(transactions) == 0 {
+		return
+	}
+	index := make(map[uint64]int, len(transactions))
+	for i, transaction := range transactions {
+		index[transaction.ID] = i
+	}
+	return db.queryRows(
+		ctx, func(rows *sql.Rows) (err error) {
+			var (
+				transactionID uint64
+				split         internal.Split
+			)
+			if err = rows.Scan(&transactionID, &split.Type, &split.Amount); err != nil {
+				return
+			}
+			if i, ok := index[transactionID]; ok {
+				transactions[i].Splits = append(transactions[i].Splits, split)
+			}
+			return
+		}, `SELECT transaction_id, type, amount FROM transaction_splits
+		WHERE user_id = ? AND transaction_id BETWEEN ? AND ? ORDER BY id`,
+		userID, transactions[0].ID, transactions[len(transactions)-1].ID,
+	)
+}
@@ -0,0 +1,149 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal/tracing"
+)
+
+type TokenPurpose string
+
+const (
+	PasswordResetToken TokenPurpose = "PASSWORD_RESET"
+	VerifyEmailToken   TokenPurpose = "VERIFY_EMAIL"
+	// TwoFactorLoginToken stands for a login whose password was right, waiting on its second factor.
+	TwoFactorLoginToken TokenPurpose = "TWO_FACTOR_LOGIN"
+	// RestoreAccountToken undoes the deletion of its user during DeletionGracePeriod.
+	RestoreAccountToken TokenPurpose = "RESTORE_ACCOUNT"
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// newToken returns a random token to hand out and the hash of it to store, so a leaked table can't be replayed.
+func newToken() (token string, hash []byte, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) []byte {
+	sum := sha256.Sum256([]byte(token))
+	return sum[:]
+}
+
+// CreateUserToken issues a single-use token for purpose valid for ttl, revoking the user's previous unused ones.
+func (db *MySQLDatabase) CreateUserToken(
+	ctx context.Context, userID uint64, purpose TokenPurpose, ttl time.Duration,
+) (token string, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.CreateUserToken")
+	defer func() { span.RecordError(err); span.End() }()
+	token, hash, err := newToken()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error generating token", "err", err)
+		return "", ErrInternal
+	}
+	now := db.now()
+	err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			if _, err = tx.ExecContext(
+				ctx, "UPDATE user_tokens SET used_on = ? WHERE user_id = ? AND purpose = ? AND used_on IS NULL",
+				now, userID, purpose,
+			); err != nil {
+				slog.ErrorContext(
+					ctx, "Error revoking previous tokens", "user_id", userID, "purpose", purpose, "err", err,
+				)
+				return internalError(err)
+			}
+			if _, err = tx.ExecContext(
+				ctx, "INSERT INTO user_tokens (token_hash, user_id, purpose, expires_on) VALUES (?, ?, ?, ?)",
+				hash, userID, purpose, now.Add(ttl),
+			); err != nil {
+				slog.ErrorContext(ctx, "Error adding token", "user_id", userID, "purpose", purpose, "err", err)
+				return internalError(err)
+			}
+			return
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	return
+}
+
+// ConsumeUserToken redeems a token issued for purpose, returning its user, it fails with ErrInvalidToken once used.
+func (db *MySQLDatabase) ConsumeUserToken(
+	ctx context.Context, purpose TokenPurpose, token string,
+) (userID uint64, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.ConsumeUserToken")
+	defer func() { span.RecordError(err); span.End() }()
+	err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			userID, err = db.consumeUserToken(ctx, tx, purpose, token)
+			return
+		},
+	)
+	if err != nil {
+		return 0, err
+	}
+	return
+}
+
+// consumeUserToken is ConsumeUserToken within tx, so that what the token grants is done in the same transaction.
+func (db *MySQLDatabase) consumeUserToken(
+	ctx context.Context, tx *sql.Tx, purpose TokenPurpose, token string,
+) (userID uint64, err error) {
+	hash, now := hashToken(token), db.now()
+	// Marking the token used first makes redeeming it atomic, only one of two concurrent requests can update it.
+	result, err := tx.ExecContext(
+		ctx, `UPDATE user_tokens SET used_on = ?
+		WHERE token_hash = ? AND purpose = ? AND used_on IS NULL AND expires_on > ?`,
+		now, hash, purpose, now,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error consuming token", "purpose", purpose, "err", err)
+		return 0, internalError(err)
+	}
+	if consumed, err := result.RowsAffected(); err != nil {
+		slog.ErrorContext(ctx, "Error consuming token", "purpose", purpose, "err", err)
+		return 0, internalError(err)
+	} else if consumed != 1 {
+		return 0, ErrInvalidToken
+	}
+	if err = tx.QueryRowContext(
+		ctx, "SELECT user_id FROM user_tokens WHERE token_hash = ?", hash,
+	).Scan(&userID); err != nil {
+		slog.ErrorContext(ctx, "Error reading consumed token", "purpose", purpose, "err", err)
+		return 0, internalError(err)
+	}
+	return
+}
+
+// CheckUserToken returns the user of a live token for purpose without redeeming it.
+func (db *MySQLDatabase) CheckUserToken(
+	ctx context.Context, purpose TokenPurpose, token string,
+) (userID uint64, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.CheckUserToken")
+	defer func() { span.RecordError(err); span.End() }()
+	err = db.underlyingDB.QueryRowContext(
+		ctx, `SELECT user_id FROM user_tokens
+		WHERE token_hash = ? AND purpose = ? AND used_on IS NULL AND expires_on > ?`,
+		hashToken(token), purpose, db.now(),
+	).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, ErrInvalidToken
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error checking token", "purpose", purpose, "err", err)
+		return 0, ErrInternal
+	}
+	return
+}
@@ -0,0 +1,215 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+	"github.com/matcha-devs/matcha/internal/tracing"
+	"github.com/matcha-devs/matcha/internal/validation"
+	"github.com/matcha-devs/matcha/internal/webhooks"
+)
+
+// transferDays is how many days apart the two sides of a transfer may be recorded and still be detected as one, since
+// money takes a while to clear between institutions.
+const transferDays = 3
+
+// AddTransfer moves amount from one of the user's accounts to another, recording a transaction on each linked as a
+// transfer, and returns both. Invalid fields are refused with validation.Errors under their JSON names.
+func (db *MySQLDatabase) AddTransfer(
+	ctx context.Context, userID, fromAccountID, toAccountID uint64, amount int64, date string,
+) (from, to internal.Transaction, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.AddTransfer")
+	defer func() { span.RecordError(err); span.End() }()
+	errs := validation.Errors{}
+	if amount <= 0 {
+		errs.Add("amount", "please enter an amount above 0")
+	}
+	if fromAccountID == toAccountID {
+		errs.Add("to_account_id", "please choose another account than the one the money comes from")
+	}
+	if date == "" {
+		date = db.today().Format(validation.DateLayout)
+	} else {
+		date = validation.Date(errs, "date", date).Format(validation.DateLayout)
+	}
+	if len(errs) > 0 {
+		return from, to, errs
+	}
+	from = internal.Transaction{FinancialAccountID: fromAccountID, Amount: -amount, Type: "ETC", Date: date}
+	to = internal.Transaction{FinancialAccountID: toAccountID, Amount: amount, Type: "ETC", Date: date}
+	err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			if from.ID, err = addTransaction(ctx, tx, userID, from); err != nil {
+				return renameField(err, "financial_account_id", "from_account_id")
+			}
+			if to.ID, err = addTransaction(ctx, tx, userID, to); err != nil {
+				return renameField(err, "financial_account_id", "to_account_id")
+			}
+			if err = linkTransfer(ctx, tx, userID, from.ID, to.ID); err != nil {
+				return
+			}
+			from.TransferID, to.TransferID = to.ID, from.ID
+			// Transfers are no purchases, however large.
+			if err = db.queueWebhookEvent(ctx, tx, userID, webhooks.TransactionCreated, from); err != nil {
+				return
+			}
+			return db.queueWebhookEvent(ctx, tx, userID, webhooks.TransactionCreated, to)
+		},
+	)
+	if err != nil {
+		return internal.Transaction{}, internal.Transaction{}, err
+	}
+	slog.InfoContext(ctx, "Transfer added", "user_id", userID, "from_id", from.ID, "to_id", to.ID)
+	return
+}
+
+// LinkTransfer marks two transactions of a user already recorded as the sides of a transfer, which must be on
+// different accounts and of opposite amounts. Transactions that can't be linked are refused with validation.Errors
+// under transaction_id, the field naming otherID.
+func (db *MySQLDatabase) LinkTransfer(ctx context.Context, userID, id, otherID uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.LinkTransfer")
+	defer func() { span.RecordError(err); span.End() }()
+	if id == otherID {
+		return validation.Errors{"transaction_id": "please choose another transaction"}
+	}
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			transaction, err := lockTransaction(ctx, tx, userID, id)
+			if err != nil {
+				return
+			}
+			other, err := lockTransaction(ctx, tx, userID, otherID)
+			if errors.Is(err, ErrNotFound) {
+				return validation.Errors{"transaction_id": "you have no transaction with this id"}
+			} else if err != nil {
+				return
+			}
+			switch {
+			case transaction.TransferID != 0 || other.TransferID != 0:
+				return validation.Errors{"transaction_id": "unlink the transfers these transactions are part of first"}
+			case transaction.FinancialAccountID == other.FinancialAccountID:
+				return validation.Errors{"transaction_id": "please choose a transaction on another account"}
+			case transaction.Amount != -other.Amount:
+				return validation.Errors{"transaction_id": "please choose a transaction of the opposite amount"}
+			}
+			return linkTransfer(ctx, tx, userID, id, otherID)
+		},
+	); err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "Transfer linked", "user_id", userID, "transaction_id", id, "other_id", otherID)
+	return
+}
+
+// UnlinkTransfer makes a transaction of a user and the other side of its transfer, if any, count as spending and
+// income again.
+func (db *MySQLDatabase) UnlinkTransfer(ctx context.Context, userID, id uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.UnlinkTransfer")
+	defer func() { span.RecordError(err); span.End() }()
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			transaction, err := lockTransaction(ctx, tx, userID, id)
+			if err != nil || transaction.TransferID == 0 {
+				return
+			}
+			if _, err = tx.ExecContext(
+				ctx, "UPDATE transactions SET transfer_id = NULL WHERE id IN (?, ?) AND user_id = ?",
+				id, transaction.TransferID, userID,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error unlinking transfer", "user_id", userID, "err", err)
+				return internalError(err)
+			}
+			return
+		},
+	); err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "Transfer unlinked", "user_id", userID, "transaction_id", id)
+	return
+}
+
+// lockTransaction selects a transaction of a user for update within tx, ErrNotFound if they have none with that id.
+func lockTransaction(
+	ctx context.Context, tx *sql.Tx, userID, id uint64,
+) (transaction internal.Transaction, err error) {
+	transaction, err = scanTransaction(
+		tx.QueryRowContext(
+			ctx, "SELECT "+transactionColumns+" FROM transactions WHERE id = ? AND user_id = ? FOR UPDATE", id, userID,
+		),
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return transaction, ErrNotFound
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error selecting transaction", "user_id", userID, "transaction_id", id, "err", err)
+		return transaction, internalError(err)
+	}
+	return
+}
+
+// linkTransfer points two transactions of a user at each other within tx.
+func linkTransfer(ctx context.Context, tx *sql.Tx, userID, id, otherID uint64) (err error) {
+	if _, err = tx.ExecContext(
+		ctx, `UPDATE transactions SET transfer_id = IF(id = ?, ?, ?) WHERE id IN (?, ?) AND user_id = ?`,
+		id, otherID, id, id, otherID, userID,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error linking transfer", "user_id", userID, "err", err)
+		return internalError(err)
+	}
+	return
+}
+
+// detectTransfers links each of transactions not part of a transfer yet with a transaction of the user of the opposite
+// amount on another account, recorded at most transferDays apart and closest in date, which is taken for the other
+// side of a transfer like a credit card payment. It returns how many transfers it found.
+func detectTransfers(
+	ctx context.Context, tx *sql.Tx, userID uint64, transactions []internal.Transaction,
+) (detected int, err error) {
+	linked := make(map[uint64]bool)
+	for _, transaction := range transactions {
+		if transaction.TransferID != 0 || linked[transaction.ID] {
+			continue
+		}
+		day, err := time.Parse(validation.DateLayout, transaction.Date)
+		if err != nil {
+			return detected, internalError(err)
+		}
+		var otherID uint64
+		err = tx.QueryRowContext(
+			ctx, `SELECT id FROM transactions
+			WHERE user_id = ? AND amount = ? AND occurred_on BETWEEN ? AND ? AND financial_account_id <> ?
+			AND transfer_id IS NULL ORDER BY ABS(DATEDIFF(occurred_on, ?)), id LIMIT 1 FOR UPDATE`,
+			userID, -transaction.Amount, day.AddDate(0, 0, -transferDays), day.AddDate(0, 0, transferDays),
+			transaction.FinancialAccountID, day,
+		).Scan(&otherID)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		} else if err != nil {
+			slog.ErrorContext(ctx, "Error detecting transfer", "user_id", userID, "err", err)
+			return detected, internalError(err)
+		}
+		if err = linkTransfer(ctx, tx, userID, transaction.ID, otherID); err != nil {
+			return detected, err
+		}
+		linked[otherID] = true
+		detected++
+	}
+	return
+}
+
+// renameField moves the message validation.Errors hold for field under name, since statements shared by several
+// methods name fields one way.
+func renameField(err error, field, name string) error {
+	var errs validation.Errors
+	if !errors.As(err, &errs) {
+		return err
+	}
+	if message, ok := errs[field]; ok {
+		delete(errs, field)
+		errs[name] = message
+	}
+	return errs
+}
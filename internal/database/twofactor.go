@@ -0,0 +1,160 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"log/slog"
+	"strings"
+
+	"github.com/matcha-devs/matcha/internal/tracing"
+)
+
+// recoveryCodeCount codes are handed out when two-factor authentication is enabled, each works once.
+const recoveryCodeCount = 10
+
+var ErrInvalidCode = errors.New("invalid authentication code")
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// newRecoveryCode returns 80 random bits as "abcd-efgh-ijkl-mnop".
+func newRecoveryCode() (code string, err error) {
+	buf := make([]byte, 10)
+	if _, err = rand.Read(buf); err != nil {
+		return
+	}
+	encoded := strings.ToLower(recoveryEncoding.EncodeToString(buf))
+	return encoded[:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:], nil
+}
+
+// normalizeRecoveryCode forgives the case, dashes and spaces of a code typed by hand.
+func normalizeRecoveryCode(code string) string {
+	return strings.NewReplacer("-", "", " ", "").Replace(strings.ToLower(code))
+}
+
+// EnableTOTP stores the encrypted TOTP secret of a user and replaces their recovery codes, which are only returned
+// here, the database keeps their hashes.
+func (db *MySQLDatabase) EnableTOTP(
+	ctx context.Context, id uint64, encryptedSecret []byte,
+) (recoveryCodes []string, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.EnableTOTP")
+	defer func() { span.RecordError(err); span.End() }()
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			recoveryCodes = nil
+			if _, err = tx.ExecContext(
+				ctx, "UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?", encryptedSecret, id,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error storing TOTP secret", "user_id", id, "err", err)
+				return internalError(err)
+			}
+			if _, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", id); err != nil {
+				slog.ErrorContext(ctx, "Error deleting recovery codes", "user_id", id, "err", err)
+				return internalError(err)
+			}
+			for range recoveryCodeCount {
+				code, err := newRecoveryCode()
+				if err != nil {
+					slog.ErrorContext(ctx, "Error generating recovery code", "err", err)
+					return ErrInternal
+				}
+				if _, err = tx.ExecContext(
+					ctx, "INSERT INTO recovery_codes (user_id, code_hash) VALUES (?, ?)",
+					id, hashToken(normalizeRecoveryCode(code)),
+				); err != nil {
+					slog.ErrorContext(ctx, "Error storing recovery code", "user_id", id, "err", err)
+					return internalError(err)
+				}
+				recoveryCodes = append(recoveryCodes, code)
+			}
+			return
+		},
+	); err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Two-factor authentication enabled", "user_id", id)
+	return
+}
+
+// GetTOTPSecret returns the encrypted TOTP secret of a user, nil when they haven't enabled two-factor authentication.
+func (db *MySQLDatabase) GetTOTPSecret(ctx context.Context, id uint64) (encryptedSecret []byte, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetTOTPSecret")
+	defer func() { span.RecordError(err); span.End() }()
+	if err = db.underlyingDB.QueryRowContext(
+		ctx, "SELECT totp_secret FROM users WHERE id = ?", id,
+	).Scan(&encryptedSecret); err != nil {
+		slog.ErrorContext(ctx, "Error selecting TOTP secret", "user_id", id, "err", err)
+		return nil, ErrInternal
+	}
+	return
+}
+
+// UseTOTPStep records that the code of a step was used, failing with ErrInvalidCode if it or a later one already was,
+// so an observed code can't be replayed.
+func (db *MySQLDatabase) UseTOTPStep(ctx context.Context, id uint64, step int64) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.UseTOTPStep")
+	defer func() { span.RecordError(err); span.End() }()
+	result, err := db.underlyingDB.ExecContext(
+		ctx, "UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, id, step,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error using TOTP step", "user_id", id, "err", err)
+		return ErrInternal
+	}
+	if used, err := result.RowsAffected(); err != nil {
+		slog.ErrorContext(ctx, "Error using TOTP step", "user_id", id, "err", err)
+		return ErrInternal
+	} else if used != 1 {
+		slog.WarnContext(ctx, "Replayed TOTP code", "user_id", id)
+		return ErrInvalidCode
+	}
+	return
+}
+
+// UseRecoveryCode redeems one of the user's recovery codes, each works once.
+func (db *MySQLDatabase) UseRecoveryCode(ctx context.Context, id uint64, code string) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.UseRecoveryCode")
+	defer func() { span.RecordError(err); span.End() }()
+	result, err := db.underlyingDB.ExecContext(
+		ctx, "UPDATE recovery_codes SET used_on = ? WHERE user_id = ? AND code_hash = ? AND used_on IS NULL",
+		db.now(), id, hashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error using recovery code", "user_id", id, "err", err)
+		return ErrInternal
+	}
+	if used, err := result.RowsAffected(); err != nil {
+		slog.ErrorContext(ctx, "Error using recovery code", "user_id", id, "err", err)
+		return ErrInternal
+	} else if used != 1 {
+		return ErrInvalidCode
+	}
+	slog.InfoContext(ctx, "Recovery code used", "user_id", id)
+	return
+}
+
+func (db *MySQLDatabase) DisableTOTP(ctx context.Context, id uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.DisableTOTP")
+	defer func() { span.RecordError(err); span.End() }()
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			if _, err = tx.ExecContext(
+				ctx, "UPDATE users SET totp_secret = NULL, totp_last_step = 0 WHERE id = ?", id,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error removing TOTP secret", "user_id", id, "err", err)
+				return internalError(err)
+			}
+			if _, err = tx.ExecContext(ctx, "DELETE FROM recovery_codes WHERE user_id = ?", id); err != nil {
+				slog.ErrorContext(ctx, "Error deleting recovery codes", "user_id", id, "err", err)
+				return internalError(err)
+			}
+			return
+		},
+	); err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "Two-factor authentication disabled", "user_id", id)
+	return
+}
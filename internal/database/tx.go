@@ -0,0 +1,101 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	mysqlDriver "github.com/go-sql-driver/mysql"
+	"github.com/matcha-devs/matcha/internal/metrics"
+	"github.com/matcha-devs/matcha/internal/tracing"
+)
+
+const (
+	// maxTxAttempts is how many times WithTx runs a transaction that keeps being rolled back by contention.
+	maxTxAttempts = 3
+	txRetryDelay  = 20 * time.Millisecond
+
+	// MySQL error numbers of transactions rolled back by contention, which go through when run again.
+	errDeadlock        = 1213
+	errLockWaitTimeout = 1205
+)
+
+var txRetries = metrics.NewCounter(
+	"matcha_db_tx_retries_total", "Transactions run again after a deadlock or lock wait timeout.",
+)
+
+// txFailure is a failed statement, reported as ErrInternal while keeping the driver error for WithTx to inspect.
+type txFailure struct {
+	err error
+}
+
+func (failure txFailure) Error() string {
+	return ErrInternal.Error()
+}
+
+func (failure txFailure) Is(target error) bool {
+	return target == ErrInternal
+}
+
+func (failure txFailure) Unwrap() error {
+	return failure.err
+}
+
+// internalError is returned in place of ErrInternal by the statements of a transaction, so that WithTx can still tell
+// whether they failed because of contention.
+func internalError(err error) error {
+	return txFailure{err}
+}
+
+// retryable reports whether err rolled back a transaction that may go through when run again.
+func retryable(err error) bool {
+	var driverErr *mysqlDriver.MySQLError
+	return errors.As(err, &driverErr) && (driverErr.Number == errDeadlock || driverErr.Number == errLockWaitTimeout)
+}
+
+// WithTx runs fn in a transaction, committed if fn returns nil and rolled back otherwise, returning the error of fn.
+// Transactions rolled back by a deadlock or a lock wait timeout are run again, up to maxTxAttempts times, so fn must
+// only change things through tx, start over cleanly, and return statement errors through internalError.
+func (db *MySQLDatabase) WithTx(ctx context.Context, fn func(tx *sql.Tx) error) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.WithTx")
+	defer func() { span.RecordError(err); span.End() }()
+	for attempt := 1; ; attempt++ {
+		if err = db.runTx(ctx, fn); err == nil || attempt == maxTxAttempts || !retryable(err) {
+			return
+		}
+		txRetries.Inc()
+		slog.WarnContext(ctx, "Retrying transaction rolled back by contention", "attempt", attempt, "err", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(attempt) * txRetryDelay):
+		}
+	}
+}
+
+func (db *MySQLDatabase) runTx(ctx context.Context, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := db.underlyingDB.BeginTx(ctx, nil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error starting transaction", "err", err)
+		return internalError(err)
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			if err := tx.Rollback(); err != nil && !errors.Is(err, sql.ErrTxDone) {
+				slog.ErrorContext(ctx, "Error rolling back transaction", "err", err)
+			}
+		}
+	}()
+	if err = fn(tx); err != nil {
+		return
+	}
+	if err = tx.Commit(); err != nil {
+		slog.ErrorContext(ctx, "Error committing transaction", "err", err)
+		return internalError(err)
+	}
+	committed = true
+	return
+}
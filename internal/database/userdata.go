@@ -0,0 +1,290 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+
+	"github.com/matcha-devs/matcha/internal"
+	"github.com/matcha-devs/matcha/internal/tracing"
+	"github.com/matcha-devs/matcha/internal/validation"
+	"github.com/matcha-devs/matcha/internal/webhooks"
+)
+
+// ErrDanglingReference is returned when imported records refer to records that aren't part of the import.
+var ErrDanglingReference = errors.New("reference to a record not imported")
+
+// GetUserData returns the financial accounts and transactions of a user, with the institutions of those accounts.
+func (db *MySQLDatabase) GetUserData(ctx context.Context, userID uint64) (data internal.UserData, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetUserData")
+	defer func() { span.RecordError(err); span.End() }()
+	if err = db.queryRows(
+		ctx, func(rows *sql.Rows) (err error) {
+			var institution internal.Institution
+			if err = rows.Scan(&institution.ID, &institution.Name); err == nil {
+				data.Institutions = append(data.Institutions, institution)
+			}
+			return
+		}, `SELECT id, name FROM institutions
+		WHERE id IN (SELECT institution_id FROM financial_accounts WHERE user_id = ?) ORDER BY id`, userID,
+	); err != nil {
+		return internal.UserData{}, err
+	}
+	if err = db.queryRows(
+		ctx, func(rows *sql.Rows) (err error) {
+			var account internal.FinancialAccount
+			if err = rows.Scan(
+				&account.ID, &account.InstitutionID, &account.AssetClass, &account.Name, &account.NetValue,
+			); err == nil {
+				data.Accounts = append(data.Accounts, account)
+			}
+			return
+		}, `SELECT id, institution_id, asset_class, name, net_value FROM financial_accounts
+		WHERE user_id = ? ORDER BY id`, userID,
+	); err != nil {
+		return internal.UserData{}, err
+	}
+	if err = db.queryRows(
+		ctx, func(rows *sql.Rows) (err error) {
+			transaction, err := scanTransaction(rows)
+			if err == nil {
+				data.Transactions = append(data.Transactions, transaction)
+			}
+			return
+		}, "SELECT "+transactionColumns+" FROM transactions WHERE user_id = ? ORDER BY id", userID,
+	); err != nil {
+		return internal.UserData{}, err
+	}
+	if err = db.loadSplits(ctx, userID, data.Transactions); err != nil {
+		return internal.UserData{}, err
+	}
+	return
+}
+
+// GetSummaryReport sums up the accounts and transactions of a user.
+func (db *MySQLDatabase) GetSummaryReport(
+	ctx context.Context, userID uint64,
+) (report internal.SummaryReport, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetSummaryReport")
+	defer func() { span.RecordError(err); span.End() }()
+	report = internal.SummaryReport{ByAssetClass: map[string]int64{}, SpendingByType: map[string]int64{}}
+	if err = db.queryRows(
+		ctx, func(rows *sql.Rows) (err error) {
+			var (
+				assetClass string
+				netValue   int64
+			)
+			if err = rows.Scan(&assetClass, &netValue); err == nil {
+				report.NetWorth += netValue
+				report.ByAssetClass[assetClass] = netValue
+			}
+			return
+		}, "SELECT asset_class, SUM(net_value) FROM financial_accounts WHERE user_id = ? GROUP BY asset_class", userID,
+	); err != nil {
+		return internal.SummaryReport{}, err
+	}
+	// Transfers, like paying off a credit card, move money between the user's accounts without spending it.
+	err = db.queryRows(
+		ctx, func(rows *sql.Rows) (err error) {
+			var (
+				transactionType string
+				spent           int64
+			)
+			if err = rows.Scan(&transactionType, &spent); err == nil {
+				report.SpendingByType[transactionType] = spent
+			}
+			return
+		}, `SELECT type, SUM(spent) FROM (
+			SELECT t.type, -t.amount AS spent FROM transactions t
+			WHERE t.user_id = ? AND t.amount < 0 AND t.transfer_id IS NULL
+			AND NOT EXISTS (SELECT 1 FROM transaction_splits s WHERE s.transaction_id = t.id)
+			UNION ALL
+			SELECT s.type, -s.amount FROM transaction_splits s JOIN transactions t ON t.id = s.transaction_id
+			WHERE s.user_id = ? AND t.amount < 0 AND t.transfer_id IS NULL
+		) spending GROUP BY type`, userID, userID,
+	)
+	if err != nil {
+		return internal.SummaryReport{}, err
+	}
+	return
+}
+
+// queryRows runs query and hands every row to scan.
+func (db *MySQLDatabase) queryRows(
+	ctx context.Context, scan func(rows *sql.Rows) error, query string, args ...any,
+) (err error) {
+	rows, err := db.underlyingDB.QueryContext(ctx, query, args...)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error querying rows", "err", err)
+		return ErrInternal
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		if err = scan(rows); err != nil {
+			slog.ErrorContext(ctx, "Error scanning row", "err", err)
+			return ErrInternal
+		}
+	}
+	if err = rows.Err(); err != nil {
+		slog.ErrorContext(ctx, "Error querying rows", "err", err)
+		return ErrInternal
+	}
+	return
+}
+
+// ImportUserData sets the profile of a user and adds the accounts and transactions of data to theirs, all or nothing.
+// Records get new IDs, institutions are matched by name and added when missing, and transactions are no longer tied
+// to recurring transactions. Transfers stay linked, and other transactions are linked as transfers when one of the
+// user's transactions on another account looks like the other side. Profiles are refused with validation.Errors like
+// UpdateUserProfile does, and splits that don't add up to their transaction's amount like SetSplits refuses them.
+func (db *MySQLDatabase) ImportUserData(
+	ctx context.Context, userID uint64, profile validation.Profile, data internal.UserData,
+) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.ImportUserData")
+	defer func() { span.RecordError(err); span.End() }()
+	birthDate, err := profile.Validate(db.now())
+	if err != nil {
+		slog.InfoContext(ctx, "Refused import with invalid profile", "user_id", userID, "err", err)
+		return
+	}
+	var detected int
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			if _, err = tx.ExecContext(
+				ctx, "UPDATE users SET first_name = ?, middle_name = ?, last_name = ?, date_of_birth = ? WHERE id = ?",
+				profile.FirstName, profile.MiddleName, profile.LastName, birthDate.Format(validation.DateLayout),
+				userID,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error importing profile", "user_id", userID, "err", err)
+				return internalError(err)
+			}
+			institutions := make(map[uint64]uint64, len(data.Institutions))
+			for _, institution := range data.Institutions {
+				if institutions[institution.ID], err = importInstitution(
+					ctx, tx, userID, institution.Name,
+				); err != nil {
+					return
+				}
+			}
+			accounts := make(map[uint64]uint64, len(data.Accounts))
+			// Exports made before transactions had dates have none, those are taken to have happened today.
+			today := db.today().Format(validation.DateLayout)
+			for _, account := range data.Accounts {
+				institutionID, ok := institutions[account.InstitutionID]
+				if !ok {
+					return ErrDanglingReference
+				}
+				result, err := tx.ExecContext(
+					ctx, `INSERT INTO financial_accounts (user_id, institution_id, asset_class, name, net_value)
+					VALUES (?, ?, ?, ?, ?)`,
+					userID, institutionID, account.AssetClass, account.Name, account.NetValue,
+				)
+				if err != nil {
+					slog.ErrorContext(ctx, "Error importing account", "user_id", userID, "err", err)
+					return internalError(err)
+				}
+				id, err := result.LastInsertId()
+				if err != nil {
+					slog.ErrorContext(ctx, "Error importing account", "user_id", userID, "err", err)
+					return internalError(err)
+				}
+				accounts[account.ID] = uint64(id)
+			}
+			imported := make([]internal.Transaction, 0, len(data.Transactions))
+			transactions := make(map[uint64]uint64, len(data.Transactions))
+			for _, transaction := range data.Transactions {
+				accountID, ok := accounts[transaction.FinancialAccountID]
+				if !ok {
+					return ErrDanglingReference
+				}
+				if transaction.Date == "" {
+					transaction.Date = today
+				}
+				result, err := tx.ExecContext(
+					ctx, `INSERT INTO transactions (user_id, financial_account_id, amount, type, occurred_on)
+					VALUES (?, ?, ?, ?, ?)`,
+					userID, accountID, transaction.Amount, transaction.Type, transaction.Date,
+				)
+				if err != nil {
+					slog.ErrorContext(ctx, "Error importing transaction", "user_id", userID, "err", err)
+					return internalError(err)
+				}
+				id, err := result.LastInsertId()
+				if err != nil {
+					slog.ErrorContext(ctx, "Error importing transaction", "user_id", userID, "err", err)
+					return internalError(err)
+				}
+				transactions[transaction.ID] = uint64(id)
+				transaction.ID, transaction.FinancialAccountID = uint64(id), accountID
+				errs := validation.Errors{}
+				if checkSplits(errs, transaction.Amount, transaction.Splits); len(errs) > 0 {
+					return errs
+				}
+				if err = addSplits(ctx, tx, userID, transaction); err != nil {
+					return err
+				}
+				imported = append(imported, transaction)
+			}
+			for i, transaction := range imported {
+				if transaction.TransferID == 0 {
+					continue
+				}
+				otherID, ok := transactions[transaction.TransferID]
+				if !ok {
+					return ErrDanglingReference
+				}
+				imported[i].TransferID = otherID
+				// Each transfer is linked once, from the side imported first.
+				if transaction.ID < otherID {
+					if err = linkTransfer(ctx, tx, userID, transaction.ID, otherID); err != nil {
+						return
+					}
+				}
+			}
+			if detected, err = detectTransfers(ctx, tx, userID, imported); err != nil || len(data.Transactions) == 0 {
+				return
+			}
+			return db.queueWebhookEvent(
+				ctx, tx, userID, webhooks.TransactionsImported,
+				map[string]int{
+					"accounts": len(data.Accounts), "transactions": len(data.Transactions),
+					"detected_transfers": detected,
+				},
+			)
+		},
+	); err != nil {
+		return
+	}
+	slog.InfoContext(
+		ctx, "User data imported", "user_id", userID, "accounts", len(data.Accounts),
+		"transactions", len(data.Transactions), "detected_transfers", detected,
+	)
+	return
+}
+
+// importInstitution returns the id of the institution called name a user can hold accounts at, adding it for them
+// if there is none.
+func importInstitution(ctx context.Context, tx *sql.Tx, userID uint64, name string) (id uint64, err error) {
+	err = tx.QueryRowContext(
+		ctx, "SELECT id FROM institutions WHERE name = ? AND (user_id IS NULL OR user_id = ?) ORDER BY id LIMIT 1",
+		name, userID,
+	).Scan(&id)
+	if err == nil {
+		return
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		slog.ErrorContext(ctx, "Error selecting institution", "err", err)
+		return 0, internalError(err)
+	}
+	result, err := tx.ExecContext(ctx, "INSERT INTO institutions (user_id, name) VALUES (?, ?)", userID, name)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error importing institution", "err", err)
+		return 0, internalError(err)
+	}
+	inserted, err := result.LastInsertId()
+	if err != nil {
+		slog.ErrorContext(ctx, "Error importing institution", "err", err)
+		return 0, internalError(err)
+	}
+	return uint64(inserted), nil
+}
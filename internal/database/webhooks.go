@@ -0,0 +1,333 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal/tracing"
+	"github.com/matcha-devs/matcha/internal/validation"
+	"github.com/matcha-devs/matcha/internal/webhooks"
+)
+
+const (
+	maxWebhooks      = 10
+	maxWebhookURLLen = 2048
+	// recentDeliveries is how many deliveries of every webhook the delivery log shows.
+	recentDeliveries = 10
+	// deliveryRetention is how long finished deliveries stay in the log.
+	deliveryRetention = 30 * 24 * time.Hour
+)
+
+// Webhook is a URL a user has subscribed to events, with the log of its latest deliveries, newest first.
+type Webhook struct {
+	ID         uint64
+	URL        string
+	Events     []webhooks.Event
+	CreatedOn  time.Time
+	Deliveries []WebhookDelivery
+}
+
+// WebhookDelivery is an attempt at sending an event to a webhook, Status is 0 until a receiver has answered.
+type WebhookDelivery struct {
+	ID          uint64
+	Event       webhooks.Event
+	Attempts    int
+	Status      int
+	LastError   string
+	CreatedOn   time.Time
+	DeliveredOn time.Time
+	FailedOn    time.Time
+}
+
+// AddWebhook subscribes rawURL to events of a user, returning the secret its deliveries are signed with. Invalid
+// URLs and events are refused with validation.Errors.
+func (db *MySQLDatabase) AddWebhook(
+	ctx context.Context, userID uint64, rawURL string, events []webhooks.Event,
+) (secret string, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.AddWebhook")
+	defer func() { span.RecordError(err); span.End() }()
+	errs := validation.Errors{}
+	rawURL = strings.TrimSpace(rawURL)
+	if parsed, err := url.Parse(rawURL); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") ||
+		parsed.Host == "" || parsed.User != nil {
+		errs.Add("url", "please enter an http or https URL")
+	} else if len(rawURL) > maxWebhookURLLen {
+		errs.Add("url", "please enter a shorter URL")
+	}
+	if len(events) == 0 {
+		errs.Add("events", "please choose at least one event")
+	}
+	joined := make([]string, len(events))
+	for i, event := range events {
+		if !slices.Contains(webhooks.Events, event) {
+			errs.Add("events", "unknown event "+string(event))
+		}
+		joined[i] = string(event)
+	}
+	if len(errs) > 0 {
+		return "", errs
+	}
+	if secret, err = webhooks.NewSecret(); err != nil {
+		slog.ErrorContext(ctx, "Error generating webhook secret", "err", err)
+		return "", ErrInternal
+	}
+	err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			var count int
+			if err = tx.QueryRowContext(
+				ctx, "SELECT COUNT(*) FROM webhooks WHERE user_id = ? FOR UPDATE", userID,
+			).Scan(&count); err != nil {
+				slog.ErrorContext(ctx, "Error counting webhooks", "user_id", userID, "err", err)
+				return internalError(err)
+			} else if count >= maxWebhooks {
+				return validation.Errors{"url": "you can't have more than 10 webhooks"}
+			}
+			if _, err = tx.ExecContext(
+				ctx, "INSERT INTO webhooks (user_id, url, secret, events) VALUES (?, ?, ?, ?)",
+				userID, rawURL, secret, strings.Join(joined, ","),
+			); err != nil {
+				slog.ErrorContext(ctx, "Error adding webhook", "user_id", userID, "err", err)
+				return internalError(err)
+			}
+			return
+		},
+	)
+	if err != nil {
+		return "", err
+	}
+	slog.InfoContext(ctx, "Webhook added", "user_id", userID, "events", joined)
+	return
+}
+
+// GetWebhooks lists the webhooks of a user, oldest first.
+func (db *MySQLDatabase) GetWebhooks(ctx context.Context, userID uint64) (hooks []Webhook, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.GetWebhooks")
+	defer func() { span.RecordError(err); span.End() }()
+	if err = db.queryRows(
+		ctx, func(rows *sql.Rows) (err error) {
+			var (
+				hook   Webhook
+				events string
+			)
+			if err = rows.Scan(&hook.ID, &hook.URL, &events, &hook.CreatedOn); err == nil {
+				for _, event := range strings.Split(events, ",") {
+					hook.Events = append(hook.Events, webhooks.Event(event))
+				}
+				hooks = append(hooks, hook)
+			}
+			return
+		}, "SELECT id, url, events, created_on FROM webhooks WHERE user_id = ? ORDER BY id", userID,
+	); err != nil {
+		return nil, err
+	}
+	for i := range hooks {
+		if err = db.queryRows(
+			ctx, func(rows *sql.Rows) (err error) {
+				var (
+					delivery              WebhookDelivery
+					status                sql.NullInt64
+					lastError             sql.NullString
+					deliveredOn, failedOn sql.NullTime
+				)
+				if err = rows.Scan(
+					&delivery.ID, &delivery.Event, &delivery.Attempts, &status, &lastError, &delivery.CreatedOn,
+					&deliveredOn, &failedOn,
+				); err == nil {
+					delivery.Status, delivery.LastError = int(status.Int64), lastError.String
+					delivery.DeliveredOn, delivery.FailedOn = deliveredOn.Time, failedOn.Time
+					hooks[i].Deliveries = append(hooks[i].Deliveries, delivery)
+				}
+				return
+			}, `SELECT id, event, attempts, response_status, last_error, created_on, delivered_on, failed_on
+			FROM webhook_deliveries WHERE webhook_id = ? ORDER BY id DESC LIMIT ?`, hooks[i].ID, recentDeliveries,
+		); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// DeleteWebhook unsubscribes a webhook of a user, dropping its deliveries including pending ones.
+func (db *MySQLDatabase) DeleteWebhook(ctx context.Context, userID, id uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.DeleteWebhook")
+	defer func() { span.RecordError(err); span.End() }()
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			if _, err = tx.ExecContext(
+				ctx, "DELETE FROM webhook_deliveries WHERE webhook_id = ? AND user_id = ?", id, userID,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error deleting webhook deliveries", "user_id", userID, "err", err)
+				return internalError(err)
+			}
+			if _, err = tx.ExecContext(
+				ctx, "DELETE FROM webhooks WHERE id = ? AND user_id = ?", id, userID,
+			); err != nil {
+				slog.ErrorContext(ctx, "Error deleting webhook", "user_id", userID, "err", err)
+				return internalError(err)
+			}
+			return
+		},
+	); err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "Webhook deleted", "user_id", userID, "webhook_id", id)
+	return
+}
+
+// SendTestWebhook queues a webhooks.TestEvent for a webhook of a user, ErrNotFound if they have none with that id.
+func (db *MySQLDatabase) SendTestWebhook(ctx context.Context, userID, id uint64) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.SendTestWebhook")
+	defer func() { span.RecordError(err); span.End() }()
+	payload, err := webhooks.NewPayload(
+		webhooks.TestEvent, map[string]string{"message": "This is a test event from Matcha."}, db.now(),
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error encoding webhook payload", "err", err)
+		return ErrInternal
+	}
+	result, err := db.underlyingDB.ExecContext(
+		ctx, `INSERT INTO webhook_deliveries (webhook_id, user_id, event, payload, next_attempt_on)
+		SELECT id, user_id, ?, ?, ? FROM webhooks WHERE id = ? AND user_id = ?`,
+		webhooks.TestEvent, payload, db.now(), id, userID,
+	)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error queueing test webhook", "user_id", userID, "err", err)
+		return ErrInternal
+	}
+	if queued, err := result.RowsAffected(); err != nil {
+		slog.ErrorContext(ctx, "Error queueing test webhook", "user_id", userID, "err", err)
+		return ErrInternal
+	} else if queued != 1 {
+		return ErrNotFound
+	}
+	return
+}
+
+// queueWebhookEvent queues an event for every webhook of the user subscribed to it within tx, so events are only
+// sent for changes that were committed. Deleted users get none.
+func (db *MySQLDatabase) queueWebhookEvent(
+	ctx context.Context, tx *sql.Tx, userID uint64, event webhooks.Event, data any,
+) (err error) {
+	payload, err := webhooks.NewPayload(event, data, db.now())
+	if err != nil {
+		slog.ErrorContext(ctx, "Error encoding webhook payload", "event", event, "err", err)
+		return internalError(err)
+	}
+	if _, err = tx.ExecContext(
+		ctx, `INSERT INTO webhook_deliveries (webhook_id, user_id, event, payload, next_attempt_on)
+		SELECT w.id, w.user_id, ?, ?, ? FROM webhooks w JOIN users u ON u.id = w.user_id AND u.deleted_on IS NULL
+		WHERE w.user_id = ? AND FIND_IN_SET(?, w.events)`,
+		event, payload, db.now(), userID, event,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error queueing webhook event", "user_id", userID, "event", event, "err", err)
+		return internalError(err)
+	}
+	return
+}
+
+// ClaimWebhookDeliveries implements webhooks.Store, rows locked by another instance's claim are skipped rather than
+// waited on. Deliveries of deleted users wait until they are restored or purged. Finished deliveries older than
+// deliveryRetention are cleared out along the way.
+func (db *MySQLDatabase) ClaimWebhookDeliveries(
+	ctx context.Context, limit int, lease time.Duration,
+) (due []webhooks.Delivery, err error) {
+	ctx, span := tracing.Start(ctx, "mysql.ClaimWebhookDeliveries")
+	defer func() { span.RecordError(err); span.End() }()
+	now := db.now()
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, `DELETE FROM webhook_deliveries
+		WHERE (delivered_on IS NOT NULL OR failed_on IS NOT NULL) AND created_on < ?`, now.Add(-deliveryRetention),
+	); err != nil {
+		slog.ErrorContext(ctx, "Error deleting old webhook deliveries", "err", err)
+		return nil, ErrInternal
+	}
+	if err = db.WithTx(
+		ctx, func(tx *sql.Tx) (err error) {
+			due = nil
+			rows, err := tx.QueryContext(
+				ctx, `SELECT d.id, w.url, w.secret, d.event, d.payload, d.attempts
+				FROM webhook_deliveries d JOIN webhooks w ON w.id = d.webhook_id
+				JOIN users u ON u.id = d.user_id AND u.deleted_on IS NULL
+				WHERE d.delivered_on IS NULL AND d.failed_on IS NULL AND d.next_attempt_on <= ?
+				ORDER BY d.next_attempt_on LIMIT ? FOR UPDATE OF d SKIP LOCKED`,
+				now, limit,
+			)
+			if err != nil {
+				slog.ErrorContext(ctx, "Error selecting due webhook deliveries", "err", err)
+				return internalError(err)
+			}
+			for rows.Next() {
+				var delivery webhooks.Delivery
+				if err = rows.Scan(
+					&delivery.ID, &delivery.URL, &delivery.Secret, &delivery.Event, &delivery.Payload,
+					&delivery.Attempts,
+				); err != nil {
+					_ = rows.Close()
+					slog.ErrorContext(ctx, "Error scanning due webhook delivery", "err", err)
+					return internalError(err)
+				}
+				delivery.Attempts++
+				due = append(due, delivery)
+			}
+			if err = rows.Err(); err != nil {
+				slog.ErrorContext(ctx, "Error selecting due webhook deliveries", "err", err)
+				return internalError(err)
+			}
+			for _, delivery := range due {
+				if _, err = tx.ExecContext(
+					ctx, "UPDATE webhook_deliveries SET attempts = ?, next_attempt_on = ? WHERE id = ?",
+					delivery.Attempts, now.Add(lease), delivery.ID,
+				); err != nil {
+					slog.ErrorContext(ctx, "Error leasing webhook delivery", "delivery_id", delivery.ID, "err", err)
+					return internalError(err)
+				}
+			}
+			return
+		},
+	); err != nil {
+		return nil, err
+	}
+	return
+}
+
+// MarkWebhookDelivered implements webhooks.Store.
+func (db *MySQLDatabase) MarkWebhookDelivered(ctx context.Context, id uint64, status int) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.MarkWebhookDelivered")
+	defer func() { span.RecordError(err); span.End() }()
+	if _, err = db.underlyingDB.ExecContext(
+		ctx, "UPDATE webhook_deliveries SET delivered_on = ?, response_status = ?, last_error = NULL WHERE id = ?",
+		db.now(), status, id,
+	); err != nil {
+		slog.ErrorContext(ctx, "Error marking webhook delivered", "delivery_id", id, "err", err)
+		return ErrInternal
+	}
+	return
+}
+
+// MarkWebhookFailed implements webhooks.Store.
+func (db *MySQLDatabase) MarkWebhookFailed(
+	ctx context.Context, id uint64, status int, reason string, retryAt time.Time,
+) (err error) {
+	ctx, span := tracing.Start(ctx, "mysql.MarkWebhookFailed")
+	defer func() { span.RecordError(err); span.End() }()
+	if len(reason) > 1024 {
+		reason = reason[:1024]
+	}
+	responseStatus := sql.NullInt64{Int64: int64(status), Valid: status != 0}
+	query := "UPDATE webhook_deliveries SET response_status = ?, last_error = ?, next_attempt_on = ? WHERE id = ?"
+	args := []any{responseStatus, reason, retryAt, id}
+	if retryAt.IsZero() {
+		query = "UPDATE webhook_deliveries SET response_status = ?, last_error = ?, failed_on = ? WHERE id = ?"
+		args = []any{responseStatus, reason, db.now(), id}
+	}
+	if _, err = db.underlyingDB.ExecContext(ctx, query, args...); err != nil {
+		slog.ErrorContext(ctx, "Error marking webhook failed", "delivery_id", id, "err", err)
+		return ErrInternal
+	}
+	return
+}
@@ -0,0 +1,103 @@
+package forecast
+
+import (
+	"time"
+
+	"github.com/matcha-devs/matcha/internal/recurrence"
+)
+
+// Account is a financial account as it stands when the forecast starts, its balance in cents.
+type Account struct {
+	ID      uint64
+	Name    string
+	Balance int64
+}
+
+// Flow is money expected to move in or out of an account on a schedule, like a bill or a paycheck, in cents.
+type Flow struct {
+	AccountID uint64
+	Name      string
+	Amount    int64
+	Rule      recurrence.Rule
+	// Start is the day the schedule started and First its first occurrence still to come, occurrences before the
+	// forecast starts are taken to happen on its first day. End is zero for schedules that go on for good.
+	Start, First, End time.Time
+}
+
+// Forecast projects the balances of accounts day by day, the From and To days included, as YYYY-MM-DD.
+type Forecast struct {
+	From     string            `json:"from"`
+	To       string            `json:"to"`
+	Accounts []AccountForecast `json:"accounts"`
+	Warnings []Warning         `json:"warnings"`
+}
+
+// AccountForecast is the balance of an account at the end of every day forecast, along with its lowest.
+type AccountForecast struct {
+	AccountID uint64  `json:"account_id"`
+	Name      string  `json:"name"`
+	Balances  []Point `json:"balances"`
+	Lowest    int64   `json:"lowest"`
+	LowestOn  string  `json:"lowest_on"`
+}
+
+type Point struct {
+	Date    string `json:"date"`
+	Balance int64  `json:"balance"`
+}
+
+// Warning is the first day, Date, an account is projected to go below zero, with its Balance that day. Accounts below
+// zero from the start, like credit cards, only warn when they are projected to come back above first.
+type Warning struct {
+	AccountID uint64 `json:"account_id"`
+	Name      string `json:"name"`
+	Date      string `json:"date"`
+	Balance   int64  `json:"balance"`
+}
+
+// Project forecasts accounts over days starting from, moved by the occurrences of flows. Flows on accounts that
+// aren't forecast are left out.
+func Project(accounts []Account, flows []Flow, from time.Time, days int) (forecast Forecast) {
+	from = recurrence.Day(from)
+	to := from.AddDate(0, 0, max(days, 1)-1)
+	forecast = Forecast{
+		From: from.Format(time.DateOnly), To: to.Format(time.DateOnly),
+		Accounts: []AccountForecast{}, Warnings: []Warning{},
+	}
+	changes := make(map[uint64][]int64, len(accounts))
+	for _, account := range accounts {
+		changes[account.ID] = make([]int64, max(days, 1))
+	}
+	for _, flow := range flows {
+		deltas, ok := changes[flow.AccountID]
+		if !ok {
+			continue
+		}
+		last := to
+		if !flow.End.IsZero() && flow.End.Before(last) {
+			last = flow.End
+		}
+		for _, day := range flow.Rule.Between(flow.Start, flow.First, last) {
+			deltas[max(int(day.Sub(from).Hours()/24), 0)] += flow.Amount
+		}
+	}
+	for _, account := range accounts {
+		projected := AccountForecast{AccountID: account.ID, Name: account.Name, Lowest: account.Balance}
+		balance, below, warned := account.Balance, account.Balance < 0, false
+		for i, delta := range changes[account.ID] {
+			balance += delta
+			date := from.AddDate(0, 0, i).Format(time.DateOnly)
+			projected.Balances = append(projected.Balances, Point{date, balance})
+			if i == 0 || balance < projected.Lowest {
+				projected.Lowest, projected.LowestOn = balance, date
+			}
+			if balance < 0 && !below && !warned {
+				forecast.Warnings = append(forecast.Warnings, Warning{account.ID, account.Name, date, balance})
+				warned = true
+			}
+			below = balance < 0
+		}
+		forecast.Accounts = append(forecast.Accounts, projected)
+	}
+	return
+}
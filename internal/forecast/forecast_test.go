@@ -0,0 +1,62 @@
+package forecast
+
+import (
+	"testing"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal/recurrence"
+)
+
+func day(text string) time.Time {
+	parsed, err := time.Parse(time.DateOnly, text)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func rule(text string) recurrence.Rule {
+	parsed, err := recurrence.Parse(text)
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+func TestProject(t *testing.T) {
+	accounts := []Account{{1, "Checking", 100000}, {2, "Card", -5000}}
+	flows := []Flow{
+		// Rent is overdue, so it is taken out on the first day.
+		{1, "Rent", -150000, rule("FREQ=MONTHLY"), day("2026-01-01"), day("2026-02-28"), time.Time{}},
+		{1, "Salary", 200000, rule("FREQ=WEEKLY;INTERVAL=2"), day("2026-03-06"), day("2026-03-06"), time.Time{}},
+		{2, "Gym", -4000, rule("FREQ=MONTHLY;BYMONTHDAY=15"), day("2026-01-15"), day("2026-03-15"), day("2026-04-30")},
+		{3, "Elsewhere", -1, rule("FREQ=DAILY"), day("2026-01-01"), day("2026-01-01"), time.Time{}},
+	}
+	forecast := Project(accounts, flows, time.Date(2026, time.March, 2, 18, 0, 0, 0, time.UTC), 90)
+	if forecast.From != "2026-03-02" || forecast.To != "2026-05-30" || len(forecast.Accounts) != 2 {
+		t.Fatal("got", forecast.From, forecast.To, len(forecast.Accounts), "expected both accounts over 90 days")
+	}
+	checking := forecast.Accounts[0]
+	if len(checking.Balances) != 90 || checking.Balances[0].Balance != -50000 {
+		t.Fatal("got", checking.Balances[:1], "expected the overdue rent on the first day")
+	}
+	// Paid on the 6th and 20th of March, 3rd and 17th of April, 1st, 15th and 29th of May, rent due on the 1st of
+	// March, April and May.
+	if got := checking.Balances[89]; got.Date != "2026-05-30" || got.Balance != 100000-3*150000+7*200000 {
+		t.Error("got", got, "expected three rents and seven paychecks")
+	}
+	if checking.Lowest != -50000 || checking.LowestOn != "2026-03-02" {
+		t.Error("got", checking.Lowest, checking.LowestOn, "expected the lowest balance on the first day")
+	}
+	card := forecast.Accounts[1]
+	if got := card.Balances[89].Balance; got != -5000-2*4000 {
+		t.Error("got", got, "expected the gym to end after April")
+	}
+	if len(forecast.Warnings) != 1 || forecast.Warnings[0].AccountID != 1 || forecast.Warnings[0].Date != "2026-03-02" {
+		t.Error("got", forecast.Warnings, "expected checking to warn, the card being negative all along")
+	}
+
+	if forecast = Project(accounts[:1], flows[1:2], day("2026-03-02"), 30); len(forecast.Warnings) != 0 {
+		t.Error("got", forecast.Warnings, "expected no warning without rent")
+	}
+}
@@ -0,0 +1,254 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Component is a dependency of the application started after, and stopped before, the components it depends on.
+// Start must not block, long-running work belongs on goroutines bound to the context it is given, see Background.
+type Component struct {
+	Name            string
+	DependsOn       []string
+	Start           func(ctx context.Context) (err error)
+	Stop            func(ctx context.Context) (err error)
+	ShutdownTimeout time.Duration
+}
+
+// Failure records a component which failed to stop, or did not stop before its deadline.
+type Failure struct {
+	Component string
+	Err       error
+}
+
+// Report summarizes a shutdown.
+type Report struct {
+	Stopped  []string
+	Failures []Failure
+}
+
+func (report Report) Err() error {
+	errs := make([]error, 0, len(report.Failures))
+	for _, failure := range report.Failures {
+		errs = append(errs, fmt.Errorf("%s: %w", failure.Component, failure.Err))
+	}
+	return errors.Join(errs...)
+}
+
+type Manager struct {
+	defaultTimeout time.Duration
+	mu             sync.Mutex
+	components     []Component
+	started        []Component
+}
+
+// New makes a manager giving components without a ShutdownTimeout the default one.
+func New(defaultTimeout time.Duration) *Manager {
+	return &Manager{defaultTimeout: defaultTimeout}
+}
+
+func (manager *Manager) Register(component Component) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+	manager.components = append(manager.components, component)
+}
+
+// order sorts the components so that each comes after its dependencies, otherwise keeping registration order.
+func (manager *Manager) order() (ordered []Component, err error) {
+	byName := make(map[string]Component, len(manager.components))
+	for _, component := range manager.components {
+		if _, duplicate := byName[component.Name]; duplicate {
+			return nil, fmt.Errorf("component %q registered twice", component.Name)
+		}
+		byName[component.Name] = component
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int, len(byName))
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("dependency cycle %s", strings.Join(append(path, name), " -> "))
+		default:
+		}
+		state[name] = visiting
+		for _, dependency := range byName[name].DependsOn {
+			if _, exists := byName[dependency]; !exists {
+				return fmt.Errorf("component %q depends on unregistered %q", name, dependency)
+			}
+			if err := visit(dependency, append(path, name)); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		ordered = append(ordered, byName[name])
+		return nil
+	}
+	for _, component := range manager.components {
+		if err = visit(component.Name, nil); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// Start starts every component in dependency order, stopping the ones already started if any of them fails.
+func (manager *Manager) Start(ctx context.Context) (err error) {
+	manager.mu.Lock()
+	ordered, err := manager.order()
+	manager.mu.Unlock()
+	if err != nil {
+		return
+	}
+	for _, component := range ordered {
+		slog.Info("Starting component", "component", component.Name)
+		if component.Start != nil {
+			if err = component.Start(ctx); err != nil {
+				err = fmt.Errorf("starting %s: %w", component.Name, err)
+				slog.Error("Component failed to start", "component", component.Name, "err", err)
+				manager.Stop()
+				return
+			}
+		}
+		manager.mu.Lock()
+		manager.started = append(manager.started, component)
+		manager.mu.Unlock()
+	}
+	return
+}
+
+// Stop stops the started components in reverse dependency order, each within its own shutdown deadline.
+func (manager *Manager) Stop() (report Report) {
+	manager.mu.Lock()
+	started := slices.Clone(manager.started)
+	manager.started = nil
+	manager.mu.Unlock()
+	slices.Reverse(started)
+	for _, component := range started {
+		if err := manager.stop(component); err != nil {
+			slog.Error("Component failed to stop", "component", component.Name, "err", err)
+			report.Failures = append(report.Failures, Failure{component.Name, err})
+		} else {
+			slog.Info("Stopped component", "component", component.Name)
+			report.Stopped = append(report.Stopped, component.Name)
+		}
+	}
+	return
+}
+
+func (manager *Manager) stop(component Component) (err error) {
+	if component.Stop == nil {
+		return
+	}
+	timeout := component.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = manager.defaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- component.Stop(ctx)
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = fmt.Errorf("did not stop within %s: %w", timeout, ctx.Err())
+	}
+	return
+}
+
+// Background makes a component out of a long-running job, run on its own goroutine until the context it receives is
+// cancelled either by the root context or by stopping the component, which waits for run to return.
+func Background(name string, dependsOn []string, run func(ctx context.Context)) Component {
+	var (
+		cancel context.CancelFunc
+		done   = make(chan struct{})
+	)
+	return Component{
+		Name:      name,
+		DependsOn: dependsOn,
+		Start: func(ctx context.Context) (err error) {
+			ctx, cancel = context.WithCancel(ctx)
+			go func() {
+				defer close(done)
+				run(ctx)
+			}()
+			return
+		},
+		Stop: func(ctx context.Context) (err error) {
+			cancel()
+			select {
+			case <-done:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			return
+		},
+	}
+}
+
+// Tasks runs short jobs handed to it while its component is started, like work finished after answering a request,
+// each on its own goroutine. Stopping the component refuses new jobs and waits for the running ones.
+type Tasks struct {
+	mu      sync.Mutex
+	running sync.WaitGroup
+	open    bool
+}
+
+// Go runs task on its own goroutine and reports whether it did, which it doesn't while the component is stopped.
+func (tasks *Tasks) Go(task func()) (ok bool) {
+	tasks.mu.Lock()
+	defer tasks.mu.Unlock()
+	if !tasks.open {
+		return false
+	}
+	tasks.running.Add(1)
+	go func() {
+		defer tasks.running.Done()
+		task()
+	}()
+	return true
+}
+
+// Component makes a component accepting jobs from its start until its stop.
+func (tasks *Tasks) Component(name string, dependsOn []string) Component {
+	return Component{
+		Name:      name,
+		DependsOn: dependsOn,
+		Start: func(context.Context) (err error) {
+			tasks.mu.Lock()
+			defer tasks.mu.Unlock()
+			tasks.open = true
+			return
+		},
+		Stop: func(ctx context.Context) (err error) {
+			tasks.mu.Lock()
+			tasks.open = false
+			tasks.mu.Unlock()
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				tasks.running.Wait()
+			}()
+			select {
+			case <-done:
+			case <-ctx.Done():
+				err = ctx.Err()
+			}
+			return
+		},
+	}
+}
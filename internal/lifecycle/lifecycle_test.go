@@ -0,0 +1,169 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// recorder registers components that log their starts and stops in order.
+type recorder struct {
+	events []string
+}
+
+func (rec *recorder) component(name string, dependsOn ...string) Component {
+	return Component{
+		Name:      name,
+		DependsOn: dependsOn,
+		Start: func(context.Context) error {
+			rec.events = append(rec.events, "start "+name)
+			return nil
+		},
+		Stop: func(context.Context) error {
+			rec.events = append(rec.events, "stop "+name)
+			return nil
+		},
+	}
+}
+
+func TestOrdering(t *testing.T) {
+	rec := &recorder{}
+	manager := New(time.Second)
+	manager.Register(rec.component("server", "database", "mailer"))
+	manager.Register(rec.component("mailer", "database"))
+	manager.Register(rec.component("database"))
+	if err := manager.Start(context.Background()); err != nil {
+		t.Fatal("Failed to start -", err)
+	}
+	report := manager.Stop()
+	if err := report.Err(); err != nil {
+		t.Fatal("Failed to stop -", err)
+	}
+	expected := []string{
+		"start database", "start mailer", "start server", "stop server", "stop mailer", "stop database",
+	}
+	if !slices.Equal(rec.events, expected) {
+		t.Error("got", rec.events, "expected", expected)
+	}
+	if !slices.Equal(report.Stopped, []string{"server", "mailer", "database"}) {
+		t.Error("got stopped", report.Stopped)
+	}
+}
+
+func TestInvalidDependencies(t *testing.T) {
+	testCases := []struct {
+		name       string
+		components []Component
+	}{
+		{"unregistered", []Component{{Name: "server", DependsOn: []string{"database"}}}},
+		{"cycle", []Component{{Name: "a", DependsOn: []string{"b"}}, {Name: "b", DependsOn: []string{"a"}}}},
+		{"duplicate", []Component{{Name: "a"}, {Name: "a"}}},
+	}
+	for _, tc := range testCases {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				manager := New(time.Second)
+				for _, component := range tc.components {
+					manager.Register(component)
+				}
+				if err := manager.Start(context.Background()); err == nil {
+					t.Error("Expected an error starting", tc.name, "components")
+				}
+			},
+		)
+	}
+}
+
+func TestStartFailureStopsStarted(t *testing.T) {
+	rec := &recorder{}
+	manager := New(time.Second)
+	manager.Register(rec.component("database"))
+	broken := rec.component("server", "database")
+	broken.Start = func(context.Context) error { return errors.New("address in use") }
+	manager.Register(broken)
+	if err := manager.Start(context.Background()); err == nil {
+		t.Fatal("Expected start to fail")
+	}
+	if expected := []string{"start database", "stop database"}; !slices.Equal(rec.events, expected) {
+		t.Error("got", rec.events, "expected", expected)
+	}
+}
+
+func TestShutdownSummary(t *testing.T) {
+	manager := New(10 * time.Millisecond)
+	manager.Register(Component{Name: "stuck", Stop: func(context.Context) error { select {} }})
+	manager.Register(Component{Name: "broken", Stop: func(context.Context) error { return errors.New("boom") }})
+	manager.Register(Component{Name: "fine", Stop: func(context.Context) error { return nil }})
+	if err := manager.Start(context.Background()); err != nil {
+		t.Fatal("Failed to start -", err)
+	}
+	report := manager.Stop()
+	var failed []string
+	for _, failure := range report.Failures {
+		failed = append(failed, failure.Component)
+	}
+	if !slices.Equal(failed, []string{"broken", "stuck"}) {
+		t.Error("got failures", failed)
+	}
+	if !errors.Is(report.Err(), context.DeadlineExceeded) {
+		t.Error("Expected the stuck component to report its deadline -", report.Err())
+	}
+	if !slices.Equal(report.Stopped, []string{"fine"}) {
+		t.Error("got stopped", report.Stopped)
+	}
+}
+
+func TestBackground(t *testing.T) {
+	root, cancelRoot := context.WithCancel(context.Background())
+	defer cancelRoot()
+	ticks := make(chan struct{}, 1)
+	manager := New(time.Second)
+	manager.Register(
+		Background(
+			"job", nil, func(ctx context.Context) {
+				ticks <- struct{}{}
+				<-ctx.Done()
+			},
+		),
+	)
+	if err := manager.Start(root); err != nil {
+		t.Fatal("Failed to start -", err)
+	}
+	<-ticks
+	if err := manager.Stop().Err(); err != nil {
+		t.Error("Background job failed to drain -", err)
+	}
+}
+
+func TestTasks(t *testing.T) {
+	var tasks Tasks
+	if tasks.Go(func() {}) {
+		t.Error("Expected a task to be refused before starting")
+	}
+	manager := New(time.Second)
+	manager.Register(tasks.Component("tasks", nil))
+	if err := manager.Start(context.Background()); err != nil {
+		t.Fatal("Failed to start -", err)
+	}
+	release, finished := make(chan struct{}), false
+	if !tasks.Go(
+		func() {
+			<-release
+			finished = true
+		},
+	) {
+		t.Fatal("Expected a task to run once started")
+	}
+	go close(release)
+	if err := manager.Stop().Err(); err != nil {
+		t.Error("Tasks failed to drain -", err)
+	}
+	if !finished {
+		t.Error("Expected stopping to wait for the running task")
+	}
+	if tasks.Go(func() {}) {
+		t.Error("Expected a task to be refused after stopping")
+	}
+}
@@ -0,0 +1,120 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/matcha-devs/matcha/internal/tracing"
+)
+
+type contextKey struct{}
+
+const redacted = "[REDACTED]"
+
+var (
+	emailPattern  = regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`)
+	requestIDChar = regexp.MustCompile(`^[A-Za-z0-9\-_.]{1,64}$`)
+	secretKeys    = map[string]struct{}{"password": {}, "psw": {}, "psw-repeat": {}, "hash": {}, "token": {}}
+)
+
+// New builds a logger writing to w in the given format ("json", otherwise text) which redacts secrets and emails, and
+// tags every record logged with a context carrying a request ID or trace.
+func New(w io.Writer, format string, level slog.Leveler) *slog.Logger {
+	options := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	var handler slog.Handler
+	if strings.EqualFold(format, "json") {
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+	return slog.New(contextHandler{handler})
+}
+
+// ParseLevel maps a level name such as "debug" or "WARN" to its slog level, defaulting to info.
+func ParseLevel(name string) (level slog.Level) {
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return slog.LevelInfo
+	}
+	return
+}
+
+// Fatal logs at error level and exits, standing in for log.Fatalln.
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+func NewRequestID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		slog.Error("Failed to generate request id", "err", err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// ValidRequestID reports whether a client supplied ID is safe to adopt and echo back.
+func ValidRequestID(id string) bool {
+	return requestIDChar.MatchString(id)
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+	return id
+}
+
+// MaskEmail keeps the first character of the local part and the domain, e.g. "j***@example.com".
+func MaskEmail(email string) string {
+	at := strings.LastIndexByte(email, '@')
+	if at < 1 {
+		return redacted
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+func redact(_ []string, attr slog.Attr) slog.Attr {
+	if _, secret := secretKeys[strings.ToLower(attr.Key)]; secret {
+		return slog.String(attr.Key, redacted)
+	}
+	switch attr.Value.Kind() {
+	case slog.KindString:
+		attr.Value = slog.StringValue(emailPattern.ReplaceAllStringFunc(attr.Value.String(), MaskEmail))
+	case slog.KindAny:
+		if err, ok := attr.Value.Any().(error); ok {
+			attr.Value = slog.StringValue(emailPattern.ReplaceAllStringFunc(err.Error(), MaskEmail))
+		}
+	default:
+	}
+	return attr
+}
+
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if id := RequestID(ctx); id != "" {
+		record.AddAttrs(slog.String("request_id", id))
+	}
+	if sc := tracing.SpanContextFromContext(ctx); sc.IsValid() {
+		record.AddAttrs(slog.String("trace_id", sc.TraceID.String()), slog.String("span_id", sc.SpanID.String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
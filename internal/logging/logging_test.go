@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestRedaction(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "json", slog.LevelInfo)
+	logger.Info(
+		"Signup for someone@example.com", "email", "someone@example.com", "password", "hunter2",
+		"err", errors.New("duplicate entry 'someone@example.com' for key 'users.email'"),
+	)
+	out := buf.String()
+	if strings.Contains(out, "someone@example.com") {
+		t.Error("Email leaked into log -", out)
+	}
+	if strings.Contains(out, "hunter2") {
+		t.Error("Password leaked into log -", out)
+	}
+	if !strings.Contains(out, "s***@example.com") {
+		t.Error("Expected masked email in log -", out)
+	}
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "json", slog.LevelInfo)
+	logger.InfoContext(WithRequestID(context.Background(), "abc123"), "Request")
+	var record map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &record); err != nil {
+		t.Fatal("Failed to decode log record -", err)
+	}
+	if record["request_id"] != "abc123" {
+		t.Error("got request_id", record["request_id"], "expected abc123")
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	testCases := []struct {
+		id    string
+		valid bool
+	}{
+		{NewRequestID(), true},
+		{"", false},
+		{"has spaces", false},
+		{"evil\nnewline", false},
+		{strings.Repeat("a", 65), false},
+	}
+	for _, tc := range testCases {
+		if ValidRequestID(tc.id) != tc.valid {
+			t.Errorf("ValidRequestID(%q) expected %v", tc.id, tc.valid)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/quotedprintable"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Message is an email to a single recipient, HTML is optional.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, message Message) (err error)
+}
+
+// LogMailer only logs messages, it is the default for local development.
+type LogMailer struct{}
+
+func (LogMailer) Send(ctx context.Context, message Message) (err error) {
+	slog.InfoContext(ctx, "Email", "to", message.To, "subject", message.Subject, "body", message.Text)
+	return
+}
+
+// FileMailer drops each message into Dir as an .eml file any mail client can open.
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+var fileSequence atomic.Uint64
+
+func (mailer FileMailer) Send(ctx context.Context, message Message) (err error) {
+	if err = os.MkdirAll(mailer.Dir, 0o700); err != nil {
+		return
+	}
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().UTC().Format("20060102T150405.000000000"), fileSequence.Add(1)%10000)
+	path := filepath.Join(mailer.Dir, name)
+	if err = os.WriteFile(path, message.render(mailer.From, time.Now()), 0o600); err != nil {
+		return
+	}
+	slog.InfoContext(ctx, "Email written", "to", message.To, "subject", message.Subject, "path", path)
+	return
+}
+
+// render encodes the message as an RFC 5322 email, multipart/alternative when it has an HTML version.
+func (message Message) render(from string, date time.Time) []byte {
+	var b bytes.Buffer
+	if from != "" {
+		b.WriteString("From: " + headerValue(from) + "\r\n")
+	}
+	b.WriteString("To: " + headerValue(message.To) + "\r\n")
+	b.WriteString("Subject: " + mime.QEncoding.Encode("utf-8", headerValue(message.Subject)) + "\r\n")
+	b.WriteString("Date: " + date.Format(time.RFC1123Z) + "\r\n")
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if message.HTML == "" {
+		writePart(&b, "text/plain", message.Text)
+		return b.Bytes()
+	}
+	boundary := newBoundary()
+	b.WriteString("Content-Type: multipart/alternative; boundary=\"" + boundary + "\"\r\n\r\n")
+	// Clients show the last part they understand, so the HTML version goes after the text one.
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain", message.Text}, {"text/html", message.HTML},
+	} {
+		b.WriteString("--" + boundary + "\r\n")
+		writePart(&b, part.contentType, part.body)
+		b.WriteString("\r\n")
+	}
+	b.WriteString("--" + boundary + "--\r\n")
+	return b.Bytes()
+}
+
+// writePart writes the headers and quoted-printable body of one part, which keeps lines short for SMTP servers.
+func writePart(b *bytes.Buffer, contentType, body string) {
+	b.WriteString("Content-Type: " + contentType + "; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(b)
+	// Writing to a bytes.Buffer can't fail.
+	_, _ = w.Write([]byte(strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")))
+	_ = w.Close()
+}
+
+func newBoundary() string {
+	buf := make([]byte, 16)
+	_, _ = rand.Read(buf)
+	return "matcha-" + hex.EncodeToString(buf)
+}
+
+// headerValue drops line breaks so a value can't smuggle extra headers into a message.
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
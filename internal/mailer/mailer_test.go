@@ -0,0 +1,136 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	mailer := FileMailer{Dir: dir}
+	for _, subject := range []string{"First", "Second"} {
+		if err := mailer.Send(
+			context.Background(), Message{To: "someone@example.com", Subject: subject, Text: "Hello\nthere"},
+		); err != nil {
+			t.Fatal("Failed to send -", err)
+		}
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal("Failed to read outbox -", err)
+	}
+	if len(files) != 2 {
+		t.Fatal("got", len(files), "files, expected 2")
+	}
+	eml, err := os.ReadFile(filepath.Join(dir, files[0].Name()))
+	if err != nil {
+		t.Fatal("Failed to read email -", err)
+	}
+	for _, expected := range []string{"To: someone@example.com\r\n", "Subject: First\r\n", "\r\n\r\nHello\r\nthere"} {
+		if !strings.Contains(string(eml), expected) {
+			t.Errorf("Expected %q in:\n%s", expected, eml)
+		}
+	}
+}
+
+func TestRenderAlternative(t *testing.T) {
+	eml := string(
+		Message{To: "someone@example.com", Subject: "Héllo", Text: "Plain", HTML: "<p>Rich</p>"}.render(
+			"Matcha <no-reply@example.com>", time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC),
+		),
+	)
+	for _, expected := range []string{
+		"From: Matcha <no-reply@example.com>\r\n", "Subject: =?utf-8?q?H=C3=A9llo?=\r\n",
+		"Content-Type: multipart/alternative; boundary=", "Content-Type: text/plain; charset=utf-8\r\n",
+		"Content-Type: text/html; charset=utf-8\r\n", "\r\n\r\nPlain\r\n", "\r\n\r\n<p>Rich</p>\r\n",
+	} {
+		if !strings.Contains(eml, expected) {
+			t.Errorf("Expected %q in:\n%s", expected, eml)
+		}
+	}
+	if strings.Index(eml, "text/plain") > strings.Index(eml, "text/html") {
+		t.Error("Expected the HTML part last")
+	}
+}
+
+func TestRender(t *testing.T) {
+	message, err := Render("password-reset", map[string]any{"Link": "https://example.com/reset?token=a&b"})
+	if err != nil {
+		t.Fatal("Failed to render -", err)
+	}
+	if message.Subject != "Reset your Matcha password" {
+		t.Error("got subject", message.Subject)
+	}
+	if !strings.Contains(message.Text, "https://example.com/reset?token=a&b") {
+		t.Error("Expected the raw link in the text version:\n", message.Text)
+	}
+	if !strings.Contains(message.HTML, `href="https://example.com/reset?token=a&amp;b"`) {
+		t.Error("Expected the escaped link in the HTML version:\n", message.HTML)
+	}
+}
+
+type fakeStore struct {
+	queued  []Queued
+	sent    []uint64
+	retryAt map[uint64]time.Time
+}
+
+func (store *fakeStore) ClaimMail(_ context.Context, limit int, _ time.Duration) (mail []Queued, err error) {
+	n := min(limit, len(store.queued))
+	mail, store.queued = store.queued[:n], store.queued[n:]
+	return
+}
+
+func (store *fakeStore) MarkMailSent(_ context.Context, id uint64) (err error) {
+	store.sent = append(store.sent, id)
+	return
+}
+
+func (store *fakeStore) MarkMailFailed(_ context.Context, id uint64, _ string, retryAt time.Time) (err error) {
+	store.retryAt[id] = retryAt
+	return
+}
+
+type failingMailer struct {
+	failTo string
+}
+
+func (mailer failingMailer) Send(_ context.Context, message Message) (err error) {
+	if message.To == mailer.failTo {
+		return errors.New("relay refused")
+	}
+	return
+}
+
+func TestOutbox(t *testing.T) {
+	now := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	store := &fakeStore{retryAt: map[uint64]time.Time{}}
+	for id := uint64(1); id <= outboxBatch+2; id++ {
+		store.queued = append(store.queued, Queued{ID: id, Attempts: 1, Message: Message{To: "ok@example.com"}})
+	}
+	store.queued = append(
+		store.queued,
+		Queued{ID: 100, Attempts: 3, Message: Message{To: "bad@example.com"}},
+		Queued{ID: 101, Attempts: 8, Message: Message{To: "bad@example.com"}},
+	)
+	outbox := NewOutbox(store, failingMailer{failTo: "bad@example.com"})
+	outbox.now = func() time.Time { return now }
+
+	if sent := outbox.Drain(context.Background()); sent != outboxBatch+2 {
+		t.Error("got", sent, "sent, expected", outboxBatch+2)
+	}
+	if len(store.sent) != outboxBatch+2 {
+		t.Error("got", len(store.sent), "marked sent, expected", outboxBatch+2)
+	}
+	if retryAt := store.retryAt[100]; !retryAt.Equal(now.Add(4 * time.Minute)) {
+		t.Error("got retry at", retryAt, "expected the third attempt to back off 4m")
+	}
+	if retryAt, exists := store.retryAt[101]; !exists || !retryAt.IsZero() {
+		t.Error("Expected the last attempt to give up, got retry at", retryAt)
+	}
+}
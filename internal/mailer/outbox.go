@@ -0,0 +1,120 @@
+package mailer
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal/metrics"
+)
+
+var outboxMessages = metrics.NewCounter(
+	"matcha_mail_outbox_messages_total", "Outbox delivery attempts by result.", "result",
+)
+
+// Queued is a message waiting in the outbox, Attempts counts the current one.
+type Queued struct {
+	ID       uint64
+	Attempts int
+	Message  Message
+}
+
+// Store persists the outbox so queued mail survives restarts.
+type Store interface {
+	// ClaimMail hands out up to limit messages due for delivery, hiding them from other claims for lease.
+	ClaimMail(ctx context.Context, limit int, lease time.Duration) (mail []Queued, err error)
+	MarkMailSent(ctx context.Context, id uint64) (err error)
+	// MarkMailFailed schedules another attempt at retryAt, or gives up on the message when retryAt is zero.
+	MarkMailFailed(ctx context.Context, id uint64, reason string, retryAt time.Time) (err error)
+}
+
+const (
+	outboxBatch = 20
+	// outboxLease must outlast a batch of sends, otherwise a slow relay gets the same message sent twice.
+	outboxLease = 10 * time.Minute
+	retryBase   = time.Minute
+	retryMax    = 6 * time.Hour
+)
+
+// Outbox delivers queued mail in the background, retrying failures with exponential backoff.
+type Outbox struct {
+	store       Store
+	mailer      Mailer
+	interval    time.Duration
+	maxAttempts int
+	now         func() time.Time
+}
+
+func NewOutbox(store Store, mailer Mailer) *Outbox {
+	return &Outbox{store: store, mailer: mailer, interval: 5 * time.Second, maxAttempts: 8, now: time.Now}
+}
+
+// Run drains the outbox every interval until ctx is cancelled.
+func (outbox *Outbox) Run(ctx context.Context) {
+	ticker := time.NewTicker(outbox.interval)
+	defer ticker.Stop()
+	for {
+		outbox.Drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain sends every message currently due, returning how many were delivered.
+func (outbox *Outbox) Drain(ctx context.Context) (sent int) {
+	for ctx.Err() == nil {
+		mail, err := outbox.store.ClaimMail(ctx, outboxBatch, outboxLease)
+		if err != nil || len(mail) == 0 {
+			return
+		}
+		for _, queued := range mail {
+			if outbox.deliver(ctx, queued) {
+				sent++
+			}
+		}
+		if len(mail) < outboxBatch {
+			return
+		}
+	}
+	return
+}
+
+func (outbox *Outbox) deliver(ctx context.Context, queued Queued) (sent bool) {
+	sendCtx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+	err := outbox.mailer.Send(sendCtx, queued.Message)
+	if err == nil {
+		outboxMessages.Inc("sent")
+		if err = outbox.store.MarkMailSent(ctx, queued.ID); err != nil {
+			slog.ErrorContext(ctx, "Error marking mail sent", "mail_id", queued.ID, "err", err)
+		}
+		return true
+	}
+	var retryAt time.Time
+	if queued.Attempts < outbox.maxAttempts {
+		outboxMessages.Inc("retry")
+		retryAt = outbox.now().Add(retryDelay(queued.Attempts))
+		slog.WarnContext(ctx, "Error sending mail, will retry", "mail_id", queued.ID, "attempts", queued.Attempts,
+			"retry_at", retryAt, "err", err)
+	} else {
+		outboxMessages.Inc("failed")
+		slog.ErrorContext(ctx, "Error sending mail, giving up", "mail_id", queued.ID, "attempts", queued.Attempts,
+			"err", err)
+	}
+	if err = outbox.store.MarkMailFailed(ctx, queued.ID, err.Error(), retryAt); err != nil {
+		slog.ErrorContext(ctx, "Error marking mail failed", "mail_id", queued.ID, "err", err)
+	}
+	return false
+}
+
+// retryDelay doubles from retryBase after every failed attempt up to retryMax.
+func retryDelay(attempts int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempts && delay < retryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMax)
+}
@@ -0,0 +1,98 @@
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer delivers messages through an SMTP relay, upgrading the connection with STARTTLS whenever it is offered.
+// Credentials are only ever sent over TLS, or to a relay on localhost.
+type SMTPMailer struct {
+	Addr     string
+	Username string
+	Password string
+	From     string
+}
+
+func (mailer SMTPMailer) Send(ctx context.Context, message Message) (err error) {
+	host, _, err := net.SplitHostPort(mailer.Addr)
+	if err != nil {
+		return
+	}
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", mailer.Addr)
+	if err != nil {
+		return
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(time.Minute)
+	}
+	if err = conn.SetDeadline(deadline); err != nil {
+		_ = conn.Close()
+		return
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		_ = conn.Close()
+		return
+	}
+	defer func() {
+		// A successful Quit already closed the connection.
+		if err != nil {
+			_ = client.Close()
+		}
+	}()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err = client.StartTLS(&tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}); err != nil {
+			return
+		}
+	}
+	if mailer.Username != "" {
+		if err = client.Auth(smtp.PlainAuth("", mailer.Username, mailer.Password, host)); err != nil {
+			return
+		}
+	}
+	from, err := envelopeAddress(mailer.From)
+	if err != nil {
+		return
+	}
+	to, err := envelopeAddress(message.To)
+	if err != nil {
+		return
+	}
+	if err = client.Mail(from); err != nil {
+		return
+	}
+	if err = client.Rcpt(to); err != nil {
+		return
+	}
+	data, err := client.Data()
+	if err != nil {
+		return
+	}
+	if _, err = data.Write(message.render(mailer.From, time.Now())); err != nil {
+		return
+	}
+	if err = data.Close(); err != nil {
+		return
+	}
+	if err = client.Quit(); err == nil {
+		slog.InfoContext(ctx, "Email sent", "to", message.To, "subject", message.Subject)
+	}
+	return
+}
+
+// envelopeAddress extracts the bare address SMTP wants from one that may carry a display name.
+func envelopeAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", err
+	}
+	return parsed.Address, nil
+}
@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmlTemplate "html/template"
+	textTemplate "text/template"
+)
+
+var (
+	//go:embed templates
+	templateFiles embed.FS
+	textTemplates = textTemplate.Must(textTemplate.ParseFS(templateFiles, "templates/*.go.txt"))
+	htmlTemplates = htmlTemplate.Must(htmlTemplate.ParseFS(templateFiles, "templates/*.go.html"))
+)
+
+// Render builds the message called name from templates/name.go.txt, which also defines the "name.subject" template,
+// and from templates/name.go.html when there is one. The recipient is left for the caller to fill in.
+func Render(name string, data map[string]any) (message Message, err error) {
+	var b bytes.Buffer
+	if err = textTemplates.ExecuteTemplate(&b, name+".subject", data); err != nil {
+		return
+	}
+	message.Subject = b.String()
+	b.Reset()
+	if err = textTemplates.ExecuteTemplate(&b, name+".go.txt", data); err != nil {
+		return
+	}
+	message.Text = b.String()
+	if htmlTemplates.Lookup(name+".go.html") == nil {
+		return
+	}
+	b.Reset()
+	if err = htmlTemplates.ExecuteTemplate(&b, name+".go.html", data); err != nil {
+		return
+	}
+	message.HTML = b.String()
+	return
+}
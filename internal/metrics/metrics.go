@@ -0,0 +1,317 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets suit request latencies measured in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the registry package level constructors register on and Handler serves.
+var Default = NewRegistry()
+
+type collector interface {
+	write(w *bufio.Writer)
+}
+
+type Registry struct {
+	mu         sync.Mutex
+	collectors []collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (registry *Registry) register(c collector) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.collectors = append(registry.collectors, c)
+}
+
+// WriteTo writes every registered metric in the Prometheus text exposition format.
+func (registry *Registry) WriteTo(w io.Writer) (n int64, err error) {
+	registry.mu.Lock()
+	collectors := slices.Clone(registry.collectors)
+	registry.mu.Unlock()
+	counter := &countingWriter{w: w}
+	buf := bufio.NewWriter(counter)
+	for _, c := range collectors {
+		c.write(buf)
+	}
+	err = buf.Flush()
+	return counter.n, err
+}
+
+func (registry *Registry) Handler() http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+			if _, err := registry.WriteTo(w); err != nil {
+				slog.ErrorContext(r.Context(), "Error writing metrics", "err", err)
+			}
+		},
+	)
+}
+
+func Handler() http.Handler {
+	return Default.Handler()
+}
+
+// series is one labelled time series of a vector metric.
+type series[T any] struct {
+	labelValues []string
+	value       T
+}
+
+// vec holds the series of a metric keyed by their label values.
+type vec[T any] struct {
+	name, help, kind string
+	labelNames       []string
+	mu               sync.Mutex
+	series           map[string]*series[T]
+	init             func() T
+}
+
+func (v *vec[T]) with(labelValues []string, update func(value *T)) {
+	if len(labelValues) != len(v.labelNames) {
+		slog.Error("Metric label mismatch", "metric", v.name, "labels", v.labelNames, "values", labelValues)
+		return
+	}
+	key := strings.Join(labelValues, "\xff")
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	s, exists := v.series[key]
+	if !exists {
+		s = &series[T]{labelValues: slices.Clone(labelValues), value: v.init()}
+		v.series[key] = s
+	}
+	update(&s.value)
+}
+
+// sorted snapshots the series ordered by label values so scrapes are stable.
+func (v *vec[T]) sorted(snapshot func(T) T) (out []series[T]) {
+	v.mu.Lock()
+	for _, s := range v.series {
+		out = append(out, series[T]{s.labelValues, snapshot(s.value)})
+	}
+	v.mu.Unlock()
+	slices.SortFunc(
+		out, func(a, b series[T]) int {
+			return slices.Compare(a.labelValues, b.labelValues)
+		},
+	)
+	return
+}
+
+func (v *vec[T]) writeHeader(w *bufio.Writer) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.kind)
+}
+
+type Counter struct {
+	vec[float64]
+}
+
+// NewCounter registers a monotonically increasing counter partitioned by the given label names.
+func NewCounter(name, help string, labelNames ...string) *Counter {
+	return Default.NewCounter(name, help, labelNames...)
+}
+
+func (registry *Registry) NewCounter(name, help string, labelNames ...string) *Counter {
+	counter := &Counter{
+		vec[float64]{
+			name: name, help: help, kind: "counter", labelNames: labelNames, series: map[string]*series[float64]{},
+			init: func() float64 { return 0 },
+		},
+	}
+	registry.register(counter)
+	return counter
+}
+
+func (counter *Counter) Inc(labelValues ...string) {
+	counter.Add(1, labelValues...)
+}
+
+func (counter *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		slog.Error("Counters cannot decrease", "metric", counter.name, "delta", delta)
+		return
+	}
+	counter.with(labelValues, func(value *float64) { *value += delta })
+}
+
+func (counter *Counter) write(w *bufio.Writer) {
+	counter.writeHeader(w)
+	for _, s := range counter.sorted(func(value float64) float64 { return value }) {
+		writeSample(w, counter.name, counter.labelNames, s.labelValues, "", "", s.value)
+	}
+}
+
+type histogramValue struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+type Histogram struct {
+	vec[histogramValue]
+	buckets []float64
+}
+
+// NewHistogram registers a histogram with the given upper bucket bounds, DefaultBuckets when nil.
+func NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	return Default.NewHistogram(name, help, buckets, labelNames...)
+}
+
+func (registry *Registry) NewHistogram(name, help string, buckets []float64, labelNames ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = slices.Clone(buckets)
+	slices.Sort(buckets)
+	histogram := &Histogram{
+		vec: vec[histogramValue]{
+			name: name, help: help, kind: "histogram", labelNames: labelNames,
+			series: map[string]*series[histogramValue]{},
+			init:   func() histogramValue { return histogramValue{counts: make([]uint64, len(buckets))} },
+		},
+		buckets: buckets,
+	}
+	registry.register(histogram)
+	return histogram
+}
+
+func (histogram *Histogram) Observe(value float64, labelValues ...string) {
+	histogram.with(
+		labelValues, func(h *histogramValue) {
+			if i, _ := slices.BinarySearch(histogram.buckets, value); i < len(h.counts) {
+				h.counts[i]++
+			}
+			h.sum += value
+			h.count++
+		},
+	)
+}
+
+func (histogram *Histogram) write(w *bufio.Writer) {
+	histogram.writeHeader(w)
+	snapshot := func(h histogramValue) histogramValue {
+		h.counts = slices.Clone(h.counts)
+		return h
+	}
+	for _, s := range histogram.sorted(snapshot) {
+		var cumulative uint64
+		for i, bound := range histogram.buckets {
+			cumulative += s.value.counts[i]
+			writeSample(
+				w, histogram.name+"_bucket", histogram.labelNames, s.labelValues, "le", formatFloat(bound),
+				float64(cumulative),
+			)
+		}
+		writeSample(
+			w, histogram.name+"_bucket", histogram.labelNames, s.labelValues, "le", "+Inf", float64(s.value.count),
+		)
+		writeSample(w, histogram.name+"_sum", histogram.labelNames, s.labelValues, "", "", s.value.sum)
+		writeSample(w, histogram.name+"_count", histogram.labelNames, s.labelValues, "", "", float64(s.value.count))
+	}
+}
+
+// funcMetric samples its value on every scrape, for state owned elsewhere like connection pools.
+type funcMetric struct {
+	name, help, kind string
+	value            func() float64
+}
+
+func NewGaugeFunc(name, help string, value func() float64) {
+	Default.NewGaugeFunc(name, help, value)
+}
+
+func (registry *Registry) NewGaugeFunc(name, help string, value func() float64) {
+	registry.register(&funcMetric{name, help, "gauge", value})
+}
+
+// NewCounterFunc is NewGaugeFunc for values that only ever increase.
+func NewCounterFunc(name, help string, value func() float64) {
+	Default.NewCounterFunc(name, help, value)
+}
+
+func (registry *Registry) NewCounterFunc(name, help string, value func() float64) {
+	registry.register(&funcMetric{name, help, "counter", value})
+}
+
+func (metric *funcMetric) write(w *bufio.Writer) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", metric.name, escapeHelp(metric.help), metric.name,
+		metric.kind)
+	writeSample(w, metric.name, nil, nil, "", "", metric.value())
+}
+
+func writeSample(
+	w *bufio.Writer, name string, labelNames, labelValues []string, extraName, extraValue string, value float64,
+) {
+	_, _ = w.WriteString(name)
+	if len(labelNames) > 0 || extraName != "" {
+		_ = w.WriteByte('{')
+		for i, labelName := range labelNames {
+			if i > 0 {
+				_ = w.WriteByte(',')
+			}
+			_, _ = fmt.Fprintf(w, "%s=\"%s\"", labelName, escapeLabel(labelValues[i]))
+		}
+		if extraName != "" {
+			if len(labelNames) > 0 {
+				_ = w.WriteByte(',')
+			}
+			_, _ = fmt.Fprintf(w, "%s=\"%s\"", extraName, extraValue)
+		}
+		_ = w.WriteByte('}')
+	}
+	_ = w.WriteByte(' ')
+	_, _ = w.WriteString(formatFloat(value))
+	_ = w.WriteByte('\n')
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return helpEscaper.Replace(help)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (n int, err error) {
+	n, err = cw.w.Write(b)
+	cw.n += int64(n)
+	return
+}
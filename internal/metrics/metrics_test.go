@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+)
+
+func scrape(t *testing.T, registry *Registry) string {
+	t.Helper()
+	var out strings.Builder
+	if _, err := registry.WriteTo(&out); err != nil {
+		t.Fatal("Failed to write metrics -", err)
+	}
+	return out.String()
+}
+
+func TestCounter(t *testing.T) {
+	registry := NewRegistry()
+	counter := registry.NewCounter("test_requests_total", "Requests served.", "route", "code")
+	counter.Inc("GET /", "200")
+	counter.Inc("GET /", "200")
+	counter.Add(3, "POST \"x\"", "500")
+	counter.Inc("missing label")
+
+	expected := `# HELP test_requests_total Requests served.
+# TYPE test_requests_total counter
+test_requests_total{route="GET /",code="200"} 2
+test_requests_total{route="POST \"x\"",code="500"} 3
+`
+	if got := scrape(t, registry); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	registry := NewRegistry()
+	histogram := registry.NewHistogram("test_latency_seconds", "Latency.", []float64{1, 0.1}, "route")
+	histogram.Observe(0.05, "a")
+	histogram.Observe(0.1, "a")
+	histogram.Observe(0.5, "a")
+	histogram.Observe(7, "a")
+
+	expected := `# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{route="a",le="0.1"} 2
+test_latency_seconds_bucket{route="a",le="1"} 3
+test_latency_seconds_bucket{route="a",le="+Inf"} 4
+test_latency_seconds_sum{route="a"} 7.65
+test_latency_seconds_count{route="a"} 4
+`
+	if got := scrape(t, registry); got != expected {
+		t.Errorf("got:\n%s\nexpected:\n%s", got, expected)
+	}
+}
+
+func TestFuncMetrics(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewGaugeFunc("test_open_connections", "Open connections.", func() float64 { return 4 })
+	registry.NewCounterFunc("test_waits_total", "Waits.", func() float64 { return 9 })
+	got := scrape(t, registry)
+	if !strings.Contains(got, "# TYPE test_open_connections gauge\ntest_open_connections 4\n") {
+		t.Error("Missing gauge in:\n" + got)
+	}
+	if !strings.Contains(got, "# TYPE test_waits_total counter\ntest_waits_total 9\n") {
+		t.Error("Missing counter in:\n" + got)
+	}
+}
@@ -0,0 +1,271 @@
+package openapi
+
+import (
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Version of the OpenAPI specification documents are written in.
+const Version = "3.0.3"
+
+// Operation is an endpoint of an API, described by values of the Go types it reads and writes.
+type Operation struct {
+	Method string
+	// Path is relative to the server URL, {name} segments are path parameters.
+	Path        string
+	Summary     string
+	Description string
+	Query       []Param
+	// Request is a value of the type of the JSON body read, nil when there is none.
+	Request any
+	// Response is a value of the type of the JSON body written on success.
+	Response any
+	// Paginated responses hold a page of Response values in "data", along with the "next_cursor" to continue from.
+	Paginated bool
+	// Status is the status of successful responses, http.StatusOK when 0.
+	Status int
+	// Public operations need no credentials.
+	Public bool
+}
+
+// Param is a query parameter of an operation, integers when Integer and strings otherwise.
+type Param struct {
+	Name        string
+	Description string
+	Integer     bool
+}
+
+// Spec describes a whole API.
+type Spec struct {
+	Title   string
+	Version string
+	// ServerURL prefixes every operation path.
+	ServerURL string
+	// Error is a value of the type of every error response.
+	Error any
+	// Security names the security schemes of operations that aren't public, keyed by name.
+	Security   map[string]map[string]any
+	Operations []Operation
+}
+
+// Document generates the OpenAPI document of spec, ready to be encoded as JSON. Named struct types become schemas
+// of their own, their properties named by their json tags and required unless tagged omitempty.
+func (spec Spec) Document() (document map[string]any) {
+	components := make(map[string]any)
+	schemas := &schemas{components}
+	paths := make(map[string]map[string]any)
+	errorResponse := map[string]any{
+		"description": "Error",
+		"content":     jsonContent(schemas.of(reflect.TypeOf(spec.Error))),
+	}
+	for _, operation := range spec.Operations {
+		item := paths[operation.Path]
+		if item == nil {
+			item = make(map[string]any)
+			paths[operation.Path] = item
+		}
+		item[strings.ToLower(operation.Method)] = spec.operation(schemas, operation, errorResponse)
+	}
+	document = map[string]any{
+		"openapi":    Version,
+		"info":       map[string]any{"title": spec.Title, "version": spec.Version},
+		"servers":    []map[string]any{{"url": spec.ServerURL}},
+		"paths":      paths,
+		"components": map[string]any{"schemas": components},
+	}
+	if len(spec.Security) > 0 {
+		document["components"].(map[string]any)["securitySchemes"] = spec.Security
+	}
+	return
+}
+
+func (spec Spec) operation(schemas *schemas, operation Operation, errorResponse map[string]any) map[string]any {
+	var parameters []map[string]any
+	for _, segment := range strings.Split(operation.Path, "/") {
+		if name, ok := strings.CutPrefix(segment, "{"); ok {
+			parameters = append(
+				parameters, map[string]any{
+					"name": strings.TrimSuffix(name, "}"), "in": "path", "required": true,
+					"schema": map[string]any{"type": "integer", "format": "int64", "minimum": 1},
+				},
+			)
+		}
+	}
+	for _, param := range operation.Query {
+		schema := map[string]any{"type": "string"}
+		if param.Integer {
+			schema = map[string]any{"type": "integer", "format": "int64"}
+		}
+		parameters = append(
+			parameters,
+			map[string]any{"name": param.Name, "in": "query", "description": param.Description, "schema": schema},
+		)
+	}
+	status := operation.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := schemas.of(reflect.TypeOf(operation.Response))
+	if operation.Paginated {
+		response = map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"data":        map[string]any{"type": "array", "items": response},
+				"next_cursor": map[string]any{"type": "string"},
+			},
+			"required": []string{"data"},
+		}
+	}
+	described := map[string]any{
+		"summary":     operation.Summary,
+		"operationId": operationID(operation),
+		"responses": map[string]any{
+			strconv.Itoa(status): map[string]any{
+				"description": http.StatusText(status), "content": jsonContent(response),
+			},
+			"default": errorResponse,
+		},
+	}
+	if operation.Description != "" {
+		described["description"] = operation.Description
+	}
+	if len(parameters) > 0 {
+		described["parameters"] = parameters
+	}
+	if operation.Request != nil {
+		described["requestBody"] = map[string]any{
+			"required": true, "content": jsonContent(schemas.of(reflect.TypeOf(operation.Request))),
+		}
+	}
+	if operation.Public {
+		described["security"] = []map[string]any{}
+	} else if len(spec.Security) > 0 {
+		names := make([]string, 0, len(spec.Security))
+		for name := range spec.Security {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		var security []map[string]any
+		for _, name := range names {
+			security = append(security, map[string]any{name: []string{}})
+		}
+		described["security"] = security
+	}
+	return described
+}
+
+func jsonContent(schema map[string]any) map[string]any {
+	return map[string]any{"application/json": map[string]any{"schema": schema}}
+}
+
+// operationID names an operation after its method and path, like getAccountsById for GET /accounts/{id}.
+func operationID(operation Operation) string {
+	id := strings.ToLower(operation.Method)
+	for _, segment := range strings.Split(operation.Path, "/") {
+		if name, ok := strings.CutPrefix(segment, "{"); ok {
+			segment = "by_" + strings.TrimSuffix(name, "}")
+		}
+		for _, word := range strings.FieldsFunc(segment, func(r rune) bool { return r == '_' || r == '.' }) {
+			id += upperFirst(word)
+		}
+	}
+	return id
+}
+
+func upperFirst(word string) string {
+	r, size := utf8.DecodeRuneInString(word)
+	return string(unicode.ToUpper(r)) + word[size:]
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemas turns Go types into JSON schemas, collecting named structs into components.
+type schemas struct {
+	components map[string]any
+}
+
+func (schemas *schemas) of(t reflect.Type) map[string]any {
+	if t == nil {
+		return map[string]any{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemas.of(t.Elem())
+	case reflect.Struct:
+		if t == timeType {
+			return map[string]any{"type": "string", "format": "date-time"}
+		}
+		if t.Name() == "" {
+			return schemas.object(t)
+		}
+		name := upperFirst(t.Name())
+		if _, ok := schemas.components[name]; !ok {
+			// Claimed before it is described, so types referring to themselves end.
+			schemas.components[name] = map[string]any{}
+			schemas.components[name] = schemas.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + name}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": schemas.of(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemas.of(t.Elem())}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "format": "int64", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	default:
+		return map[string]any{}
+	}
+}
+
+func (schemas *schemas) object(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			embedded := schemas.object(field.Type)
+			for property, schema := range embedded["properties"].(map[string]any) {
+				properties[property] = schema
+			}
+			if embeddedRequired, ok := embedded["required"].([]string); ok {
+				required = append(required, embeddedRequired...)
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = schemas.of(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			required = append(required, name)
+		}
+	}
+	object := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		object["required"] = required
+	}
+	return object
+}
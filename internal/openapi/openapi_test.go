@@ -0,0 +1,93 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type account struct {
+	ID      uint64    `json:"id"`
+	Name    string    `json:"name"`
+	Note    string    `json:"note,omitempty"`
+	Opened  time.Time `json:"opened"`
+	Tags    []string  `json:"tags"`
+	Parent  *account  `json:"parent,omitempty"`
+	private int
+}
+
+type problem struct {
+	Message string `json:"message"`
+}
+
+func TestDocument(t *testing.T) {
+	document := Spec{
+		Title: "Test", Version: "1", ServerURL: "/api", Error: problem{},
+		Security: map[string]map[string]any{"bearer": {"type": "http", "scheme": "bearer"}},
+		Operations: []Operation{
+			{Method: "GET", Path: "/accounts", Response: account{}, Paginated: true,
+				Query: []Param{{Name: "limit", Integer: true}}},
+			{Method: "POST", Path: "/accounts", Request: account{}, Response: account{}, Status: 201},
+			{Method: "GET", Path: "/accounts/{id}", Description: "One account.", Response: account{}},
+			{Method: "GET", Path: "/openapi.json", Response: map[string]any{}, Public: true},
+		},
+	}.Document()
+	// Round trip through JSON, so the checks see the document as clients do.
+	encoded, err := json.Marshal(document)
+	if err != nil {
+		t.Fatal("Failed to encode document -", err)
+	}
+	var decoded map[string]any
+	if err = json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal("Failed to decode document -", err)
+	}
+	lookup := func(path ...string) (value any) {
+		value = decoded
+		for _, key := range path {
+			value = value.(map[string]any)[key]
+		}
+		return
+	}
+
+	schema := lookup("components", "schemas", "Account").(map[string]any)
+	properties := schema["properties"].(map[string]any)
+	if len(properties) != 6 || properties["private"] != nil {
+		t.Error("got properties", properties, "expected the exported fields only")
+	}
+	if !reflect.DeepEqual(schema["required"], []any{"id", "name", "opened", "tags"}) {
+		t.Error("got required", schema["required"], "expected the fields without omitempty")
+	}
+	if got := lookup("components", "schemas", "Account", "properties", "opened", "format"); got != "date-time" {
+		t.Error("got", got, "expected times as date-time strings")
+	}
+	parent := lookup("components", "schemas", "Account", "properties", "parent", "$ref")
+	if parent != "#/components/schemas/Account" {
+		t.Error("got", parent, "expected a reference to the schema itself")
+	}
+
+	list := lookup("paths", "/accounts", "get").(map[string]any)
+	if list["operationId"] != "getAccounts" {
+		t.Error("got", list["operationId"], "expected getAccounts")
+	}
+	page := lookup("paths", "/accounts", "get", "responses", "200", "content", "application/json", "schema")
+	if got := page.(map[string]any)["properties"].(map[string]any)["data"]; got == nil {
+		t.Error("got", page, "expected a page of accounts")
+	}
+	if got := lookup("paths", "/accounts", "post", "responses", "201", "description"); got != "Created" {
+		t.Error("got", got, "expected a 201 response")
+	}
+	if got := lookup("paths", "/accounts/{id}", "get", "description"); got != "One account." {
+		t.Error("got", got, "expected the description")
+	}
+	parameters := lookup("paths", "/accounts/{id}", "get", "parameters").([]any)
+	if len(parameters) != 1 || parameters[0].(map[string]any)["in"] != "path" {
+		t.Error("got", parameters, "expected the id path parameter")
+	}
+	if got := lookup("paths", "/openapi.json", "get", "security"); len(got.([]any)) != 0 {
+		t.Error("got", got, "expected public operations to need no credentials")
+	}
+	if got := lookup("paths", "/accounts", "get", "security"); len(got.([]any)) != 1 {
+		t.Error("got", got, "expected the bearer scheme")
+	}
+}
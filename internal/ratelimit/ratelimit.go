@@ -0,0 +1,149 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Clock is injected so tests can control time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var SystemClock Clock = systemClock{}
+
+// pruneThreshold is how many keys a limiter holds before it forgets the ones back at rest.
+const pruneThreshold = 10_000
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter keeps one token bucket per key (an IP, an account...), refilled at a steady rate up to its burst size.
+type Limiter struct {
+	perSecond float64
+	burst     float64
+	clock     Clock
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+}
+
+// NewLimiter allows burst events at once per key, then one more every interval.
+func NewLimiter(interval time.Duration, burst int, clock Clock) *Limiter {
+	return &Limiter{
+		perSecond: 1 / interval.Seconds(),
+		burst:     float64(burst),
+		clock:     clock,
+		buckets:   map[string]*bucket{},
+	}
+}
+
+func (limiter *Limiter) refill(b *bucket, now time.Time) {
+	b.tokens = math.Min(limiter.burst, b.tokens+now.Sub(b.last).Seconds()*limiter.perSecond)
+	b.last = now
+}
+
+// Allow takes a token for key, otherwise reporting how long until one is available.
+func (limiter *Limiter) Allow(key string) (ok bool, retryAfter time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	now := limiter.clock.Now()
+	b, exists := limiter.buckets[key]
+	if !exists {
+		if len(limiter.buckets) >= pruneThreshold {
+			limiter.prune(now)
+		}
+		b = &bucket{tokens: limiter.burst, last: now}
+		limiter.buckets[key] = b
+	}
+	limiter.refill(b, now)
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / limiter.perSecond * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune forgets full buckets, they behave exactly like new ones.
+func (limiter *Limiter) prune(now time.Time) {
+	for key, b := range limiter.buckets {
+		if limiter.refill(b, now); b.tokens >= limiter.burst {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+type failures struct {
+	count int
+	last  time.Time
+}
+
+// Backoff imposes progressively longer delays on a key after consecutive failures: base after the first, doubling
+// with every further failure up to max.
+type Backoff struct {
+	base, max time.Duration
+	clock     Clock
+	mu        sync.Mutex
+	failures  map[string]*failures
+}
+
+func NewBackoff(base, max time.Duration, clock Clock) *Backoff {
+	return &Backoff{base: base, max: max, clock: clock, failures: map[string]*failures{}}
+}
+
+func (backoff *Backoff) delay(count int) time.Duration {
+	if count == 0 {
+		return 0
+	}
+	delay := backoff.base
+	for i := 1; i < count && delay < backoff.max; i++ {
+		delay *= 2
+	}
+	return min(delay, backoff.max)
+}
+
+// Wait reports how long key must wait before its next attempt.
+func (backoff *Backoff) Wait(key string) time.Duration {
+	backoff.mu.Lock()
+	defer backoff.mu.Unlock()
+	f, exists := backoff.failures[key]
+	if !exists {
+		return 0
+	}
+	return max(0, f.last.Add(backoff.delay(f.count)).Sub(backoff.clock.Now()))
+}
+
+func (backoff *Backoff) Fail(key string) {
+	backoff.mu.Lock()
+	defer backoff.mu.Unlock()
+	now := backoff.clock.Now()
+	if len(backoff.failures) >= pruneThreshold {
+		for k, f := range backoff.failures {
+			// Keys quiet for twice the longest delay have served their time.
+			if now.Sub(f.last) > 2*backoff.max {
+				delete(backoff.failures, k)
+			}
+		}
+	}
+	f, exists := backoff.failures[key]
+	if !exists {
+		f = &failures{}
+		backoff.failures[key] = f
+	}
+	f.count++
+	f.last = now
+}
+
+func (backoff *Backoff) Reset(key string) {
+	backoff.mu.Lock()
+	defer backoff.mu.Unlock()
+	delete(backoff.failures, key)
+}
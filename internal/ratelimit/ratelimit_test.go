@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (clock *fakeClock) Now() time.Time {
+	return clock.now
+}
+
+func (clock *fakeClock) Advance(d time.Duration) {
+	clock.now = clock.now.Add(d)
+}
+
+func TestLimiter(t *testing.T) {
+	clock := &fakeClock{time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)}
+	limiter := NewLimiter(10*time.Second, 3, clock)
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("1.2.3.4"); !ok {
+			t.Fatal("Burst attempt", i, "was refused")
+		}
+	}
+	ok, retryAfter := limiter.Allow("1.2.3.4")
+	if ok {
+		t.Fatal("Expected the drained bucket to refuse")
+	}
+	if retryAfter != 10*time.Second {
+		t.Error("got retry after", retryAfter, "expected 10s")
+	}
+	if ok, _ := limiter.Allow("5.6.7.8"); !ok {
+		t.Error("Keys should not share buckets")
+	}
+
+	clock.Advance(5 * time.Second)
+	if ok, retryAfter := limiter.Allow("1.2.3.4"); ok || retryAfter != 5*time.Second {
+		t.Error("got", ok, retryAfter, "expected a refusal for another 5s")
+	}
+	clock.Advance(5 * time.Second)
+	if ok, _ := limiter.Allow("1.2.3.4"); !ok {
+		t.Error("Expected a token to have refilled")
+	}
+	clock.Advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		if ok, _ := limiter.Allow("1.2.3.4"); !ok {
+			t.Error("Expected refill to stop at the burst size, refused attempt", i)
+		}
+	}
+	if ok, _ := limiter.Allow("1.2.3.4"); ok {
+		t.Error("Expected refill to stop at the burst size")
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	clock := &fakeClock{time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)}
+	backoff := NewBackoff(time.Second, 8*time.Second, clock)
+
+	if wait := backoff.Wait("someone"); wait != 0 {
+		t.Fatal("got wait", wait, "before any failure")
+	}
+	for _, expected := range []time.Duration{1, 2, 4, 8, 8} {
+		backoff.Fail("someone")
+		if wait := backoff.Wait("someone"); wait != expected*time.Second {
+			t.Error("got wait", wait, "expected", expected*time.Second)
+		}
+	}
+	clock.Advance(3 * time.Second)
+	if wait := backoff.Wait("someone"); wait != 5*time.Second {
+		t.Error("got wait", wait, "expected the delay to count down to 5s")
+	}
+	backoff.Reset("someone")
+	if wait := backoff.Wait("someone"); wait != 0 {
+		t.Error("got wait", wait, "after reset")
+	}
+}
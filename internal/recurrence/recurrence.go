@@ -0,0 +1,283 @@
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is how often the periods of a rule come around, its occurrences are picked within each period.
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// maxPeriods bounds the search for the next occurrence, a rule that picks no day in that many periods never will.
+const maxPeriods = 1000
+
+var ErrInvalid = errors.New("invalid recurrence rule")
+
+var weekdayCodes = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+// BusinessDays are the weekdays that BYDAY=MO,TU,WE,TH,FR stands for.
+var BusinessDays = []time.Weekday{time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday}
+
+// Rule is the subset of RFC 5545 recurrence rules that bills and paychecks need: FREQ, INTERVAL, BYDAY weekdays,
+// a single BYMONTHDAY and a single BYSETPOS. Negative month days and positions count from the end, so the last
+// business day of every month is "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1". Unlike RFC 5545, month days past
+// the end of a month fall on its last day, so bills due on the 31st are due on the 30th in April.
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	Weekdays []time.Weekday
+	MonthDay int
+	SetPos   int
+}
+
+// Parse reads a rule like "FREQ=WEEKLY;INTERVAL=2", with or without an "RRULE:" prefix.
+func Parse(text string) (rule Rule, err error) {
+	rule.Interval = 1
+	text = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(text)), "RRULE:")
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(text, ";") {
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || seen[name] {
+			return Rule{}, fmt.Errorf("%w: %q", ErrInvalid, part)
+		}
+		seen[name] = true
+		switch name {
+		case "FREQ":
+			rule.Freq = Frequency(value)
+			if !slices.Contains([]Frequency{Daily, Weekly, Monthly, Yearly}, rule.Freq) {
+				return Rule{}, fmt.Errorf("%w: unsupported frequency %q", ErrInvalid, value)
+			}
+		case "INTERVAL":
+			if rule.Interval, err = strconv.Atoi(value); err != nil || rule.Interval < 1 || rule.Interval > 999 {
+				return Rule{}, fmt.Errorf("%w: INTERVAL must be between 1 and 999", ErrInvalid)
+			}
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				day := slices.Index(weekdayCodes, code)
+				if day < 0 {
+					return Rule{}, fmt.Errorf("%w: unsupported weekday %q", ErrInvalid, code)
+				}
+				if !slices.Contains(rule.Weekdays, time.Weekday(day)) {
+					rule.Weekdays = append(rule.Weekdays, time.Weekday(day))
+				}
+			}
+		case "BYMONTHDAY":
+			if rule.MonthDay, err = strconv.Atoi(value); err != nil || rule.MonthDay == 0 || rule.MonthDay < -31 ||
+				rule.MonthDay > 31 {
+				return Rule{}, fmt.Errorf("%w: BYMONTHDAY must be a single day between -31 and 31", ErrInvalid)
+			}
+		case "BYSETPOS":
+			if rule.SetPos, err = strconv.Atoi(value); err != nil || rule.SetPos == 0 || rule.SetPos < -31 ||
+				rule.SetPos > 31 {
+				return Rule{}, fmt.Errorf("%w: BYSETPOS must be a single position between -31 and 31", ErrInvalid)
+			}
+		default:
+			return Rule{}, fmt.Errorf("%w: unsupported part %q", ErrInvalid, name)
+		}
+	}
+	switch {
+	case rule.Freq == "":
+		return Rule{}, fmt.Errorf("%w: FREQ is required", ErrInvalid)
+	case rule.MonthDay != 0 && rule.Freq != Monthly:
+		return Rule{}, fmt.Errorf("%w: BYMONTHDAY needs FREQ=MONTHLY", ErrInvalid)
+	case rule.MonthDay != 0 && len(rule.Weekdays) > 0:
+		return Rule{}, fmt.Errorf("%w: BYMONTHDAY can't be combined with BYDAY", ErrInvalid)
+	case len(rule.Weekdays) > 0 && rule.Freq == Yearly:
+		return Rule{}, fmt.Errorf("%w: BYDAY isn't supported with FREQ=YEARLY", ErrInvalid)
+	case rule.SetPos != 0 && len(rule.Weekdays) == 0:
+		return Rule{}, fmt.Errorf("%w: BYSETPOS needs BYDAY", ErrInvalid)
+	}
+	slices.SortFunc(rule.Weekdays, func(a, b time.Weekday) int { return mondayFirst(a) - mondayFirst(b) })
+	return
+}
+
+// String writes the rule the way Parse reads it.
+func (rule Rule) String() string {
+	parts := []string{"FREQ=" + string(rule.Freq)}
+	if rule.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(rule.Interval))
+	}
+	if len(rule.Weekdays) > 0 {
+		codes := make([]string, len(rule.Weekdays))
+		for i, day := range rule.Weekdays {
+			codes[i] = weekdayCodes[day]
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if rule.MonthDay != 0 {
+		parts = append(parts, "BYMONTHDAY="+strconv.Itoa(rule.MonthDay))
+	}
+	if rule.SetPos != 0 {
+		parts = append(parts, "BYSETPOS="+strconv.Itoa(rule.SetPos))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Describe puts the rule in words, like "every 2 weeks" or "monthly on the last business day".
+func (rule Rule) Describe() (description string) {
+	units := map[Frequency]string{Daily: "day", Weekly: "week", Monthly: "month", Yearly: "year"}
+	adverbs := map[Frequency]string{Daily: "daily", Weekly: "weekly", Monthly: "monthly", Yearly: "yearly"}
+	description = adverbs[rule.Freq]
+	if rule.Interval > 1 {
+		description = fmt.Sprintf("every %d %ss", rule.Interval, units[rule.Freq])
+	}
+	switch {
+	case rule.MonthDay == -1:
+		description += " on the last day"
+	case rule.MonthDay < 0:
+		description += fmt.Sprintf(" %d days before the end of the month", -rule.MonthDay-1)
+	case rule.MonthDay > 0:
+		description += " on day " + strconv.Itoa(rule.MonthDay)
+	case len(rule.Weekdays) > 0:
+		days := "business day"
+		if !slices.Equal(rule.Weekdays, BusinessDays) {
+			names := make([]string, len(rule.Weekdays))
+			for i, day := range rule.Weekdays {
+				names[i] = day.String()
+			}
+			days = strings.Join(names, " or ")
+		}
+		switch rule.SetPos {
+		case 0:
+			description += " on every " + days
+		case -1:
+			description += " on the last " + days
+		case 1:
+			description += " on the first " + days
+		case -2:
+			description += " on the second to last " + days
+		default:
+			if rule.SetPos < 0 {
+				description += fmt.Sprintf(" on %s number %d from the end", days, -rule.SetPos)
+			} else {
+				description += fmt.Sprintf(" on %s number %d", days, rule.SetPos)
+			}
+		}
+	}
+	return
+}
+
+// Next returns the first occurrence on or after from of a schedule that started on start, the zero time if there is
+// none. Dates are days, times of day are ignored.
+func (rule Rule) Next(start, from time.Time) time.Time {
+	start, from = Day(start), Day(from)
+	if from.Before(start) {
+		from = start
+	}
+	for k := max(rule.periodsBetween(start, from)-1, 0); k < rule.periodsBetween(start, from)+maxPeriods; k++ {
+		for _, day := range rule.period(start, k) {
+			if !day.Before(from) {
+				return day
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// Between lists the occurrences from from to to, both included, of a schedule that started on start.
+func (rule Rule) Between(start, from, to time.Time) (days []time.Time) {
+	to = Day(to)
+	for day := rule.Next(start, from); !day.IsZero() && !day.After(to); day = rule.Next(start, day.AddDate(0, 0, 1)) {
+		days = append(days, day)
+	}
+	return
+}
+
+// Day is the date of t at midnight UTC, the form every date of this package is in.
+func Day(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// periodsBetween is about how many periods of the rule go from start to day, never more.
+func (rule Rule) periodsBetween(start, day time.Time) int {
+	var units int
+	switch rule.Freq {
+	case Daily:
+		units = int(day.Sub(start).Hours() / 24)
+	case Weekly:
+		units = int(day.Sub(start).Hours()/24) / 7
+	case Monthly:
+		units = (day.Year()-start.Year())*12 + int(day.Month()-start.Month())
+	case Yearly:
+		units = day.Year() - start.Year()
+	}
+	return max(units/rule.Interval, 0)
+}
+
+// period lists the occurrences, in order, of the kth period of a schedule that started on start.
+func (rule Rule) period(start time.Time, k int) (days []time.Time) {
+	var candidates []time.Time
+	switch rule.Freq {
+	case Daily:
+		day := start.AddDate(0, 0, k*rule.Interval)
+		if len(rule.Weekdays) == 0 || slices.Contains(rule.Weekdays, day.Weekday()) {
+			candidates = append(candidates, day)
+		}
+	case Weekly:
+		monday := start.AddDate(0, 0, -mondayFirst(start.Weekday())+7*k*rule.Interval)
+		weekdays := rule.Weekdays
+		if len(weekdays) == 0 {
+			weekdays = []time.Weekday{start.Weekday()}
+		}
+		for i := range 7 {
+			if day := monday.AddDate(0, 0, i); slices.Contains(weekdays, day.Weekday()) {
+				candidates = append(candidates, day)
+			}
+		}
+	case Monthly:
+		first := time.Date(start.Year(), start.Month()+time.Month(k*rule.Interval), 1, 0, 0, 0, 0, time.UTC)
+		switch {
+		case rule.MonthDay != 0:
+			candidates = append(candidates, monthDay(first, rule.MonthDay))
+		case len(rule.Weekdays) > 0:
+			for day := first; day.Month() == first.Month(); day = day.AddDate(0, 0, 1) {
+				if slices.Contains(rule.Weekdays, day.Weekday()) {
+					candidates = append(candidates, day)
+				}
+			}
+		default:
+			candidates = append(candidates, monthDay(first, start.Day()))
+		}
+	case Yearly:
+		first := time.Date(start.Year()+k*rule.Interval, start.Month(), 1, 0, 0, 0, 0, time.UTC)
+		candidates = append(candidates, monthDay(first, start.Day()))
+	}
+	if rule.SetPos > 0 && rule.SetPos <= len(candidates) {
+		candidates = candidates[rule.SetPos-1 : rule.SetPos]
+	} else if rule.SetPos < 0 && -rule.SetPos <= len(candidates) {
+		candidates = candidates[len(candidates)+rule.SetPos : len(candidates)+rule.SetPos+1]
+	} else if rule.SetPos != 0 {
+		candidates = nil
+	}
+	for _, day := range candidates {
+		if !day.Before(start) {
+			days = append(days, day)
+		}
+	}
+	return
+}
+
+// monthDay is the nth day of the month starting on first, counted from its end when negative, kept within the month.
+func monthDay(first time.Time, n int) time.Time {
+	last := first.AddDate(0, 1, -1).Day()
+	if n < 0 {
+		n = last + n + 1
+	}
+	return first.AddDate(0, 0, min(max(n, 1), last)-1)
+}
+
+// mondayFirst numbers weekdays from Monday, 0, to Sunday, 6.
+func mondayFirst(day time.Weekday) int {
+	return (int(day) + 6) % 7
+}
@@ -0,0 +1,113 @@
+package recurrence
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func date(text string) time.Time {
+	day, err := time.Parse(time.DateOnly, text)
+	if err != nil {
+		panic(err)
+	}
+	return day
+}
+
+func TestParse(t *testing.T) {
+	for _, tc := range []struct {
+		text, expected string
+	}{
+		{"FREQ=MONTHLY", "FREQ=MONTHLY"},
+		{"rrule:freq=weekly;interval=2", "FREQ=WEEKLY;INTERVAL=2"},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1", "FREQ=MONTHLY;BYMONTHDAY=-1"},
+		{"FREQ=MONTHLY;BYSETPOS=-1;BYDAY=FR,MO,TU,WE,TH", "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1"},
+		{"FREQ=DAILY;INTERVAL=1", "FREQ=DAILY"},
+	} {
+		rule, err := Parse(tc.text)
+		if err != nil || rule.String() != tc.expected {
+			t.Errorf("Parse(%q) = %q %v, expected %q", tc.text, rule, err, tc.expected)
+		}
+	}
+	for _, text := range []string{
+		"", "INTERVAL=2", "FREQ=HOURLY", "FREQ=MONTHLY;INTERVAL=0", "FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=WEEKLY;BYMONTHDAY=1", "FREQ=MONTHLY;BYSETPOS=1", "FREQ=MONTHLY;BYDAY=1MO", "FREQ=YEARLY;BYDAY=MO",
+		"FREQ=MONTHLY;FREQ=WEEKLY", "FREQ=MONTHLY;COUNT=3",
+	} {
+		if _, err := Parse(text); !errors.Is(err, ErrInvalid) {
+			t.Errorf("Parse(%q) = %v, expected ErrInvalid", text, err)
+		}
+	}
+}
+
+func TestBetween(t *testing.T) {
+	for _, tc := range []struct {
+		rule, start, from, to string
+		expected              []string
+	}{
+		{"FREQ=MONTHLY", "2026-01-31", "2026-01-01", "2026-04-30",
+			[]string{"2026-01-31", "2026-02-28", "2026-03-31", "2026-04-30"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=15", "2026-01-20", "2026-01-01", "2026-03-31",
+			[]string{"2026-02-15", "2026-03-15"}},
+		{"FREQ=WEEKLY;INTERVAL=2", "2026-01-02", "2026-01-10", "2026-02-14",
+			[]string{"2026-01-16", "2026-01-30", "2026-02-13"}},
+		{"FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1", "2026-01-01", "2026-01-01", "2026-05-31",
+			[]string{"2026-01-30", "2026-02-27", "2026-03-31", "2026-04-30", "2026-05-29"}},
+		{"FREQ=MONTHLY;BYMONTHDAY=-1", "2024-01-15", "2024-01-01", "2024-03-31",
+			[]string{"2024-01-31", "2024-02-29", "2024-03-31"}},
+		{"FREQ=YEARLY", "2024-02-29", "2024-01-01", "2026-12-31",
+			[]string{"2024-02-29", "2025-02-28", "2026-02-28"}},
+		{"FREQ=DAILY;INTERVAL=3", "2026-01-01", "2031-06-01", "2031-06-07",
+			[]string{"2031-06-01", "2031-06-04", "2031-06-07"}},
+		{"FREQ=WEEKLY;BYDAY=MO,FR", "2026-01-07", "2026-01-01", "2026-01-13",
+			[]string{"2026-01-09", "2026-01-12"}},
+	} {
+		rule, err := Parse(tc.rule)
+		if err != nil {
+			t.Fatal("Failed to parse", tc.rule, "-", err)
+		}
+		var got []string
+		for _, day := range rule.Between(date(tc.start), date(tc.from), date(tc.to)) {
+			got = append(got, day.Format(time.DateOnly))
+		}
+		if len(got) != len(tc.expected) {
+			t.Errorf("%s from %s got %v, expected %v", tc.rule, tc.start, got, tc.expected)
+			continue
+		}
+		for i := range got {
+			if got[i] != tc.expected[i] {
+				t.Errorf("%s from %s got %v, expected %v", tc.rule, tc.start, got, tc.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	rule, _ := Parse("FREQ=MONTHLY;BYDAY=SA;BYSETPOS=5")
+	if got := rule.Next(date("2026-01-01"), date("2026-02-01")); !got.Equal(date("2026-05-30")) {
+		t.Error("got", got, "expected the next month with five Saturdays")
+	}
+	rule, _ = Parse("FREQ=MONTHLY;BYMONTHDAY=10")
+	at := time.Date(2026, 3, 10, 18, 30, 0, 0, time.FixedZone("EST", -5*3600))
+	if got := rule.Next(date("2026-01-01"), at); !got.Equal(date("2026-03-10")) {
+		t.Error("got", got, "expected times of day to be ignored")
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	for text, expected := range map[string]string{
+		"FREQ=MONTHLY":                                  "monthly",
+		"FREQ=MONTHLY;BYMONTHDAY=15":                    "monthly on day 15",
+		"FREQ=WEEKLY;INTERVAL=2":                        "every 2 weeks",
+		"FREQ=MONTHLY;BYMONTHDAY=-1":                    "monthly on the last day",
+		"FREQ=YEARLY":                                   "yearly",
+		"FREQ=WEEKLY;BYDAY=MO,WE":                       "weekly on every Monday or Wednesday",
+		"FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1": "monthly on the last business day",
+	} {
+		rule, _ := Parse(text)
+		if got := rule.Describe(); got != expected {
+			t.Errorf("%s got %q, expected %q", text, got, expected)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var ErrDecrypt = errors.New("secret can't be decrypted")
+
+// Box encrypts small secrets at rest with AES-256-GCM, so a leaked database alone doesn't give them away.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// New takes a 32 byte key.
+func New(key []byte) (box *Box, err error) {
+	if len(key) != 32 {
+		return nil, errors.New("secret key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+	return &Box{aead}, nil
+}
+
+// ParseKey decodes a base64 key, as generated with "openssl rand -base64 32".
+func ParseKey(encoded string) (key []byte, err error) {
+	encoded = strings.TrimSpace(encoded)
+	if key, err = base64.StdEncoding.DecodeString(encoded); err != nil {
+		key, err = base64.RawURLEncoding.DecodeString(encoded)
+	}
+	return
+}
+
+// Seal encrypts plaintext, context binds the ciphertext to where it is stored (a user id...) so it can't be moved.
+func (box *Box) Seal(plaintext []byte, context string) (ciphertext []byte, err error) {
+	nonce := make([]byte, box.aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return
+	}
+	return box.aead.Seal(nonce, nonce, plaintext, []byte(context)), nil
+}
+
+func (box *Box) Open(ciphertext []byte, context string) (plaintext []byte, err error) {
+	size := box.aead.NonceSize()
+	if len(ciphertext) < size {
+		return nil, ErrDecrypt
+	}
+	if plaintext, err = box.aead.Open(nil, ciphertext[:size], ciphertext[size:], []byte(context)); err != nil {
+		return nil, ErrDecrypt
+	}
+	return
+}
@@ -0,0 +1,38 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestBox(t *testing.T) {
+	key, err := ParseKey("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=")
+	if err != nil {
+		t.Fatal("Failed to parse key -", err)
+	}
+	box, err := New(key)
+	if err != nil {
+		t.Fatal("Failed to create box -", err)
+	}
+	sealed, err := box.Seal([]byte("hunter2"), "user:1")
+	if err != nil {
+		t.Fatal("Failed to seal -", err)
+	}
+	if bytes.Contains(sealed, []byte("hunter2")) {
+		t.Error("Expected the plaintext to be hidden")
+	}
+	if opened, err := box.Open(sealed, "user:1"); err != nil || string(opened) != "hunter2" {
+		t.Error("got", opened, err, "expected the plaintext back")
+	}
+	if _, err := box.Open(sealed, "user:2"); !errors.Is(err, ErrDecrypt) {
+		t.Error("Expected a different context to be refused, got", err)
+	}
+	sealed[len(sealed)-1] ^= 1
+	if _, err := box.Open(sealed, "user:1"); !errors.Is(err, ErrDecrypt) {
+		t.Error("Expected a tampered ciphertext to be refused, got", err)
+	}
+	if _, err := New(key[:16]); err == nil {
+		t.Error("Expected a 16 byte key to be refused")
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// certCheckInterval bounds how often handshakes look at the certificate files for rotations.
+const certCheckInterval = 10 * time.Second
+
+// certReloader serves a certificate/key pair and picks up rotated files without a restart, keeping the previous pair
+// if a rotation is only half written or otherwise fails to load.
+type certReloader struct {
+	certFile, keyFile string
+	mu                sync.Mutex
+	certificate       *tls.Certificate
+	modTime           time.Time
+	lastCheck         time.Time
+	now               func() time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (reloader *certReloader, err error) {
+	reloader = &certReloader{certFile: certFile, keyFile: keyFile, now: time.Now}
+	if err = reloader.load(); err != nil {
+		return nil, err
+	}
+	return
+}
+
+func (reloader *certReloader) latestModTime() (latest time.Time, err error) {
+	for _, file := range []string{reloader.certFile, reloader.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return
+}
+
+func (reloader *certReloader) load() (err error) {
+	modTime, err := reloader.latestModTime()
+	if err != nil {
+		return
+	}
+	certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return
+	}
+	reloader.certificate, reloader.modTime = &certificate, modTime
+	return
+}
+
+func (reloader *certReloader) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+	if now := reloader.now(); now.Sub(reloader.lastCheck) >= certCheckInterval {
+		reloader.lastCheck = now
+		if modTime, err := reloader.latestModTime(); err != nil {
+			slog.Error("Error checking TLS certificate files", "err", err)
+		} else if !modTime.Equal(reloader.modTime) {
+			if err := reloader.load(); err != nil {
+				slog.Error("Error reloading rotated TLS certificate, keeping the previous one", "err", err)
+			} else {
+				slog.Info("Reloaded rotated TLS certificate", "cert", reloader.certFile)
+			}
+		}
+	}
+	return reloader.certificate, nil
+}
@@ -2,20 +2,63 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"strconv"
 	"time"
+
+	"github.com/matcha-devs/matcha/internal/logging"
 )
 
+// Config controls how the server listens, TLS is enabled by giving both a certificate and key file.
+type Config struct {
+	Addr         string
+	CertFile     string
+	KeyFile      string
+	RedirectAddr string
+	HSTSMaxAge   time.Duration
+}
+
+// ConfigFromEnv reads MATCHA_ADDR, MATCHA_TLS_CERT, MATCHA_TLS_KEY, MATCHA_REDIRECT_ADDR (a plain HTTP listener that
+// redirects to HTTPS) and MATCHA_HSTS_MAX_AGE (a duration such as "8760h", "0s" to disable).
+func ConfigFromEnv() (config Config) {
+	config = Config{
+		Addr:         os.Getenv("MATCHA_ADDR"),
+		CertFile:     os.Getenv("MATCHA_TLS_CERT"),
+		KeyFile:      os.Getenv("MATCHA_TLS_KEY"),
+		RedirectAddr: os.Getenv("MATCHA_REDIRECT_ADDR"),
+		HSTSMaxAge:   365 * 24 * time.Hour,
+	}
+	if maxAge := os.Getenv("MATCHA_HSTS_MAX_AGE"); maxAge != "" {
+		var err error
+		if config.HSTSMaxAge, err = time.ParseDuration(maxAge); err != nil {
+			logging.Fatal("Invalid MATCHA_HSTS_MAX_AGE", "value", maxAge, "err", err)
+		}
+	}
+	return
+}
+
+func (config Config) tlsEnabled() bool {
+	return config.CertFile != "" && config.KeyFile != ""
+}
+
 type HTTPServer struct {
 	underlyingServer http.Server
+	redirectServer   *http.Server
+	certificates     *certReloader
 }
 
-func New(handler http.Handler) *HTTPServer {
-	return &HTTPServer{
-		http.Server{
-			Addr:                         ":8080",
+func New(handler http.Handler, config Config) *HTTPServer {
+	if config.Addr == "" {
+		config.Addr = ":8080"
+	}
+	s := &HTTPServer{
+		underlyingServer: http.Server{
+			Addr:                         config.Addr,
 			Handler:                      handler,
 			DisableGeneralOptionsHandler: false,
 			TLSConfig:                    nil,
@@ -24,30 +67,99 @@ func New(handler http.Handler) *HTTPServer {
 			WriteTimeout:                 time.Second,
 			IdleTimeout:                  30 * time.Second,
 			MaxHeaderBytes:               0,
-			TLSNextProto:                 nil,
+			TLSNextProto:                 nil, // Left nil so that TLS listeners negotiate HTTP/2.
 			ConnState:                    nil,
-			ErrorLog:                     nil,
+			ErrorLog:                     slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
 			BaseContext:                  nil,
 			ConnContext:                  nil,
 		},
 	}
+	if !config.tlsEnabled() {
+		return s
+	}
+	var err error
+	if s.certificates, err = newCertReloader(config.CertFile, config.KeyFile); err != nil {
+		logging.Fatal("Error loading TLS certificate", "cert", config.CertFile, "key", config.KeyFile, "err", err)
+	}
+	s.underlyingServer.TLSConfig = &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.certificates.GetCertificate,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if config.HSTSMaxAge > 0 {
+		s.underlyingServer.Handler = withHSTS(config.HSTSMaxAge, handler)
+	}
+	if config.RedirectAddr != "" {
+		s.redirectServer = &http.Server{
+			Addr:              config.RedirectAddr,
+			Handler:           redirectToHTTPS(config.Addr),
+			ReadHeaderTimeout: 2 * time.Second,
+			ErrorLog:          s.underlyingServer.ErrorLog,
+		}
+	}
+	return s
 }
 
 func (s *HTTPServer) Run() (err error) {
-	log.Println("HTTP server starting on", s.underlyingServer.Addr, "🫡")
-	if err = s.underlyingServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
-		log.Fatalln("HTTP server run error -", err)
+	if s.redirectServer != nil {
+		go func() {
+			slog.Info("HTTP redirect server starting", "addr", s.redirectServer.Addr)
+			if err := s.redirectServer.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				logging.Fatal("HTTP redirect server run error", "err", err)
+			}
+		}()
+	}
+	if s.certificates != nil {
+		slog.Info("HTTPS server starting", "addr", s.underlyingServer.Addr)
+		err = s.underlyingServer.ListenAndServeTLS("", "")
+	} else {
+		slog.Info("HTTP server starting", "addr", s.underlyingServer.Addr)
+		err = s.underlyingServer.ListenAndServe()
+	}
+	if !errors.Is(err, http.ErrServerClosed) {
+		logging.Fatal("HTTP server run error", "err", err)
 	}
 	return
 }
 
-func (s *HTTPServer) Shutdown(maxClientDisconnectTime time.Duration) (err error) {
-	ctx, release := context.WithTimeout(context.Background(), maxClientDisconnectTime)
-	defer release()
-	if err = s.underlyingServer.Shutdown(ctx); err != nil {
-		log.Println("HTTP server close error -", err)
+// Shutdown stops accepting connections and waits for in-flight requests until ctx is done.
+func (s *HTTPServer) Shutdown(ctx context.Context) (err error) {
+	if s.redirectServer != nil {
+		if err = s.redirectServer.Shutdown(ctx); err != nil {
+			slog.Error("HTTP redirect server close error", "err", err)
+		}
+	}
+	if err = errors.Join(err, s.underlyingServer.Shutdown(ctx)); err != nil {
+		slog.Error("HTTP server close error", "err", err)
 	} else {
-		log.Println("HTTP server has shutdown 👋🏽")
+		slog.Info("HTTP server has shutdown")
 	}
 	return
 }
+
+func withHSTS(maxAge time.Duration, handler http.Handler) http.Handler {
+	value := "max-age=" + strconv.Itoa(int(maxAge.Seconds())) + "; includeSubDomains"
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Strict-Transport-Security", value)
+			handler.ServeHTTP(w, r)
+		},
+	)
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same host and path on the HTTPS listener at httpsAddr.
+func redirectToHTTPS(httpsAddr string) http.Handler {
+	_, httpsPort, _ := net.SplitHostPort(httpsAddr)
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			host := r.Host
+			if hostname, _, err := net.SplitHostPort(r.Host); err == nil {
+				host = hostname
+			}
+			if httpsPort != "" && httpsPort != "443" {
+				host = net.JoinHostPort(host, httpsPort)
+			}
+			http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+		},
+	)
+}
@@ -1 +1,141 @@
 package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeCertificate(t *testing.T, certFile, keyFile string, serial int64, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Failed to generate key -", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal("Failed to create certificate -", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal("Failed to marshal key -", err)
+	}
+	for file, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der}, keyFile: {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := os.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal("Failed to write", file, "-", err)
+		}
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatal("Failed to set modification time -", err)
+		}
+	}
+}
+
+func serialOf(t *testing.T, reloader *certReloader) int64 {
+	t.Helper()
+	certificate, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatal("Failed to get certificate -", err)
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		t.Fatal("Failed to parse certificate -", err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Hour)
+	writeCertificate(t, certFile, keyFile, 1, start)
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal("Failed to load certificate -", err)
+	}
+	now := time.Now()
+	reloader.now = func() time.Time { return now }
+	if serial := serialOf(t, reloader); serial != 1 {
+		t.Fatal("got serial", serial, "expected 1")
+	}
+
+	writeCertificate(t, certFile, keyFile, 2, start.Add(time.Minute))
+	if serial := serialOf(t, reloader); serial != 1 {
+		t.Error("Reloaded before the check interval elapsed, got serial", serial)
+	}
+	now = now.Add(certCheckInterval)
+	if serial := serialOf(t, reloader); serial != 2 {
+		t.Error("Failed to reload rotated certificate, got serial", serial)
+	}
+
+	if err := os.WriteFile(keyFile, []byte("half written"), 0o600); err != nil {
+		t.Fatal("Failed to corrupt key -", err)
+	}
+	now = now.Add(certCheckInterval)
+	if serial := serialOf(t, reloader); serial != 2 {
+		t.Error("Expected to keep the previous certificate after a bad rotation, got serial", serial)
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	testCases := []struct {
+		name, httpsAddr, host, target, expected string
+	}{
+		{"default_port", ":443", "matcha.example:80", "/login?next=%2F", "https://matcha.example/login?next=%2F"},
+		{"custom_port", ":8443", "matcha.example", "/dashboard", "https://matcha.example:8443/dashboard"},
+		{"ipv6", ":8443", "[::1]:8080", "/", "https://[::1]:8443/"},
+	}
+	for _, tc := range testCases {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodGet, tc.target, nil)
+				r.Host = tc.host
+				w := httptest.NewRecorder()
+				redirectToHTTPS(tc.httpsAddr).ServeHTTP(w, r)
+				if w.Code != http.StatusPermanentRedirect {
+					t.Error("got status", w.Code, "expected", http.StatusPermanentRedirect)
+				}
+				if location := w.Header().Get("Location"); location != tc.expected {
+					t.Error("got location", location, "expected", tc.expected)
+				}
+			},
+		)
+	}
+}
+
+func TestHSTS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	writeCertificate(t, certFile, keyFile, 1, time.Now())
+	s := New(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		Config{CertFile: certFile, KeyFile: keyFile, HSTSMaxAge: time.Hour},
+	)
+	w := httptest.NewRecorder()
+	s.underlyingServer.Handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if hsts := w.Header().Get("Strict-Transport-Security"); hsts != "max-age=3600; includeSubDomains" {
+		t.Error("got Strict-Transport-Security", hsts)
+	}
+	if protocols := s.underlyingServer.TLSConfig.NextProtos; len(protocols) == 0 || protocols[0] != "h2" {
+		t.Error("Expected HTTP/2 to be offered first, got", protocols)
+	}
+}
@@ -0,0 +1,309 @@
+package takeout
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+)
+
+const (
+	// Format names the archives Matcha exports, Version goes up whenever files change in a way older importers can't
+	// read.
+	Format  = "matcha-takeout"
+	Version = 1
+
+	manifestName = "manifest.json"
+	// maxFileSize bounds how much of each file Read decompresses, so a crafted archive can't exhaust memory.
+	maxFileSize = 64 << 20
+	maxNameLen  = 255
+)
+
+var ErrInvalid = errors.New("invalid export")
+
+// Manifest describes an export, listing every other file in it with its checksum.
+type Manifest struct {
+	Format     string    `json:"format"`
+	Version    int       `json:"version"`
+	ExportedOn time.Time `json:"exported_on"`
+	Files      []File    `json:"files"`
+}
+
+type File struct {
+	Name string `json:"name"`
+	// Records is how many rows a data file holds, zero for the profile.
+	Records int    `json:"records"`
+	SHA256  string `json:"sha256"`
+}
+
+// Profile is the personal details of the user who exported, emails aren't imported since the importing account has
+// its own.
+type Profile struct {
+	FirstName   string    `json:"first_name"`
+	MiddleName  string    `json:"middle_name"`
+	LastName    string    `json:"last_name"`
+	Email       string    `json:"email"`
+	DateOfBirth string    `json:"date_of_birth"`
+	CreatedOn   time.Time `json:"created_on"`
+}
+
+// Export is everything a user can take with them. IDs are those of the exporting instance, they only link records
+// of the same export together.
+type Export struct {
+	Profile Profile
+	internal.UserData
+}
+
+// archive builds the files of an export and its manifest.
+type archive struct {
+	zip      *zip.Writer
+	now      time.Time
+	manifest Manifest
+}
+
+func (archive *archive) add(name string, records int, content []byte) (err error) {
+	file, err := archive.zip.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: archive.now})
+	if err != nil {
+		return
+	}
+	if _, err = file.Write(content); err != nil {
+		return
+	}
+	sum := sha256.Sum256(content)
+	archive.manifest.Files = append(archive.manifest.Files, File{name, records, hex.EncodeToString(sum[:])})
+	return
+}
+
+func (archive *archive) addJSON(name string, records int, value any) (err error) {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return
+	}
+	return archive.add(name, records, content)
+}
+
+func (archive *archive) addCSV(name string, rows [][]string) (err error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	if err = writer.WriteAll(rows); err != nil {
+		return
+	}
+	return archive.add(name, len(rows)-1, buf.Bytes())
+}
+
+// Write packages export as a ZIP of JSON files, the ones Read imports, with a CSV copy of each table for spreadsheets
+// and a manifest.
+func Write(w io.Writer, export Export, now time.Time) (err error) {
+	archive := &archive{zip: zip.NewWriter(w), now: now, manifest: Manifest{Format, Version, now, nil}}
+	data := export.UserData
+	if err = archive.addJSON("profile.json", 0, export.Profile); err != nil {
+		return
+	}
+	institutions := [][]string{{"id", "name"}}
+	for _, institution := range data.Institutions {
+		institutions = append(institutions, []string{strconv.FormatUint(institution.ID, 10), csvText(institution.Name)})
+	}
+	accounts := [][]string{{"id", "institution_id", "asset_class", "name", "net_value"}}
+	for _, account := range data.Accounts {
+		accounts = append(
+			accounts, []string{
+				strconv.FormatUint(account.ID, 10), strconv.FormatUint(account.InstitutionID, 10), account.AssetClass,
+				csvText(account.Name), strconv.FormatInt(account.NetValue, 10),
+			},
+		)
+	}
+	transactions := [][]string{{"id", "financial_account_id", "amount", "type", "date", "transfer_id"}}
+	for _, transaction := range data.Transactions {
+		transferID := ""
+		if transaction.TransferID != 0 {
+			transferID = strconv.FormatUint(transaction.TransferID, 10)
+		}
+		transactions = append(
+			transactions, []string{
+				strconv.FormatUint(transaction.ID, 10), strconv.FormatUint(transaction.FinancialAccountID, 10),
+				strconv.FormatInt(transaction.Amount, 10), transaction.Type, transaction.Date, transferID,
+			},
+		)
+	}
+	for _, table := range []struct {
+		name string
+		rows any
+		csv  [][]string
+	}{
+		{"institutions", nonNil(data.Institutions), institutions},
+		{"accounts", nonNil(data.Accounts), accounts},
+		{"transactions", nonNil(data.Transactions), transactions},
+	} {
+		if err = archive.addJSON(table.name+".json", len(table.csv)-1, table.rows); err != nil {
+			return
+		}
+		if err = archive.addCSV(table.name+".csv", table.csv); err != nil {
+			return
+		}
+	}
+	content, err := json.MarshalIndent(archive.manifest, "", "  ")
+	if err != nil {
+		return
+	}
+	file, err := archive.zip.CreateHeader(&zip.FileHeader{Name: manifestName, Method: zip.Deflate, Modified: now})
+	if err != nil {
+		return
+	}
+	if _, err = file.Write(content); err != nil {
+		return
+	}
+	return archive.zip.Close()
+}
+
+// nonNil keeps empty tables as [] rather than null in JSON.
+func nonNil[T any](rows []T) []T {
+	if rows == nil {
+		return []T{}
+	}
+	return rows
+}
+
+// csvText keeps spreadsheets from running text that looks like a formula.
+func csvText(text string) string {
+	if text != "" && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
+
+// Read opens an export made by Write, checking every file against the manifest and every record against the others.
+// Errors about the archive itself wrap ErrInvalid.
+func Read(r io.ReaderAt, size int64) (export Export, err error) {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return export, fmt.Errorf("%w: not a ZIP file", ErrInvalid)
+	}
+	files := make(map[string]*zip.File, len(reader.File))
+	for _, file := range reader.File {
+		files[file.Name] = file
+	}
+	content, err := readFile(files, manifestName)
+	if err != nil {
+		return
+	}
+	var manifest Manifest
+	if err = json.Unmarshal(content, &manifest); err != nil || manifest.Format != Format {
+		return export, fmt.Errorf("%w: not a Matcha export", ErrInvalid)
+	}
+	if manifest.Version > Version {
+		return export, fmt.Errorf("%w: made by a newer version of Matcha", ErrInvalid)
+	}
+	for _, entry := range []struct {
+		name  string
+		value any
+	}{
+		{"profile.json", &export.Profile},
+		{"institutions.json", &export.Institutions},
+		{"accounts.json", &export.Accounts},
+		{"transactions.json", &export.Transactions},
+	} {
+		i := slices.IndexFunc(manifest.Files, func(file File) bool { return file.Name == entry.name })
+		if i < 0 {
+			return export, fmt.Errorf("%w: %s missing from manifest", ErrInvalid, entry.name)
+		}
+		if content, err = readFile(files, entry.name); err != nil {
+			return
+		}
+		if sum := sha256.Sum256(content); hex.EncodeToString(sum[:]) != manifest.Files[i].SHA256 {
+			return export, fmt.Errorf("%w: %s is damaged", ErrInvalid, entry.name)
+		}
+		if err = json.Unmarshal(content, entry.value); err != nil {
+			return export, fmt.Errorf("%w: %s is malformed", ErrInvalid, entry.name)
+		}
+	}
+	return export, check(export.UserData)
+}
+
+func readFile(files map[string]*zip.File, name string) (content []byte, err error) {
+	file, ok := files[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is missing", ErrInvalid, name)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s is unreadable", ErrInvalid, name)
+	}
+	defer func() { _ = reader.Close() }()
+	if content, err = io.ReadAll(io.LimitReader(reader, maxFileSize+1)); err != nil {
+		return nil, fmt.Errorf("%w: %s is unreadable", ErrInvalid, name)
+	} else if len(content) > maxFileSize {
+		return nil, fmt.Errorf("%w: %s is too large", ErrInvalid, name)
+	}
+	return
+}
+
+// check makes sure records only refer to records of the same export and hold values the database accepts.
+func check(data internal.UserData) (err error) {
+	institutions := make(map[uint64]struct{}, len(data.Institutions))
+	for _, institution := range data.Institutions {
+		if _, ok := institutions[institution.ID]; ok || !validName(institution.Name) {
+			return fmt.Errorf("%w: institution %d", ErrInvalid, institution.ID)
+		}
+		institutions[institution.ID] = struct{}{}
+	}
+	accounts := make(map[uint64]struct{}, len(data.Accounts))
+	for _, account := range data.Accounts {
+		_, duplicate := accounts[account.ID]
+		_, institution := institutions[account.InstitutionID]
+		if duplicate || !institution || !validName(account.Name) ||
+			!slices.Contains(internal.AssetClasses, account.AssetClass) {
+			return fmt.Errorf("%w: account %d", ErrInvalid, account.ID)
+		}
+		accounts[account.ID] = struct{}{}
+	}
+	transactions := make(map[uint64]internal.Transaction, len(data.Transactions))
+	for _, transaction := range data.Transactions {
+		// Exports made before transactions had dates have none.
+		_, dateErr := time.Parse(time.DateOnly, transaction.Date)
+		_, duplicate := transactions[transaction.ID]
+		if _, ok := accounts[transaction.FinancialAccountID]; !ok || duplicate || !validSplits(transaction) ||
+			!slices.Contains(internal.TransactionTypes, transaction.Type) || transaction.Date != "" && dateErr != nil {
+			return fmt.Errorf("%w: transaction %d", ErrInvalid, transaction.ID)
+		}
+		transactions[transaction.ID] = transaction
+	}
+	// Both sides of a transfer point at each other.
+	for _, transaction := range data.Transactions {
+		if transaction.TransferID == 0 {
+			continue
+		}
+		other, ok := transactions[transaction.TransferID]
+		if !ok || other.TransferID != transaction.ID || other.FinancialAccountID == transaction.FinancialAccountID ||
+			other.Amount != -transaction.Amount {
+			return fmt.Errorf("%w: transaction %d", ErrInvalid, transaction.ID)
+		}
+	}
+	return
+}
+
+// validSplits reports whether the splits of a transaction, if any, have known types and add up to its amount.
+func validSplits(transaction internal.Transaction) bool {
+	var sum int64
+	for _, split := range transaction.Splits {
+		if !slices.Contains(internal.TransactionTypes, split.Type) {
+			return false
+		}
+		sum += split.Amount
+	}
+	return len(transaction.Splits) == 0 || sum == transaction.Amount
+}
+
+func validName(name string) bool {
+	return strings.TrimSpace(name) != "" && len(name) <= maxNameLen
+}
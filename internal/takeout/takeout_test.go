@@ -0,0 +1,125 @@
+package takeout
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+)
+
+var (
+	now    = time.Date(2026, time.March, 15, 12, 0, 0, 0, time.UTC)
+	export = Export{
+		Profile{"Mary", "", "Jones", "mary@example.com", "2000-01-31", now.AddDate(-1, 0, 0)},
+		internal.UserData{
+			Institutions: []internal.Institution{{ID: 7, Name: "First Bank"}},
+			Accounts: []internal.FinancialAccount{
+				{ID: 3, InstitutionID: 7, AssetClass: "CASH", Name: "=Checking", NetValue: 120050},
+			},
+			Transactions: []internal.Transaction{
+				{ID: 1, FinancialAccountID: 3, Amount: -4599, Type: "GROCERY", Date: "2026-03-14"},
+				{ID: 2, FinancialAccountID: 3, Amount: 250000, Type: "ETC"},
+				{
+					ID: 4, FinancialAccountID: 3, Amount: -9000, Type: "GROCERY", Date: "2026-03-14",
+					Splits: []internal.Split{{Type: "GROCERY", Amount: -6000}, {Type: "ETC", Amount: -3000}},
+				},
+			},
+		},
+	}
+)
+
+func write(t *testing.T, export Export) []byte {
+	var buf bytes.Buffer
+	if err := Write(&buf, export, now); err != nil {
+		t.Fatal("Failed to write export -", err)
+	}
+	return buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	content := write(t, export)
+	got, err := Read(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		t.Fatal("Failed to read export -", err)
+	}
+	if !reflect.DeepEqual(got, export) {
+		t.Error("got", got, "expected", export)
+	}
+
+	reader, _ := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	for _, file := range reader.File {
+		if file.Name != "accounts.csv" {
+			continue
+		}
+		rc, _ := file.Open()
+		csv, _ := io.ReadAll(rc)
+		if !strings.Contains(string(csv), "3,7,CASH,'=Checking,120050") {
+			t.Error("got", string(csv), "expected the account with its name escaped")
+		}
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	dangling := export
+	dangling.Transactions = []internal.Transaction{{ID: 1, FinancialAccountID: 4, Amount: 100, Type: "ETC"}}
+	badDate := export
+	badDate.Transactions = []internal.Transaction{
+		{ID: 1, FinancialAccountID: 3, Amount: 100, Type: "ETC", Date: "3/14"},
+	}
+	oneSided := export
+	oneSided.Transactions = []internal.Transaction{
+		{ID: 1, FinancialAccountID: 3, Amount: -100, Type: "ETC", TransferID: 2},
+		{ID: 2, FinancialAccountID: 3, Amount: 100, Type: "ETC"},
+	}
+	unbalanced := export
+	unbalanced.Transactions = []internal.Transaction{
+		{
+			ID: 1, FinancialAccountID: 3, Amount: -100, Type: "ETC",
+			Splits: []internal.Split{{Type: "GROCERY", Amount: -60}, {Type: "ETC", Amount: -30}},
+		},
+	}
+	unknownClass := export
+	unknownClass.Accounts = []internal.FinancialAccount{
+		{ID: 3, InstitutionID: 7, AssetClass: "GOLD", Name: "Vault"},
+	}
+
+	// Tampering with a file after the manifest was written breaks its checksum.
+	var tampered bytes.Buffer
+	original := write(t, export)
+	reader, _ := zip.NewReader(bytes.NewReader(original), int64(len(original)))
+	writer := zip.NewWriter(&tampered)
+	for _, file := range reader.File {
+		rc, _ := file.Open()
+		content, _ := io.ReadAll(rc)
+		if file.Name == "transactions.json" {
+			content = bytes.Replace(content, []byte("-4599"), []byte("-9"), 1)
+		}
+		w, _ := writer.Create(file.Name)
+		_, _ = w.Write(content)
+	}
+	_ = writer.Close()
+
+	for name, content := range map[string][]byte{
+		"NotZIP":           []byte("not a zip"),
+		"DanglingAccount":  write(t, dangling),
+		"UnknownClass":     write(t, unknownClass),
+		"BadDate":          write(t, badDate),
+		"OneSidedTransfer": write(t, oneSided),
+		"UnbalancedSplits": write(t, unbalanced),
+		"Tampered":         tampered.Bytes(),
+	} {
+		t.Run(
+			name, func(t *testing.T) {
+				if _, err := Read(bytes.NewReader(content), int64(len(content))); !errors.Is(err, ErrInvalid) {
+					t.Error("Expected ErrInvalid, got", err)
+				}
+			},
+		)
+	}
+}
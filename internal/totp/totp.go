@@ -0,0 +1,78 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"time"
+)
+
+const (
+	// Period is how long each code is valid, Digits how long codes are, the defaults every authenticator app supports.
+	Period = 30 * time.Second
+	Digits = 6
+	// skew is how many periods either side of now are accepted, to forgive clocks that drift.
+	skew = 1
+)
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewSecret returns a random 160-bit secret, the size RFC 4226 recommends for HMAC-SHA1.
+func NewSecret() (secret []byte, err error) {
+	secret = make([]byte, 20)
+	_, err = rand.Read(secret)
+	return
+}
+
+// EncodeSecret is how secrets are typed into authenticator apps by hand.
+func EncodeSecret(secret []byte) string {
+	return encoding.EncodeToString(secret)
+}
+
+// URI is the otpauth provisioning URI authenticator apps read from QR codes.
+func URI(issuer, account string, secret []byte) string {
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	query := url.Values{
+		"secret":    {EncodeSecret(secret)},
+		"issuer":    {issuer},
+		"algorithm": {"SHA1"},
+		"digits":    {fmt.Sprint(Digits)},
+		"period":    {fmt.Sprint(int(Period.Seconds()))},
+	}
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Step is the number of periods since the Unix epoch at t.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period.Seconds())
+}
+
+// Code is the code for a step, as defined by RFC 4226 HOTP with the step as the counter.
+func Code(secret []byte, step int64) string {
+	mac := hmac.New(sha1.New, secret)
+	_ = binary.Write(mac, binary.BigEndian, step)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", Digits, value%1_000_000)
+}
+
+// Verify checks code against the steps around t, returning the step it matched so callers can refuse to accept the
+// same step twice.
+func Verify(secret []byte, code string, t time.Time) (ok bool, step int64) {
+	if len(code) != Digits {
+		return false, 0
+	}
+	now := Step(t)
+	for step = now - skew; step <= now+skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(Code(secret, step)), []byte(code)) == 1 {
+			return true, step
+		}
+	}
+	return false, 0
+}
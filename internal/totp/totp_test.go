@@ -0,0 +1,54 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// secret is the SHA1 key of the RFC 6238 test vectors.
+var secret = []byte("12345678901234567890")
+
+func TestCode(t *testing.T) {
+	// The RFC lists 8 digit codes, these are their last 6 digits.
+	for _, vector := range []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"}, {1111111109, "081804"}, {1111111111, "050471"}, {1234567890, "005924"},
+		{2000000000, "279037"}, {20000000000, "353130"},
+	} {
+		if code := Code(secret, Step(time.Unix(vector.unix, 0))); code != vector.code {
+			t.Error("got", code, "at", vector.unix, "expected", vector.code)
+		}
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	for _, offset := range []time.Duration{-Period, 0, Period} {
+		code := Code(secret, Step(now.Add(offset)))
+		if ok, step := Verify(secret, code, now); !ok || step != Step(now.Add(offset)) {
+			t.Error("Expected the code", offset, "away to be accepted for its own step, got", ok, step)
+		}
+	}
+	if ok, _ := Verify(secret, Code(secret, Step(now.Add(2*Period))), now); ok {
+		t.Error("Expected a code two periods away to be refused")
+	}
+	if ok, _ := Verify(secret, "", now); ok {
+		t.Error("Expected an empty code to be refused")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Matcha", "someone@example.com", secret))
+	if err != nil {
+		t.Fatal("Failed to parse URI -", err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/Matcha:someone@example.com" {
+		t.Error("got", uri, "expected an otpauth TOTP URI labelled with the issuer and account")
+	}
+	if got := uri.Query().Get("secret"); got != "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" {
+		t.Error("got secret", got)
+	}
+}
@@ -0,0 +1,47 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// WriterExporter writes each span as a line of JSON, for local debugging without a collector.
+type WriterExporter struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	closer  io.Closer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+	return &WriterExporter{encoder: json.NewEncoder(w)}
+}
+
+// NewFileExporter appends spans to the file at path, creating it if needed.
+func NewFileExporter(path string) (exporter *WriterExporter, err error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	exporter = NewWriterExporter(file)
+	exporter.closer = file
+	return
+}
+
+func (exporter *WriterExporter) Export(span SpanData) (err error) {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	return exporter.encoder.Encode(span)
+}
+
+func (exporter *WriterExporter) Shutdown(_ context.Context) (err error) {
+	exporter.mu.Lock()
+	defer exporter.mu.Unlock()
+	if exporter.closer != nil {
+		err = exporter.closer.Close()
+		exporter.closer = nil
+	}
+	return
+}
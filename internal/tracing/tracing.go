@@ -0,0 +1,231 @@
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	TraceID [16]byte
+	SpanID  [8]byte
+)
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// SpanContext is the part of a span that crosses process boundaries in a W3C traceparent header.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+// Traceparent formats the span context as a version 00 W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent reads a W3C traceparent header, accepting future versions as the spec requires.
+func ParseTraceparent(header string) (sc SpanContext, ok bool) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return SpanContext{}, false
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if _, err := hex.Decode(make([]byte, 1), []byte(version)); err != nil || len(flags) != 2 {
+		return SpanContext{}, false
+	}
+	if len(traceID) != 32 || len(spanID) != 16 || strings.ToLower(traceID+spanID) != traceID+spanID {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(traceID)); err != nil {
+		return SpanContext{}, false
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(spanID)); err != nil {
+		return SpanContext{}, false
+	}
+	var flagBits [1]byte
+	if _, err := hex.Decode(flagBits[:], []byte(flags)); err != nil {
+		return SpanContext{}, false
+	}
+	sc.Sampled = flagBits[0]&1 == 1
+	return sc, sc.IsValid()
+}
+
+// SpanData is a finished span as handed to exporters.
+type SpanData struct {
+	Name         string         `json:"name"`
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	DurationMS   float64        `json:"duration_ms"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Error        string         `json:"error,omitempty"`
+}
+
+type Exporter interface {
+	Export(span SpanData) (err error)
+	Shutdown(ctx context.Context) (err error)
+}
+
+var exporter atomic.Pointer[Exporter]
+
+// SetExporter installs the exporter finished spans are sent to, or disables recording when nil.
+func SetExporter(e Exporter) {
+	if e == nil {
+		exporter.Store(nil)
+		return
+	}
+	exporter.Store(&e)
+}
+
+// Shutdown flushes and closes the installed exporter, if any.
+func Shutdown(ctx context.Context) (err error) {
+	if e := exporter.Swap(nil); e != nil {
+		return (*e).Shutdown(ctx)
+	}
+	return
+}
+
+type Span struct {
+	context   SpanContext
+	parent    SpanID
+	name      string
+	start     time.Time
+	recording bool
+	mu        sync.Mutex
+	attrs     map[string]any
+	err       error
+	ended     bool
+}
+
+type spanKey struct{}
+
+// Start begins a span as a child of the span, or remote span context, carried by ctx.
+func Start(ctx context.Context, name string) (context.Context, *Span) {
+	span := &Span{name: name, start: time.Now(), recording: exporter.Load() != nil}
+	if parent := SpanContextFromContext(ctx); parent.IsValid() {
+		span.context.TraceID, span.parent, span.context.Sampled = parent.TraceID, parent.SpanID, parent.Sampled
+	} else {
+		span.context.TraceID, span.context.Sampled = newTraceID(), span.recording
+	}
+	span.context.SpanID = newSpanID()
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// ContextWithRemote makes an incoming span context, usually parsed from a traceparent header, the parent of the spans
+// started from the returned context.
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, spanKey{}, &Span{context: sc})
+}
+
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if span := FromContext(ctx); span != nil {
+		return span.context
+	}
+	return SpanContext{}
+}
+
+func (span *Span) SpanContext() SpanContext {
+	return span.context
+}
+
+func (span *Span) SetAttribute(key string, value any) {
+	if !span.recording {
+		return
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	if span.attrs == nil {
+		span.attrs = map[string]any{}
+	}
+	span.attrs[key] = value
+}
+
+// RecordError marks the span as failed, keeping the first error recorded.
+func (span *Span) RecordError(err error) {
+	if err == nil || !span.recording {
+		return
+	}
+	span.mu.Lock()
+	defer span.mu.Unlock()
+	if span.err == nil {
+		span.err = err
+	}
+}
+
+// End finishes the span and exports it, later calls are ignored.
+func (span *Span) End() {
+	span.mu.Lock()
+	if span.ended || !span.recording {
+		span.ended = true
+		span.mu.Unlock()
+		return
+	}
+	span.ended = true
+	end := time.Now()
+	data := SpanData{
+		Name:       span.name,
+		TraceID:    span.context.TraceID.String(),
+		SpanID:     span.context.SpanID.String(),
+		Start:      span.start,
+		End:        end,
+		DurationMS: float64(end.Sub(span.start).Microseconds()) / 1000,
+		Attributes: span.attrs,
+	}
+	if span.parent != (SpanID{}) {
+		data.ParentSpanID = span.parent.String()
+	}
+	if span.err != nil {
+		data.Error = span.err.Error()
+	}
+	span.mu.Unlock()
+	if e := exporter.Load(); e != nil {
+		if err := (*e).Export(data); err != nil {
+			slog.Error("Failed to export span", "span", span.name, "err", err)
+		}
+	}
+}
+
+func newTraceID() (id TraceID) {
+	random(id[:])
+	return
+}
+
+func newSpanID() (id SpanID) {
+	random(id[:])
+	return
+}
+
+func random(b []byte) {
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprint("tracing: failed to read random bytes - ", err))
+	}
+}
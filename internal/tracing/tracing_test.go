@@ -0,0 +1,93 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	testCases := []struct {
+		name    string
+		header  string
+		valid   bool
+		sampled bool
+	}{
+		{"sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true},
+		{"not_sampled", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false},
+		{"future_version", "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", true, true},
+		{"forbidden_version", "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false},
+		{"zero_trace_id", "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false},
+		{"zero_span_id", "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false},
+		{"uppercase", "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false},
+		{"short", "00-4bf92f3577b34da6a3ce929d0e0e4736-01", false, false},
+		{"empty", "", false, false},
+	}
+	for _, tc := range testCases {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				sc, ok := ParseTraceparent(tc.header)
+				if ok != tc.valid {
+					t.Fatalf("got valid %v, expected %v", ok, tc.valid)
+				}
+				if ok && sc.Sampled != tc.sampled {
+					t.Errorf("got sampled %v, expected %v", sc.Sampled, tc.sampled)
+				}
+				if ok && tc.name == "sampled" && sc.Traceparent() != tc.header {
+					t.Errorf("got traceparent %s, expected %s", sc.Traceparent(), tc.header)
+				}
+			},
+		)
+	}
+}
+
+func TestSpansExport(t *testing.T) {
+	var buf bytes.Buffer
+	SetExporter(NewWriterExporter(&buf))
+	defer SetExporter(nil)
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	ctx, parent := Start(ContextWithRemote(context.Background(), remote), "GET /dashboard")
+	_, child := Start(ctx, "mysql.GetUser")
+	child.SetAttribute("user_id", 1)
+	child.RecordError(errors.New("no rows"))
+	child.End()
+	child.End()
+	parent.End()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatal("Expected 2 exported spans, got", len(lines), "-", buf.String())
+	}
+	var exported [2]SpanData
+	for i, line := range lines {
+		if err := json.Unmarshal([]byte(line), &exported[i]); err != nil {
+			t.Fatal("Failed to decode span -", err)
+		}
+	}
+	childData, parentData := exported[0], exported[1]
+	if parentData.TraceID != remote.TraceID.String() || childData.TraceID != remote.TraceID.String() {
+		t.Error("Spans did not join the remote trace")
+	}
+	if parentData.ParentSpanID != remote.SpanID.String() {
+		t.Error("got parent span", parentData.ParentSpanID, "expected", remote.SpanID.String())
+	}
+	if childData.ParentSpanID != parentData.SpanID {
+		t.Error("got child parent span", childData.ParentSpanID, "expected", parentData.SpanID)
+	}
+	if childData.Error != "no rows" || childData.Attributes["user_id"] != float64(1) {
+		t.Error("Child span lost its error or attributes -", childData)
+	}
+}
+
+func TestSpansWithoutExporter(t *testing.T) {
+	ctx, span := Start(context.Background(), "unrecorded")
+	span.SetAttribute("ignored", true)
+	span.End()
+	if !SpanContextFromContext(ctx).IsValid() {
+		t.Error("Unrecorded spans should still propagate a valid span context")
+	}
+}
@@ -0,0 +1,262 @@
+package validation
+
+import (
+	"net/mail"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// DateLayout is how dates are typed into forms and stored.
+	DateLayout     = "2006-01-02"
+	MaxNameLength  = 64
+	MaxEmailLength = 254
+	MinPassword    = 8
+	// MaxPassword is in bytes, bcrypt ignores anything past it.
+	MaxPassword = 72
+	MinAge      = 13
+	MaxAge      = 120
+)
+
+// commonPasswords are refused outright, they are the first ones guessed.
+var commonPasswords = map[string]struct{}{
+	"password": {}, "password1": {}, "password123": {}, "12345678": {}, "123456789": {}, "1234567890": {},
+	"qwertyuiop": {}, "qwerty123": {}, "iloveyou": {}, "11111111": {}, "abc12345": {}, "letmein1": {},
+	"welcome1": {}, "matcha123": {}, "passw0rd": {},
+}
+
+// Errors maps form field names to what is wrong with them, at most one message per field.
+type Errors map[string]string
+
+func (errs Errors) Error() string {
+	fields := make([]string, 0, len(errs))
+	for field := range errs {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return "invalid " + strings.Join(fields, ", ")
+}
+
+// Add records message for field unless the field already has one, the first problem found is the one shown.
+func (errs Errors) Add(field, message string) {
+	if _, ok := errs[field]; !ok {
+		errs[field] = message
+	}
+}
+
+// Err is nil when no field has an error, so the result can be returned as an error directly.
+func (errs Errors) Err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Name trims a person's name and checks it is made of letters, spaces, hyphens, apostrophes and periods.
+func Name(errs Errors, field, name string, required bool) string {
+	name = strings.Join(strings.Fields(name), " ")
+	switch {
+	case name == "":
+		if required {
+			errs.Add(field, "please fill in this field")
+		}
+	case utf8.RuneCountInString(name) > MaxNameLength:
+		errs.Add(field, "please use at most 64 characters")
+	case strings.IndexFunc(name, notNameRune) >= 0:
+		errs.Add(field, "please use only letters, spaces, hyphens and apostrophes")
+	case strings.IndexFunc(name, unicode.IsLetter) < 0:
+		errs.Add(field, "please include at least one letter")
+	}
+	return name
+}
+
+func notNameRune(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.Is(unicode.Mn, r) && !strings.ContainsRune(" -'’.", r)
+}
+
+// Text trims a free text field like an account name and checks it is filled in and at most maxLength characters.
+func Text(errs Errors, field, text string, maxLength int) string {
+	text = strings.TrimSpace(text)
+	switch {
+	case text == "":
+		errs.Add(field, "please fill in this field")
+	case utf8.RuneCountInString(text) > maxLength:
+		errs.Add(field, "please use at most "+strconv.Itoa(maxLength)+" characters")
+	case strings.IndexFunc(text, unicode.IsControl) >= 0:
+		errs.Add(field, "please use only printable characters")
+	}
+	return text
+}
+
+// OneOf checks value is one of allowed, like a choice from a drop-down.
+func OneOf(errs Errors, field, value string, allowed []string) {
+	if !slices.Contains(allowed, value) {
+		errs.Add(field, "please choose one of "+strings.Join(allowed, ", "))
+	}
+}
+
+// Email checks a bare address and returns it normalized by NormalizeEmail.
+func Email(errs Errors, field, email string) string {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		errs.Add(field, "please fill in this field")
+		return email
+	}
+	address, err := mail.ParseAddress("<" + email + ">")
+	if err != nil || len(email) > MaxEmailLength {
+		errs.Add(field, "please enter an email address like name@example.com")
+		return email
+	}
+	if domain := address.Address[strings.LastIndexByte(address.Address, '@')+1:]; !strings.Contains(domain, ".") {
+		errs.Add(field, "please enter an email address like name@example.com")
+		return email
+	}
+	return NormalizeEmail(address.Address)
+}
+
+// NormalizeEmail trims an address and lower-cases its domain, the mailbox name is kept as typed since it may be
+// case-sensitive. Addresses typed at login go through it too, so they match the address stored at signup.
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndexByte(email, '@')
+	if at < 0 {
+		return email
+	}
+	return email[:at] + strings.ToLower(email[at:])
+}
+
+// Password checks the strength of a new password, refusing short, common and single-kind passwords and those made
+// from the user's email.
+func Password(errs Errors, field, password, email string) {
+	switch {
+	case password == "":
+		errs.Add(field, "please fill in this field")
+	case utf8.RuneCountInString(password) < MinPassword:
+		errs.Add(field, "please use at least 8 characters")
+	case len(password) > MaxPassword:
+		errs.Add(field, "please use at most 72 bytes")
+	case isCommon(password):
+		errs.Add(field, "this password is too common")
+	case characterKinds(password) < 2:
+		errs.Add(field, "please mix letters with numbers, symbols or capitals")
+	case containsEmail(password, email):
+		errs.Add(field, "please don't use your email in your password")
+	}
+}
+
+func isCommon(password string) bool {
+	_, ok := commonPasswords[strings.ToLower(password)]
+	return ok
+}
+
+// characterKinds counts which of lower-case letters, capitals, digits and other characters the password uses.
+func characterKinds(password string) (kinds int) {
+	for _, is := range []func(rune) bool{
+		unicode.IsLower, unicode.IsUpper, unicode.IsDigit,
+		func(r rune) bool { return !unicode.IsLetter(r) && !unicode.IsDigit(r) },
+	} {
+		if strings.IndexFunc(password, is) >= 0 {
+			kinds++
+		}
+	}
+	return
+}
+
+// containsEmail reports whether the password contains the mailbox name of email, the first thing guessed after it.
+func containsEmail(password, email string) bool {
+	name, _, _ := strings.Cut(strings.ToLower(email), "@")
+	return len(name) >= 4 && strings.Contains(strings.ToLower(password), name)
+}
+
+// Date parses a date typed as YYYY-MM-DD, checking its year is plausible.
+func Date(errs Errors, field, date string) (day time.Time) {
+	date = strings.TrimSpace(date)
+	if date == "" {
+		errs.Add(field, "please fill in this field")
+		return
+	}
+	day, err := time.Parse(DateLayout, date)
+	if err != nil {
+		errs.Add(field, "please enter a date like 2000-01-31")
+	} else if day.Year() < 1900 || day.Year() > 2999 {
+		errs.Add(field, "please check the year")
+	}
+	return
+}
+
+// DateOfBirth parses a date of birth typed as YYYY-MM-DD, checking the user is between MinAge and MaxAge on now.
+func DateOfBirth(errs Errors, field, date string, now time.Time) (dateOfBirth time.Time) {
+	date = strings.TrimSpace(date)
+	if date == "" {
+		errs.Add(field, "please fill in this field")
+		return
+	}
+	dateOfBirth, err := time.Parse(DateLayout, date)
+	if err != nil {
+		errs.Add(field, "please enter a date like 2000-01-31")
+		return
+	}
+	switch age := Age(dateOfBirth, now); {
+	case dateOfBirth.After(now):
+		errs.Add(field, "this date is in the future")
+	case age < MinAge:
+		errs.Add(field, "you must be at least 13 years old")
+	case age > MaxAge:
+		errs.Add(field, "please check the year")
+	}
+	return
+}
+
+// Age is how many birthdays someone born on dateOfBirth has had on now.
+func Age(dateOfBirth, now time.Time) (age int) {
+	age = now.Year() - dateOfBirth.Year()
+	if now.Month() < dateOfBirth.Month() || now.Month() == dateOfBirth.Month() && now.Day() < dateOfBirth.Day() {
+		age--
+	}
+	return
+}
+
+// Profile is the personal details of a user, its fields are checked under the names the forms give them.
+type Profile struct {
+	FirstName   string
+	MiddleName  string
+	LastName    string
+	DateOfBirth string
+}
+
+// Validate normalizes the profile in place and returns the date of birth it parsed, with an Errors error when any
+// field is wrong.
+func (profile *Profile) Validate(now time.Time) (dateOfBirth time.Time, err error) {
+	errs := Errors{}
+	dateOfBirth = profile.validate(errs, now)
+	return dateOfBirth, errs.Err()
+}
+
+func (profile *Profile) validate(errs Errors, now time.Time) (dateOfBirth time.Time) {
+	profile.FirstName = Name(errs, "first_name", profile.FirstName, true)
+	profile.MiddleName = Name(errs, "middle_name", profile.MiddleName, false)
+	profile.LastName = Name(errs, "last_name", profile.LastName, true)
+	return DateOfBirth(errs, "date_of_birth", profile.DateOfBirth, now)
+}
+
+// Signup is a signup form, its fields are checked under the names signup.go.html gives them.
+type Signup struct {
+	Profile
+	Email    string
+	Password string
+}
+
+// Validate normalizes the signup in place and returns the date of birth it parsed, with an Errors error when any field
+// is wrong.
+func (signup *Signup) Validate(now time.Time) (dateOfBirth time.Time, err error) {
+	errs := Errors{}
+	dateOfBirth = signup.Profile.validate(errs, now)
+	signup.Email = Email(errs, "email", signup.Email)
+	Password(errs, "psw", signup.Password, signup.Email)
+	return dateOfBirth, errs.Err()
+}
@@ -0,0 +1,154 @@
+package validation
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2026, time.March, 15, 12, 0, 0, 0, time.UTC)
+
+func TestName(t *testing.T) {
+	for _, tc := range []struct {
+		name, expected string
+		required, ok   bool
+	}{
+		{"  Mary   Jane ", "Mary Jane", true, true},
+		{"O'Brien-Smith", "O'Brien-Smith", true, true},
+		{"José", "José", true, true},
+		{"", "", false, true},
+		{"", "", true, false},
+		{"R2D2", "R2D2", true, false},
+		{"--", "--", true, false},
+		{"<script>", "<script>", true, false},
+	} {
+		errs := Errors{}
+		if got := Name(errs, "name", tc.name, tc.required); got != tc.expected || (len(errs) == 0) != tc.ok {
+			t.Errorf("Name(%q) = %q %v, expected %q ok %v", tc.name, got, errs, tc.expected, tc.ok)
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	for _, tc := range []struct {
+		email, expected string
+		ok              bool
+	}{
+		{" Matcha@Example.COM ", "Matcha@example.com", true},
+		{"a.b+tag@sub.example.org", "a.b+tag@sub.example.org", true},
+		{"", "", false},
+		{"not-an-email", "", false},
+		{"user@localhost", "", false},
+		{"Matcha <matcha@example.com>", "", false},
+	} {
+		errs := Errors{}
+		got := Email(errs, "email", tc.email)
+		if (len(errs) == 0) != tc.ok || tc.ok && got != tc.expected {
+			t.Errorf("Email(%q) = %q %v, expected %q ok %v", tc.email, got, errs, tc.expected, tc.ok)
+		}
+	}
+}
+
+func TestText(t *testing.T) {
+	for _, tc := range []struct {
+		text, expected string
+		ok             bool
+	}{
+		{"  Checking #2 ", "Checking #2", true},
+		{"", "", false},
+		{"Savings for a new car", "Savings for a new car", false},
+		{"tab\there", "tab\there", false},
+	} {
+		errs := Errors{}
+		if got := Text(errs, "name", tc.text, 16); got != tc.expected || (len(errs) == 0) != tc.ok {
+			t.Errorf("Text(%q) = %q %v, expected %q ok %v", tc.text, got, errs, tc.expected, tc.ok)
+		}
+	}
+}
+
+func TestPassword(t *testing.T) {
+	for _, tc := range []struct {
+		password string
+		ok       bool
+	}{
+		{"correct horse", true},
+		{"Tr0ub4dor", true},
+		{"short1", false},
+		{"alllowercase", false},
+		{"Password1", false},
+		{"maryjane_99", false},
+		{string(make([]byte, 73)) + "aA", false},
+	} {
+		errs := Errors{}
+		if Password(errs, "psw", tc.password, "maryjane@example.com"); (len(errs) == 0) != tc.ok {
+			t.Errorf("Password(%q) got %v, expected ok %v", tc.password, errs, tc.ok)
+		}
+	}
+}
+
+func TestDate(t *testing.T) {
+	for _, tc := range []struct {
+		date string
+		ok   bool
+	}{
+		{" 2026-03-15 ", true},
+		{"2030-01-01", true},
+		{"1850-01-01", false},
+		{"2026-02-29", false},
+		{"03/15/2026", false},
+		{"", false},
+	} {
+		errs := Errors{}
+		if Date(errs, "date", tc.date); (len(errs) == 0) != tc.ok {
+			t.Errorf("Date(%q) got %v, expected ok %v", tc.date, errs, tc.ok)
+		}
+	}
+}
+
+func TestDateOfBirth(t *testing.T) {
+	for _, tc := range []struct {
+		date string
+		ok   bool
+	}{
+		{"2000-01-31", true},
+		{"2013-03-15", true},
+		{"2013-03-16", false},
+		{"2030-01-01", false},
+		{"1890-01-01", false},
+		{"31/01/2000", false},
+		{"2000-02-30", false},
+		{"", false},
+	} {
+		errs := Errors{}
+		if DateOfBirth(errs, "date_of_birth", tc.date, now); (len(errs) == 0) != tc.ok {
+			t.Errorf("DateOfBirth(%q) got %v, expected ok %v", tc.date, errs, tc.ok)
+		}
+	}
+}
+
+func TestSignupValidate(t *testing.T) {
+	signup := Signup{Profile{" Mary ", "", "Jones", "2000-01-31"}, "mary@Example.com", "Tr0ub4dor"}
+	dateOfBirth, err := signup.Validate(now)
+	if err != nil {
+		t.Fatal("Expected a valid signup, got", err)
+	}
+	if signup.FirstName != "Mary" || signup.Email != "mary@example.com" ||
+		dateOfBirth.Format(DateLayout) != "2000-01-31" {
+		t.Error("got", signup, dateOfBirth, "expected it normalized")
+	}
+
+	signup = Signup{Profile{"", "", "Jones", "tomorrow"}, "mary", "short"}
+	_, err = signup.Validate(now)
+	var errs Errors
+	if !errors.As(err, &errs) {
+		t.Fatal("Expected Errors, got", err)
+	}
+	for _, field := range []string{"first_name", "email", "psw", "date_of_birth"} {
+		if errs[field] == "" {
+			t.Error("Expected an error for", field, "got", errs)
+		}
+	}
+	if _, ok := errs["last_name"]; ok || len(errs) != 4 {
+		t.Error("got", errs, "expected only the wrong fields")
+	}
+}
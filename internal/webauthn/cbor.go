@@ -0,0 +1,119 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// The subset of CBOR (RFC 8949) authenticators use for attestation objects and COSE keys: definite lengths, integers,
+// byte and text strings, arrays, maps and simple values. Values decode to int64, []byte, string, []any, map[any]any,
+// bool or nil.
+
+var errCBOR = errors.New("malformed CBOR")
+
+// maxCBORDepth bounds nesting, so hostile input can't exhaust the stack.
+const maxCBORDepth = 16
+
+// decodeCBOR decodes the first value of data, returning the bytes that follow it.
+func decodeCBOR(data []byte) (value any, rest []byte, err error) {
+	return decodeCBORItem(data, 0)
+}
+
+func decodeCBORItem(data []byte, depth int) (value any, rest []byte, err error) {
+	if depth > maxCBORDepth || len(data) == 0 {
+		return nil, nil, errCBOR
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+	if major == 7 {
+		switch info {
+		case 20:
+			return false, data, nil
+		case 21:
+			return true, data, nil
+		case 22, 23:
+			return nil, data, nil
+		default:
+			return nil, nil, errCBOR
+		}
+	}
+	argument, data, err := cborArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return int64(argument), data, nil
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, nil, errCBOR
+		}
+		return -1 - int64(argument), data, nil
+	case 2, 3:
+		if argument > uint64(len(data)) {
+			return nil, nil, errCBOR
+		}
+		if major == 3 {
+			return string(data[:argument]), data[argument:], nil
+		}
+		return data[:argument:argument], data[argument:], nil
+	case 4:
+		// Every item takes at least a byte, which bounds allocations by the input size.
+		if argument > uint64(len(data)) {
+			return nil, nil, errCBOR
+		}
+		array := make([]any, argument)
+		for i := range array {
+			if array[i], data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+		}
+		return array, data, nil
+	case 5:
+		if argument > uint64(len(data))/2 {
+			return nil, nil, errCBOR
+		}
+		object := make(map[any]any, argument)
+		for range argument {
+			var key, value any
+			if key, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, errCBOR
+			}
+			if value, data, err = decodeCBORItem(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			object[key] = value
+		}
+		return object, data, nil
+	default:
+		// Tags aren't used by WebAuthn.
+		return nil, nil, errCBOR
+	}
+}
+
+// cborArgument reads the argument following an initial byte, indefinite lengths (info 31) aren't supported.
+func cborArgument(info byte, data []byte) (argument uint64, rest []byte, err error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24 && len(data) >= 1:
+		return uint64(data[0]), data[1:], nil
+	case info == 25 && len(data) >= 2:
+		return uint64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case info == 26 && len(data) >= 4:
+		return uint64(binary.BigEndian.Uint32(data)), data[4:], nil
+	case info == 27 && len(data) >= 8:
+		return binary.BigEndian.Uint64(data), data[8:], nil
+	default:
+		return 0, nil, errCBOR
+	}
+}
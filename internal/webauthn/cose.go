@@ -0,0 +1,103 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// COSE algorithm identifiers (RFC 9053) offered to authenticators, in order of preference.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+var ErrUnsupportedKey = errors.New("unsupported public key")
+
+// COSE key parameters, negative labels depend on the key type.
+const (
+	coseKty    = 1
+	coseAlg    = 3
+	coseCrv    = -1
+	coseX      = -2
+	coseY      = -3
+	coseRSAN   = -1
+	coseRSAE   = -2
+	ktyOKP     = 1
+	ktyEC2     = 2
+	ktyRSA     = 3
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+// publicKey is a credential public key that checks its own signatures.
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key, as found in attested credential data and stored with credentials.
+func parsePublicKey(cose []byte) (key publicKey, err error) {
+	value, _, err := decodeCBOR(cose)
+	if err != nil {
+		return
+	}
+	object, ok := value.(map[any]any)
+	if !ok {
+		return key, ErrUnsupportedKey
+	}
+	kty, _ := object[int64(coseKty)].(int64)
+	key.alg, _ = object[int64(coseAlg)].(int64)
+	crv, _ := object[int64(coseCrv)].(int64)
+	switch {
+	case kty == ktyEC2 && key.alg == AlgES256 && crv == crvP256:
+		x, _ := object[int64(coseX)].([]byte)
+		y, _ := object[int64(coseY)].([]byte)
+		if len(x) != 32 || len(y) != 32 {
+			return key, ErrUnsupportedKey
+		}
+		ecKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !ecKey.Curve.IsOnCurve(ecKey.X, ecKey.Y) {
+			return key, ErrUnsupportedKey
+		}
+		key.key = ecKey
+	case kty == ktyOKP && key.alg == AlgEdDSA && crv == crvEd25519:
+		x, _ := object[int64(coseX)].([]byte)
+		if len(x) != ed25519.PublicKeySize {
+			return key, ErrUnsupportedKey
+		}
+		key.key = ed25519.PublicKey(x)
+	case kty == ktyRSA && key.alg == AlgRS256:
+		n, _ := object[int64(coseRSAN)].([]byte)
+		e, _ := object[int64(coseRSAE)].([]byte)
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) < 256 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return key, ErrUnsupportedKey
+		}
+		key.key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+	default:
+		return key, ErrUnsupportedKey
+	}
+	return
+}
+
+func (key publicKey) verify(data, signature []byte) (ok bool) {
+	switch k := key.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(k, digest[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(k, data, signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
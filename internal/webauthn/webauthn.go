@@ -0,0 +1,271 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"slices"
+	"time"
+)
+
+var (
+	ErrInvalidResponse = errors.New("invalid authenticator response")
+	// ErrCloned is returned when a signature counter goes backwards, a sign that the authenticator was copied.
+	ErrCloned = errors.New("authenticator signature counter went backwards")
+)
+
+// Timeout is how long browsers give users to complete a ceremony.
+const Timeout = 5 * time.Minute
+
+// Authenticator data flags.
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// RelyingParty is this site as authenticators know it, ID is its domain and Origins the exact origins pages are
+// served from.
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+}
+
+// Credential is what is stored for a registered authenticator, PublicKey is a COSE_Key.
+type Credential struct {
+	ID        []byte
+	PublicKey []byte
+	SignCount uint32
+}
+
+// User is the account a credential is registered for, ID is an opaque handle that must not contain personal data.
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+type rpEntity struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type userEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type credentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type credentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type authenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the publicKey options of navigator.credentials.create, binary fields are base64url encoded.
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     rpEntity               `json:"rp"`
+	User                   userEntity             `json:"user"`
+	PubKeyCredParams       []credentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []credentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection authenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the publicKey options of navigator.credentials.get, binary fields are base64url encoded.
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []credentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+func descriptors(credentialIDs [][]byte) (list []credentialDescriptor) {
+	list = make([]credentialDescriptor, 0, len(credentialIDs))
+	for _, id := range credentialIDs {
+		list = append(list, credentialDescriptor{"public-key", base64.RawURLEncoding.EncodeToString(id)})
+	}
+	return
+}
+
+// CreationOptions asks for a discoverable credential with no attestation, excluding the user's existing credentials
+// so the same authenticator isn't registered twice.
+func (rp RelyingParty) CreationOptions(user User, challenge string, existing [][]byte) CreationOptions {
+	return CreationOptions{
+		Challenge: challenge,
+		RP:        rpEntity{ID: rp.ID, Name: rp.Name},
+		User: userEntity{
+			ID: base64.RawURLEncoding.EncodeToString(user.ID), Name: user.Name, DisplayName: user.DisplayName,
+		},
+		PubKeyCredParams: []credentialParameter{
+			{"public-key", AlgES256}, {"public-key", AlgEdDSA}, {"public-key", AlgRS256},
+		},
+		Timeout:                Timeout.Milliseconds(),
+		ExcludeCredentials:     descriptors(existing),
+		AuthenticatorSelection: authenticatorSelection{ResidentKey: "preferred", UserVerification: "preferred"},
+		Attestation:            "none",
+	}
+}
+
+// RequestOptions allows any of credentialIDs, or any discoverable credential for this site when there are none.
+func (rp RelyingParty) RequestOptions(challenge string, credentialIDs [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		Timeout:          Timeout.Milliseconds(),
+		AllowCredentials: descriptors(credentialIDs),
+		UserVerification: "preferred",
+	}
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+// checkClientData verifies the ceremony type and origin, returning the challenge for the caller to redeem.
+func (rp RelyingParty) checkClientData(clientDataJSON []byte, ceremony string) (challenge string, err error) {
+	var data clientData
+	if err = json.Unmarshal(clientDataJSON, &data); err != nil {
+		return "", ErrInvalidResponse
+	}
+	if data.Type != ceremony || !slices.Contains(rp.Origins, data.Origin) || data.Challenge == "" {
+		return "", ErrInvalidResponse
+	}
+	return data.Challenge, nil
+}
+
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData checks the data is for this relying party and that the user was present.
+func (rp RelyingParty) parseAuthenticatorData(raw []byte) (data authenticatorData, err error) {
+	if len(raw) < 37 {
+		return data, ErrInvalidResponse
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.ID))
+	if !bytes.Equal(raw[:32], rpIDHash[:]) {
+		return data, ErrInvalidResponse
+	}
+	data.flags, data.signCount = raw[32], binary.BigEndian.Uint32(raw[33:37])
+	if data.flags&flagUserPresent == 0 {
+		return data, ErrInvalidResponse
+	}
+	if data.flags&flagAttested == 0 {
+		return
+	}
+	// Attested credential data: a 16 byte AAGUID, the credential id behind its 2 byte length, then its COSE_Key.
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return data, ErrInvalidResponse
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	if rest = rest[18:]; len(rest) < idLength {
+		return data, ErrInvalidResponse
+	}
+	data.credentialID, rest = rest[:idLength], rest[idLength:]
+	_, after, err := decodeCBOR(rest)
+	if err != nil {
+		return data, ErrInvalidResponse
+	}
+	data.publicKey = rest[:len(rest)-len(after)]
+	return
+}
+
+// Registration is a verified new credential, Challenge must still be checked against the one issued for it.
+type Registration struct {
+	Challenge    string
+	Credential   Credential
+	UserVerified bool
+}
+
+// VerifyRegistration checks the response of navigator.credentials.create. Only "none" attestation is accepted, which
+// is what browsers send for the CreationOptions above: credentials are trusted because the logged-in user registered
+// them, not because of who made the authenticator.
+func (rp RelyingParty) VerifyRegistration(
+	clientDataJSON, attestationObject []byte,
+) (registration Registration, err error) {
+	if registration.Challenge, err = rp.checkClientData(clientDataJSON, "webauthn.create"); err != nil {
+		return
+	}
+	value, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return registration, ErrInvalidResponse
+	}
+	object, _ := value.(map[any]any)
+	format, _ := object["fmt"].(string)
+	statement, _ := object["attStmt"].(map[any]any)
+	rawAuthData, _ := object["authData"].([]byte)
+	if format != "none" || len(statement) != 0 {
+		return registration, ErrInvalidResponse
+	}
+	data, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return
+	}
+	if data.credentialID == nil || len(data.credentialID) > 1023 {
+		return registration, ErrInvalidResponse
+	}
+	if _, err = parsePublicKey(data.publicKey); err != nil {
+		return
+	}
+	registration.Credential = Credential{ID: data.credentialID, PublicKey: data.publicKey, SignCount: data.signCount}
+	registration.UserVerified = data.flags&flagUserVerified != 0
+	return
+}
+
+// Assertion is a verified login, Challenge must still be checked against the one issued for it and SignCount stored.
+type Assertion struct {
+	Challenge    string
+	SignCount    uint32
+	UserVerified bool
+}
+
+// VerifyAssertion checks the response of navigator.credentials.get for a stored credential.
+func (rp RelyingParty) VerifyAssertion(
+	credential Credential, clientDataJSON, rawAuthData, signature []byte,
+) (assertion Assertion, err error) {
+	if assertion.Challenge, err = rp.checkClientData(clientDataJSON, "webauthn.get"); err != nil {
+		return
+	}
+	data, err := rp.parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return
+	}
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	if !key.verify(append(slices.Clip(rawAuthData), clientDataHash[:]...), signature) {
+		return assertion, ErrInvalidResponse
+	}
+	// Authenticators that don't count signatures always send 0.
+	if (data.signCount != 0 || credential.SignCount != 0) && data.signCount <= credential.SignCount {
+		return assertion, ErrCloned
+	}
+	assertion.SignCount = data.signCount
+	assertion.UserVerified = data.flags&flagUserVerified != 0
+	return
+}
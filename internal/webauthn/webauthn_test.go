@@ -0,0 +1,237 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"sort"
+	"testing"
+)
+
+var rp = RelyingParty{ID: "matcha.example", Name: "Matcha", Origins: []string{"https://matcha.example"}}
+
+// encodeCBOR is the encoder half the package doesn't need, enough to play an authenticator.
+func encodeCBOR(value any) []byte {
+	var b bytes.Buffer
+	head := func(major byte, argument uint64) {
+		switch {
+		case argument < 24:
+			b.WriteByte(major<<5 | byte(argument))
+		case argument < 1<<8:
+			b.Write([]byte{major<<5 | 24, byte(argument)})
+		case argument < 1<<16:
+			b.WriteByte(major<<5 | 25)
+			b.Write(binary.BigEndian.AppendUint16(nil, uint16(argument)))
+		default:
+			b.WriteByte(major<<5 | 26)
+			b.Write(binary.BigEndian.AppendUint32(nil, uint32(argument)))
+		}
+	}
+	switch v := value.(type) {
+	case int:
+		if v >= 0 {
+			head(0, uint64(v))
+		} else {
+			head(1, uint64(-1-v))
+		}
+	case []byte:
+		head(2, uint64(len(v)))
+		b.Write(v)
+	case string:
+		head(3, uint64(len(v)))
+		b.WriteString(v)
+	case map[any]any:
+		head(5, uint64(len(v)))
+		keys := make([]any, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Slice(keys, func(i, j int) bool { return bytes.Compare(encodeCBOR(keys[i]), encodeCBOR(keys[j])) < 0 })
+		for _, key := range keys {
+			b.Write(encodeCBOR(key))
+			b.Write(encodeCBOR(v[key]))
+		}
+	default:
+		panic("unsupported CBOR value")
+	}
+	return b.Bytes()
+}
+
+// authenticator is a software authenticator holding a single credential.
+type authenticator struct {
+	id        []byte
+	cose      []byte
+	sign      func(data []byte) []byte
+	signCount uint32
+}
+
+func newES256Authenticator(t *testing.T) *authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal("Failed to generate key -", err)
+	}
+	return &authenticator{
+		id: []byte("es256-credential"),
+		cose: encodeCBOR(map[any]any{
+			coseKty: ktyEC2, coseAlg: AlgES256, coseCrv: crvP256,
+			coseX: key.X.FillBytes(make([]byte, 32)), coseY: key.Y.FillBytes(make([]byte, 32)),
+		}),
+		sign: func(data []byte) []byte {
+			digest := sha256.Sum256(data)
+			signature, err := ecdsa.SignASN1(rand.Reader, key, digest[:])
+			if err != nil {
+				t.Fatal("Failed to sign -", err)
+			}
+			return signature
+		},
+	}
+}
+
+func newEd25519Authenticator(t *testing.T) *authenticator {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal("Failed to generate key -", err)
+	}
+	return &authenticator{
+		id:   []byte("ed25519-credential"),
+		cose: encodeCBOR(map[any]any{coseKty: ktyOKP, coseAlg: AlgEdDSA, coseCrv: crvEd25519, coseX: []byte(public)}),
+		sign: func(data []byte) []byte { return ed25519.Sign(private, data) },
+	}
+}
+
+func (a *authenticator) authData(rpID string, flags byte, attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+	if attested {
+		data = append(data, make([]byte, 16)...)
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, a.cose...)
+	}
+	return data
+}
+
+func clientDataJSON(ceremony, challenge, origin string) []byte {
+	data, _ := json.Marshal(clientData{Type: ceremony, Challenge: challenge, Origin: origin})
+	return data
+}
+
+func (a *authenticator) register(challenge string) (clientData, attestationObject []byte) {
+	return clientDataJSON("webauthn.create", challenge, rp.Origins[0]), encodeCBOR(map[any]any{
+		"fmt": "none", "attStmt": map[any]any{}, "authData": a.authData(rp.ID, flagUserPresent|flagAttested, true),
+	})
+}
+
+func (a *authenticator) assert(challenge string, flags byte) (clientData, authData, signature []byte) {
+	a.signCount++
+	clientData = clientDataJSON("webauthn.get", challenge, rp.Origins[0])
+	authData = a.authData(rp.ID, flags, false)
+	clientDataHash := sha256.Sum256(clientData)
+	return clientData, authData, a.sign(append(authData, clientDataHash[:]...))
+}
+
+func TestCeremonies(t *testing.T) {
+	for name, newAuthenticator := range map[string]func(*testing.T) *authenticator{
+		"ES256": newES256Authenticator, "EdDSA": newEd25519Authenticator,
+	} {
+		t.Run(
+			name, func(t *testing.T) {
+				a := newAuthenticator(t)
+				registration, err := rp.VerifyRegistration(a.register("register-challenge"))
+				if err != nil {
+					t.Fatal("Failed to verify registration -", err)
+				}
+				if registration.Challenge != "register-challenge" || !bytes.Equal(registration.Credential.ID, a.id) {
+					t.Error("got", registration, "expected the challenge and credential id back")
+				}
+
+				credential := registration.Credential
+				clientData, authData, signature := a.assert("login-challenge", flagUserPresent|flagUserVerified)
+				assertion, err := rp.VerifyAssertion(credential, clientData, authData, signature)
+				if err != nil {
+					t.Fatal("Failed to verify assertion -", err)
+				}
+				if assertion.Challenge != "login-challenge" || assertion.SignCount != 1 || !assertion.UserVerified {
+					t.Error("got", assertion, "expected a user verified assertion with count 1")
+				}
+				credential.SignCount = assertion.SignCount
+
+				clientData, authData, signature = a.assert("login-challenge", flagUserPresent)
+				signature[len(signature)-1] ^= 1
+				if _, err := rp.VerifyAssertion(credential, clientData, authData, signature); err == nil {
+					t.Error("Expected a bad signature to be refused")
+				}
+				a.signCount = 0
+				clientData, authData, signature = a.assert("login-challenge", flagUserPresent)
+				_, err = rp.VerifyAssertion(credential, clientData, authData, signature)
+				if !errors.Is(err, ErrCloned) {
+					t.Error("Expected a counter going backwards to be refused, got", err)
+				}
+			},
+		)
+	}
+}
+
+func TestVerifyRegistrationRefusals(t *testing.T) {
+	a := newES256Authenticator(t)
+	clientData, attestationObject := a.register("challenge")
+
+	wrongOrigin := clientDataJSON("webauthn.create", "challenge", "https://evil.example")
+	if _, err := rp.VerifyRegistration(wrongOrigin, attestationObject); !errors.Is(err, ErrInvalidResponse) {
+		t.Error("Expected another origin to be refused, got", err)
+	}
+	wrongCeremony := clientDataJSON("webauthn.get", "challenge", rp.Origins[0])
+	if _, err := rp.VerifyRegistration(wrongCeremony, attestationObject); !errors.Is(err, ErrInvalidResponse) {
+		t.Error("Expected an assertion's client data to be refused, got", err)
+	}
+	otherRP := encodeCBOR(map[any]any{
+		"fmt": "none", "attStmt": map[any]any{},
+		"authData": a.authData("evil.example", flagUserPresent|flagAttested, true),
+	})
+	if _, err := rp.VerifyRegistration(clientData, otherRP); !errors.Is(err, ErrInvalidResponse) {
+		t.Error("Expected another relying party's credential to be refused, got", err)
+	}
+	absent := encodeCBOR(map[any]any{
+		"fmt": "none", "attStmt": map[any]any{}, "authData": a.authData(rp.ID, flagAttested, true),
+	})
+	if _, err := rp.VerifyRegistration(clientData, absent); !errors.Is(err, ErrInvalidResponse) {
+		t.Error("Expected a registration without user presence to be refused, got", err)
+	}
+	packed := encodeCBOR(map[any]any{
+		"fmt": "packed", "attStmt": map[any]any{"alg": AlgES256, "sig": []byte("sig")},
+		"authData": a.authData(rp.ID, flagUserPresent|flagAttested, true),
+	})
+	if _, err := rp.VerifyRegistration(clientData, packed); !errors.Is(err, ErrInvalidResponse) {
+		t.Error("Expected attestation other than none to be refused, got", err)
+	}
+}
+
+func TestDecodeCBOR(t *testing.T) {
+	value, rest, err := decodeCBOR(append(encodeCBOR(map[any]any{1: -7, "a": []byte{1, 2}, -1: "x"}), 0xff))
+	if err != nil {
+		t.Fatal("Failed to decode -", err)
+	}
+	object := value.(map[any]any)
+	if object[int64(1)] != int64(-7) || object[int64(-1)] != "x" || !bytes.Equal(object["a"].([]byte), []byte{1, 2}) {
+		t.Error("got", object)
+	}
+	if !bytes.Equal(rest, []byte{0xff}) {
+		t.Error("got rest", rest, "expected the trailing byte")
+	}
+	for _, malformed := range [][]byte{
+		{}, {0x5f}, {0x42, 0x01}, {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, {0xc1, 0x00},
+		bytes.Repeat([]byte{0x81}, maxCBORDepth+2),
+	} {
+		if _, _, err := decodeCBOR(malformed); err == nil {
+			t.Error("Expected", base64.StdEncoding.EncodeToString(malformed), "to be refused")
+		}
+	}
+}
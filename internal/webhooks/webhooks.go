@@ -0,0 +1,278 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal/metrics"
+)
+
+var deliveries = metrics.NewCounter(
+	"matcha_webhook_deliveries_total", "Webhook delivery attempts by result.", "result",
+)
+
+// Event is the type of something that happened to a user's finances, which webhooks subscribe to.
+type Event string
+
+const (
+	// TransactionCreated is sent for every transaction recorded through the API.
+	TransactionCreated Event = "transaction.created"
+	// LargePurchase is sent for transactions spending at least LargePurchaseCents.
+	LargePurchase Event = "transaction.large_purchase"
+	// TransactionsImported is sent once per import of a takeout, with the number of transactions added.
+	TransactionsImported Event = "transactions.imported"
+	// TestEvent is only sent on request, every webhook receives it.
+	TestEvent Event = "test"
+)
+
+// Events lists the events webhooks can subscribe to, in the order they are shown to users.
+var Events = []Event{TransactionCreated, LargePurchase, TransactionsImported}
+
+// LargePurchaseCents is the smallest spending, in cents, that counts as a LargePurchase.
+const LargePurchaseCents = 500_00
+
+const (
+	SignatureHeader = "Matcha-Signature"
+	EventHeader     = "Matcha-Event"
+	DeliveryHeader  = "Matcha-Delivery"
+)
+
+// Payload is the JSON body of every delivery, ID is shared by the deliveries of one event to several webhooks.
+type Payload struct {
+	ID        string    `json:"id"`
+	Type      Event     `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// NewPayload encodes an event with a new random ID.
+func NewPayload(event Event, data any, now time.Time) (payload []byte, err error) {
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return
+	}
+	return json.Marshal(Payload{"evt_" + hex.EncodeToString(id), event, now.UTC(), data})
+}
+
+// NewSecret returns a random secret to sign the deliveries of a new webhook with.
+func NewSecret() (secret string, err error) {
+	buf := make([]byte, 32)
+	if _, err = rand.Read(buf); err != nil {
+		return
+	}
+	return "whsec_" + hex.EncodeToString(buf), nil
+}
+
+// Sign returns the SignatureHeader of a payload sent at timestamp, "t=<unix seconds>,v1=<hex HMAC-SHA256>" of
+// "<unix seconds>.<payload>" keyed by secret. Receivers should recompute it and refuse old timestamps to stop replays.
+func Sign(secret string, timestamp time.Time, payload []byte) string {
+	unix := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + unix + ",v1=" + hex.EncodeToString(mac(secret, unix, payload))
+}
+
+// Verify checks a SignatureHeader against secret, refusing signatures older than tolerance.
+func Verify(secret, signature string, payload []byte, now time.Time, tolerance time.Duration) bool {
+	var unix, sum string
+	for _, part := range strings.Split(signature, ",") {
+		if value, ok := strings.CutPrefix(part, "t="); ok {
+			unix = value
+		} else if value, ok = strings.CutPrefix(part, "v1="); ok {
+			sum = value
+		}
+	}
+	seconds, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil || now.Sub(time.Unix(seconds, 0)).Abs() > tolerance {
+		return false
+	}
+	expected, err := hex.DecodeString(sum)
+	return err == nil && hmac.Equal(expected, mac(secret, unix, payload))
+}
+
+func mac(secret, unix string, payload []byte) []byte {
+	hash := hmac.New(sha256.New, []byte(secret))
+	hash.Write([]byte(unix + "."))
+	hash.Write(payload)
+	return hash.Sum(nil)
+}
+
+// Delivery is an event waiting to be sent to a webhook, Attempts counts the current one.
+type Delivery struct {
+	ID       uint64
+	URL      string
+	Secret   string
+	Event    Event
+	Payload  []byte
+	Attempts int
+}
+
+// Store persists deliveries, so events survive restarts and users can look back at what was sent.
+type Store interface {
+	// ClaimWebhookDeliveries hands out up to limit deliveries that are due, hiding them from other claims for lease.
+	ClaimWebhookDeliveries(ctx context.Context, limit int, lease time.Duration) (due []Delivery, err error)
+	MarkWebhookDelivered(ctx context.Context, id uint64, status int) (err error)
+	// MarkWebhookFailed schedules another attempt at retryAt, or gives up on the delivery when retryAt is zero. Status
+	// is 0 when no response came back.
+	MarkWebhookFailed(ctx context.Context, id uint64, status int, reason string, retryAt time.Time) (err error)
+}
+
+const (
+	batch = 20
+	// lease must outlast a batch of requests, otherwise a slow receiver gets the same event twice.
+	lease        = 10 * time.Minute
+	timeout      = 10 * time.Second
+	retryBase    = 30 * time.Second
+	retryMax     = 6 * time.Hour
+	maxErrorBody = 256
+)
+
+var errPrivateAddress = errors.New("webhooks can't be delivered to private network addresses")
+
+// Dispatcher delivers webhooks in the background, retrying failures with exponential backoff.
+type Dispatcher struct {
+	// AllowPrivate lets deliveries reach loopback and private network addresses, which are refused otherwise so users
+	// can't make the server probe its own network.
+	AllowPrivate bool
+	store        Store
+	client       *http.Client
+	interval     time.Duration
+	maxAttempts  int
+	now          func() time.Time
+}
+
+func NewDispatcher(store Store) (dispatcher *Dispatcher) {
+	dispatcher = &Dispatcher{store: store, interval: 5 * time.Second, maxAttempts: 10, now: time.Now}
+	dialer := &net.Dialer{Timeout: timeout, Control: dispatcher.checkAddress}
+	dispatcher.client = &http.Client{
+		Timeout:   timeout,
+		Transport: &http.Transport{DialContext: dialer.DialContext, TLSHandshakeTimeout: timeout},
+		// Following redirects would get around the address check of the original request, and hide misconfigured
+		// URLs from their owners.
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	return
+}
+
+// checkAddress runs on every connection, after names are resolved, so a DNS name can't point to a private address.
+func (dispatcher *Dispatcher) checkAddress(_, address string, _ syscall.RawConn) (err error) {
+	if dispatcher.AllowPrivate {
+		return
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() {
+		return errPrivateAddress
+	}
+	return
+}
+
+// Run drains the due deliveries every interval until ctx is cancelled.
+func (dispatcher *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(dispatcher.interval)
+	defer ticker.Stop()
+	for {
+		dispatcher.Drain(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Drain sends every delivery currently due, returning how many were accepted.
+func (dispatcher *Dispatcher) Drain(ctx context.Context) (delivered int) {
+	for ctx.Err() == nil {
+		due, err := dispatcher.store.ClaimWebhookDeliveries(ctx, batch, lease)
+		if err != nil || len(due) == 0 {
+			return
+		}
+		for _, delivery := range due {
+			if dispatcher.deliver(ctx, delivery) {
+				delivered++
+			}
+		}
+		if len(due) < batch {
+			return
+		}
+	}
+	return
+}
+
+func (dispatcher *Dispatcher) deliver(ctx context.Context, delivery Delivery) (delivered bool) {
+	status, err := dispatcher.send(ctx, delivery)
+	if err == nil {
+		deliveries.Inc("delivered")
+		if err = dispatcher.store.MarkWebhookDelivered(ctx, delivery.ID, status); err != nil {
+			slog.ErrorContext(ctx, "Error marking webhook delivered", "delivery_id", delivery.ID, "err", err)
+		}
+		return true
+	}
+	var retryAt time.Time
+	if delivery.Attempts < dispatcher.maxAttempts && !errors.Is(err, errPrivateAddress) {
+		deliveries.Inc("retry")
+		retryAt = dispatcher.now().Add(retryDelay(delivery.Attempts))
+		slog.WarnContext(ctx, "Error delivering webhook, will retry", "delivery_id", delivery.ID,
+			"attempts", delivery.Attempts, "retry_at", retryAt, "err", err)
+	} else {
+		deliveries.Inc("failed")
+		slog.WarnContext(ctx, "Error delivering webhook, giving up", "delivery_id", delivery.ID,
+			"attempts", delivery.Attempts, "err", err)
+	}
+	if err = dispatcher.store.MarkWebhookFailed(ctx, delivery.ID, status, err.Error(), retryAt); err != nil {
+		slog.ErrorContext(ctx, "Error marking webhook failed", "delivery_id", delivery.ID, "err", err)
+	}
+	return false
+}
+
+// send posts a delivery, any 2xx response accepts it.
+func (dispatcher *Dispatcher) send(ctx context.Context, delivery Delivery) (status int, err error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "Matcha-Webhooks/1")
+	request.Header.Set(EventHeader, string(delivery.Event))
+	request.Header.Set(DeliveryHeader, strconv.FormatUint(delivery.ID, 10))
+	request.Header.Set(SignatureHeader, Sign(delivery.Secret, dispatcher.now(), delivery.Payload))
+	response, err := dispatcher.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = response.Body.Close() }()
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, maxErrorBody))
+		return response.StatusCode, fmt.Errorf("receiver answered %s: %s", response.Status, body)
+	}
+	// Draining the body lets the connection be reused.
+	_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	return response.StatusCode, nil
+}
+
+// retryDelay doubles from retryBase after every failed attempt up to retryMax.
+func retryDelay(attempts int) time.Duration {
+	delay := retryBase
+	for i := 1; i < attempts && delay < retryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, retryMax)
+}
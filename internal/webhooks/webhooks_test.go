@@ -0,0 +1,123 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	now := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+	payload := []byte(`{"type":"test"}`)
+	signature := Sign("secret", now, payload)
+	if !Verify("secret", signature, payload, now.Add(time.Minute), 5*time.Minute) {
+		t.Error("Expected", signature, "to verify")
+	}
+	for name, ok := range map[string]bool{
+		"wrong secret":  Verify("other", signature, payload, now, 5*time.Minute),
+		"changed body":  Verify("secret", signature, []byte(`{"type":"tested"}`), now, 5*time.Minute),
+		"replayed late": Verify("secret", signature, payload, now.Add(time.Hour), 5*time.Minute),
+		"malformed":     Verify("secret", "v1=00", payload, now, 5*time.Minute),
+	} {
+		if ok {
+			t.Error("Expected a signature with a", name, "to be refused")
+		}
+	}
+}
+
+type fakeStore struct {
+	due       []Delivery
+	delivered map[uint64]int
+	retryAt   map[uint64]time.Time
+}
+
+func (store *fakeStore) ClaimWebhookDeliveries(
+	_ context.Context, limit int, _ time.Duration,
+) (due []Delivery, err error) {
+	n := min(limit, len(store.due))
+	due, store.due = store.due[:n], store.due[n:]
+	return
+}
+
+func (store *fakeStore) MarkWebhookDelivered(_ context.Context, id uint64, status int) (err error) {
+	store.delivered[id] = status
+	return
+}
+
+func (store *fakeStore) MarkWebhookFailed(
+	_ context.Context, id uint64, _ int, _ string, retryAt time.Time,
+) (err error) {
+	store.retryAt[id] = retryAt
+	return
+}
+
+func TestDispatcher(t *testing.T) {
+	now := time.Now()
+	var received []Payload
+	receiver := httptest.NewServer(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				if !Verify("whsec_test", r.Header.Get(SignatureHeader), body, now, time.Minute) {
+					http.Error(w, "bad signature", http.StatusUnauthorized)
+					return
+				}
+				var payload Payload
+				if err := json.Unmarshal(body, &payload); err != nil || r.Header.Get(EventHeader) != "test" {
+					http.Error(w, "bad payload", http.StatusBadRequest)
+					return
+				}
+				received = append(received, payload)
+			},
+		),
+	)
+	defer receiver.Close()
+
+	payload, err := NewPayload(TestEvent, map[string]string{"hello": "world"}, now)
+	if err != nil {
+		t.Fatal("Failed to encode payload -", err)
+	}
+	store := &fakeStore{
+		due: []Delivery{
+			{ID: 1, URL: receiver.URL, Secret: "whsec_test", Event: TestEvent, Payload: payload, Attempts: 1},
+			{ID: 2, URL: receiver.URL, Secret: "whsec_wrong", Event: TestEvent, Payload: payload, Attempts: 3},
+			{ID: 3, URL: receiver.URL, Secret: "whsec_wrong", Event: TestEvent, Payload: payload, Attempts: 10},
+		},
+		delivered: map[uint64]int{}, retryAt: map[uint64]time.Time{},
+	}
+	dispatcher := NewDispatcher(store)
+	dispatcher.now = func() time.Time { return now }
+
+	if delivered := dispatcher.Drain(context.Background()); delivered != 0 {
+		t.Error("got", delivered, "delivered, expected loopback receivers to be refused")
+	}
+	if retryAt, exists := store.retryAt[1]; !exists || !retryAt.IsZero() {
+		t.Error("Expected deliveries to private addresses to give up at once, got retry at", retryAt)
+	}
+
+	dispatcher.AllowPrivate = true
+	store.due = []Delivery{
+		{ID: 1, URL: receiver.URL, Secret: "whsec_test", Event: TestEvent, Payload: payload, Attempts: 1},
+		{ID: 2, URL: receiver.URL, Secret: "whsec_wrong", Event: TestEvent, Payload: payload, Attempts: 3},
+		{ID: 3, URL: receiver.URL, Secret: "whsec_wrong", Event: TestEvent, Payload: payload, Attempts: 10},
+	}
+	if delivered := dispatcher.Drain(context.Background()); delivered != 1 {
+		t.Error("got", delivered, "delivered, expected 1")
+	}
+	if status := store.delivered[1]; status != http.StatusOK {
+		t.Error("got status", status, "expected delivery 1 to be accepted")
+	}
+	if len(received) != 1 || received[0].Type != TestEvent || received[0].ID == "" {
+		t.Error("got", received, "expected the test event")
+	}
+	if retryAt := store.retryAt[2]; !retryAt.Equal(now.Add(2 * time.Minute)) {
+		t.Error("got retry at", retryAt, "expected the third attempt to back off 2m")
+	}
+	if retryAt, exists := store.retryAt[3]; !exists || !retryAt.IsZero() {
+		t.Error("Expected the last attempt to give up, got retry at", retryAt)
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal/ratelimit"
+)
+
+// loginGuard throttles password checks per client IP and per account, on top of the lockout kept by the database.
+type loginGuard struct {
+	perIP      *ratelimit.Limiter
+	perAccount *ratelimit.Limiter
+	backoff    *ratelimit.Backoff
+}
+
+func newLoginGuard(clock ratelimit.Clock) *loginGuard {
+	return &loginGuard{
+		perIP:      ratelimit.NewLimiter(6*time.Second, 20, clock),
+		perAccount: ratelimit.NewLimiter(time.Minute, 5, clock),
+		backoff:    ratelimit.NewBackoff(time.Second, time.Minute, clock),
+	}
+}
+
+var loginLimits = newLoginGuard(ratelimit.SystemClock)
+
+// mailGuard throttles requests that email someone, so they can't be used to flood an inbox.
+type mailGuard struct {
+	perIP    *ratelimit.Limiter
+	perEmail *ratelimit.Limiter
+}
+
+func newMailGuard(clock ratelimit.Clock) *mailGuard {
+	return &mailGuard{
+		perIP:    ratelimit.NewLimiter(time.Minute, 10, clock),
+		perEmail: ratelimit.NewLimiter(20*time.Minute, 3, clock),
+	}
+}
+
+var mailLimits = newMailGuard(ratelimit.SystemClock)
+
+func (guard *mailGuard) allow(r *http.Request, email string) (ok bool, retryAfter time.Duration) {
+	if ok, retryAfter = guard.perIP.Allow(clientIP(r)); !ok {
+		return
+	}
+	return guard.perEmail.Allow(strings.ToLower(email))
+}
+
+func clientIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// allow reports whether a password may be checked for email now, otherwise how long the client should wait.
+func (guard *loginGuard) allow(r *http.Request, email string) (ok bool, retryAfter time.Duration) {
+	account := strings.ToLower(email)
+	if wait := guard.backoff.Wait(account); wait > 0 {
+		return false, wait
+	}
+	if ok, retryAfter = guard.perIP.Allow(clientIP(r)); !ok {
+		return
+	}
+	return guard.perAccount.Allow(account)
+}
+
+func (guard *loginGuard) failed(email string) {
+	guard.backoff.Fail(strings.ToLower(email))
+}
+
+func (guard *loginGuard) succeeded(email string) {
+	guard.backoff.Reset(strings.ToLower(email))
+}
+
+func tooManyAttempts(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	slog.WarnContext(r.Context(), "Throttled login attempt", "ip", clientIP(r), "retry_after", retryAfter)
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+	http.Error(w, "Too many attempts, please try again later.", http.StatusTooManyRequests)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+	"github.com/matcha-devs/matcha/internal/mailer"
+)
+
+// notificationLabels names the kinds of optional email on the settings page. Nothing sends them yet, the preferences
+// are kept for when budgets and subscription tracking send their emails through notify.
+var notificationLabels = map[internalDatabase.NotificationKind]string{
+	internalDatabase.BudgetAlerts:         "Budget alerts when spending nears a limit",
+	internalDatabase.SubscriptionWarnings: "Warnings before subscriptions renew",
+}
+
+type notificationSetting struct {
+	Kind    internalDatabase.NotificationKind
+	Label   string
+	Enabled bool
+}
+
+// queueMail renders the mail template called name and queues it for to, it is sent regardless of preferences.
+func queueMail(ctx context.Context, to, name string, data map[string]any) (err error) {
+	message, err := mailer.Render(name, data)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error rendering mail", "template", name, "err", err)
+		return internalDatabase.ErrInternal
+	}
+	message.To = to
+	return matcha.database.EnqueueMail(ctx, message)
+}
+
+// notify queues an optional email of the given kind, unless the user turned that kind off. It has no callers until the
+// features behind notificationLabels land.
+func notify(
+	ctx context.Context, userID uint64, kind internalDatabase.NotificationKind, name string, data map[string]any,
+) (err error) {
+	preferences, err := matcha.database.GetNotificationPreferences(ctx, userID)
+	if err != nil {
+		return
+	}
+	if !preferences[kind] {
+		slog.InfoContext(ctx, "Notification turned off", "user_id", userID, "kind", kind)
+		return
+	}
+	user := matcha.database.GetUser(ctx, userID)
+	if user == nil {
+		return internalDatabase.ErrInternal
+	} else if !user.EmailVerified() {
+		slog.InfoContext(ctx, "Notification to unverified email dropped", "user_id", userID, "kind", kind)
+		return
+	}
+	data["PreferencesURL"] = matcha.baseURL + "/settings"
+	return queueMail(ctx, user.Email, name, data)
+}
+
+// postNotifications saves the settings page checkboxes, a kind left unchecked is turned off.
+func postNotifications(w http.ResponseWriter, r *http.Request) {
+	user := checkLoginStatus(w, r)
+	if user == nil {
+		return
+	}
+	for _, kind := range internalDatabase.NotificationKinds {
+		enabled := r.FormValue(string(kind)) == "on"
+		if err := matcha.database.SetNotificationPreference(r.Context(), user.ID, kind, enabled); err != nil {
+			writeMessage(w, r, userMessage(err))
+			return
+		}
+	}
+	writeMessage(w, r, "Email preferences saved.")
+}
@@ -3,33 +3,127 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+	"github.com/matcha-devs/matcha/internal/logging"
+	"github.com/matcha-devs/matcha/internal/mailer"
+	"github.com/matcha-devs/matcha/internal/secrets"
 	internalServer "github.com/matcha-devs/matcha/internal/server"
+	"github.com/matcha-devs/matcha/internal/tracing"
+	"github.com/matcha-devs/matcha/internal/webhooks"
 )
 
 var matcha *app
 
-func init() {
-	matcha = newApp(
-		internalServer.New(loggedRouter()), internalDatabase.New("matcha_db", "root", os.Getenv("MYSQL_PASSWORD")),
+// setup wires the app from the environment, called by main rather than from init so tests run without a database.
+func setup() {
+	// MATCHA_LOG_FORMAT picks "json" or "text" output, MATCHA_LOG_LEVEL one of "debug", "info", "warn" or "error".
+	slog.SetDefault(
+		logging.New(os.Stderr, os.Getenv("MATCHA_LOG_FORMAT"), logging.ParseLevel(os.Getenv("MATCHA_LOG_LEVEL"))),
 	)
+	// MATCHA_TRACE_OUTPUT exports spans as JSON lines to "stdout" or to a file path, tracing is off when unset.
+	switch output := os.Getenv("MATCHA_TRACE_OUTPUT"); output {
+	case "":
+	case "stdout":
+		tracing.SetExporter(tracing.NewWriterExporter(os.Stdout))
+	default:
+		exporter, err := tracing.NewFileExporter(output)
+		if err != nil {
+			logging.Fatal("Error opening trace output", "path", output, "err", err)
+		}
+		tracing.SetExporter(exporter)
+	}
+	// MATCHA_SMTP_ADDR sends mail through an SMTP relay, MATCHA_MAIL_DIR drops it as files into a directory instead,
+	// otherwise it is only logged.
+	from := os.Getenv("MATCHA_MAIL_FROM")
+	if from == "" {
+		from = "Matcha <no-reply@localhost>"
+	}
+	var mail mailer.Mailer = mailer.LogMailer{}
+	if addr := os.Getenv("MATCHA_SMTP_ADDR"); addr != "" {
+		mail = mailer.SMTPMailer{
+			Addr:     addr,
+			Username: os.Getenv("MATCHA_SMTP_USERNAME"),
+			Password: os.Getenv("MATCHA_SMTP_PASSWORD"),
+			From:     from,
+		}
+	} else if dir := os.Getenv("MATCHA_MAIL_DIR"); dir != "" {
+		mail = mailer.FileMailer{Dir: dir, From: from}
+	}
+	// MATCHA_BASE_URL is the public address of the app used in emailed links, never taken from request headers.
+	baseURL := strings.TrimRight(os.Getenv("MATCHA_BASE_URL"), "/")
+	if baseURL == "" {
+		baseURL = "http://localhost:8080"
+	}
+	rp, err := relyingParty(baseURL)
+	if err != nil {
+		logging.Fatal("Invalid MATCHA_BASE_URL", "err", err)
+	}
+	// MATCHA_SECRET_KEY is a base64 encoded 32 byte key encrypting secrets like TOTP seeds, losing it locks out every
+	// user with two-factor authentication.
+	var box *secrets.Box
+	if encoded := os.Getenv("MATCHA_SECRET_KEY"); encoded != "" {
+		key, err := secrets.ParseKey(encoded)
+		if err == nil {
+			box, err = secrets.New(key)
+		}
+		if err != nil {
+			logging.Fatal("Invalid MATCHA_SECRET_KEY", "err", err)
+		}
+	} else {
+		slog.Warn("MATCHA_SECRET_KEY is unset, two-factor authentication is unavailable")
+	}
+	db := internalDatabase.New("matcha_db", "root", os.Getenv("MYSQL_PASSWORD"))
+	// MATCHA_WEBHOOKS_ALLOW_PRIVATE=true lets webhooks reach loopback and private network addresses, for development.
+	hooks := webhooks.NewDispatcher(db)
+	hooks.AllowPrivate = os.Getenv("MATCHA_WEBHOOKS_ALLOW_PRIVATE") == "true"
+	// MATCHA_METRICS_TOKEN is the bearer token scrapers send for /metrics, which isn't served at all without one.
+	router := loggedRouter(os.Getenv("MATCHA_METRICS_TOKEN"))
+	matcha = newApp(internalServer.New(router, internalServer.ConfigFromEnv()), db, mail, hooks, baseURL, rp, box)
+	registerDBStats(matcha.database.Stats)
 }
 
 func main() {
-	// Channel to catch "ctrl+c" such that dependencies will be closed safely before opening them.
-	ctrlC := make(chan os.Signal, 1)
-	signal.Notify(ctrlC, syscall.SIGINT, syscall.SIGTERM)
+	setup()
+	unlock := flag.String("unlock", "", "lift the login lockout of the account with this email and exit")
+	flag.Parse()
+	if *unlock != "" {
+		unlockUser(*unlock)
+		return
+	}
 
-	// Open said dependencies and run application on a new go routine.
-	go matcha.run()
+	// Root context cancelled on "ctrl+c" such that dependencies and background jobs are closed safely.
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
 
-	// Block the main goroutine until ctrl+c interrupt is raised.
-	<-ctrlC
+	// Open said dependencies and block the main goroutine until ctrl+c interrupt is raised.
+	if err := matcha.run(ctx); err != nil {
+		logging.Fatal("Failed to start application", "err", err)
+	}
+	<-ctx.Done()
 
 	// Stop application and close the dependencies before exiting.
 	matcha.close()
 }
+
+// unlockUser is the administrator's way to let a locked out user back in before their lockout expires.
+func unlockUser(email string) {
+	if err := matcha.database.Open(); err != nil {
+		logging.Fatal("Failed to open database", "err", err)
+	}
+	defer func() {
+		if err := matcha.database.Close(); err != nil {
+			slog.Error("Failed to close database", "err", err)
+		}
+	}()
+	if err := matcha.database.UnlockUser(context.Background(), email); err != nil {
+		slog.Error("Failed to unlock user", "email", email, "err", err)
+	}
+}
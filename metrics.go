@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/matcha-devs/matcha/internal/metrics"
+)
+
+var (
+	httpRequests = metrics.NewCounter(
+		"matcha_http_requests_total", "HTTP requests served by route pattern and status code.", "route", "code",
+	)
+	httpRequestSeconds = metrics.NewHistogram(
+		"matcha_http_request_duration_seconds", "HTTP request latency by route pattern.", nil, "route",
+	)
+	httpTimeouts = metrics.NewCounter(
+		"matcha_http_timeouts_total", "Requests cut off by the client timeout by route pattern.", "route",
+	)
+	logins        = metrics.NewCounter("matcha_logins_total", "Login attempts by result.", "result")
+	apiTokenAuths = metrics.NewCounter(
+		"matcha_api_token_auths_total", "API requests authenticated by personal API token by success.", "ok",
+	)
+)
+
+// getMetrics serves the metrics to scrapers sending token as a bearer token. They tell about traffic and logins, so
+// without a token they aren't served at all.
+func getMetrics(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		sent, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized.", http.StatusUnauthorized)
+			return
+		}
+		metrics.Handler().ServeHTTP(w, r)
+	}
+}
+
+// registerDBStats exports the connection pool statistics of the database, sampled on every scrape.
+func registerDBStats(stats func() sql.DBStats) {
+	for _, stat := range []struct {
+		name, help string
+		counter    bool
+		value      func(sql.DBStats) float64
+	}{
+		{"matcha_db_max_open_connections", "Maximum number of open connections to the database.", false,
+			func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+		{"matcha_db_open_connections", "Established connections both in use and idle.", false,
+			func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+		{"matcha_db_in_use_connections", "Connections currently in use.", false,
+			func(s sql.DBStats) float64 { return float64(s.InUse) }},
+		{"matcha_db_idle_connections", "Idle connections.", false,
+			func(s sql.DBStats) float64 { return float64(s.Idle) }},
+		{"matcha_db_wait_count_total", "Total connections waited for.", true,
+			func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+		{"matcha_db_wait_duration_seconds_total", "Total time blocked waiting for a new connection.", true,
+			func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+		{"matcha_db_max_idle_closed_total", "Connections closed due to SetMaxIdleConns.", true,
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+		{"matcha_db_max_idle_time_closed_total", "Connections closed due to SetConnMaxIdleTime.", true,
+			func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }},
+		{"matcha_db_max_lifetime_closed_total", "Connections closed due to SetConnMaxLifetime.", true,
+			func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+	} {
+		sample := func() float64 { return stat.value(stats()) }
+		if stat.counter {
+			metrics.NewCounterFunc(stat.name, stat.help, sample)
+		} else {
+			metrics.NewGaugeFunc(stat.name, stat.help, sample)
+		}
+	}
+}
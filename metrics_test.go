@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetMetrics(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		status        int
+	}{
+		{"no token configured", "", "Bearer ", http.StatusNotFound},
+		{"missing token", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"basic auth", "secret", "Basic secret", http.StatusUnauthorized},
+		{"right token", "secret", "Bearer secret", http.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+				if tc.authorization != "" {
+					r.Header.Set("Authorization", tc.authorization)
+				}
+				w := httptest.NewRecorder()
+				getMetrics(tc.token)(w, r)
+				if w.Code != tc.status {
+					t.Error("Expected status", tc.status, "got", w.Code)
+				}
+			},
+		)
+	}
+}
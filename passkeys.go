@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+	"github.com/matcha-devs/matcha/internal/webauthn"
+)
+
+const (
+	maxPasskeyBody    = 64 << 10
+	maxPasskeyNameLen = 64
+)
+
+// passkeySetting is a passkey as the settings page lists it, ID is base64url encoded for the delete form.
+type passkeySetting struct {
+	ID         string
+	Name       string
+	CreatedOn  time.Time
+	LastUsedOn time.Time
+}
+
+// base64URL is binary data that browsers send base64url encoded in JSON.
+type base64URL []byte
+
+func (b *base64URL) UnmarshalJSON(data []byte) (err error) {
+	var encoded string
+	if err = json.Unmarshal(data, &encoded); err != nil {
+		return
+	}
+	*b, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	return
+}
+
+// passkeyResponse is a PublicKeyCredential as public/scripts/passkeys.js posts it.
+type passkeyResponse struct {
+	ID       base64URL `json:"id"`
+	Name     string    `json:"name"`
+	Response struct {
+		ClientDataJSON    base64URL `json:"clientDataJSON"`
+		AttestationObject base64URL `json:"attestationObject"`
+		AuthenticatorData base64URL `json:"authenticatorData"`
+		Signature         base64URL `json:"signature"`
+		UserHandle        base64URL `json:"userHandle"`
+	} `json:"response"`
+}
+
+// relyingParty derives the WebAuthn relying party from baseURL, passkeys are bound to its host name.
+func relyingParty(baseURL string) (rp webauthn.RelyingParty, err error) {
+	parsed, err := url.Parse(baseURL)
+	if err != nil {
+		return
+	}
+	if parsed.Hostname() == "" {
+		return rp, errors.New("base URL has no host")
+	}
+	return webauthn.RelyingParty{
+		ID: parsed.Hostname(), Name: "Matcha", Origins: []string{parsed.Scheme + "://" + parsed.Host},
+	}, nil
+}
+
+// userHandle is the opaque id authenticators store for a user, it must not contain personal data.
+func userHandle(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.ErrorContext(r.Context(), "Error writing JSON", "err", err)
+	}
+}
+
+func readPasskeyResponse(w http.ResponseWriter, r *http.Request) (response passkeyResponse, ok bool) {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPasskeyBody)).Decode(&response); err != nil {
+		slog.InfoContext(r.Context(), "Malformed passkey response", "err", err)
+		http.Error(w, "your browser sent a malformed passkey response", http.StatusBadRequest)
+		return response, false
+	}
+	return response, true
+}
+
+func postPasskeyRegisterOptions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := checkLoginStatus(w, r)
+	if user == nil || !requireVerifiedEmail(w, r, user) {
+		return
+	}
+	passkeys, err := matcha.database.GetPasskeys(ctx, user.ID)
+	if err != nil {
+		http.Error(w, userMessage(err), http.StatusInternalServerError)
+		return
+	}
+	existing := make([][]byte, 0, len(passkeys))
+	for _, passkey := range passkeys {
+		existing = append(existing, passkey.ID)
+	}
+	challenge, err := matcha.database.CreateWebAuthnChallenge(
+		ctx, user.ID, internalDatabase.RegisterCeremony, webauthn.Timeout,
+	)
+	if err != nil {
+		http.Error(w, userMessage(err), http.StatusInternalServerError)
+		return
+	}
+	account := webauthn.User{
+		ID: userHandle(user.ID), Name: user.Email, DisplayName: user.FirstName + " " + user.LastName,
+	}
+	writeJSON(w, r, matcha.relyingParty.CreationOptions(account, challenge, existing))
+}
+
+func postPasskeyRegister(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := checkLoginStatus(w, r)
+	if user == nil || !requireVerifiedEmail(w, r, user) {
+		return
+	}
+	response, ok := readPasskeyResponse(w, r)
+	if !ok {
+		return
+	}
+	registration, err := matcha.relyingParty.VerifyRegistration(
+		response.Response.ClientDataJSON, response.Response.AttestationObject,
+	)
+	if err != nil {
+		slog.InfoContext(ctx, "Passkey registration refused", "user_id", user.ID, "err", err)
+		http.Error(w, "your passkey couldn't be verified", http.StatusBadRequest)
+		return
+	}
+	// The challenge proves the response was made for this user's ceremony and not replayed from another.
+	if id, err := matcha.database.ConsumeWebAuthnChallenge(
+		ctx, internalDatabase.RegisterCeremony, registration.Challenge,
+	); err != nil || id != user.ID {
+		slog.InfoContext(ctx, "Passkey registration with unusable challenge", "user_id", user.ID, "err", err)
+		http.Error(w, "your passkey request expired, please try again", http.StatusBadRequest)
+		return
+	}
+	name := strings.TrimSpace(response.Name)
+	if name == "" {
+		name = "Passkey"
+	} else if len([]rune(name)) > maxPasskeyNameLen {
+		name = string([]rune(name)[:maxPasskeyNameLen])
+	}
+	err = matcha.database.AddPasskey(ctx, user.ID, registration.Credential, name)
+	if errors.Is(err, internalDatabase.ErrPasskeyTaken) {
+		http.Error(w, "this passkey is already registered", http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, userMessage(err), http.StatusInternalServerError)
+		return
+	}
+	writeMessage(w, r, "Passkey added.")
+}
+
+func postPasskeyLoginOptions(w http.ResponseWriter, r *http.Request) {
+	if ok, retryAfter := loginLimits.perIP.Allow(clientIP(r)); !ok {
+		tooManyAttempts(w, r, retryAfter)
+		return
+	}
+	challenge, err := matcha.database.CreateWebAuthnChallenge(
+		r.Context(), 0, internalDatabase.LoginCeremony, webauthn.Timeout,
+	)
+	if err != nil {
+		http.Error(w, userMessage(err), http.StatusInternalServerError)
+		return
+	}
+	// No credentials are listed, the browser offers the passkeys it has for this site, so the email isn't needed.
+	writeJSON(w, r, matcha.relyingParty.RequestOptions(challenge, nil))
+}
+
+// postPasskeyLogin logs in with a passkey, passkeys that didn't verify the user still need the second factor of users
+// with two-factor authentication.
+func postPasskeyLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if ok, retryAfter := loginLimits.perIP.Allow(clientIP(r)); !ok {
+		logins.Inc("throttled")
+		tooManyAttempts(w, r, retryAfter)
+		return
+	}
+	response, ok := readPasskeyResponse(w, r)
+	if !ok {
+		return
+	}
+	refuse := func(reason string, err error) {
+		logins.Inc("failure")
+		slog.InfoContext(ctx, "Passkey login refused", "reason", reason, "err", err)
+		http.Error(w, "your passkey couldn't be verified", http.StatusUnauthorized)
+	}
+	id, credential, err := matcha.database.GetPasskey(ctx, response.ID)
+	if err != nil {
+		refuse("unknown passkey", err)
+		return
+	}
+	assertion, err := matcha.relyingParty.VerifyAssertion(
+		credential, response.Response.ClientDataJSON, response.Response.AuthenticatorData, response.Response.Signature,
+	)
+	if errors.Is(err, webauthn.ErrCloned) {
+		slog.WarnContext(ctx, "Passkey may have been cloned", "user_id", id)
+	}
+	if err != nil {
+		refuse("invalid assertion", err)
+		return
+	}
+	if _, err = matcha.database.ConsumeWebAuthnChallenge(
+		ctx, internalDatabase.LoginCeremony, assertion.Challenge,
+	); err != nil {
+		refuse("unusable challenge", err)
+		return
+	}
+	if handle := response.Response.UserHandle; len(handle) != 0 && string(handle) != string(userHandle(id)) {
+		refuse("user handle mismatch", nil)
+		return
+	}
+	err = matcha.database.UsePasskey(ctx, credential.ID, assertion.SignCount)
+	if errors.Is(err, internalDatabase.ErrAccountDeleted) {
+		logins.Inc("deleted")
+		http.Error(w, userMessage(err), http.StatusForbidden)
+		return
+	} else if err != nil {
+		http.Error(w, userMessage(err), http.StatusInternalServerError)
+		return
+	}
+	user := matcha.database.GetUser(ctx, id)
+	if user == nil {
+		http.Error(w, userMessage(internalDatabase.ErrInternal), http.StatusInternalServerError)
+		return
+	}
+	if !user.EmailVerified() {
+		// Like password logins, since a passkey stays with the account when its email is changed.
+		logins.Inc("unverified")
+		if ok, _ := mailLimits.allow(r, user.Email); ok {
+			if err = sendVerification(ctx, id, user.Email); err != nil {
+				slog.ErrorContext(ctx, "Error sending verification", "user_id", id, "err", err)
+			}
+		}
+		http.Error(w, "Please verify your email first with the link we've emailed you.", http.StatusForbidden)
+		return
+	}
+	if user.TOTPEnabled && !assertion.UserVerified {
+		logins.Inc("second_factor")
+		if err = beginTwoFactorLogin(w, r, id); err != nil {
+			http.Error(w, userMessage(err), http.StatusInternalServerError)
+		}
+		return
+	}
+	logins.Inc("success")
+	if err = startSession(w, r, id); err != nil {
+		http.Error(w, userMessage(err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("HX-Redirect", "/dashboard")
+}
+
+func postDeletePasskey(w http.ResponseWriter, r *http.Request) {
+	user := checkLoginStatus(w, r)
+	if user == nil {
+		return
+	}
+	id, err := base64.RawURLEncoding.DecodeString(r.FormValue("id"))
+	if err != nil {
+		writeMessage(w, r, "please reload the page and try again")
+		return
+	}
+	if err = matcha.database.DeletePasskey(r.Context(), user.ID, id); err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+}
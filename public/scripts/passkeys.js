@@ -0,0 +1,76 @@
+// Copyright (c) 2024 Andrea Goh and Seoyoung Cho.
+
+// Runs the passkey ceremonies of forms marked data-passkey="register" or data-passkey="login". The server speaks
+// base64url wherever the browser API wants bytes, and gets the CSRF token the same way HTMX requests do.
+(() => {
+    const toBytes = (value) =>
+        Uint8Array.from(atob(value.replace(/-/g, "+").replace(/_/g, "/")), (c) => c.charCodeAt(0));
+    const toBase64URL = (buffer) =>
+        btoa(String.fromCharCode(...new Uint8Array(buffer))).replace(/\+/g, "-").replace(/\//g, "_")
+            .replace(/=+$/, "");
+
+    async function post(path, body) {
+        const headers = JSON.parse(document.documentElement.getAttribute("hx-headers"));
+        headers["Content-Type"] = "application/json";
+        const response = await fetch(path, {method: "POST", headers, body: JSON.stringify(body ?? {})});
+        if (!response.ok) {
+            throw new Error(await response.text());
+        }
+        return response;
+    }
+
+    async function register(form) {
+        const options = await (await post("/passkeys/register/options")).json();
+        options.challenge = toBytes(options.challenge);
+        options.user.id = toBytes(options.user.id);
+        options.excludeCredentials = options.excludeCredentials.map((c) => ({...c, id: toBytes(c.id)}));
+        const credential = await navigator.credentials.create({publicKey: options});
+        await post("/passkeys/register", {
+            id: credential.id,
+            name: form.elements.name.value,
+            response: {
+                clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+                attestationObject: toBase64URL(credential.response.attestationObject),
+            },
+        });
+        window.location.reload();
+    }
+
+    async function login() {
+        const options = await (await post("/passkeys/login/options")).json();
+        options.challenge = toBytes(options.challenge);
+        options.allowCredentials = options.allowCredentials.map((c) => ({...c, id: toBytes(c.id)}));
+        const credential = await navigator.credentials.get({publicKey: options});
+        const response = await post("/passkeys/login", {
+            id: credential.id,
+            response: {
+                clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+                authenticatorData: toBase64URL(credential.response.authenticatorData),
+                signature: toBase64URL(credential.response.signature),
+                userHandle: credential.response.userHandle ? toBase64URL(credential.response.userHandle) : "",
+            },
+        });
+        window.location.href = response.headers.get("HX-Redirect") ?? "/dashboard";
+    }
+
+    document.querySelectorAll("form[data-passkey]").forEach((form) => {
+        const output = form.querySelector("output");
+        if (!window.PublicKeyCredential) {
+            output.textContent = "Your browser doesn't support passkeys.";
+            form.querySelectorAll("input, button").forEach((input) => input.disabled = true);
+            return;
+        }
+        form.addEventListener("submit", async (event) => {
+            event.preventDefault();
+            output.textContent = "";
+            try {
+                await (form.dataset.passkey === "register" ? register(form) : login());
+            } catch (err) {
+                // A cancelled browser prompt rejects with NotAllowedError, which needs no explanation.
+                if (err.name !== "NotAllowedError") {
+                    output.textContent = err.message;
+                }
+            }
+        });
+    });
+})();
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+	"github.com/matcha-devs/matcha/internal/validation"
+)
+
+// upcomingDays is how far ahead the dashboard and the API look for upcoming bills by default.
+const upcomingDays = 30
+
+// recurringFields are the recurring transaction form fields that may be marked with an error.
+var recurringFields = []string{"financial_account_id", "name", "amount", "type", "rule", "starts_on", "ends_on"}
+
+type repeatPreset struct {
+	Rule  string
+	Label string
+}
+
+// repeatPresets are the schedules offered on the dashboard, the API takes any rule recurrence.Parse does.
+var repeatPresets = []repeatPreset{
+	{"FREQ=MONTHLY", "Monthly, on the day it starts"},
+	{"FREQ=MONTHLY;BYMONTHDAY=-1", "Monthly, on the last day"},
+	{"FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-1", "Monthly, on the last business day"},
+	{"FREQ=WEEKLY", "Weekly"},
+	{"FREQ=WEEKLY;INTERVAL=2", "Every 2 weeks"},
+	{"FREQ=YEARLY", "Yearly"},
+}
+
+type recurringRow struct {
+	ID       uint64
+	Name     string
+	Account  string
+	Amount   string
+	Schedule string
+	NextOn   string
+}
+
+type upcomingRow struct {
+	Date    string
+	Name    string
+	Account string
+	Amount  string
+	// Overdue occurrences are still waiting for a matching transaction.
+	Overdue bool
+}
+
+// dashboardData is what the dashboard shows on top of pageData.
+type dashboardData struct {
+	Upcoming         []upcomingRow
+	Recurring        []recurringRow
+	Accounts         []internal.FinancialAccount
+	TransactionTypes []string
+	RepeatPresets    []repeatPreset
+	Today            string
+	Forecast         forecastView
+}
+
+func getDashboard(w http.ResponseWriter, r *http.Request) {
+	data := newPageData(r, "dashboard")
+	if data.User = checkLoginStatus(w, r); data.User == nil {
+		return
+	}
+	if err := dashboard(r, &data); err != nil {
+		http.Error(w, userMessage(err), http.StatusInternalServerError)
+		return
+	}
+	executeTemplate(w, r, "dashboard.go.html", data)
+}
+
+// dashboard fills in the upcoming bills, recurring transactions and cash-flow forecast of the user, along with what
+// the form to add a recurring transaction offers. The forecast looks as many days ahead as the forecast parameter
+// says.
+func dashboard(r *http.Request, data *pageData) (err error) {
+	ctx, userID := r.Context(), data.User.ID
+	accounts, err := allAccounts(ctx, userID)
+	if err != nil {
+		return
+	}
+	names := make(map[uint64]string, len(accounts))
+	for _, account := range accounts {
+		names[account.ID] = account.Name
+	}
+	recurring, err := matcha.database.GetRecurring(ctx, userID)
+	if err != nil {
+		return
+	}
+	today := time.Now()
+	upcoming, err := matcha.database.GetUpcoming(ctx, userID, today.AddDate(0, 0, upcomingDays))
+	if err != nil {
+		return
+	}
+	days := defaultForecastDays
+	if param := r.URL.Query().Get("forecast"); param != "" {
+		if days, err = forecastDays(param); err != nil {
+			days, err = defaultForecastDays, nil
+		}
+	}
+	projected, err := projectForecast(ctx, userID, days, nil, "")
+	if err != nil {
+		return
+	}
+	todayDate := today.Format(validation.DateLayout)
+	data.Dashboard = dashboardData{
+		Accounts: accounts, TransactionTypes: internal.TransactionTypes, RepeatPresets: repeatPresets, Today: todayDate,
+		Forecast: newForecastView(projected, days, ""),
+	}
+	for _, occurrence := range upcoming {
+		data.Dashboard.Upcoming = append(
+			data.Dashboard.Upcoming, upcomingRow{
+				occurrence.Date, occurrence.Name, names[occurrence.FinancialAccountID], formatCents(occurrence.Amount),
+				occurrence.Date < todayDate,
+			},
+		)
+	}
+	for _, scheduled := range recurring {
+		schedule := describeRule(scheduled.Rule)
+		if scheduled.EndsOn != "" {
+			schedule += " until " + scheduled.EndsOn
+		}
+		data.Dashboard.Recurring = append(
+			data.Dashboard.Recurring, recurringRow{
+				scheduled.ID, scheduled.Name, names[scheduled.FinancialAccountID], formatCents(scheduled.Amount),
+				schedule, scheduled.NextOn,
+			},
+		)
+	}
+	return
+}
+
+// postRecurring adds a recurring transaction from the dashboard form, where amounts are typed in dollars and whether
+// money goes out or comes in is chosen apart.
+func postRecurring(w http.ResponseWriter, r *http.Request) {
+	user := checkLoginStatus(w, r)
+	if user == nil || !requireVerifiedEmail(w, r, user) {
+		return
+	}
+	errs := validation.Errors{}
+	accountID, err := strconv.ParseUint(r.FormValue("financial_account_id"), 10, 64)
+	if err != nil {
+		errs.Add("financial_account_id", "please choose an account")
+	}
+	amount, ok := parseCents(r.FormValue("amount"))
+	if !ok || amount <= 0 {
+		errs.Add("amount", "please enter an amount like 1500.00")
+	}
+	if r.FormValue("direction") != "in" {
+		amount = -amount
+	}
+	_, err = matcha.database.AddRecurring(
+		r.Context(), user.ID, internal.RecurringTransaction{
+			FinancialAccountID: accountID, Name: r.FormValue("name"), Amount: amount, Type: r.FormValue("type"),
+			Rule: r.FormValue("rule"), StartsOn: r.FormValue("starts_on"), EndsOn: r.FormValue("ends_on"),
+		},
+	)
+	var dbErrs validation.Errors
+	if errors.As(err, &dbErrs) {
+		for field, message := range dbErrs {
+			errs.Add(field, message)
+		}
+	} else if err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, r, recurringFields, errs)
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+}
+
+func postDeleteRecurring(w http.ResponseWriter, r *http.Request) {
+	user := checkLoginStatus(w, r)
+	if user == nil || !requireVerifiedEmail(w, r, user) {
+		return
+	}
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		writeMessage(w, r, "please reload the page and try again")
+		return
+	}
+	if err = matcha.database.DeleteRecurring(r.Context(), user.ID, id); err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+}
+
+// parseCents reads an amount of dollars like "1,500.5" into cents.
+func parseCents(text string) (cents int64, ok bool) {
+	text = strings.ReplaceAll(strings.TrimPrefix(strings.TrimSpace(text), "$"), ",", "")
+	whole, fraction, _ := strings.Cut(text, ".")
+	if whole == "" && fraction == "" || len(fraction) > 2 || strings.ContainsAny(whole+fraction, "+-") {
+		return 0, false
+	}
+	fraction += strings.Repeat("0", 2-len(fraction))
+	if whole == "" {
+		whole = "0"
+	}
+	dollars, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || dollars > 1e12 {
+		return 0, false
+	}
+	hundredths, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return dollars*100 + hundredths, true
+}
+
+// formatCents writes an amount in cents as dollars, like "-$1,500.00".
+func formatCents(cents int64) string {
+	sign := ""
+	if cents < 0 {
+		sign, cents = "-", -cents
+	}
+	dollars := strconv.FormatInt(cents/100, 10)
+	for i := len(dollars) - 3; i > 0; i -= 3 {
+		dollars = dollars[:i] + "," + dollars[i:]
+	}
+	return sign + "$" + dollars + "." + strconv.FormatInt(cents%100+100, 10)[1:]
+}
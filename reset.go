@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+	"github.com/matcha-devs/matcha/internal/validation"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	resetSentMessage = "If an account uses that email, we've sent it a link to reset the password."
+)
+
+func postForgotPassword(w http.ResponseWriter, r *http.Request) {
+	email := validation.NormalizeEmail(r.FormValue("email"))
+	if ok, retryAfter := mailLimits.allow(r, email); !ok {
+		tooManyAttempts(w, r, retryAfter)
+		return
+	}
+	// Whether the email is registered or not, the work happens after answering the same way, so neither the response
+	// nor its timing tell.
+	ctx := context.WithoutCancel(r.Context())
+	if !matcha.tasks.Go(func() { sendPasswordReset(ctx, email) }) {
+		slog.ErrorContext(ctx, "Password reset dropped while shutting down", "email", email)
+	}
+	writeMessage(w, r, resetSentMessage)
+}
+
+func sendPasswordReset(ctx context.Context, email string) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	id := matcha.database.GetUserID(ctx, email)
+	if id == 0 {
+		slog.InfoContext(ctx, "Password reset for unknown email", "email", email)
+		return
+	}
+	token, err := matcha.database.CreateUserToken(ctx, id, internalDatabase.PasswordResetToken, passwordResetTTL)
+	if err != nil {
+		return
+	}
+	link := matcha.baseURL + "/reset-password?token=" + url.QueryEscape(token)
+	if err := queueMail(ctx, email, "password-reset", map[string]any{"Link": link}); err != nil {
+		slog.ErrorContext(ctx, "Failed to queue password reset", "user_id", id, "err", err)
+	}
+}
+
+func postResetPassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	password := r.FormValue("psw")
+	// The account isn't known before the token is redeemed, so the password can't be checked against its email.
+	errs := validation.Errors{}
+	if validation.Password(errs, "psw", password, ""); len(errs) > 0 {
+		writeMessage(w, r, errs["psw"])
+		return
+	}
+	if password != r.FormValue("psw-repeat") {
+		writeMessage(w, r, "passwords do not match")
+		return
+	}
+	if _, err := matcha.database.ResetPassword(ctx, r.FormValue("token"), password); err != nil {
+		slog.InfoContext(ctx, "Password reset refused", "err", err)
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	endSession(w, r)
+	w.Header().Set("HX-Redirect", "/login")
+}
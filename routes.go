@@ -1,32 +1,294 @@
 package main
 
 import (
-	"log"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/matcha-devs/matcha/internal/logging"
+	"github.com/matcha-devs/matcha/internal/tracing"
+)
+
+const (
+	maxHandleTime = 5 * time.Second
+	// maxTakeoutTime bounds exporting and importing everything a user has, up to maxImportSize of it.
+	maxTakeoutTime = time.Minute
+	csrfCookieName = "c_csrf"
+	csrfHeaderName = "X-CSRF-Token"
+	csrfFormField  = "csrf_token"
 )
 
-const maxHandleTime = 5 * time.Second
+type csrfTokenKey struct{}
+
+// csrfKey keys the CSRF tokens derived from login sessions, so they can't be worked out from the session cookie. It's
+// made anew on every start, which only makes pages open from before a restart reload.
+var csrfKey = func() (key []byte) {
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		logging.Fatal("Error generating CSRF key", "err", err)
+	}
+	return
+}()
+
+// responseRecorder remembers the status and size of a response for the access logs.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (n int, err error) {
+	n, err = rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return
+}
+
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+func withRequestID(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !logging.ValidRequestID(id) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set("X-Request-ID", id)
+		handler.ServeHTTP(w, r.WithContext(logging.WithRequestID(r.Context(), id)))
+	}
+}
 
 func withRequestLogs(handler http.Handler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		log.Println("Request:", r.Method, r.URL)
-		handler.ServeHTTP(w, r)
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		handler.ServeHTTP(rec, r)
+		slog.InfoContext(
+			r.Context(), "Request", "method", r.Method, "path", r.URL.Path, "status", rec.status, "bytes", rec.bytes,
+			"latency", time.Since(start),
+		)
 	}
 }
 
-func withClientTimeout(handlerFunc http.HandlerFunc) http.Handler {
-	return http.TimeoutHandler(handlerFunc, maxHandleTime, "")
+// withMetrics counts and times requests under the route pattern that matched them.
+func withMetrics(pattern string, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		handler.ServeHTTP(rec, r)
+		httpRequestSeconds.Observe(time.Since(start).Seconds(), pattern)
+		httpRequests.Inc(pattern, strconv.Itoa(rec.status))
+	}
 }
 
-func loggedRouter() http.Handler {
+// withTracing opens the server span of a request, joining the caller's trace when it sent a traceparent header.
+func withTracing(pattern string, handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if remote, ok := tracing.ParseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = tracing.ContextWithRemote(ctx, remote)
+		}
+		ctx, span := tracing.Start(ctx, pattern)
+		defer span.End()
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.Path)
+		w.Header().Set("traceparent", span.SpanContext().Traceparent())
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttribute("http.status_code", rec.status)
+	}
+}
+
+// csrfToken is the token of the client's login session, or browser before logging in, that withCSRF expects back on
+// unsafe requests.
+func csrfToken(r *http.Request) string {
+	token, _ := r.Context().Value(csrfTokenKey{}).(string)
+	return token
+}
+
+// forbidden is the single response given to requests refused for security reasons.
+func forbidden(w http.ResponseWriter, r *http.Request, reason string) {
+	slog.WarnContext(r.Context(), "Forbidden request", "reason", reason, "method", r.Method, "path", r.URL.Path)
+	http.Error(w, "Forbidden - please reload the page and try again.", http.StatusForbidden)
+}
+
+// sameOrigin checks the Origin header, falling back to the Referer, against the host the request was sent to.
+func sameOrigin(r *http.Request) (ok bool, reason string) {
+	source := r.Header.Get("Origin")
+	if source == "" {
+		if source = r.Header.Get("Referer"); source == "" {
+			return true, ""
+		}
+	}
+	origin, err := url.Parse(source)
+	if err != nil || origin.Host == "" {
+		return false, "malformed origin"
+	}
+	if origin.Host != r.Host {
+		return false, "cross origin request from " + origin.Host
+	}
+	return true, ""
+}
+
+// withCSRF requires unsafe requests to come from this origin and to echo a token in the X-CSRF-Token header (set on
+// every HTMX request by common.go.html) or in a csrf_token form field. Logged in browsers get a token derived from
+// their session, which is useless once it ends, others a random token in a cookie for forms like the login one. API
+// requests with a personal API token are exempt.
+func withCSRF(handler http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var token string
+		if session, err := r.Cookie(sessionCookieName); err == nil && session.Value != "" {
+			token = sessionCSRFToken(session.Value)
+		} else if cookie, err := r.Cookie(csrfCookieName); err == nil && len(cookie.Value) == 43 {
+			token = cookie.Value
+		} else {
+			buf := make([]byte, 32)
+			if _, err := rand.Read(buf); err != nil {
+				slog.ErrorContext(r.Context(), "Failed to generate CSRF token", "err", err)
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+				return
+			}
+			token = base64.RawURLEncoding.EncodeToString(buf)
+			http.SetCookie(
+				w, &http.Cookie{
+					Name:     csrfCookieName,
+					Value:    token,
+					Path:     "/",
+					HttpOnly: true,
+					Secure:   r.TLS != nil,
+					SameSite: http.SameSiteLaxMode,
+				},
+			)
+		}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		default:
+			// Browsers only send an Authorization header cross site after a CORS preflight, which is never allowed,
+			// and the API doesn't look at the cookies of requests carrying one.
+			if strings.HasPrefix(r.URL.Path, apiPrefix+"/") && r.Header.Get("Authorization") != "" {
+				break
+			}
+			if ok, reason := sameOrigin(r); !ok {
+				forbidden(w, r, reason)
+				return
+			}
+			sent := r.Header.Get(csrfHeaderName)
+			if sent == "" {
+				sent = r.PostFormValue(csrfFormField)
+			}
+			if subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+				forbidden(w, r, "missing or invalid CSRF token")
+				return
+			}
+		}
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfTokenKey{}, token)))
+	}
+}
+
+// sessionCSRFToken is the CSRF token of a login session.
+func sessionCSRFToken(session string) string {
+	mac := hmac.New(sha256.New, csrfKey)
+	mac.Write([]byte(session))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func withClientTimeout(pattern string, timeout time.Duration, handlerFunc http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// The server's read and write timeouts suit quick routes, slower ones push them back to their own.
+			if timeout > maxHandleTime {
+				deadline, controller := time.Now().Add(timeout), http.NewResponseController(w)
+				err := errors.Join(controller.SetReadDeadline(deadline), controller.SetWriteDeadline(deadline))
+				if err != nil {
+					slog.WarnContext(r.Context(), "Failed to extend connection deadlines", "route", pattern, "err", err)
+				}
+			}
+			// The timeout handler returns before the wrapped handler finishes only when it gave up on it.
+			var finished atomic.Bool
+			http.TimeoutHandler(
+				http.HandlerFunc(
+					func(w http.ResponseWriter, r *http.Request) {
+						handlerFunc(w, r)
+						finished.Store(true)
+					},
+				), timeout, "",
+			).ServeHTTP(w, r)
+			if !finished.Load() {
+				httpTimeouts.Inc(pattern)
+				slog.WarnContext(r.Context(), "Request timed out", "route", pattern)
+			}
+		},
+	)
+}
+
+// loggedRouter routes every page and API endpoint, /metrics only for scrapers sending metricsToken.
+func loggedRouter(metricsToken string) http.Handler {
 	mux := http.NewServeMux()
-	mux.Handle("GET /public/", withClientTimeout(getPublic))
-	mux.Handle("GET /{$}", withClientTimeout(getIndex))
-	mux.Handle("POST /signup", withClientTimeout(postSignup))
-	mux.Handle("POST /login", withClientTimeout(postLogin))
-	mux.Handle("POST /logout", withClientTimeout(postLogout))
-	mux.Handle("POST /delete-user", withClientTimeout(postDeleteUser))
-	mux.Handle("GET /", withClientTimeout(getPage))
-	return withRequestLogs(mux)
+	handleWithin := func(pattern string, timeout time.Duration, handlerFunc http.HandlerFunc) {
+		mux.Handle(
+			pattern, withMetrics(pattern, withTracing(pattern, withClientTimeout(pattern, timeout, handlerFunc))),
+		)
+	}
+	handle := func(pattern string, handlerFunc http.HandlerFunc) {
+		handleWithin(pattern, maxHandleTime, handlerFunc)
+	}
+	handle("GET /public/", getPublic)
+	handle("GET /{$}", getIndex)
+	handle("GET /metrics", getMetrics(metricsToken))
+	handle("POST /signup", postSignup)
+	handle("POST /login", postLogin)
+	handle("POST /logout", postLogout)
+	handle("POST /delete-user", postDeleteUser)
+	handle("POST /restore-account", postRestoreAccount)
+	handle("POST /forgot-password", postForgotPassword)
+	handle("POST /reset-password", postResetPassword)
+	handle("POST /verify-email", postVerifyEmail)
+	handle("POST /resend-verification", postResendVerification)
+	handle("POST /change-email", postChangeEmail)
+	handle("POST /two-factor-login", postTwoFactorLogin)
+	handle("GET /two-factor", getTwoFactor)
+	handle("POST /two-factor/enable", postEnableTwoFactor)
+	handle("POST /two-factor/disable", postDisableTwoFactor)
+	handle("POST /passkeys/register/options", postPasskeyRegisterOptions)
+	handle("POST /passkeys/register", postPasskeyRegister)
+	handle("POST /passkeys/login/options", postPasskeyLoginOptions)
+	handle("POST /passkeys/login", postPasskeyLogin)
+	handle("POST /passkeys/delete", postDeletePasskey)
+	handle("POST /api-tokens", postAPIToken)
+	handle("POST /api-tokens/delete", postDeleteAPIToken)
+	handle("POST /webhooks", postWebhook)
+	handle("POST /webhooks/delete", postDeleteWebhook)
+	handle("POST /webhooks/test", postTestWebhook)
+	handle("GET /dashboard", getDashboard)
+	handle("POST /recurring", postRecurring)
+	handle("POST /recurring/delete", postDeleteRecurring)
+	handle("POST /forecast", postWhatIf)
+	handle("GET /settings", getSettings)
+	handle("POST /profile", postProfile)
+	handle("POST /change-password", postChangePassword)
+	handle("POST /notifications", postNotifications)
+	handleWithin("GET /export", maxTakeoutTime, getExport)
+	handleWithin("POST /import", maxTakeoutTime, postImport)
+	for _, route := range apiRoutes {
+		handle(route.Method+" "+apiPrefix+route.Path, serveAPI(route))
+	}
+	handle("GET "+apiPrefix+"/", apiNotFound)
+	handle("GET /", getPage)
+	return withRequestID(withRequestLogs(withCSRF(mux)))
 }
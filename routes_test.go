@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	handler := withCSRF(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, csrfToken(r))
+			},
+		),
+	)
+	const (
+		browserToken = "bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+		session      = "session"
+	)
+	browser := &http.Cookie{Name: csrfCookieName, Value: browserToken}
+	loggedIn := &http.Cookie{Name: sessionCookieName, Value: session}
+	tests := []struct {
+		name    string
+		method  string
+		cookies []*http.Cookie
+		header  map[string]string
+		form    url.Values
+		status  int
+	}{
+		{"safe method without token", http.MethodGet, []*http.Cookie{browser}, nil, nil, http.StatusOK},
+		{"missing token", http.MethodPost, []*http.Cookie{browser}, nil, nil, http.StatusForbidden},
+		{
+			"wrong token", http.MethodPost, []*http.Cookie{browser}, map[string]string{csrfHeaderName: "wrong"}, nil,
+			http.StatusForbidden,
+		},
+		{
+			"browser token without origin or referer", http.MethodPost, []*http.Cookie{browser},
+			map[string]string{csrfHeaderName: browserToken}, nil, http.StatusOK,
+		},
+		{
+			"browser token in form", http.MethodPost, []*http.Cookie{browser}, nil,
+			url.Values{csrfFormField: {browserToken}}, http.StatusOK,
+		},
+		{
+			"same origin", http.MethodPost, []*http.Cookie{browser},
+			map[string]string{csrfHeaderName: browserToken, "Origin": "http://example.com"}, nil, http.StatusOK,
+		},
+		{
+			"cross origin", http.MethodPost, []*http.Cookie{browser},
+			map[string]string{csrfHeaderName: browserToken, "Origin": "https://evil.example"}, nil,
+			http.StatusForbidden,
+		},
+		{
+			"cross origin referer", http.MethodPost, []*http.Cookie{browser},
+			map[string]string{csrfHeaderName: browserToken, "Referer": "https://evil.example/page"}, nil,
+			http.StatusForbidden,
+		},
+		{
+			"session token", http.MethodPost, []*http.Cookie{browser, loggedIn},
+			map[string]string{csrfHeaderName: sessionCSRFToken(session)}, nil, http.StatusOK,
+		},
+		{
+			"browser token once logged in", http.MethodPost, []*http.Cookie{browser, loggedIn},
+			map[string]string{csrfHeaderName: browserToken}, nil, http.StatusForbidden,
+		},
+		{
+			"token of another session", http.MethodPost, []*http.Cookie{loggedIn},
+			map[string]string{csrfHeaderName: sessionCSRFToken("other")}, nil, http.StatusForbidden,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				r := httptest.NewRequest(tc.method, "http://example.com/login", strings.NewReader(tc.form.Encode()))
+				if tc.form != nil {
+					r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+				}
+				for name, value := range tc.header {
+					r.Header.Set(name, value)
+				}
+				for _, cookie := range tc.cookies {
+					r.AddCookie(cookie)
+				}
+				w := httptest.NewRecorder()
+				handler(w, r)
+				if w.Code != tc.status {
+					t.Error("Expected status", tc.status, "got", w.Code, w.Body.String())
+				}
+			},
+		)
+	}
+}
+
+func TestCSRFCookie(t *testing.T) {
+	handler := withCSRF(
+		http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, csrfToken(r))
+			},
+		),
+	)
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "http://example.com/login", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != csrfCookieName || cookies[0].Value != w.Body.String() {
+		t.Fatal("Expected a new browser to get its token in a cookie, got", cookies, w.Body.String())
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "http://example.com/dashboard", nil)
+	r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: "session"})
+	w = httptest.NewRecorder()
+	handler(w, r)
+	if len(w.Result().Cookies()) != 0 || w.Body.String() != sessionCSRFToken("session") {
+		t.Error("Expected a logged in browser to get the token of its session, got", w.Body.String())
+	}
+	if sessionCSRFToken("session") == sessionCSRFToken("other") || sessionCSRFToken("session") == "session" {
+		t.Error("Expected every session to get a token of its own")
+	}
+}
+
+func TestCSRFAPIToken(t *testing.T) {
+	handler := withCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		status        int
+	}{
+		{"api with token", apiPrefix + "/transactions", "Bearer token", http.StatusOK},
+		{"api without token", apiPrefix + "/transactions", "", http.StatusForbidden},
+		{"page with token", "/login", "Bearer token", http.StatusForbidden},
+	}
+	for _, tc := range tests {
+		t.Run(
+			tc.name, func(t *testing.T) {
+				r := httptest.NewRequest(http.MethodPost, "http://example.com"+tc.path, nil)
+				r.Header.Set("Origin", "https://evil.example")
+				if tc.authorization != "" {
+					r.Header.Set("Authorization", tc.authorization)
+				}
+				w := httptest.NewRecorder()
+				handler(w, r)
+				if w.Code != tc.status {
+					t.Error("Expected status", tc.status, "got", w.Code, w.Body.String())
+				}
+			},
+		)
+	}
+}
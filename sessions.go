@@ -0,0 +1,78 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+)
+
+const (
+	sessionCookieName = "c_session"
+	sessionDuration   = 20 * time.Minute
+)
+
+// startSession logs the user in by storing a new session and handing its token to the browser.
+func startSession(w http.ResponseWriter, r *http.Request, userID uint64) (err error) {
+	token, err := matcha.database.CreateSession(r.Context(), userID, sessionDuration)
+	if err != nil {
+		return
+	}
+	slog.InfoContext(r.Context(), "Started session", "user_id", userID)
+	http.SetCookie(
+		w, &http.Cookie{
+			Name:     sessionCookieName,
+			Value:    token,
+			Path:     "/",
+			Expires:  time.Now().Add(sessionDuration),
+			MaxAge:   int(sessionDuration.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		},
+	)
+	return
+}
+
+// endSession deletes the session of the request, if any, and tells the browser to forget it along with its CSRF
+// token, so whoever uses the browser next gets a new one.
+func endSession(w http.ResponseWriter, r *http.Request) {
+	if cookie, err := r.Cookie(sessionCookieName); err == nil {
+		if err := matcha.database.DeleteSession(r.Context(), cookie.Value); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to delete session", "err", err)
+		}
+	}
+	for _, name := range []string{sessionCookieName, csrfCookieName} {
+		http.SetCookie(
+			w, &http.Cookie{
+				Name:     name,
+				Value:    "",
+				Path:     "/",
+				MaxAge:   -1,
+				HttpOnly: true,
+				Secure:   r.TLS != nil,
+				SameSite: http.SameSiteLaxMode,
+			},
+		)
+	}
+}
+
+func checkLoginStatus(w http.ResponseWriter, r *http.Request) (user *internal.User) {
+	ctx := r.Context()
+	cookie, err := r.Cookie(sessionCookieName)
+	if errors.Is(err, http.ErrNoCookie) {
+		slog.InfoContext(ctx, "Client has no session cookie")
+		http.Error(w, "Unauthorized login session.", http.StatusUnauthorized)
+		return nil
+	} else if err != nil {
+		slog.ErrorContext(ctx, "Error getting session cookie", "err", err)
+		http.Error(w, "Unauthorized login session.", http.StatusUnauthorized)
+		return nil
+	}
+	if user = matcha.database.GetSessionUser(ctx, cookie.Value); user == nil {
+		http.Error(w, "Invalid login session.", http.StatusUnauthorized)
+	}
+	return
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+	"github.com/matcha-devs/matcha/internal/validation"
+)
+
+var (
+	// profileFields are the profile form fields that may be marked with an error.
+	profileFields  = []string{"first_name", "middle_name", "last_name", "date_of_birth"}
+	passwordFields = []string{"current_password", "new_password", "new_password_repeat", "code"}
+)
+
+// profileForm reads the personal details shared by the signup and settings forms.
+func profileForm(r *http.Request) validation.Profile {
+	return validation.Profile{
+		FirstName:   r.FormValue("first_name"),
+		MiddleName:  r.FormValue("middle_name"),
+		LastName:    r.FormValue("last_name"),
+		DateOfBirth: r.FormValue("date_of_birth"),
+	}
+}
+
+func getSettings(w http.ResponseWriter, r *http.Request) {
+	data := newPageData(r, "settings")
+	if data.User = checkLoginStatus(w, r); data.User == nil {
+		return
+	}
+	preferences, err := matcha.database.GetNotificationPreferences(r.Context(), data.User.ID)
+	if err != nil {
+		http.Error(w, userMessage(err), http.StatusInternalServerError)
+		return
+	}
+	for _, kind := range internalDatabase.NotificationKinds {
+		data.Notifications = append(
+			data.Notifications, notificationSetting{kind, notificationLabels[kind], preferences[kind]},
+		)
+	}
+	passkeys, err := matcha.database.GetPasskeys(r.Context(), data.User.ID)
+	if err != nil {
+		http.Error(w, userMessage(err), http.StatusInternalServerError)
+		return
+	}
+	for _, passkey := range passkeys {
+		data.Passkeys = append(
+			data.Passkeys, passkeySetting{
+				base64.RawURLEncoding.EncodeToString(passkey.ID), passkey.Name, passkey.CreatedOn, passkey.LastUsedOn,
+			},
+		)
+	}
+	if err = apiTokenSettings(r, &data); err != nil {
+		http.Error(w, userMessage(err), http.StatusInternalServerError)
+		return
+	}
+	if err = webhookSettings(r, &data); err != nil {
+		http.Error(w, userMessage(err), http.StatusInternalServerError)
+		return
+	}
+	executeTemplate(w, r, "settings.go.html", data)
+}
+
+func postProfile(w http.ResponseWriter, r *http.Request) {
+	user := checkLoginStatus(w, r)
+	if user == nil {
+		return
+	}
+	profile := profileForm(r)
+	err := matcha.database.UpdateUserProfile(
+		r.Context(), user.ID, profile.FirstName, profile.MiddleName, profile.LastName, profile.DateOfBirth,
+	)
+	var errs validation.Errors
+	if errors.As(err, &errs) {
+		writeFieldErrors(w, r, profileFields, errs)
+		return
+	} else if err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	writeFieldErrors(w, r, profileFields, nil)
+	writeMessage(w, r, "Profile saved.")
+}
+
+// postChangePassword changes the password once the current one, and second factor if any, are confirmed, every other
+// session is logged out.
+func postChangePassword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := checkLoginStatus(w, r)
+	if user == nil {
+		return
+	}
+	errs := validation.Errors{}
+	if r.FormValue("new_password") != r.FormValue("new_password_repeat") {
+		errs.Add("new_password_repeat", "passwords do not match")
+		writeFieldErrors(w, r, passwordFields, errs)
+		return
+	}
+	if ok, retryAfter := loginLimits.allow(r, user.Email); !ok {
+		tooManyAttempts(w, r, retryAfter)
+		return
+	}
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	// The password goes first, so a wrong one doesn't use up the step of the code or a recovery code.
+	_, err = matcha.database.AuthenticateLogin(ctx, user.Email, r.FormValue("current_password"))
+	if errors.Is(err, internalDatabase.ErrInvalidCredentials) {
+		loginLimits.failed(user.Email)
+		errs.Add("current_password", "wrong password")
+		writeFieldErrors(w, r, passwordFields, errs)
+		return
+	} else if err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	if err = checkSecondFactor(ctx, user, r.FormValue("code")); err != nil {
+		loginLimits.failed(user.Email)
+		errs.Add("code", userMessage(err))
+		writeFieldErrors(w, r, passwordFields, errs)
+		return
+	}
+	err = matcha.database.ChangePassword(
+		ctx, user.ID, r.FormValue("current_password"), r.FormValue("new_password"), cookie.Value,
+	)
+	if errors.Is(err, internalDatabase.ErrInvalidCredentials) {
+		loginLimits.failed(user.Email)
+		errs.Add("current_password", "wrong password")
+		writeFieldErrors(w, r, passwordFields, errs)
+		return
+	} else if errors.As(err, &errs) {
+		writeFieldErrors(w, r, passwordFields, errs)
+		return
+	} else if err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	loginLimits.succeeded(user.Email)
+	writeFieldErrors(w, r, passwordFields, nil)
+	writeMessage(w, r, "Password changed, your other devices have been logged out.")
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal/takeout"
+	"github.com/matcha-devs/matcha/internal/validation"
+)
+
+// maxImportSize bounds uploaded exports, well above what a household's history compresses to.
+const maxImportSize = 32 << 20
+
+// getExport downloads everything the user can take to another Matcha instance, as made by takeout.Write.
+func getExport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := checkLoginStatus(w, r)
+	if user == nil || !requireVerifiedEmail(w, r, user) {
+		return
+	}
+	data, err := matcha.database.GetUserData(ctx, user.ID)
+	if err != nil {
+		http.Error(w, userMessage(err), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	export := takeout.Export{
+		Profile: takeout.Profile{
+			FirstName: user.FirstName, MiddleName: user.MiddleName, LastName: user.LastName, Email: user.Email,
+			DateOfBirth: user.DateOfBirth, CreatedOn: user.CreatedOn,
+		},
+		UserData: data,
+	}
+	// Built in memory first, so a failure halfway gives an error rather than a truncated archive.
+	var buf bytes.Buffer
+	if err = takeout.Write(&buf, export, now); err != nil {
+		slog.ErrorContext(ctx, "Error writing export", "user_id", user.ID, "err", err)
+		http.Error(w, userMessage(err), http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(ctx, "User data exported", "user_id", user.ID, "bytes", buf.Len())
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set(
+		"Content-Disposition", fmt.Sprintf(`attachment; filename="matcha-export-%s.zip"`, now.Format(time.DateOnly)),
+	)
+	w.Header().Set("Cache-Control", "no-store")
+	if _, err = buf.WriteTo(w); err != nil {
+		slog.ErrorContext(ctx, "Error sending export", "user_id", user.ID, "err", err)
+	}
+}
+
+// postImport adds the data of an export, possibly made by another instance, to the user's account.
+func postImport(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := checkLoginStatus(w, r)
+	if user == nil || !requireVerifiedEmail(w, r, user) {
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		slog.InfoContext(ctx, "Import without a readable file", "user_id", user.ID, "err", err)
+		writeMessage(w, r, "please choose a Matcha export of at most 32 MB")
+		return
+	}
+	defer func() { _ = file.Close() }()
+	export, err := takeout.Read(file, header.Size)
+	if err != nil {
+		slog.InfoContext(ctx, "Refused invalid export", "user_id", user.ID, "err", err)
+		writeMessage(w, r, "this file isn't a Matcha export or is damaged")
+		return
+	}
+	profile := validation.Profile{
+		FirstName: export.Profile.FirstName, MiddleName: export.Profile.MiddleName,
+		LastName: export.Profile.LastName, DateOfBirth: export.Profile.DateOfBirth,
+	}
+	err = matcha.database.ImportUserData(ctx, user.ID, profile, export.UserData)
+	if errors.As(err, new(validation.Errors)) {
+		writeMessage(w, r, "the profile in this export isn't valid: "+err.Error())
+		return
+	} else if err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	writeMessage(
+		w, r, fmt.Sprintf("Imported %d accounts and %d transactions.", len(export.Accounts), len(export.Transactions)),
+	)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+	"github.com/matcha-devs/matcha/internal/totp"
+)
+
+const (
+	twoFactorCookieName = "c_2fa"
+	// twoFactorLoginTTL is how long a user has to enter their code after their password.
+	twoFactorLoginTTL = 5 * time.Minute
+	totpIssuer        = "Matcha"
+)
+
+var errTwoFactorUnavailable = errors.New("two-factor authentication is not configured, MATCHA_SECRET_KEY is unset")
+
+// twoFactorSetup is what the two-factor page shows while enrolling.
+type twoFactorSetup struct {
+	// URI is typed so templates keep its otpauth scheme, which they would otherwise treat as unsafe.
+	URI        template.URL
+	Secret     string
+	Enrollment string
+}
+
+// totpContext binds an encrypted secret to its user, so secrets can't be swapped between rows.
+func totpContext(purpose string, id uint64) string {
+	return purpose + ":" + strconv.FormatUint(id, 10)
+}
+
+// checkSecondFactor verifies a code from the authenticator app, or a recovery code, of a user with two-factor
+// authentication, it always passes for users without.
+func checkSecondFactor(ctx context.Context, user *internal.User, code string) (err error) {
+	if !user.TOTPEnabled {
+		return
+	}
+	if matcha.secrets == nil {
+		slog.ErrorContext(ctx, "Can't check second factor", "user_id", user.ID, "err", errTwoFactorUnavailable)
+		return internalDatabase.ErrInternal
+	}
+	code = strings.TrimSpace(code)
+	if code == "" {
+		return internalDatabase.ErrInvalidCode
+	}
+	if len(code) != totp.Digits {
+		return matcha.database.UseRecoveryCode(ctx, user.ID, code)
+	}
+	encrypted, err := matcha.database.GetTOTPSecret(ctx, user.ID)
+	if err != nil {
+		return
+	}
+	secret, err := matcha.secrets.Open(encrypted, totpContext("totp", user.ID))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error decrypting TOTP secret", "user_id", user.ID, "err", err)
+		return internalDatabase.ErrInternal
+	}
+	ok, step := totp.Verify(secret, code, time.Now())
+	if !ok {
+		return internalDatabase.ErrInvalidCode
+	}
+	return matcha.database.UseTOTPStep(ctx, user.ID, step)
+}
+
+// beginTwoFactorLogin holds a login whose password was right until the second factor is given on its own page.
+func beginTwoFactorLogin(w http.ResponseWriter, r *http.Request, id uint64) (err error) {
+	token, err := matcha.database.CreateUserToken(
+		r.Context(), id, internalDatabase.TwoFactorLoginToken, twoFactorLoginTTL,
+	)
+	if err != nil {
+		return
+	}
+	http.SetCookie(
+		w, &http.Cookie{
+			Name:     twoFactorCookieName,
+			Value:    token,
+			Path:     "/",
+			MaxAge:   int(twoFactorLoginTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   r.TLS != nil,
+			SameSite: http.SameSiteLaxMode,
+		},
+	)
+	w.Header().Set("HX-Redirect", "/two-factor-login")
+	return
+}
+
+func postTwoFactorLogin(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	cookie, err := r.Cookie(twoFactorCookieName)
+	if err != nil {
+		writeMessage(w, r, "your login expired, please log in again")
+		return
+	}
+	id, err := matcha.database.CheckUserToken(ctx, internalDatabase.TwoFactorLoginToken, cookie.Value)
+	if err != nil {
+		writeMessage(w, r, "your login expired, please log in again")
+		return
+	}
+	user := matcha.database.GetUser(ctx, id)
+	if user == nil {
+		writeMessage(w, r, userMessage(internalDatabase.ErrInternal))
+		return
+	}
+	if ok, retryAfter := loginLimits.allow(r, user.Email); !ok {
+		logins.Inc("throttled")
+		tooManyAttempts(w, r, retryAfter)
+		return
+	}
+	if err = checkSecondFactor(ctx, user, r.FormValue("code")); err != nil {
+		logins.Inc("failure")
+		loginLimits.failed(user.Email)
+		slog.InfoContext(ctx, "Second factor failed", "user_id", id, "err", err)
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	// Redeeming the login token only now lets users retry a mistyped code, and only once when it is right.
+	if _, err = matcha.database.ConsumeUserToken(ctx, internalDatabase.TwoFactorLoginToken, cookie.Value); err != nil {
+		writeMessage(w, r, "your login expired, please log in again")
+		return
+	}
+	logins.Inc("success")
+	loginLimits.succeeded(user.Email)
+	http.SetCookie(w, &http.Cookie{Name: twoFactorCookieName, Path: "/", MaxAge: -1, HttpOnly: true})
+	if err = startSession(w, r, id); err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	w.Header().Set("HX-Redirect", "/dashboard")
+}
+
+func getTwoFactor(w http.ResponseWriter, r *http.Request) {
+	data := newPageData(r, "two-factor")
+	if data.User = checkLoginStatus(w, r); data.User == nil || !requireVerifiedEmail(w, r, data.User) {
+		return
+	}
+	if !data.User.TOTPEnabled && matcha.secrets != nil {
+		secret, err := totp.NewSecret()
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error generating TOTP secret", "err", err)
+			http.Error(w, userMessage(err), http.StatusInternalServerError)
+			return
+		}
+		// The secret only reaches the database once a code proves the app has it, until then it travels sealed in
+		// the form.
+		enrollment, err := matcha.secrets.Seal(secret, totpContext("totp-enroll", data.User.ID))
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Error sealing TOTP secret", "err", err)
+			http.Error(w, userMessage(err), http.StatusInternalServerError)
+			return
+		}
+		data.TwoFactor = twoFactorSetup{
+			URI:        template.URL(totp.URI(totpIssuer, data.User.Email, secret)),
+			Secret:     totp.EncodeSecret(secret),
+			Enrollment: base64.RawURLEncoding.EncodeToString(enrollment),
+		}
+	}
+	executeTemplate(w, r, "two-factor.go.html", data)
+}
+
+// postEnableTwoFactor turns two-factor authentication on once the password and a code of the new secret check out.
+func postEnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := checkLoginStatus(w, r)
+	if user == nil || !requireVerifiedEmail(w, r, user) {
+		return
+	}
+	if matcha.secrets == nil {
+		writeMessage(w, r, userMessage(errTwoFactorUnavailable))
+		return
+	}
+	if user.TOTPEnabled {
+		// A second enrollment would replace the secret and recovery codes without the current ones being asked for.
+		writeMessage(w, r, "Two-factor authentication is already on.")
+		return
+	}
+	if ok, retryAfter := loginLimits.allow(r, user.Email); !ok {
+		tooManyAttempts(w, r, retryAfter)
+		return
+	}
+	if _, err := matcha.database.AuthenticateLogin(ctx, user.Email, r.FormValue("password")); err != nil {
+		loginLimits.failed(user.Email)
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	loginLimits.succeeded(user.Email)
+	enrollment, err := base64.RawURLEncoding.DecodeString(r.FormValue("enrollment"))
+	if err != nil {
+		writeMessage(w, r, "please reload the page and try again")
+		return
+	}
+	secret, err := matcha.secrets.Open(enrollment, totpContext("totp-enroll", user.ID))
+	if err != nil {
+		writeMessage(w, r, "please reload the page and try again")
+		return
+	}
+	ok, step := totp.Verify(secret, strings.TrimSpace(r.FormValue("code")), time.Now())
+	if !ok {
+		writeMessage(w, r, userMessage(internalDatabase.ErrInvalidCode))
+		return
+	}
+	encrypted, err := matcha.secrets.Seal(secret, totpContext("totp", user.ID))
+	if err != nil {
+		slog.ErrorContext(ctx, "Error sealing TOTP secret", "err", err)
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	recoveryCodes, err := matcha.database.EnableTOTP(ctx, user.ID, encrypted)
+	if err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	if err = matcha.database.UseTOTPStep(ctx, user.ID, step); err != nil {
+		slog.ErrorContext(ctx, "Error using enrollment TOTP step", "user_id", user.ID, "err", err)
+	}
+	writeMessage(
+		w, r, "Two-factor authentication is on. Keep these recovery codes somewhere safe, each one lets you log in "+
+			"once without your phone:\n\n"+strings.Join(recoveryCodes, "\n"),
+	)
+}
+
+// postDisableTwoFactor turns two-factor authentication off after checking both the password and a code again.
+func postDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := checkLoginStatus(w, r)
+	if user == nil {
+		return
+	}
+	if ok, retryAfter := loginLimits.allow(r, user.Email); !ok {
+		tooManyAttempts(w, r, retryAfter)
+		return
+	}
+	_, err := matcha.database.AuthenticateLogin(ctx, user.Email, r.FormValue("password"))
+	if err == nil {
+		err = checkSecondFactor(ctx, user, r.FormValue("code"))
+	}
+	if err != nil {
+		loginLimits.failed(user.Email)
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	loginLimits.succeeded(user.Email)
+	if err = matcha.database.DisableTOTP(ctx, user.ID); err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/matcha-devs/matcha/internal"
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+	"github.com/matcha-devs/matcha/internal/validation"
+)
+
+const verificationTTL = 24 * time.Hour
+
+// unverifiedPages are the pages users may reach before verifying their email, enough to fix a mistyped address.
+var unverifiedPages = map[string]struct{}{"dashboard": {}, "settings": {}}
+
+// requireVerifiedEmail refuses the request unless the user verified their email, reporting whether it may go on.
+func requireVerifiedEmail(w http.ResponseWriter, r *http.Request, user *internal.User) (ok bool) {
+	if user.EmailVerified() {
+		return true
+	}
+	slog.InfoContext(r.Context(), "Unverified user refused", "user_id", user.ID, "path", r.URL.Path)
+	http.Error(w, "Please verify your email first.", http.StatusForbidden)
+	return false
+}
+
+// sendVerification emails a link proving the user owns email, replacing any link sent before.
+func sendVerification(ctx context.Context, id uint64, email string) (err error) {
+	token, err := matcha.database.CreateUserToken(ctx, id, internalDatabase.VerifyEmailToken, verificationTTL)
+	if err != nil {
+		return
+	}
+	link := matcha.baseURL + "/verify-email?token=" + url.QueryEscape(token)
+	return queueMail(ctx, email, "verify-email", map[string]any{"Link": link})
+}
+
+func postVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if _, err := matcha.database.VerifyEmail(ctx, r.FormValue("token")); err != nil {
+		slog.InfoContext(ctx, "Email verification with unusable token", "err", err)
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	// The link may be opened on a device that isn't logged in.
+	if _, err := r.Cookie(sessionCookieName); err == nil {
+		w.Header().Set("HX-Redirect", "/dashboard")
+	} else {
+		w.Header().Set("HX-Redirect", "/login")
+	}
+}
+
+func postResendVerification(w http.ResponseWriter, r *http.Request) {
+	user := checkLoginStatus(w, r)
+	if user == nil {
+		return
+	}
+	if user.EmailVerified() {
+		writeMessage(w, r, "Your email is already verified.")
+		return
+	}
+	if ok, retryAfter := mailLimits.allow(r, user.Email); !ok {
+		tooManyAttempts(w, r, retryAfter)
+		return
+	}
+	if err := sendVerification(r.Context(), user.ID, user.Email); err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	writeMessage(w, r, "We've sent you a new link.")
+}
+
+// postChangeEmail moves the account to a new email once the password, and second factor if any, are confirmed, the new
+// email is unverified until its link is followed.
+func postChangeEmail(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	user := checkLoginStatus(w, r)
+	if user == nil {
+		return
+	}
+	errs := validation.Errors{}
+	email := validation.Email(errs, "email", r.FormValue("email"))
+	if len(errs) > 0 {
+		writeMessage(w, r, errs["email"])
+		return
+	}
+	if ok, retryAfter := loginLimits.allow(r, user.Email); !ok {
+		tooManyAttempts(w, r, retryAfter)
+		return
+	}
+	_, err := matcha.database.AuthenticateLogin(ctx, user.Email, r.FormValue("password"))
+	if err == nil {
+		err = checkSecondFactor(ctx, user, r.FormValue("code"))
+	}
+	if err != nil {
+		loginLimits.failed(user.Email)
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	loginLimits.succeeded(user.Email)
+	if ok, retryAfter := mailLimits.allow(r, email); !ok {
+		tooManyAttempts(w, r, retryAfter)
+		return
+	}
+	if err = matcha.database.ChangeEmail(ctx, user.ID, email); errors.Is(err, internalDatabase.ErrEmailTaken) {
+		writeMessage(w, r, "we couldn't change your email to that address")
+		return
+	} else if err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	if err = sendVerification(ctx, user.ID, email); err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+}
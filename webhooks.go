@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	internalDatabase "github.com/matcha-devs/matcha/internal/database"
+	"github.com/matcha-devs/matcha/internal/validation"
+	"github.com/matcha-devs/matcha/internal/webhooks"
+)
+
+// webhookFields are the webhook form fields that may be marked with an error.
+var webhookFields = []string{"url", "events"}
+
+// eventLabels describe the events webhooks can subscribe to on the settings page.
+var eventLabels = map[webhooks.Event]string{
+	webhooks.TransactionCreated:   "A transaction is recorded",
+	webhooks.LargePurchase:        "A purchase of $500 or more is recorded",
+	webhooks.TransactionsImported: "Transactions are imported",
+}
+
+type eventSetting struct {
+	Event webhooks.Event
+	Label string
+}
+
+// webhookSettings lists the webhooks of a user and their delivery logs for the settings page, along with the events
+// to choose from for a new one.
+func webhookSettings(r *http.Request, data *pageData) (err error) {
+	if data.Webhooks, err = matcha.database.GetWebhooks(r.Context(), data.User.ID); err != nil {
+		return
+	}
+	for _, event := range webhooks.Events {
+		data.WebhookEvents = append(data.WebhookEvents, eventSetting{event, eventLabels[event]})
+	}
+	return
+}
+
+// postWebhook subscribes a URL to events and shows the secret its deliveries are signed with, only this once.
+func postWebhook(w http.ResponseWriter, r *http.Request) {
+	user := checkLoginStatus(w, r)
+	if user == nil || !requireVerifiedEmail(w, r, user) {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeMessage(w, r, "please reload the page and try again")
+		return
+	}
+	var events []webhooks.Event
+	for _, event := range r.PostForm["events"] {
+		events = append(events, webhooks.Event(event))
+	}
+	secret, err := matcha.database.AddWebhook(r.Context(), user.ID, r.FormValue("url"), events)
+	var errs validation.Errors
+	if errors.As(err, &errs) {
+		writeFieldErrors(w, r, webhookFields, errs)
+		return
+	} else if err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	writeFieldErrors(w, r, webhookFields, nil)
+	writeMessage(
+		w, r, "Webhook added. Copy the secret its "+webhooks.SignatureHeader+" header is signed with now, it won't "+
+			"be shown again: "+secret,
+	)
+}
+
+func postDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	user := checkLoginStatus(w, r)
+	if user == nil {
+		return
+	}
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		writeMessage(w, r, "please reload the page and try again")
+		return
+	}
+	if err = matcha.database.DeleteWebhook(r.Context(), user.ID, id); err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	w.Header().Set("HX-Refresh", "true")
+}
+
+// postTestWebhook queues a test event for a webhook, it shows up in the delivery log once the page is reloaded.
+func postTestWebhook(w http.ResponseWriter, r *http.Request) {
+	user := checkLoginStatus(w, r)
+	if user == nil || !requireVerifiedEmail(w, r, user) {
+		return
+	}
+	id, err := strconv.ParseUint(r.FormValue("id"), 10, 64)
+	if err != nil {
+		writeMessage(w, r, "please reload the page and try again")
+		return
+	}
+	err = matcha.database.SendTestWebhook(r.Context(), user.ID, id)
+	if errors.Is(err, internalDatabase.ErrNotFound) {
+		writeMessage(w, r, "please reload the page and try again")
+		return
+	} else if err != nil {
+		writeMessage(w, r, userMessage(err))
+		return
+	}
+	writeMessage(w, r, "Test event queued, reload the page in a few seconds to see how it went.")
+}